@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	alertWebhook      = flag.String("alert-webhook", "", "URL the phases longer than their --max-latency are posted to as JSON alerts, with --interval, --schedule or --operator: a firing alert on the first violation of a streak and a resolved one once it recovers; disabled when empty")
+	alertResolveAfter = flag.Int("alert-resolve-after", 3, "number of consecutive measurements of a phase within its --max-latency after which its --alert-webhook alert is resolved")
+)
+
+// newAlerter returns the alerter of --alert-webhook, or nil without it.
+func newAlerter() (*probe.Alerter, error) {
+	if *alertWebhook == "" {
+		return nil, nil
+	}
+	if *interval <= 0 && !*operatorMode && len(schedules) == 0 {
+		return nil, fmt.Errorf("--alert-webhook needs --interval, --schedule or --operator")
+	}
+	if len(maxLatency) == 0 {
+		return nil, fmt.Errorf("--alert-webhook needs --max-latency thresholds")
+	}
+	if *alertResolveAfter < 1 {
+		return nil, fmt.Errorf("--alert-resolve-after must be at least 1, got %d", *alertResolveAfter)
+	}
+	return probe.NewAlerter(*alertWebhook, *alertResolveAfter, *clusterName), nil
+}
+
+// latencySamples returns the phases of probes with a threshold, in order, as
+// samples of the trace traceID. Phases that failed other than by timing out
+// say nothing of their latency and are left out.
+func latencySamples(probes []results.Probe, thresholds map[string]time.Duration, traceID string) []probe.Sample {
+	var samples []probe.Sample
+	for _, pr := range probes {
+		for _, ph := range pr.Phases {
+			threshold, ok := thresholds[ph.Name]
+			if !ok || ph.Outcome != results.OutcomeSuccess && ph.Outcome != results.OutcomeTimeout {
+				continue
+			}
+			samples = append(samples, probe.Sample{
+				Kind:      pr.Kind,
+				Phase:     ph.Name,
+				Measured:  ph.Duration,
+				Threshold: threshold,
+				TraceID:   traceID,
+			})
+		}
+	}
+	return samples
+}
+
+// alertLatency checks the phases of the run's probes against their
+// thresholds and sends the alerts they trigger.
+func (p *prober) alertLatency(ctx context.Context, probes []results.Probe) {
+	if p.alerter == nil {
+		return
+	}
+	traceID := trace.SpanContextFromContext(ctx).TraceID().String()
+	for _, s := range latencySamples(probes, p.thresholds, traceID) {
+		alert := p.alerter.Observe(s)
+		if alert == nil {
+			continue
+		}
+		p.log.InfoContext(ctx, "Sending latency alert", "status", alert.Status, "kind", alert.Kind, "phase", alert.Phase,
+			"measured", alert.Measured, "threshold", alert.Threshold, "streak", alert.Streak)
+		actx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := p.alerter.Send(actx, alert); err != nil {
+			p.log.ErrorContext(ctx, "Failed to send latency alert", "url", *alertWebhook, "error", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	kubeconfig   = flag.String("kubeconfig", "", "path to a kubeconfig file to reach a remote cluster with, e.g. from a laptop or CI runner; defaults to $KUBECONFIG, then ~/.kube/config, then the in-cluster configuration")
+	kubeContext  = flag.String("context", "", "name of the kubeconfig context to use; defaults to the kubeconfig's current context")
+	apiServerURL = flag.String("api-server-url", "", "URL of the API server, possibly with a path prefix when reached through a reverse proxy; defaults to the in-cluster configuration")
+	apiTokenFile = flag.String("api-token-file", "", "file holding the bearer token to authenticate with, overriding the service account's")
+	apiHeaders   = headerFlag{}
+)
+
+func init() {
+	flag.Var(apiHeaders, "api-header", "key=value header added to every request to the API server, e.g. for an authenticating proxy; may be repeated")
+}
+
+// headerFlag collects repeated key=value flags into headers.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var pairs []string
+	for key, values := range h {
+		for _, v := range values {
+			pairs = append(pairs, key+"="+v)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (headerFlag) repeated() {}
+
+func (h headerFlag) Set(s string) error {
+	// An empty value sets nothing.
+	if s == "" {
+		return nil
+	}
+	key, value, err := probe.ParseHeader(s)
+	if err != nil {
+		return err
+	}
+	http.Header(h).Add(key, value)
+	return nil
+}
+
+// restConfig returns the configuration used to reach the API server, with
+// the token and headers overrides applied: a bare one when --api-server-url
+// is set, that of the kubeconfig context kubeContext when one is found, the
+// current one when empty, the in-cluster one otherwise or when kubeContext
+// is inClusterContext. The namespace is the kubeconfig context's, empty when
+// not using one.
+func restConfig(kubeContext string) (config *rest.Config, namespace string, err error) {
+	switch {
+	case *apiServerURL == "":
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = *kubeconfig
+		if kubeContext == inClusterContext || *kubeconfig == "" && kubeContext == "" && !kubeconfigExists(rules.Precedence) {
+			c, err := rest.InClusterConfig()
+			if err != nil {
+				return nil, "", err
+			}
+			config = c
+			break
+		}
+		kc := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext})
+		c, err := kc.ClientConfig()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load kubeconfig: %w", err)
+		}
+		ns, _, err := kc.Namespace()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read the kubeconfig's namespace: %w", err)
+		}
+		config, namespace = c, ns
+	default:
+		u, err := url.Parse(*apiServerURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, "", fmt.Errorf("invalid --api-server-url %q, must be an http(s) URL", *apiServerURL)
+		}
+		config = &rest.Config{Host: *apiServerURL}
+	}
+
+	if *apiTokenFile != "" {
+		config.BearerToken = ""
+		config.BearerTokenFile = *apiTokenFile
+	}
+	if len(apiHeaders) > 0 {
+		config.Wrap(probe.HeaderWrapper(http.Header(apiHeaders)))
+	}
+	return config, namespace, nil
+}
+
+// kubeconfigExists reports whether any of the kubeconfig files exists.
+func kubeconfigExists(paths []string) bool {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAPIServer makes sure the API server is reachable at the configured
+// URL, telling which layer failed otherwise. It returns the phase of the
+// check, and a result describing the failure if any.
+func (p *prober) checkAPIServer(ctx context.Context, config *rest.Config) (results.Phase, *results.Probe) {
+	client, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return results.Phase{}, &results.Probe{
+			Kind:       p.kind,
+			Outcome:    results.OutcomeError,
+			Attributes: map[string]string{"namespace": p.namespace},
+			Errors:     []string{err.Error()},
+		}
+	}
+
+	ph, err := probe.RunStage(ctx, p.tracer, probe.Stage{
+		Name: "check-api-server",
+		Run: func(ctx context.Context) error {
+			return probe.CheckAPIServer(ctx, client, config.Host)
+		},
+	})
+	if err == nil {
+		return ph, nil
+	}
+
+	result := &results.Probe{
+		Kind:       p.kind,
+		Outcome:    results.OutcomeError,
+		Phases:     []results.Phase{ph},
+		Attributes: map[string]string{"namespace": p.namespace},
+		Errors:     []string{err.Error()},
+	}
+	var layerErr *probe.LayerError
+	if errors.As(err, &layerErr) {
+		result.Attributes["api.failed_layer"] = layerErr.Layer
+	}
+	return ph, result
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// artifactTimeout bounds the time spent collecting each item of a bundle, so
+// that gathering artifacts can never hang the end of a run.
+const artifactTimeout = 5 * time.Second
+
+// artifacts collects what's needed to debug a failed run without access to
+// the cluster, and writes it as a bundle directory when the run fails. A nil
+// *artifacts collects nothing.
+type artifacts struct {
+	dir       string
+	retention int
+	clientset kubernetes.Interface
+	namespace string
+
+	mu           sync.Mutex
+	maxObs       int
+	observations []any
+	objects      map[string]any
+	involved     []string
+}
+
+func newArtifacts(dir string, retention, maxObservations int, clientset kubernetes.Interface, namespace string) *artifacts {
+	if dir == "" {
+		return nil
+	}
+	return &artifacts{
+		dir:       dir,
+		retention: retention,
+		clientset: clientset,
+		namespace: namespace,
+		maxObs:    maxObservations,
+		objects:   make(map[string]any),
+	}
+}
+
+// enabled reports whether artifacts are collected.
+func (a *artifacts) enabled() bool {
+	return a != nil
+}
+
+// observe records a poll observation, keeping only the most recent ones.
+func (a *artifacts) observe(obs any) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.observations = append(a.observations, obs)
+	if len(a.observations) > a.maxObs {
+		a.observations = a.observations[len(a.observations)-a.maxObs:]
+	}
+}
+
+// snapshot records the final state of an object created by the probe, and
+// marks it as one whose events should be collected.
+func (a *artifacts) snapshot(name string, obj any) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.objects[name] = obj
+	a.involved = append(a.involved, name)
+}
+
+// write writes the bundle for a failed run into its own directory and prunes
+// the oldest bundles beyond the retention. Collection is best effort: items
+// that fail are reported in errors.txt rather than aborting the bundle.
+func (a *artifacts) write(ctx context.Context, res *results.Results, traceID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	dir := filepath.Join(a.dir, fmt.Sprintf("%s-%s", res.Run.Start.UTC().Format("20060102T150405Z"), res.Run.ID))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create artifacts directory: %w", err)
+	}
+
+	var errs []string
+	put := func(name string, v any) {
+		if err := writeJSON(filepath.Join(dir, name), v); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	put("result.json", res)
+	put("config.json", effectiveConfig())
+	put("observations.json", a.observations)
+	for name, obj := range a.objects {
+		put(name+".json", obj)
+	}
+	for _, name := range a.involved {
+		ictx, cancel := context.WithTimeout(ctx, artifactTimeout)
+		events, err := a.clientset.CoreV1().Events(a.namespace).List(ictx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("involvedObject.name", name).String(),
+		})
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("events for %s: %v", name, err))
+			continue
+		}
+		put(name+".events.json", events.Items)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "trace_id.txt"), []byte(traceID+"\n"), 0o644); err != nil {
+		errs = append(errs, fmt.Sprintf("trace_id.txt: %v", err))
+	}
+	if len(errs) > 0 {
+		_ = os.WriteFile(filepath.Join(dir, "errors.txt"), []byte(strings.Join(errs, "\n")+"\n"), 0o644)
+	}
+
+	slog.Info("Wrote failure artifacts", "dir", dir)
+	return a.prune()
+}
+
+// prune removes the oldest bundles beyond the retention.
+func (a *artifacts) prune() error {
+	if a.retention <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list artifacts: %w", err)
+	}
+
+	var dirs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs = append(dirs, e.Name())
+		}
+	}
+	// Bundle names start with their timestamp, so they sort chronologically
+	slices.Sort(dirs)
+	for len(dirs) > a.retention {
+		if err := os.RemoveAll(filepath.Join(a.dir, dirs[0])); err != nil {
+			return fmt.Errorf("failed to prune artifacts: %w", err)
+		}
+		dirs = dirs[1:]
+	}
+	return nil
+}
+
+// effectiveConfig returns the value of every flag, with anything that looks
+// like a secret redacted.
+func effectiveConfig() map[string]string {
+	cfg := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		name := strings.ToLower(f.Name)
+		for _, s := range []string{"token", "secret", "password", "header", "key"} {
+			if strings.Contains(name, s) && v != "" {
+				v = "REDACTED"
+			}
+		}
+		cfg[f.Name] = v
+	})
+	return cfg
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+// reportOutstanding logs the objects the run created and didn't delete,
+// which are left behind, unless --cleanup=false meant to keep them.
+func (p *prober) reportOutstanding(ctx context.Context) {
+	if !*cleanup {
+		return
+	}
+	for _, e := range p.ledger.Outstanding() {
+		p.log.WarnContext(ctx, "Object created by the run was not deleted", "resource", e.Resource, "object_namespace", e.Namespace, "name", e.Name, "uid", e.UID)
+	}
+}
+
+// runCleanup implements the cleanup subcommand, deleting the objects of a
+// --ledger-file that were never deleted. It returns the process exit code.
+func runCleanup(args []string) int {
+	fs := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s cleanup --ledger-file=path [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	path := fs.String("ledger-file", "", "file the objects to delete were recorded in with --ledger-file")
+	fs.StringVar(kubeconfig, "kubeconfig", *kubeconfig, flag.Lookup("kubeconfig").Usage)
+	fs.StringVar(kubeContext, "context", *kubeContext, flag.Lookup("context").Usage)
+	timeout := fs.Duration("timeout", time.Minute, "how long deleting the objects may take")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ledger, err := probe.OpenLedger(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Deletions are appended to the file, the next cleanup skipping them
+	defer ledger.Close()
+	config, _, err := restConfig(*kubeContext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	client, err := metadata.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(probe.WithLedger(context.Background(), ledger), *timeout)
+	defer cancel()
+	outstanding := ledger.Outstanding()
+	// Newest first, the objects before the namespaces they are in
+	slices.Reverse(outstanding)
+	exitCode := 0
+	for _, e := range outstanding {
+		if err := deleteLedgerEntry(ctx, client, e); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("deleted %s %s\n", e.Resource, objectName(e))
+	}
+	return exitCode
+}
+
+// ledgerResources are the resources of the objects recorded in a ledger.
+var ledgerResources = slices.Concat(probe.ReapedResources, []schema.GroupVersionResource{
+	probe.NamespaceResource,
+	probe.WebhookConfigurationResource,
+})
+
+// deleteLedgerEntry deletes the object of e with client, provided it is
+// still the one that was recorded.
+func deleteLedgerEntry(ctx context.Context, client metadata.Interface, e probe.LedgerEntry) error {
+	i := slices.IndexFunc(ledgerResources, func(gvr schema.GroupVersionResource) bool { return gvr.Resource == e.Resource })
+	if i < 0 {
+		return fmt.Errorf("%s %s: unknown resource", e.Resource, objectName(e))
+	}
+	var resource metadata.ResourceInterface = client.Resource(ledgerResources[i])
+	if e.Namespace != "" {
+		resource = client.Resource(ledgerResources[i]).Namespace(e.Namespace)
+	}
+	propagation := metav1.DeletePropagationBackground
+	err := probe.DeleteOwned(ctx, e.Resource, e.Namespace, e.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}, func(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+		return resource.Delete(ctx, name, opts)
+	})
+	if err != nil && !probe.IsUIDMismatch(err) {
+		return fmt.Errorf("failed to delete %s %s: %w", e.Resource, objectName(e), err)
+	}
+	return err
+}
+
+// objectName returns the namespace/name of the object of e, or its name
+// alone if it is cluster-scoped.
+func objectName(e probe.LedgerEntry) string {
+	if e.Namespace == "" {
+		return e.Name
+	}
+	return e.Namespace + "/" + e.Name
+}
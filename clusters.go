@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+// inClusterContext is the --contexts entry of the cluster the prober runs in.
+const inClusterContext = "in-cluster"
+
+var kubeContexts = flag.String("contexts", "", "comma-separated kubeconfig contexts of the clusters probed concurrently with --interval, each as context or name=context to name the cluster otherwise, "+inClusterContext+" standing for the prober's own cluster; every span and metric gets the k8s.cluster.name of its cluster")
+
+// clusterTarget is a cluster of --contexts.
+type clusterTarget struct {
+	// name is recorded as k8s.cluster.name.
+	name string
+	// context is the kubeconfig context, or inClusterContext.
+	context string
+}
+
+// parseClusterTargets returns the clusters of --contexts, nil when unset.
+func parseClusterTargets() ([]clusterTarget, error) {
+	if *kubeContexts == "" {
+		return nil, nil
+	}
+	var targets []clusterTarget
+	for entry := range strings.SplitSeq(*kubeContexts, ",") {
+		name, kubeContext, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			kubeContext = name
+		}
+		name, kubeContext = strings.TrimSpace(name), strings.TrimSpace(kubeContext)
+		if name == "" || kubeContext == "" {
+			return nil, fmt.Errorf("invalid --contexts entry %q, must be context or name=context", entry)
+		}
+		if slices.ContainsFunc(targets, func(t clusterTarget) bool { return t.name == name }) {
+			return nil, fmt.Errorf("cluster %q is listed twice in --contexts", name)
+		}
+		targets = append(targets, clusterTarget{name: name, context: kubeContext})
+	}
+	return targets, nil
+}
+
+// validateClusterTargets returns an error unless --contexts can be used with
+// the other flags: each cluster is probed by its own --interval loop.
+func validateClusterTargets() error {
+	if *kubeContexts == "" {
+		return nil
+	}
+	var errs []error
+	if *interval <= 0 {
+		errs = append(errs, errors.New("--contexts needs --interval"))
+	}
+	for name, set := range map[string]bool{
+		"--context":        *kubeContext != "",
+		"--api-server-url": *apiServerURL != "",
+		"--config-watch":   *configWatch != 0,
+		"--trigger-addr":   *triggerAddr != "",
+		"--slo-config":     *sloConfig != "",
+	} {
+		if set {
+			errs = append(errs, fmt.Errorf("--contexts can't be used with %s", name))
+		}
+	}
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errors.Join(errs...)
+}
+
+// clusterClients are the configurations and clients of a probed cluster.
+type clusterClients struct {
+	config *rest.Config
+	// identityConfig is config before the token refresher, the ephemeral
+	// identity authenticating with its own token.
+	identityConfig *rest.Config
+	clientset      *kubernetes.Clientset
+	contentClients map[string]kubernetes.Interface
+	metadata       metadata.Interface
+	// namespace is the kubeconfig context's, empty when not using one.
+	namespace string
+}
+
+// connect returns the instrumented clients of the cluster of the kubeconfig
+// context kubeContext, the current one when empty, or the in-cluster
+// configuration, unless given an API server URL.
+func connect(ctx context.Context, kubeContext string, tp trace.TracerProvider) (*clusterClients, error) {
+	config, kubeNamespace, err := restConfig(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	setContentType(config, *contentType)
+	throttle := telemetry.NewThrottleRecorder(float32(*kubeQPS), *kubeBurst, *throttleThreshold)
+	throttle.EventBurst, throttle.EventWindow = *pollEventBurst, *pollEventWindow
+	config.RateLimiter = throttle
+	rejections := must(telemetry.NewRejectionRecorder(otel.Meter("k8s-latency-probe")))
+	rejections.MaxRetries, rejections.Backoff = *throttleRetries, *throttleBackoff
+	rejections.EventBurst, rejections.EventWindow = *pollEventBurst, *pollEventWindow
+	config.Wrap(rejections.Wrap)
+	config.Wrap(telemetry.RecordAuditID)
+	var apfNames func(string) (string, bool)
+	if *resolveAPF {
+		// Copied before the wrap below, never resolving its own requests
+		names := probe.NewAPFNames(must(kubernetes.NewForConfig(rest.CopyConfig(config))))
+		rctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := names.Refresh(rctx)
+		cancel()
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to list the API Priority and Fairness configuration, only recording UIDs", "error", err)
+		} else {
+			apfNames = names.Name
+		}
+	}
+	config.Wrap(telemetry.RecordAPF(apfNames))
+	if *propagateTrace {
+		config.Wrap(telemetry.PropagateTraceContext)
+	}
+	if *requestSpans && *connectionSpans {
+		config.Wrap(telemetry.NewConnectionTracer(tp).Wrap)
+	}
+	if *requestSpans {
+		config.Wrap(telemetry.NewRequestTracer(tp).Wrap)
+	} else if *escalateAfter > 0 {
+		// Escalated runs record their requests all the same
+		tracer := telemetry.NewRequestTracer(tp)
+		tracer.OnDemand = true
+		config.Wrap(tracer.Wrap)
+	}
+	// The ephemeral identity authenticates with its own token
+	identityConfig := rest.CopyConfig(config)
+	if config.BearerTokenFile != "" {
+		refresher, err := probe.NewTokenRefresher(config.BearerTokenFile, otel.Meter("k8s-latency-probe"))
+		if err != nil {
+			return nil, err
+		}
+		config.Wrap(refresher.Wrap)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	byContentType, err := newContentClients(config)
+	if err != nil {
+		return nil, err
+	}
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterClients{
+		config:         config,
+		identityConfig: identityConfig,
+		clientset:      clientset,
+		contentClients: byContentType,
+		metadata:       metadataClient,
+		namespace:      kubeNamespace,
+	}, nil
+}
+
+// forCluster returns a runner sharing r's configuration, probing the cluster
+// named name with cc instead. Its probes run in --namespace, or the
+// namespace of the cluster's kubeconfig context, and are only owned by the
+// prober's pod in its own cluster.
+func (r *runner) forCluster(name string, cc *clusterClients, inCluster bool) *runner {
+	c := r.forProbe(r.kind, r.namespace)
+	c.cluster = name
+	c.clientset = cc.clientset
+	c.config = cc.config
+	c.identityConfig = cc.identityConfig
+	c.contentClients = cc.contentClients
+	c.metrics = must(telemetry.NewRunMetrics(otel.Meter("k8s-latency-probe")))
+	c.uploader = r.uploader.forCluster(name)
+	c.zones = probe.NewZoneResolver(cc.clientset)
+	if r.nodes != nil {
+		c.nodes = probe.NewNodeSampler(r.nodes.Size, r.nodes.Selector)
+	}
+	if r.pending != nil {
+		c.pending = &probe.PendingSampler{Client: cc.metadata}
+	}
+	switch {
+	case inCluster:
+		c.namespace = r.namespace
+	case *namespaceOverride != "":
+		c.namespace = *namespaceOverride
+	case cc.namespace != "":
+		c.namespace = cc.namespace
+	default:
+		c.namespace = "default"
+	}
+	pause := *r.pause
+	pause.Client, pause.Namespace = cc.clientset, c.namespace
+	c.pause = &pause
+	if !inCluster {
+		// The prober's pod isn't in this cluster
+		c.owners, c.eventTarget = nil, nil
+	}
+	return c
+}
+
+// clusterSet probes several clusters, each by its own runner.
+type clusterSet struct {
+	clusters []probedCluster
+}
+
+// probedCluster is a cluster of a clusterSet.
+type probedCluster struct {
+	runner *runner
+	// metadata is the client of the reaper.
+	metadata metadata.Interface
+}
+
+// newClusterSet connects to the clusters of targets and returns their
+// runners, derived from base, or nil without targets.
+func newClusterSet(ctx context.Context, base *runner, targets []clusterTarget, tp trace.TracerProvider) (*clusterSet, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	s := &clusterSet{}
+	for _, t := range targets {
+		cc, err := connect(ctx, t.context, tp)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", t.name, err)
+		}
+		s.clusters = append(s.clusters, probedCluster{
+			runner:   base.forCluster(t.name, cc, t.context == inClusterContext),
+			metadata: cc.metadata,
+		})
+	}
+	return s, nil
+}
+
+// list returns the clusters of s, none for a nil *clusterSet.
+func (s *clusterSet) list() []probedCluster {
+	if s == nil {
+		return nil
+	}
+	return s.clusters
+}
+
+// loop runs the loop of every cluster until ctx is done.
+func (s *clusterSet) loop(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+	for _, c := range s.clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.runner.loop(ctx, interval)
+		}()
+	}
+	wg.Wait()
+}
+
+// activeRuns returns the IDs of the runs in flight in every cluster.
+func (s *clusterSet) activeRuns() []string {
+	var ids []string
+	for _, c := range s.clusters {
+		ids = append(ids, c.runner.activeRuns()...)
+	}
+	return ids
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runCompare implements the compare subcommand, printing a phase by phase
+// comparison of two results files. It returns the process exit code.
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s compare [flags] before.json after.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	threshold := fs.Float64("threshold", 10, "change of a phase's p50 or p95, in percent, considered significant")
+	format := fs.String("format", "table", "output format, one of table, json or markdown")
+	failOnRegression := fs.Bool("fail-on-regression", false, "exit with code 1 when any phase regressed by at least --threshold")
+	v1Kind := fs.String("v1-kind", "pod", "probe kind assumed for single-probe schema v1 results, which don't record it")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var sides [2][]*results.Results
+	for i, path := range fs.Args() {
+		rs, err := readResults(path, *v1Kind)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		sides[i] = rs
+	}
+
+	c := results.Compare(sides[0], sides[1], *threshold)
+
+	var err error
+	switch *format {
+	case "table":
+		err = writeCompareTable(os.Stdout, c)
+	case "markdown":
+		err = writeCompareMarkdown(os.Stdout, c)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(c)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown --format %q\n", *format)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write comparison: %v\n", err)
+		return 1
+	}
+
+	if *failOnRegression && c.Regressed() {
+		return 1
+	}
+	return 0
+}
+
+// readResults reads every results document in the file at path.
+func readResults(path, v1Kind string) ([]*results.Results, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rs, err := results.ParseAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, r := range rs {
+		for i := range r.Run.Probes {
+			if r.Run.Probes[i].Kind == "" {
+				r.Run.Probes[i].Kind = v1Kind
+			}
+		}
+	}
+	return rs, nil
+}
+
+func writeCompareTable(w io.Writer, c results.Comparison) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tN\tP50 BEFORE\tP50 AFTER\tΔ P50\tP95 BEFORE\tP95 AFTER\tΔ P95\t")
+	for _, r := range c.Rows {
+		mark := ""
+		if r.Significant {
+			mark = " *"
+		}
+		fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			r.Key, mark, counts(r),
+			p50(r.Before), p50(r.After), formatDelta(r.DeltaP50),
+			p95(r.Before), p95(r.After), formatDelta(r.DeltaP95),
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n* changed by at least %g%%\n", c.Threshold)
+	return err
+}
+
+func writeCompareMarkdown(w io.Writer, c results.Comparison) error {
+	var b strings.Builder
+	b.WriteString("| Phase | N | p50 before | p50 after | Δ p50 | p95 before | p95 after | Δ p95 |\n")
+	b.WriteString("|---|---|---:|---:|---:|---:|---:|---:|\n")
+	for _, r := range c.Rows {
+		key := "`" + r.Key + "`"
+		if r.Significant {
+			key = "**" + key + "**"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s | %s |\n",
+			key, counts(r),
+			p50(r.Before), p50(r.After), formatDelta(r.DeltaP50),
+			p95(r.Before), p95(r.After), formatDelta(r.DeltaP95),
+		)
+	}
+	fmt.Fprintf(&b, "\nPhases in bold changed by at least %g%%.\n", c.Threshold)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// counts returns the number of samples on each side, e.g. "10/12".
+func counts(r results.ComparisonRow) string {
+	n := func(p *results.Percentiles) string {
+		if p == nil {
+			return "-"
+		}
+		return fmt.Sprint(p.Count)
+	}
+	return n(r.Before) + "/" + n(r.After)
+}
+
+func p50(p *results.Percentiles) string {
+	if p == nil {
+		return "-"
+	}
+	return formatDuration(p.P50)
+}
+
+func p95(p *results.Percentiles) string {
+	if p == nil {
+		return "-"
+	}
+	return formatDuration(p.P95)
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond / 10).String()
+}
+
+func formatDelta(d *float64) string {
+	if d == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", *d)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+var configFile = flag.String("config", "", "path to a YAML file setting flags by name, as printed by the config print-defaults subcommand; flags given on the command line take precedence")
+
+// probeFlags lists the flags only relevant to some kinds of probes. Every
+// other flag applies to all of them.
+var probeFlags = map[string][]string{
+	"mutate-from":                 {"pod", "pod-status", "pod-ready", "endpoints", "configmap-mount", "dns", "pvc", "image-pull", "scheduler", "preemption", "webhook-overhead"},
+	"per-node":                    perNodeKinds,
+	"per-zone":                    perZoneKinds,
+	"node-selector":               perNodeKinds,
+	"node-sample":                 perNodeKinds,
+	"node-pinning":                perNodeKinds,
+	"pod-template":                {"pod", "pod-status", "pod-ready", "endpoints", "configmap-mount", "dns", "pvc", "image-pull", "scheduler", "preemption", "webhook-overhead"},
+	"image":                       {"pod", "pod-status", "pod-ready", "endpoints", "configmap-mount", "dns", "pvc", "job", "image-pull", "scheduler", "preemption", "webhook-overhead", "e2e"},
+	"payload-size":                {"configmap", "secret"},
+	"payload-sweep":               {"configmap", "secret"},
+	"ip-family":                   {"e2e", "dns"},
+	"traffic-policy":              {"e2e"},
+	"storage-class":               {"pvc"},
+	"pvc-size":                    {"pvc"},
+	"pvc-mount":                   {"pvc"},
+	"compare-content-types":       {"verbs", "reads"},
+	"victim-priority-class":       {"preemption"},
+	"preemptor-priority-class":    {"preemption"},
+	"namespace-objects":           {"namespace-deletion"},
+	"webhook-match-labels":        {"webhook-overhead"},
+	"webhook-unmatched-namespace": {"webhook-overhead"},
+	"webhook-dry-run":             {"webhook-overhead"},
+	"webhook-calibrate-service":   {"webhook-overhead"},
+	"webhook-calibrate-addr":      {"webhook-overhead"},
+	"rbac-check":                  {"rbac-propagation"},
+	"token-review":                {"token-request"},
+}
+
+// runConfig implements the config subcommand. It returns the process exit
+// code.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print-defaults" {
+		fmt.Fprintf(os.Stderr, "Usage: %s config print-defaults [--probe=kind]\n", os.Args[0])
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config print-defaults", flag.ContinueOnError)
+	kind := fs.String("probe", "", "only print the options relevant to this kind of probe, one of "+strings.Join(probeKinds, ", "))
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *kind != "" && !slices.Contains(probeKinds, *kind) {
+		fmt.Fprintf(os.Stderr, "unknown --probe %q\n", *kind)
+		return 2
+	}
+
+	if err := printDefaults(os.Stdout, flag.CommandLine, *kind); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printDefaults writes a YAML config file setting every flag of fs relevant
+// to kind, or all of them if kind is empty, to its default value, with its
+// usage as a comment.
+func printDefaults(w io.Writer, fs *flag.FlagSet, kind string) error {
+	bw := bufio.NewWriter(w)
+	first := true
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		kinds, ok := probeFlags[f.Name]
+		if kind != "" && ok && !slices.Contains(kinds, kind) {
+			return
+		}
+
+		value := f.DefValue
+		if f.Name == "probe" && kind != "" {
+			value = kind
+		}
+		if !first {
+			fmt.Fprintln(bw)
+		}
+		first = false
+		fmt.Fprintf(bw, "# %s\n", f.Usage)
+		if ok {
+			fmt.Fprintf(bw, "# Only used by the %s probes.\n", strings.Join(kinds, " and "))
+		}
+		if _, ok := f.Value.(repeatedValue); ok && value == "" {
+			// An empty list sets nothing, as not repeating the flag.
+			fmt.Fprintf(bw, "%s: []\n", f.Name)
+			return
+		}
+		fmt.Fprintf(bw, "%s: %s\n", f.Name, yamlScalar(f, value))
+	})
+	return bw.Flush()
+}
+
+// yamlScalar formats value, a value of f, as a YAML scalar. Only booleans and
+// numbers are left unquoted.
+func yamlScalar(f *flag.Flag, value string) string {
+	if g, ok := f.Value.(flag.Getter); ok {
+		switch g.Get().(type) {
+		case bool, int, int64, uint, uint64, float64:
+			return value
+		}
+	}
+	return strconv.Quote(value)
+}
+
+// loadConfig sets the flags of fs named in the YAML file at path, skipping
+// the ones set explicitly on the command line.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	values, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for name, raw := range values {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("config %s: unknown option %q", path, name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := setOption(fs, name, raw); err != nil {
+			return fmt.Errorf("config %s: invalid %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// readConfig returns the options of the YAML config file at path, by name.
+func readConfig(path string) (map[string]json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	var values map[string]json.RawMessage
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// setOption sets the flag name to raw, the JSON value of its option in a
+// config file.
+func setOption(fs *flag.FlagSet, name string, raw json.RawMessage) error {
+	if obj, ok := fs.Lookup(name).Value.(objectValue); ok && isObject(raw) {
+		return obj.SetObject(raw)
+	}
+	for _, value := range configValues(raw) {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectValue is implemented by the flags that can be given a whole object
+// in the config file, e.g. --pod-template, rather than key=value pairs.
+type objectValue interface {
+	SetObject(raw json.RawMessage) error
+}
+
+// repeatedValue is implemented by the flags that may be repeated, which are
+// printed as a list by config print-defaults, an empty one when unset.
+type repeatedValue interface {
+	repeated()
+}
+
+// isObject reports whether raw is a JSON object.
+func isObject(raw json.RawMessage) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{"))
+}
+
+// configValues returns the flag values set by raw, the JSON value of an
+// option: a scalar sets a single value, a list sets each of its elements in
+// turn, and a map sets a key=value pair per entry, as repeated flags would.
+func configValues(raw json.RawMessage) []string {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		values := make([]string, 0, len(list))
+		for _, elem := range list {
+			values = append(values, configScalar(elem))
+		}
+		return values
+	}
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &entries); err == nil {
+		values := make([]string, 0, len(entries))
+		for _, key := range slices.Sorted(maps.Keys(entries)) {
+			values = append(values, key+"="+configScalar(entries[key]))
+		}
+		return values
+	}
+	return []string{configScalar(raw)}
+}
+
+// configScalar returns the flag value set by raw, a JSON scalar: strings are
+// unquoted, numbers and booleans used as is.
+func configScalar(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrintDefaultsRoundTrip(t *testing.T) {
+	defaults, err := probeConfig()
+	if err != nil {
+		t.Fatalf("probeConfig() error = %v", err)
+	}
+	t.Cleanup(func() { flag.Set("probe", flag.Lookup("probe").DefValue) })
+
+	for _, kind := range append([]string{""}, probeKinds...) {
+		// The prober's flags, without those of the test binary
+		fs := flag.NewFlagSet("k8s-latency-probe", flag.ContinueOnError)
+		flag.VisitAll(func(f *flag.Flag) {
+			if !strings.HasPrefix(f.Name, "test.") {
+				fs.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+
+		var out bytes.Buffer
+		if err := printDefaults(&out, fs, kind); err != nil {
+			t.Fatalf("printDefaults(%q) error = %v", kind, err)
+		}
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, out.Bytes(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadConfig(fs, path); err != nil {
+			t.Fatalf("loadConfig() of the %q defaults error = %v", kind, err)
+		}
+
+		fs.VisitAll(func(f *flag.Flag) {
+			want := f.DefValue
+			if f.Name == "probe" && kind != "" {
+				want = kind
+			}
+			if got := f.Value.String(); got != want {
+				t.Errorf("%q defaults: %s = %q, want %q", kind, f.Name, got, want)
+			}
+		})
+		cfg, err := probeConfig()
+		if err != nil {
+			t.Fatalf("probeConfig() of the %q defaults error = %v", kind, err)
+		}
+		if !reflect.DeepEqual(cfg, defaults) {
+			t.Errorf("%q defaults: probe config = %+v, want %+v", kind, cfg, defaults)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+var (
+	contentType         = flag.String("content-type", contentJSON, "wire format of the requests to the API server, json or protobuf; custom resources are always read as json")
+	compareContentTypes = flag.Bool("compare-content-types", false, "make the requests of the verbs and reads probes with each content type in turn, their phases suffixed with @json and @protobuf, to quantify serialization overhead")
+)
+
+// Wire formats of the requests to the API server.
+const (
+	contentJSON     = "json"
+	contentProtobuf = "protobuf"
+)
+
+// contentTypes are the Content-Type and Accept headers of the requests of
+// each wire format. Protobuf requests fall back to JSON for the types
+// without a protobuf encoding.
+var contentTypes = map[string]struct{ contentType, accept string }{
+	contentJSON:     {runtime.ContentTypeJSON, runtime.ContentTypeJSON},
+	contentProtobuf: {runtime.ContentTypeProtobuf, runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON},
+}
+
+// validateContentType returns an error unless --content-type is known.
+func validateContentType() error {
+	if _, ok := contentTypes[*contentType]; !ok {
+		return fmt.Errorf("invalid --content-type %q, must be one of %s or %s", *contentType, contentJSON, contentProtobuf)
+	}
+	return nil
+}
+
+// setContentType sets the wire format of the requests made through config.
+// The dynamic and metadata clients ignore it, setting their own.
+func setContentType(config *rest.Config, name string) {
+	config.ContentType = contentTypes[name].contentType
+	config.AcceptContentTypes = contentTypes[name].accept
+}
+
+// newContentClients returns a clientset making its requests with each
+// content type, by name, or nil without --compare-content-types.
+func newContentClients(config *rest.Config) (map[string]kubernetes.Interface, error) {
+	if !*compareContentTypes {
+		return nil, nil
+	}
+	clients := make(map[string]kubernetes.Interface)
+	for _, name := range []string{contentJSON, contentProtobuf} {
+		cfg := rest.CopyConfig(config)
+		setContentType(cfg, name)
+		client, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		clients[name] = client
+	}
+	return clients, nil
+}
+
+// contentClients are the clients making requests of one content type.
+type contentClients struct {
+	// name is the content type, empty for the prober's own clients.
+	name    string
+	clients probe.Clients
+}
+
+// byContentType returns the clients the verbs and reads probes make their
+// requests with in turn: one for each content type with
+// --compare-content-types, the prober's own otherwise. Deletes still go
+// through the prober's cleanup client.
+func (p *prober) byContentType() []contentClients {
+	if p.contentClients == nil {
+		return []contentClients{{clients: p.clients}}
+	}
+	var out []contentClients
+	for _, name := range []string{contentJSON, contentProtobuf} {
+		out = append(out, contentClients{
+			name:    name,
+			clients: probe.Clients{Measure: p.contentClients[name], Cleanup: p.clients.Cleanup},
+		})
+	}
+	return out
+}
+
+// suffix returns the suffix of the names of the phases of requests made
+// with c, "@json" or "@protobuf", or none for the prober's own clients.
+func (c contentClients) suffix() string {
+	if c.name == "" {
+		return ""
+	}
+	return "@" + c.name
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// loop runs the probe every interval until ctx is done. Runs never overlap:
+// one lasting longer than interval delays the next, which then starts right
+// away. Each run's outcome is reported through its own results, a failed run
+// doesn't stop the loop. Config reloads, see --config-watch, are applied
+// between runs and take effect from the next one, the interval included.
+func (r *runner) loop(ctx context.Context, interval time.Duration) {
+	for {
+		start := time.Now()
+		if code := r.run(ctx); code != 0 {
+			slog.WarnContext(ctx, "Run failed", "exit_code", code, "next_run_in", max(time.Until(start.Add(interval)), 0).Round(time.Second).String())
+		}
+		r.logRollingSummary(ctx)
+
+		timer := time.NewTimer(time.Until(start.Add(interval)))
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				slog.InfoContext(ctx, "Stopping, no more runs")
+				return
+			case trigger := <-r.reloader.triggered():
+				if r.reloader.reload(ctx, r, trigger) == nil {
+					interval = r.reloader.interval()
+					timer.Reset(time.Until(start.Add(interval)))
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+}
+
+// logRollingSummary logs how the runs of the daemon went so far: how many
+// there were, the ratio of those that succeeded, skipped ones aside, and
+// whether the kind is escalated, see --escalate-after.
+func (r *runner) logRollingSummary(ctx context.Context) {
+	ratio, ok := r.metrics.SuccessRatio(r.kind)
+	if !ok {
+		return
+	}
+	st := r.escalation.Snapshot()[r.kind]
+	slog.InfoContext(ctx, "Rolling summary", "kind", r.kind, "runs", r.metrics.Runs(r.kind), "success_ratio", ratio,
+		"escalated", st.Escalated, "consecutive_failures", st.ConsecutiveFailures)
+}
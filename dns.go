@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runDNS measures how long the cluster DNS takes to resolve a freshly
+// created headless Service to the ready pod it selects, from inside the
+// prober.
+func (p *prober) runDNS(ctx context.Context, ipFamily probe.IPFamily) results.Probe {
+	dnsResult := results.Probe{
+		Kind:    "dns",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+	if ipFamily != probe.IPFamilyPrimary {
+		dnsResult.Attributes["ip_family"] = string(ipFamily)
+	}
+
+	name := fmt.Sprintf("probe-dns-%s", p.instance)
+	labels := p.labels(map[string]string{
+		"app":            "probe-dns",
+		"probe-instance": p.instance,
+	})
+	svc := probe.ServiceOptions{
+		Name:        name,
+		Namespace:   p.namespace,
+		Labels:      labels,
+		Selector:    labels,
+		Annotations: p.annotations(),
+		Port:        80,
+		TargetPort:  80,
+		Headless:    true,
+		IPFamily:    ipFamily,
+
+		FieldManager: *fieldManager,
+	}
+	dnsResult.Attributes["host"] = svc.Host()
+
+	var (
+		created, observed corev1.Pod
+		skew              time.Duration
+	)
+	stages := []probe.Stage{
+		probe.CreatePod(p.clients, probe.PodOptions{
+			Name:            name,
+			Namespace:       p.namespace,
+			Image:           p.cfg.Image,
+			FieldManager:    *fieldManager,
+			Labels:          labels,
+			Annotations:     p.annotations(),
+			Template:        p.cfg.PodTemplate,
+			Mutators:        p.cfg.Mutators,
+			OwnerReferences: p.owners,
+		}, &created, &skew),
+		probe.WaitPodReady(p.clients.Measure, p.tracer, p.namespace, name, p.cfg.PollInterval, &skew, &observed),
+		probe.CreateService(p.clients, svc),
+	}
+
+	// Wait on each requested family in turn, or on the pod's primary IP.
+	families := ipFamily.Families()
+	if families == nil {
+		families = []corev1.IPFamily{""}
+	}
+	for _, family := range families {
+		stages = append(stages, probe.WaitDNS(svc.Host(), &observed, probe.DNSOptions{
+			Interval:    p.cfg.PollInterval,
+			IPFamily:    family,
+			EventBurst:  p.eventBurst(),
+			EventWindow: *pollEventWindow,
+		}))
+	}
+
+	phases, err := probe.RunStages(ctx, p.tracer, stages)
+	dnsResult.Phases = phases
+	dnsResult.Attributes["clock_skew_ms"] = strconv.FormatInt(skew.Milliseconds(), 10)
+	if err != nil {
+		dnsResult.Outcome = probe.OutcomeFor(err)
+		dnsResult.Errors = append(dnsResult.Errors, err.Error())
+	}
+
+	return dnsResult
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runE2E measures how long it takes to deploy an HTTP server like a user
+// would, and have it serve traffic through a Service. With --per-node, the
+// traffic comes from a client pod on the sample's node rather than from the
+// prober, measuring when the node's data path to the Service is programmed.
+func (p *prober) runE2E(ctx context.Context, ipFamily probe.IPFamily, trafficPolicy corev1.ServiceInternalTrafficPolicy) results.Probe {
+	name := fmt.Sprintf("probe-e2e-%s", p.instance)
+	labels := p.labels(map[string]string{
+		"app":            "probe-e2e",
+		"probe-instance": p.instance,
+	})
+	svc := probe.ServiceOptions{
+		Name:          name,
+		Namespace:     p.namespace,
+		Labels:        labels,
+		Selector:      labels,
+		Annotations:   p.annotations(),
+		Port:          80,
+		TargetPort:    probe.DefaultHTTPPort,
+		IPFamily:      ipFamily,
+		TrafficPolicy: trafficPolicy,
+
+		FieldManager: *fieldManager,
+	}
+
+	stages := []probe.Stage{
+		probe.CreateDeployment(p.clients, probe.DeploymentOptions{
+			Name:      name,
+			Namespace: p.namespace,
+			Labels:    labels,
+
+			Annotations:  p.annotations(),
+			FieldManager: *fieldManager,
+		}, time.Second),
+		probe.WaitDeploymentAvailable(p.clients.Measure, p.namespace, name, time.Second),
+		probe.CreateService(p.clients, svc),
+	}
+
+	// Wait on each requested family in turn, or on whichever the cluster
+	// resolves first.
+	families := ipFamily.Families()
+	if families == nil {
+		families = []corev1.IPFamily{""}
+	}
+	var dataPathReady time.Time
+	if p.node != "" {
+		stages = append(stages, probe.WaitDataPath(p.clients, probe.DataPathOptions{
+			Name:         name + "-client",
+			Namespace:    p.namespace,
+			Node:         p.node,
+			URL:          svc.URL(),
+			Labels:       p.labels(map[string]string{"app": "probe-e2e-client", "probe-instance": p.instance}),
+			Annotations:  p.annotations(),
+			Image:        p.cfg.Image,
+			FieldManager: *fieldManager,
+		}, time.Second, &dataPathReady))
+		families = nil
+	}
+	for _, family := range families {
+		stages = append(stages, probe.WaitHTTP(svc.URL(), probe.HTTPOptions{
+			Interval:    500 * time.Millisecond,
+			IPFamily:    family,
+			EventBurst:  p.eventBurst(),
+			EventWindow: *pollEventWindow,
+		}))
+	}
+
+	start := time.Now()
+	phases, err := probe.RunStages(ctx, p.tracer, stages)
+
+	e2eResult := results.Probe{
+		Kind:    "e2e",
+		Outcome: probe.OutcomeFor(err),
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+	if ipFamily != probe.IPFamilyPrimary {
+		e2eResult.Attributes["ip_family"] = string(ipFamily)
+	}
+	if trafficPolicy != "" {
+		e2eResult.Attributes["traffic_policy"] = string(trafficPolicy)
+	}
+	if err != nil {
+		e2eResult.Errors = append(e2eResult.Errors, err.Error())
+	}
+
+	// Startup latency depends heavily on whether the image had to be pulled,
+	// record it so cached and uncached runs can be compared separately.
+	ictx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	cached, known, cacheErr := probe.ImageCached(ictx, p.clients.Cleanup, p.namespace, name+"-")
+	cancel()
+	switch {
+	case cacheErr != nil:
+		p.log.WarnContext(ctx, "Failed to list image pull events, not recording image cache state", "error", cacheErr)
+	case known:
+		e2eResult.Attributes[probe.AttrImageCached] = strconv.FormatBool(cached)
+	}
+
+	// The end-to-end duration spans every stage up to the first successful
+	// HTTP response, teardown excluded.
+	var end time.Time
+	for _, ph := range phases {
+		if !strings.HasPrefix(ph.Name, "teardown-") {
+			end = ph.Start.Add(ph.Duration)
+		}
+	}
+	e2eResult.Phases = append(e2eResult.Phases, results.Phase{
+		Name:     "end-to-end",
+		Start:    start,
+		Duration: end.Sub(start),
+		Outcome:  e2eResult.Outcome,
+	})
+	e2eResult.Phases = append(e2eResult.Phases, phases...)
+	if ph, ok := dataPathPhase(phases, dataPathReady); ok {
+		e2eResult.Phases = append(e2eResult.Phases, ph)
+	}
+
+	return e2eResult
+}
+
+// dataPathPhase returns the data-path-ready phase, from the creation of the
+// Service to the first successful request of the node's client pod, as seen
+// by the node: it is only as accurate as the clock skew between the node and
+// the prober.
+func dataPathPhase(phases []results.Phase, ready time.Time) (results.Phase, bool) {
+	if ready.IsZero() {
+		return results.Phase{}, false
+	}
+	i := slices.IndexFunc(phases, func(ph results.Phase) bool { return ph.Name == "create-service" })
+	if i < 0 {
+		return results.Phase{}, false
+	}
+	created := phases[i].Start.Add(phases[i].Duration)
+	return results.Phase{
+		Name:     "data-path-ready",
+		Start:    created,
+		Duration: max(ready.Sub(created), 0),
+		Outcome:  results.OutcomeSuccess,
+	}, true
+}
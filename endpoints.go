@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runEndpoints measures how long the endpoints controllers take to list a
+// ready pod in the EndpointSlices and Endpoints of a freshly created Service
+// selecting it, the lag behind rollouts sending traffic to pods that are
+// gone or not yet known.
+func (p *prober) runEndpoints(ctx context.Context) results.Probe {
+	epResult := results.Probe{
+		Kind:    "endpoints",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+
+	name := fmt.Sprintf("probe-endpoints-%s", p.instance)
+	labels := p.labels(map[string]string{
+		"app":            "probe-endpoints",
+		"probe-instance": p.instance,
+	})
+	opts := probe.PodOptions{
+		Name:            name,
+		Namespace:       p.namespace,
+		Image:           p.cfg.Image,
+		FieldManager:    *fieldManager,
+		Labels:          labels,
+		Annotations:     p.annotations(),
+		Template:        p.cfg.PodTemplate,
+		Mutators:        p.cfg.Mutators,
+		OwnerReferences: p.owners,
+	}
+
+	var (
+		created, observed corev1.Pod
+		skew              time.Duration
+		propagation       []results.Phase
+	)
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		probe.CreatePod(p.clients, opts, &created, &skew),
+		probe.WaitPodReady(p.clients.Measure, p.tracer, p.namespace, name, p.cfg.PollInterval, &skew, &observed),
+		probe.CreateService(p.clients, probe.ServiceOptions{
+			Name:        name,
+			Namespace:   p.namespace,
+			Labels:      labels,
+			Selector:    labels,
+			Annotations: p.annotations(),
+			Port:        80,
+			TargetPort:  80,
+
+			FieldManager: *fieldManager,
+		}),
+		probe.WaitEndpoints(p.clients.Measure, p.tracer, p.namespace, name, p.cfg.PollInterval, &observed, &propagation),
+	})
+	epResult.Phases = append(phases, propagation...)
+	epResult.Attributes["clock_skew_ms"] = strconv.FormatInt(skew.Milliseconds(), 10)
+	if observed.Spec.NodeName != "" {
+		epResult.Attributes["node"] = observed.Spec.NodeName
+	}
+	if err != nil {
+		epResult.Outcome = probe.OutcomeFor(err)
+		epResult.Errors = append(epResult.Errors, err.Error())
+	}
+
+	return epResult
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var ephemeralNamespace = flag.Bool("ephemeral-namespace", false, "probe in a namespace created for every run and deleted after it, measuring its creation and its deletion, isolating the probes from the workloads of the cluster; needs the permissions of the probes in every namespace")
+
+// validateEphemeralNamespace returns an error unless --ephemeral-namespace
+// can be used with the other flags.
+func validateEphemeralNamespace() error {
+	if !*ephemeralNamespace {
+		return nil
+	}
+	if fansOutNamespaces() {
+		return errors.New("--ephemeral-namespace can't be used with --namespaces or --namespace-selector")
+	}
+	if *operatorMode {
+		return errors.New("--ephemeral-namespace can't be used with --operator, each LatencyProbe probing its own namespace")
+	}
+	return nil
+}
+
+// ephemeralNamespacePermissions returns the permissions the probes of kind
+// need in any namespace, along with those needed to create and delete their
+// namespace.
+func ephemeralNamespacePermissions(kind string) probe.Permissions {
+	perms, ns := probe.ProbePermissions(kind), probe.NamespacePermissions()
+	return probe.Permissions{
+		Measure: slices.Concat(ns.Measure, perms.Measure),
+		Cleanup: slices.Concat(ns.Cleanup, perms.Cleanup),
+	}
+}
+
+// useEphemeralNamespace creates a namespace for the run and switches the
+// prober to probe in it, waiting until pods can be created in it. Owner
+// references don't apply across namespaces, so the probes are no longer owned
+// by the prober's pod. It returns the phases it measured and a function
+// deleting the namespace and returning the phase of the deletion, nil when
+// teardowns are skipped, which must be called even when an error is
+// returned.
+func (p *prober) useEphemeralNamespace(ctx context.Context) ([]results.Phase, func() (*results.Phase, error), error) {
+	opts := probe.NamespaceOptions{
+		Name: fmt.Sprintf("probe-%s", p.runID),
+		Labels: p.labels(map[string]string{
+			"app": "probe",
+		}),
+		Annotations:  p.annotations(),
+		FieldManager: *fieldManager,
+	}
+
+	created := false
+	teardown := func() (*results.Phase, error) {
+		if !created {
+			return nil, nil
+		}
+		if probe.TeardownSkipped(ctx) {
+			p.log.InfoContext(ctx, "Leaving the ephemeral namespace behind", "ephemeral_namespace", opts.Name)
+			return nil, nil
+		}
+		// The namespace must go even if the run's context is done
+		ph, err := probe.RunStage(context.WithoutCancel(ctx), p.tracer, probe.DeleteNamespace(p.clients.Cleanup, opts.Name, p.cfg.PollInterval))
+		return &ph, err
+	}
+
+	var phases []results.Phase
+	ph, err := probe.RunStage(ctx, p.tracer, probe.CreateNamespace(p.clients, opts))
+	phases = append(phases, ph)
+	if err != nil {
+		return phases, teardown, fmt.Errorf("create-namespace: %w", err)
+	}
+	created = true
+
+	ph, err = probe.RunStage(ctx, p.tracer, probe.WaitNamespaceReady(p.clients.Measure, opts.Name, p.cfg.PollInterval))
+	phases = append(phases, ph)
+	if err != nil {
+		return phases, teardown, fmt.Errorf("wait-namespace-ready: %w", err)
+	}
+
+	p.namespace = opts.Name
+	p.log = probeLogger(p.runID, p.instance, p.namespace, p.kind, p.cluster)
+	p.owners = nil
+	return phases, teardown, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	escalateAfter      = flag.Int("escalate-after", 5, "number of consecutive failed runs of a probe kind after which its runs record every request, poll attempt and pod Event and write failure artifacts; 0 disables escalation")
+	escalateClearAfter = flag.Int("escalate-clear-after", 3, "number of consecutive successful runs after which an escalated probe kind goes back to the configured diagnostics")
+)
+
+// escalationArtifactsDir is where escalated runs write their failure
+// artifacts when --artifacts-dir isn't set.
+var escalationArtifactsDir = filepath.Join(os.TempDir(), "k8s-latency-probe-artifacts")
+
+// newEscalation returns the escalation tracker configured by
+// --escalate-after and --escalate-clear-after, or nil when disabled.
+func newEscalation() (*probe.Escalation, error) {
+	if *escalateAfter < 0 || *escalateClearAfter < 1 {
+		return nil, fmt.Errorf("--escalate-after must not be negative and --escalate-clear-after must be at least 1, got %d and %d", *escalateAfter, *escalateClearAfter)
+	}
+	if *escalateAfter == 0 {
+		return nil, nil
+	}
+	return probe.NewEscalation(*escalateAfter, *escalateClearAfter), nil
+}
+
+// kindOutcomes returns the outcome of each kind of probe of a run: the first
+// failure among its probes, success if they all succeeded, or the skip if
+// none was attempted.
+func kindOutcomes(probes []results.Probe) map[string]results.Outcome {
+	out := map[string]results.Outcome{}
+	for _, pr := range probes {
+		current, seen := out[pr.Kind]
+		switch {
+		case !seen, current.Skipped() && !pr.Outcome.Skipped():
+			out[pr.Kind] = pr.Outcome
+		case current == results.OutcomeSuccess && pr.Outcome.Attempted() && pr.Outcome != results.OutcomeSuccess:
+			out[pr.Kind] = pr.Outcome
+		}
+	}
+	return out
+}
+
+// recordEscalation records the outcome of the run's probes in the escalation
+// streaks, logging each transition and emitting it as an Event.
+func (p *prober) recordEscalation(ctx context.Context, probes []results.Probe) {
+	if p.escalation == nil {
+		return
+	}
+	outcomes := kindOutcomes(probes)
+	for _, kind := range slices.Sorted(maps.Keys(outcomes)) {
+		t := p.escalation.Record(kind, outcomes[kind])
+		if t == probe.TransitionNone {
+			continue
+		}
+		st := p.escalation.Snapshot()[kind]
+		if t == probe.TransitionEscalated {
+			p.log.WarnContext(ctx, "Probe escalated, collecting every diagnostic", "kind", kind, "consecutive_failures", st.ConsecutiveFailures)
+		} else {
+			p.log.InfoContext(ctx, "Probe deescalated", "kind", kind, "consecutive_successes", st.ConsecutiveSuccesses)
+		}
+		ectx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := probe.RecordTransition(ectx, p.clients.Cleanup, p.namespace, kind, t, st); err != nil {
+			p.log.WarnContext(ctx, "Failed to record escalation event", "kind", kind, "error", err)
+		}
+		cancel()
+	}
+}
+
+// eventBurst returns the number of poll attempts recorded as individual span
+// events, all of them while escalated.
+func (p *prober) eventBurst() int {
+	if p.escalated {
+		return math.MaxInt
+	}
+	return *pollEventBurst
+}
+
+// watchesPodEvents reports whether the Events involving the probe pods are
+// recorded, always while escalated.
+func (p *prober) watchesPodEvents() bool {
+	return *podEvents || p.escalated
+}
+
+// failureArtifactsDir returns the directory failure artifacts are written to, if
+// any: --artifacts-dir, or a temporary one while escalated.
+func failureArtifactsDir(escalated bool) string {
+	if *artifactsDir == "" && escalated {
+		return escalationArtifactsDir
+	}
+	return *artifactsDir
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+var resultEvents = flag.Bool("result-events", false, "emit a ProbeSucceeded, ProbeThresholdExceeded or ProbeFailed Event with the phases' durations after each probe, on the prober's pod, or on the LatencyProbe in operator mode")
+
+// proberReference returns a reference to the prober's own pod, running in
+// podNamespace, which the result Events are about. Without the pod's name
+// and UID, they are about the probes' namespace instead.
+func proberReference(podNamespace, namespace string) corev1.ObjectReference {
+	name, uid := os.Getenv(podNameEnv), os.Getenv(podUIDEnv)
+	if name == "" || uid == "" || podNamespace == "" {
+		return probe.NamespaceReference(namespace)
+	}
+	return corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  podNamespace,
+		Name:       name,
+		UID:        types.UID(uid),
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+var (
+	healthAddr   = flag.String("health-addr", "", "address on which /healthz and /readyz are served, e.g. :8081; disabled when empty")
+	healthMaxAge = flag.Duration("health-max-age", 0, "age past which the last run makes /healthz, and the last successful run /readyz, fail; defaults to twice --interval plus --timeout")
+)
+
+// health tracks the runs of the prober, and the health of its exporters, to
+// report them on /healthz and /readyz.
+type health struct {
+	started    time.Time
+	maxAge     time.Duration
+	export     *telemetry.ExportHealth
+	escalation *probe.Escalation
+
+	mu          sync.Mutex
+	lastRun     time.Time
+	lastSuccess time.Time
+	// standby is set while another replica is the leader, see
+	// --leader-elect: no run is expected then.
+	standby bool
+}
+
+// healthStatus is the body of the /healthz and /readyz responses.
+type healthStatus struct {
+	Status      string                 `json:"status"`
+	Reasons     []string               `json:"reasons,omitempty"`
+	LastRun     time.Time              `json:"last_run,omitzero"`
+	LastSuccess time.Time              `json:"last_success,omitzero"`
+	Standby     bool                   `json:"standby,omitempty"`
+	Exporter    telemetry.ExportStatus `json:"exporter"`
+
+	// Escalation is the escalation state of every probe kind run so far,
+	// on /readyz.
+	Escalation map[string]probe.EscalationState `json:"escalation,omitempty"`
+}
+
+func newHealth(maxAge time.Duration, export *telemetry.ExportHealth, escalation *probe.Escalation) *health {
+	return &health{started: time.Now(), maxAge: maxAge, export: export, escalation: escalation}
+}
+
+// record records the end of a run, successful when its exit code is 0. A nil
+// *health records nothing.
+func (h *health) record(code int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastRun = time.Now()
+	if code == 0 {
+		h.lastSuccess = h.lastRun
+	}
+}
+
+// setStandby records whether another replica is the leader. Becoming the
+// leader starts the clock again, as if the prober just started.
+func (h *health) setStandby(standby bool) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.standby && !standby {
+		h.started = time.Now()
+	}
+	h.standby = standby
+}
+
+// status returns the state of the prober. It is live as long as runs keep
+// ending, whatever their outcome, and ready as long as they keep succeeding
+// and the exporters keep exporting. Both count from startup, or from
+// becoming the leader, until the first run ends. A standby replica is both
+// as long as its exporters are healthy.
+func (h *health) status(now time.Time) (live, ready healthStatus) {
+	h.mu.Lock()
+	started, lastRun, lastSuccess, standby := h.started, h.lastRun, h.lastSuccess, h.standby
+	h.mu.Unlock()
+
+	base := healthStatus{
+		LastRun:     lastRun,
+		LastSuccess: lastSuccess,
+		Standby:     standby,
+		Exporter:    h.export.Status(),
+	}
+	live, ready = base, base
+	ready.Escalation = h.escalation.Snapshot()
+	if standby {
+		if !ready.Exporter.Healthy {
+			ready.Reasons = append(ready.Reasons, "the latest export failed")
+		}
+		return live, ready
+	}
+	if now.Sub(latest(started, lastRun)) > h.maxAge {
+		live.Reasons = append(live.Reasons, "no run ended within "+h.maxAge.String())
+	}
+	if now.Sub(latest(started, lastSuccess)) > h.maxAge {
+		ready.Reasons = append(ready.Reasons, "no successful run within "+h.maxAge.String())
+	}
+	if !ready.Exporter.Healthy {
+		ready.Reasons = append(ready.Reasons, "the latest export failed")
+	}
+	return live, ready
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// healthz serves the liveness status, on /healthz.
+func (h *health) healthz(w http.ResponseWriter, _ *http.Request) {
+	live, _ := h.status(time.Now())
+	writeHealthStatus(w, live)
+}
+
+// readyz serves the readiness status, on /readyz.
+func (h *health) readyz(w http.ResponseWriter, _ *http.Request) {
+	_, ready := h.status(time.Now())
+	writeHealthStatus(w, ready)
+}
+
+// writeHealthStatus writes s, with a 503 status code when it has reasons to
+// fail.
+func writeHealthStatus(w http.ResponseWriter, s healthStatus) {
+	code := http.StatusOK
+	s.Status = "ok"
+	if len(s.Reasons) > 0 {
+		code = http.StatusServiceUnavailable
+		s.Status = "failing"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(s)
+}
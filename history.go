@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	resultsConfigMap = flag.String("results-configmap", "", "name of a ConfigMap in the probes' namespace where the last --results-history probe results are kept; disabled when empty")
+	resultsHistory   = flag.Int("results-history", 10, "number of most recent probe results kept in --results-configmap and in the status of LatencyProbe resources")
+)
+
+// historyKey is the key of the results in the --results-configmap.
+const historyKey = "results.json"
+
+// nodeCoverageKey is the key of the --per-node rotation state in the
+// --results-configmap: the names of the nodes already sampled in the current
+// rotation, see probe.NodeSampler.Covered.
+const nodeCoverageKey = "node-coverage.json"
+
+// probeRecord is the summary of a probe's result kept in the results
+// history, for in-cluster consumers without access to the traces.
+type probeRecord struct {
+	RunID   string          `json:"runID"`
+	Time    metav1.Time     `json:"time"`
+	Probe   string          `json:"probe"`
+	Outcome results.Outcome `json:"outcome"`
+	Success bool            `json:"success"`
+	Phases  []phaseRecord   `json:"phases,omitempty"`
+}
+
+// phaseRecord is the summary of a phase of a probeRecord.
+type phaseRecord struct {
+	Name     string          `json:"name"`
+	Duration metav1.Duration `json:"duration"`
+	Outcome  results.Outcome `json:"outcome"`
+}
+
+// probeRecords summarizes the results of the probes of run.
+func probeRecords(run results.Run) []probeRecord {
+	records := make([]probeRecord, 0, len(run.Probes))
+	for _, pr := range run.Probes {
+		rec := probeRecord{
+			RunID:   run.ID,
+			Time:    metav1.NewTime(run.Start),
+			Probe:   pr.Kind,
+			Outcome: pr.Outcome,
+			Success: pr.Outcome == results.OutcomeSuccess,
+		}
+		for _, ph := range pr.Phases {
+			rec.Phases = append(rec.Phases, phaseRecord{
+				Name:     ph.Name,
+				Duration: metav1.Duration{Duration: ph.Duration},
+				Outcome:  ph.Outcome,
+			})
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// appendHistory returns history with records appended, keeping only the
+// size most recent.
+func appendHistory(history []probeRecord, size int, records ...probeRecord) []probeRecord {
+	history = append(history, records...)
+	return history[max(len(history)-size, 0):]
+}
+
+// historyConfigMap keeps the most recent probe results in a ConfigMap, as a
+// JSON array under historyKey, oldest first. A nil *historyConfigMap keeps
+// nothing.
+type historyConfigMap struct {
+	name string
+	size int
+}
+
+func newHistoryConfigMap(name string, size int) *historyConfigMap {
+	if name == "" {
+		return nil
+	}
+	return &historyConfigMap{name: name, size: size}
+}
+
+// record adds the results of run to the ConfigMap in namespace, creating it
+// if needed.
+func (h *historyConfigMap) record(ctx context.Context, client kubernetes.Interface, namespace string, run results.Run) error {
+	if h == nil {
+		return nil
+	}
+	return h.update(ctx, client, namespace, func(cm *corev1.ConfigMap) error {
+		var history []probeRecord
+		if data := cm.Data[historyKey]; data != "" {
+			if err := json.Unmarshal([]byte(data), &history); err != nil {
+				return fmt.Errorf("invalid %s in ConfigMap %s: %w", historyKey, h.name, err)
+			}
+		}
+		data, err := json.Marshal(appendHistory(history, h.size, probeRecords(run)...))
+		if err != nil {
+			return err
+		}
+		cm.Data[historyKey] = string(data)
+		return nil
+	})
+}
+
+// nodeCoverage returns the nodes covered by the current --per-node rotation
+// as last saved in the ConfigMap in namespace with saveNodeCoverage, and
+// false if none was.
+func (h *historyConfigMap) nodeCoverage(ctx context.Context, client kubernetes.Interface, namespace string) ([]string, bool, error) {
+	if h == nil {
+		return nil, false, nil
+	}
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, h.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	data, ok := cm.Data[nodeCoverageKey]
+	if !ok {
+		return nil, false, nil
+	}
+	var covered []string
+	if err := json.Unmarshal([]byte(data), &covered); err != nil {
+		return nil, false, fmt.Errorf("invalid %s in ConfigMap %s: %w", nodeCoverageKey, h.name, err)
+	}
+	return covered, true, nil
+}
+
+// saveNodeCoverage saves the nodes covered by the current --per-node
+// rotation in the ConfigMap in namespace, creating it if needed.
+func (h *historyConfigMap) saveNodeCoverage(ctx context.Context, client kubernetes.Interface, namespace string, covered []string) error {
+	if h == nil {
+		return nil
+	}
+	data, err := json.Marshal(covered)
+	if err != nil {
+		return err
+	}
+	return h.update(ctx, client, namespace, func(cm *corev1.ConfigMap) error {
+		cm.Data[nodeCoverageKey] = string(data)
+		return nil
+	})
+}
+
+// update applies fn to the ConfigMap in namespace, created if needed, and
+// writes it back, retrying on conflicts.
+func (h *historyConfigMap) update(ctx context.Context, client kubernetes.Interface, namespace string, fn func(*corev1.ConfigMap) error) error {
+	configMaps := client.CoreV1().ConfigMaps(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, h.name, metav1.GetOptions{})
+		exists := err == nil
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: h.name, Namespace: namespace}}
+		} else if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if err := fn(cm); err != nil {
+			return err
+		}
+
+		if !exists {
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{FieldManager: *fieldManager})
+			if apierrors.IsAlreadyExists(err) {
+				// Created concurrently, start over
+				return apierrors.NewConflict(corev1.Resource("configmaps"), h.name, err)
+			}
+			return err
+		}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{FieldManager: *fieldManager})
+		return err
+	})
+}
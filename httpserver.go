@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// httpRoutes maps addresses to the handlers served on them, by pattern, so
+// that endpoints given the same address share a server.
+type httpRoutes map[string]map[string]http.Handler
+
+func (r httpRoutes) handle(addr, pattern string, handler http.Handler) {
+	if r[addr] == nil {
+		r[addr] = make(map[string]http.Handler)
+	}
+	r[addr][pattern] = handler
+}
+
+// serve serves every route until the returned function is called. Failing
+// to listen is fatal, serving errors are only reported.
+func (r httpRoutes) serve() (stop func()) {
+	var stops []func()
+	for _, addr := range slices.Sorted(maps.Keys(r)) {
+		stops = append(stops, serveHTTP(addr, r[addr]))
+	}
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// serveHTTP serves the handlers, by pattern, at addr until the returned
+// function is called.
+func serveHTTP(addr string, handlers map[string]http.Handler) (stop func()) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("Failed to listen", "addr", addr, "error", err)
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server failed", "addr", addr, "error", err)
+		}
+	}()
+	slog.Info("Serving HTTP", "addr", ln.Addr().String(), "endpoints", slices.Sorted(maps.Keys(handlers)))
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("Failed to shutdown HTTP server", "addr", addr, "error", err)
+		}
+	}
+}
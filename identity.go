@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// useEphemeralIdentity creates a throwaway ServiceAccount, optionally bound
+// with a RoleBinding, and switches the prober to measure as that identity.
+// Cleanup keeps using the prober's own identity. It returns the phases it
+// measured and a teardown function deleting everything it created, which
+// must be called even when an error is returned.
+func (p *prober) useEphemeralIdentity(ctx context.Context, config *rest.Config) ([]results.Phase, func(), error) {
+	opts := probe.IdentityOptions{
+		Name:      fmt.Sprintf("probe-%s", p.instance),
+		Namespace: p.namespace,
+		Labels: p.labels(map[string]string{
+			"app":            "probe",
+			"probe-instance": p.instance,
+		}),
+		Annotations:     p.annotations(),
+		TokenExpiration: time.Hour,
+		FieldManager:    *fieldManager,
+	}
+	switch {
+	case *ephemeralRoleBinding != "":
+		rb, err := probe.RoleBindingFromFile(*ephemeralRoleBinding)
+		if err != nil {
+			return nil, func() {}, err
+		}
+		opts.RoleBinding = rb
+	case *ephemeralClusterRole != "":
+		opts.RoleBinding = probe.ClusterRoleBinding(*ephemeralClusterRole)
+	}
+
+	own := p.clients.Cleanup
+	stages := []probe.Stage{probe.CreateServiceAccount(own, opts)}
+	if opts.RoleBinding != nil {
+		stages = append(stages, probe.CreateRoleBinding(own, opts))
+	}
+
+	var started []probe.Stage
+	teardown := func() {
+		if probe.TeardownSkipped(ctx) {
+			p.log.InfoContext(ctx, "Leaving the ephemeral identity behind")
+			return
+		}
+		tctx := context.WithoutCancel(ctx)
+		for i := len(started) - 1; i >= 0; i-- {
+			tctx, cancel := context.WithTimeout(tctx, probe.TeardownTimeout)
+			if err := started[i].Teardown(tctx); err != nil {
+				p.log.ErrorContext(ctx, "Failed to tear down", "stage", started[i].Name, "error", err)
+			}
+			cancel()
+		}
+	}
+
+	var token string
+	stages = append(stages, probe.RequestToken(own, opts, &token))
+
+	var phases []results.Phase
+	for _, s := range stages {
+		if s.Teardown != nil {
+			started = append(started, s)
+		}
+		ph, err := probe.RunStage(ctx, p.tracer, s)
+		phases = append(phases, ph)
+		if err != nil {
+			return phases, teardown, fmt.Errorf("%s: %w", s.Name, err)
+		}
+	}
+
+	client, err := probe.TokenClient(config, token)
+	if err != nil {
+		return phases, teardown, fmt.Errorf("failed to create clientset for %s: %w", opts.Name, err)
+	}
+
+	ph, err := probe.RunStage(ctx, p.tracer, probe.FirstUse(client, p.namespace, p.cfg.PollInterval))
+	phases = append(phases, ph)
+	if err != nil {
+		return phases, teardown, fmt.Errorf("first-use: %w", err)
+	}
+
+	p.clients.Measure = client
+	return phases, teardown, nil
+}
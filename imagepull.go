@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runImagePull measures image pulls apart from the rest of pod startup: a
+// first pod always pulls its image, the kubelet's Events giving the pull's
+// duration, then a second pod on the same node starts from the image it
+// left in the node's cache. Comparing their container-start phases gives the
+// cost of the pull as seen by workloads.
+func (p *prober) runImagePull(ctx context.Context) results.Probe {
+	pullResult := results.Probe{
+		Kind:    "image-pull",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+			"image":     p.cfg.Image,
+		},
+	}
+
+	podOpts := func(name string, mutators ...probe.PodMutator) probe.PodOptions {
+		return probe.PodOptions{
+			Name:         name,
+			Namespace:    p.namespace,
+			Image:        p.cfg.Image,
+			FieldManager: *fieldManager,
+			Labels: p.labels(map[string]string{
+				"app": "probe",
+			}),
+			Annotations:     p.annotations(),
+			Template:        p.cfg.PodTemplate,
+			Mutators:        slices.Concat(p.cfg.Mutators, mutators),
+			OwnerReferences: p.owners,
+		}
+	}
+	pullOpts := podOpts(fmt.Sprintf("probe-pull-%s", p.instance), probe.PullAlways)
+	var pulled, cached corev1.Pod
+	cachedOpts := podOpts(fmt.Sprintf("probe-cached-%s", p.instance), func(pod *corev1.Pod) error {
+		// Bypass the scheduler to land where the image was just pulled
+		pod.Spec.NodeName = pulled.Spec.NodeName
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].ImagePullPolicy = corev1.PullIfNotPresent
+		}
+		return nil
+	})
+
+	var (
+		skew               time.Duration
+		pulledAt, cachedAt time.Time
+		pull               []results.Phase
+	)
+	// Both pods go through the same stages, named after the pod
+	createPull := probe.CreatePod(p.clients, pullOpts, new(corev1.Pod), &skew)
+	createPull.Name = "create-pull-pod"
+	waitPull := probe.WaitPodRunning(p.clients.Measure, p.namespace, pullOpts.Name, p.cfg.PollInterval, &pulled, &pulledAt)
+	waitPull.Name = "wait-pull-running"
+	createCached := probe.CreatePod(p.clients, cachedOpts, new(corev1.Pod), new(time.Duration))
+	createCached.Name = "create-cached-pod"
+	waitCached := probe.WaitPodRunning(p.clients.Measure, p.namespace, cachedOpts.Name, p.cfg.PollInterval, &cached, &cachedAt)
+	waitCached.Name = "wait-cached-running"
+
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		createPull,
+		waitPull,
+		probe.WaitImagePulled(p.clients.Measure, p.tracer, p.namespace, pullOpts.Name, p.cfg.PollInterval, &skew, &pull),
+		createCached,
+		waitCached,
+	})
+	pullResult.Phases = append(phases, pull...)
+	pullResult.Attributes["clock_skew_ms"] = strconv.FormatInt(skew.Milliseconds(), 10)
+	if pulled.Spec.NodeName != "" {
+		pullResult.Attributes["node"] = pulled.Spec.NodeName
+	}
+	if err != nil {
+		pullResult.Outcome = probe.OutcomeFor(err)
+		pullResult.Errors = append(pullResult.Errors, err.Error())
+		return pullResult
+	}
+
+	for _, pod := range []struct {
+		pod    *corev1.Pod
+		at     time.Time
+		suffix string
+	}{{&pulled, pulledAt, ""}, {&cached, cachedAt, "-cached"}} {
+		derived, _ := probe.StatusPhases(pod.pod, pod.at, skew)
+		for _, ph := range derived {
+			if ph.Name == "container-start" {
+				ph.Name += pod.suffix
+				pullResult.Phases = append(pullResult.Phases, ph)
+			}
+		}
+	}
+	return pullResult
+}
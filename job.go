@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runJob measures how long a short-lived Job takes to get its pod created by
+// the job controller, started, and its completion reported back on the Job,
+// a different controller path than bare pods.
+func (p *prober) runJob(ctx context.Context) results.Probe {
+	jobResult := results.Probe{
+		Kind:    "job",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+
+	name := fmt.Sprintf("probe-job-%s", p.instance)
+	var (
+		pod         corev1.Pod
+		skew        time.Duration
+		propagation []results.Phase
+	)
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		probe.CreateJob(p.clients, probe.JobOptions{
+			Name:      name,
+			Namespace: p.namespace,
+			Labels: p.labels(map[string]string{
+				"app":            "probe-job",
+				"probe-instance": p.instance,
+			}),
+			Annotations:  p.annotations(),
+			Image:        p.cfg.Image,
+			FieldManager: *fieldManager,
+		}, time.Second, &skew),
+		probe.WaitJobPod(p.clients.Measure, p.namespace, name, p.cfg.PollInterval, &pod),
+		probe.WaitJobPodStarted(p.clients.Measure, &pod, p.cfg.PollInterval),
+		probe.WaitJobComplete(p.clients.Measure, p.tracer, p.namespace, name, p.cfg.PollInterval, &pod, &skew, &propagation),
+	})
+	jobResult.Phases = append(phases, propagation...)
+	jobResult.Attributes["clock_skew_ms"] = strconv.FormatInt(skew.Milliseconds(), 10)
+	if pod.Spec.NodeName != "" {
+		jobResult.Attributes["node"] = pod.Spec.NodeName
+	}
+	for _, ev := range probe.PodEvents(&pod) {
+		jobResult.AddEvent(ev.Name, ev.Time.Add(-skew))
+	}
+	if pod.Name != "" {
+		probe.RecordPodLifecycle(ctx, &pod, skew)
+	}
+	if err != nil {
+		jobResult.Outcome = probe.OutcomeFor(err)
+		jobResult.Errors = append(jobResult.Errors, err.Error())
+	}
+
+	return jobResult
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var (
+	leaderElect              = flag.Bool("leader-elect", false, "with --interval, --schedule or --operator, only probe while holding a Lease in the prober's namespace, so that a single replica probes at a time while the others stand by")
+	leaderElectLeaseDuration = flag.Duration("leader-elect-lease-duration", 15*time.Second, "duration of the --leader-elect Lease, after which a standby replica takes over from a leader that stopped renewing it")
+)
+
+// leaderLeaseName is the name of the Lease used by --leader-elect, distinct
+// from the one used by --exclusive, which is held for single runs.
+const leaderLeaseName = "k8s-latency-probe-leader"
+
+// lead runs work whenever this replica holds the leader Lease in namespace,
+// until ctx is done. The context given to work is done as soon as the Lease
+// is lost, and the Lease is only campaigned for again once work returned, so
+// that a replica never has two of them in flight.
+func (r *runner) lead(ctx context.Context, namespace string, work func(context.Context)) error {
+	identity := must(newID())
+	if host, err := os.Hostname(); err == nil {
+		identity = host + "_" + identity
+	}
+	log := slog.With("lease", namespace+"/"+leaderLeaseName, "identity", identity)
+
+	// Held for as long as work runs, see above.
+	var working sync.Mutex
+	for ctx.Err() == nil {
+		r.health.setStandby(true)
+		var leading atomic.Bool
+		returned := make(chan struct{})
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock: &resourcelock.LeaseLock{
+				LeaseMeta:  metav1.ObjectMeta{Name: leaderLeaseName, Namespace: namespace},
+				Client:     r.clientset.CoordinationV1(),
+				LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+			},
+			Name:            leaderLeaseName,
+			LeaseDuration:   *leaderElectLeaseDuration,
+			RenewDeadline:   *leaderElectLeaseDuration * 2 / 3,
+			RetryPeriod:     *leaderElectLeaseDuration / 5,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					defer close(returned)
+					working.Lock()
+					defer working.Unlock()
+					if ctx.Err() != nil {
+						return
+					}
+					leading.Store(true)
+					log.InfoContext(ctx, "Became the leader, probing")
+					r.health.setStandby(false)
+					work(ctx)
+				},
+				OnStoppedLeading: func() {
+					if leading.Load() {
+						log.InfoContext(ctx, "No longer the leader")
+					}
+				},
+				OnNewLeader: func(leader string) {
+					if leader != identity {
+						log.InfoContext(ctx, "Standing by", "leader", leader)
+					}
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		elector.Run(ctx)
+		// Run only returns without having led once ctx is done, work is
+		// then waited for below
+		if ctx.Err() == nil {
+			<-returned
+		}
+	}
+
+	// Wait for the last runs to end
+	working.Lock()
+	return nil
+}
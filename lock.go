@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// lockName is the name of the Lease used by --exclusive.
+const lockName = "k8s-latency-probe"
+
+// newLock returns the exclusive lock of the prober's namespace, held for a
+// whole run.
+func (p *prober) newLock() *probe.Lock {
+	holder := p.runID
+	if host, err := os.Hostname(); err == nil {
+		holder = host + "/" + p.runID
+	}
+	return &probe.Lock{
+		Client:       p.clients.Cleanup,
+		Namespace:    p.namespace,
+		Name:         lockName,
+		Holder:       holder,
+		Duration:     p.cfg.RunTimeout + probe.ExpiryMargin,
+		FieldManager: *fieldManager,
+	}
+}
+
+// acquireLock takes the exclusive lock, waiting up to --exclusive-wait for
+// another run to release it. The time spent is recorded as its own span and
+// in the probe.lock_wait.duration histogram, so that contention between
+// overlapping runs doesn't go unnoticed.
+func (p *prober) acquireLock(ctx context.Context, lock *probe.Lock) (results.LockWait, error) {
+	ctx, span := p.tracer.Start(ctx, "prober.acquire-lock")
+	defer span.End()
+	p.status.SetPhase("acquire-lock")
+
+	last := ""
+	wait, err := lock.Acquire(ctx, *exclusiveWait, time.Second, func(holder string) {
+		if holder != last {
+			p.log.InfoContext(ctx, "Lock is held, waiting", "lock", lock.Namespace+"/"+lock.Name, "holder", holder)
+			span.AddEvent("lock held", trace.WithAttributes(attribute.String("lock.holder", holder)))
+			last = holder
+		}
+	})
+
+	span.SetAttributes(
+		attribute.Bool("lock.contended", wait.Contended),
+		attribute.String("lock.holder", wait.Holder),
+	)
+	p.metrics.RecordLockWait(ctx, wait)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return wait, err
+}
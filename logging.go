@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	logFormat = flag.String("log-format", "text", "format of the logs written to stderr, either text or json")
+	logLevel  = flag.String("log-level", "info", "minimum level of the logs written, one of debug, info, warn or error")
+)
+
+// newLogger returns the logger writing to w in the given format and level.
+// Records logged with a context carrying a span get its trace and span IDs,
+// so that logs can be correlated with traces.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid --log-level %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid --log-format %q, must be text or json", format)
+	}
+	return slog.New(traceHandler{h}), nil
+}
+
+// traceHandler adds the trace and span IDs of the span in the record's
+// context to the records it handles.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// probeLogger returns the logger of a probe, adding its run ID, instance ID,
+// namespace and kind to every line, and its cluster when probing several.
+func probeLogger(runID, instance, namespace, kind, cluster string) *slog.Logger {
+	log := slog.With("run_id", runID, "instance", instance, "namespace", namespace, "probe", kind)
+	if cluster != "" {
+		log = log.With("cluster", cluster)
+	}
+	return log
+}
+
+// durationMS returns d as a log attribute in fractional milliseconds, the
+// unit of the phase durations everywhere else.
+func durationMS(key string, d time.Duration) slog.Attr {
+	return slog.Float64(key, float64(d.Microseconds())/1000)
+}
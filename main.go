@@ -4,146 +4,1259 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/robfig/cron/v3"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/sdk/resource"
-	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+	"go.wperron.io/k8slatencyprobe/pkg/store"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+// errSpanAbandoned is recorded on the spans still open when the run ends.
+var errSpanAbandoned = errors.New("span still open when the run ended")
+
+// The prober's namespace is read from namespaceEnv, or namespaceFile when it
+// is not set.
+const (
+	namespaceEnv  = telemetry.EnvNamespaceName
+	namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// probeKinds lists the kinds of probes the prober can run: the built-in ones,
+// then those registered by the packages compiled in, see probe.Register.
+var probeKinds = withRegisteredKinds("pod", "pod-status", "pod-ready", "endpoints", "e2e", "configmap", "secret", "configmap-mount", "dns", "pvc", "job", "image-pull", "scheduler", "preemption", "verbs", "reads", "namespace-deletion", "webhook-overhead", "rbac-propagation", "token-request")
+
+// withRegisteredKinds returns the built-in kinds followed by the registered
+// ones, panicking if one of those is named like a built-in kind.
+func withRegisteredKinds(builtin ...string) []string {
+	for _, name := range probe.RegisteredNames() {
+		if slices.Contains(builtin, name) {
+			panic(fmt.Sprintf("registered probe %q has the name of a built-in probe", name))
+		}
+		builtin = append(builtin, name)
+	}
+	return builtin
+}
+
+var (
+	probeKind     = flag.String("probe", "pod", "kind of probe to run, one of "+strings.Join(probeKinds, ", "))
+	interval      = flag.Duration("interval", 0, "run the probe every interval until stopped instead of once; each run is its own trace and results document")
+	resultsSchema = flag.Int("results-schema", results.SchemaVersion, "version of the JSON results schema to write (1 is deprecated)")
+	exporter      = flag.String("exporter", "", "telemetry exporter to use, one of otlp, stdout (written to stderr) or none; defaults to $OTEL_TRACES_EXPORTER, or otlp")
+	otlpProtocol  = flag.String("otlp-protocol", "", "OTLP transport, one of grpc or http/protobuf; defaults to $OTEL_EXPORTER_OTLP_PROTOCOL, or grpc")
+	clusterName   = flag.String("cluster-name", "", "name of the cluster, recorded as the k8s.cluster.name resource attribute to tell the telemetry of several clusters apart; defaults to $K8S_CLUSTER_NAME")
+	otlpEndpoint  = flag.String("otlp-endpoint", "", "URL (or host:port, over TLS) of the OTLP endpoint, overriding $OTEL_EXPORTER_OTLP_ENDPOINT")
+	metricsAddr   = flag.String("metrics-addr", "", "address on which Prometheus metrics are served on /metrics, e.g. :9090; disabled when empty")
+
+	fieldManager = flag.String("field-manager", "k8s-latency-probe", "field manager set on every write made by the probes")
+
+	detection = flag.String("detection", probe.DetectionWatch, "how the pod probe detects the label change, either watch (a Watch on the label selector) or poll (List calls, for comparison)")
+
+	mutateFrom = flag.String("mutate-from", "", "path to a YAML strategic merge patch applied to the probe pod before it is created")
+
+	pauseAnnotation = flag.String("pause-annotation", "", "annotation (or ConfigMap key with --pause-configmap) on the prober's namespace holding a pause expression")
+	pauseConfigMap  = flag.String("pause-configmap", "", "name of a ConfigMap in the prober's namespace holding the --pause-annotation key")
+	pauseCron       = flag.String("pause-cron", "", "cron expression of a recurring pause window, e.g. \"0 2 * * 6\"")
+	pauseDuration   = flag.Duration("pause-duration", time.Hour, "duration of each recurring pause window started by --pause-cron")
+
+	requireCleanupRBAC = flag.Bool("require-cleanup-rbac", false, "fail instead of warning when the permissions needed to clean up are missing")
+	printRBAC          = flag.Bool("print-rbac", false, "print a ClusterRole granting every permission the probes need and exit")
+
+	artifactsDir          = flag.String("artifacts-dir", "", "directory where a debugging bundle is written for each failed run")
+	artifactsRetention    = flag.Int("artifacts-retention", 10, "maximum number of failure bundles kept in --artifacts-dir")
+	artifactsObservations = flag.Int("artifacts-observations", 50, "number of most recent poll observations included in failure bundles")
+
+	payloadSize  = flag.String("payload-size", "0", "size of the random payload written by the configmap and secret probes, e.g. 64KiB")
+	payloadSweep = flag.String("payload-sweep", "", "comma-separated payload sizes measured in turn by the configmap and secret probes, overrides --payload-size")
+
+	ipFamilyFlag = flag.String("ip-family", "", "address families the HTTP and DNS probes connect over, one of ipv4, ipv6 or dual; defaults to the cluster's primary family")
+
+	trafficPolicyFlag = flag.String("traffic-policy", "", "internal traffic policy of the Service created by the e2e probe, one of local or cluster")
+
+	exclusive     = flag.Bool("exclusive", false, "hold a Lease in the prober's namespace for the whole run, so that overlapping runs never measure concurrently")
+	exclusiveWait = flag.Duration("exclusive-wait", time.Minute, "how long to wait for another run to release the --exclusive lock before skipping")
+
+	showProgress = flag.Bool("progress", false, "render live progress of the run on stderr")
+
+	ephemeralSA          = flag.Bool("ephemeral-serviceaccount", false, "measure as a throwaway ServiceAccount created for the run instead of the prober's own identity")
+	ephemeralClusterRole = flag.String("ephemeral-clusterrole", "prober", "ClusterRole bound to the ephemeral ServiceAccount in the prober's namespace, empty for none")
+	ephemeralRoleBinding = flag.String("ephemeral-rolebinding-template", "", "path to a YAML RoleBinding template binding the ephemeral ServiceAccount, overrides --ephemeral-clusterrole")
+
+	propagateTrace    = flag.Bool("propagate-trace-context", true, "send the W3C traceparent header with every API request, so that the API server's spans join the probe's traces")
+	requestSpans      = flag.Bool("request-spans", true, "record every API request as a child span of the phase making it")
+	connectionSpans   = flag.Bool("connection-spans", true, "record the DNS lookup, TCP connect, TLS handshake and time to first byte of every API request as child spans of the request's, with --request-spans")
+	resolveAPF        = flag.Bool("apf-names", true, "record the names of the API Priority and Fairness FlowSchema and priority level of every API request besides their UIDs, which needs permission to list them")
+	throttleThreshold = flag.Duration("client-throttle-threshold", 10*time.Millisecond, "client-side rate limiter waits at least this long are recorded as span events")
+	kubeQPS           = flag.Float64("kube-qps", float64(rest.DefaultQPS), "sustained rate of requests to the API server allowed by the client-side rate limiter, per second")
+	kubeBurst         = flag.Int("kube-burst", rest.DefaultBurst, "number of requests to the API server the client-side rate limiter lets through in a burst, above --kube-qps")
+	throttleRetries   = flag.Int("throttle-retries", 10, "number of times a request rejected by the API server with a 429 is retried, with an exponential backoff; 0 leaves the retries to client-go")
+	throttleBackoff   = flag.Duration("throttle-backoff", time.Second, "delay before retrying a request rejected with a 429 the first time, doubling with every retry up to 30s, and at least its Retry-After delay")
+
+	statusFilePath = flag.String("status-file", "", "path where a compact JSON status of the run is written when it ends, e.g. /dev/termination-log; truncated to 4KB")
+
+	clusterContextSampling = flag.Bool("cluster-context-sampling", false, "sample the number of pods pending cluster-wide before creating probe pods, which needs permission to list pods in all namespaces")
+
+	timelinePath = flag.String("timeline", "", "path where a timeline of the run's phases and events is rendered, as an SVG image if it ends with .svg, an HTML page otherwise")
+
+	ledgerFile = flag.String("ledger-file", "", "file to which the UID of every object created is appended, so that it can be cleaned up later")
+
+	pollEventBurst  = flag.Int("poll-events-burst", 10, "number of poll attempts, and throttling events of a phase, recorded as individual span events before aggregating")
+	pollEventWindow = flag.Duration("poll-events-window", 5*time.Second, "interval at which aggregated poll attempt and throttling events are recorded")
 )
 
 func main() {
-	// Create background context listening for cancellation on SIGTERM and SIGINT
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(runCompare(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfig(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "store" {
+		os.Exit(runStore(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cleanup" {
+		os.Exit(runCleanup(os.Args[2:]))
+	}
 
-	ctx, cancelSig := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	if isKubectlPlugin(os.Args[0]) {
+		setPluginDefaults(flag.CommandLine)
+	}
+	flag.Parse()
+	cmdline := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+	if *configFile != "" {
+		if err := loadConfig(flag.CommandLine, *configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if *printRBAC {
+		fmt.Print(probe.RBACManifest("prober", probeKinds...))
+		return
+	}
+	logger, err := newLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	if *resultsSchema != 1 && *resultsSchema != results.SchemaVersion {
+		fmt.Fprintf(os.Stderr, "unsupported --results-schema %d\n", *resultsSchema)
+		os.Exit(2)
+	}
+	if !slices.Contains(probeKinds, *probeKind) {
+		fmt.Fprintf(os.Stderr, "unknown --probe %q\n", *probeKind)
+		os.Exit(2)
+	}
+	if *operatorMode && *interval > 0 {
+		fmt.Fprintln(os.Stderr, "--interval can't be used with --operator, each LatencyProbe sets its own")
+		os.Exit(2)
+	}
+	if len(schedules) > 0 && (*interval > 0 || *operatorMode) {
+		fmt.Fprintln(os.Stderr, "--schedule can't be used with --interval or --operator")
+		os.Exit(2)
+	}
+	if (*scheduleAddr != "" || *scheduleJitter != 0) && len(schedules) == 0 {
+		fmt.Fprintln(os.Stderr, "--schedule-addr and --schedule-jitter need --schedule")
+		os.Exit(2)
+	}
+	if *triggerAddr != "" && *interval <= 0 && !*operatorMode && len(schedules) == 0 {
+		fmt.Fprintln(os.Stderr, "--trigger-addr needs --interval, --schedule or --operator")
+		os.Exit(2)
+	}
+	if *leaderElect && *interval <= 0 && !*operatorMode && len(schedules) == 0 {
+		fmt.Fprintln(os.Stderr, "--leader-elect needs --interval, --schedule or --operator")
+		os.Exit(2)
+	}
+	if *leaderElect && *leaderElectLeaseDuration < time.Second {
+		fmt.Fprintf(os.Stderr, "--leader-elect-lease-duration must be at least 1s, got %s\n", *leaderElectLeaseDuration)
+		os.Exit(2)
+	}
+	if *detection != probe.DetectionWatch && *detection != probe.DetectionPoll {
+		fmt.Fprintf(os.Stderr, "unknown --detection %q, must be one of %s or %s\n", *detection, probe.DetectionWatch, probe.DetectionPoll)
+		os.Exit(2)
+	}
+	if *resultsHistory < 1 {
+		fmt.Fprintf(os.Stderr, "--results-history must be at least 1, got %d\n", *resultsHistory)
+		os.Exit(2)
+	}
+	if *namespaceObjects < 0 {
+		fmt.Fprintf(os.Stderr, "--namespace-objects must not be negative, got %d\n", *namespaceObjects)
+		os.Exit(2)
+	}
+	if *rbacCheck != probe.RBACCheckReview && *rbacCheck != probe.RBACCheckRequest {
+		fmt.Fprintf(os.Stderr, "unknown --rbac-check %q, must be one of %s or %s\n", *rbacCheck, probe.RBACCheckReview, probe.RBACCheckRequest)
+		os.Exit(2)
+	}
+	if err := validateSampling(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *kubeQPS <= 0 || *kubeBurst < 1 {
+		fmt.Fprintf(os.Stderr, "--kube-qps must be positive and --kube-burst at least 1, got %g and %d\n", *kubeQPS, *kubeBurst)
+		os.Exit(2)
+	}
+	if *throttleRetries < 0 || *throttleBackoff < 0 {
+		fmt.Fprintf(os.Stderr, "--throttle-retries and --throttle-backoff must not be negative, got %d and %s\n", *throttleRetries, *throttleBackoff)
+		os.Exit(2)
+	}
+	if err := validateContentType(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := validateOutput(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := validateResultsFormat(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *kubeContext != "" && *apiServerURL != "" {
+		fmt.Fprintln(os.Stderr, "--context can't be used with --api-server-url")
+		os.Exit(2)
+	}
+	nodes, err := newNodeSampler(*probeKind)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slos, err := newSLOMonitor()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	escalation, err := newEscalation()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	alerter, err := newAlerter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	uploader, err := newResultUploader()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	namespaces, err := newNamespaceFanOut()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := validateEphemeralNamespace(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	clusterTargets, err := parseClusterTargets()
+	if err == nil {
+		err = validateClusterTargets()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	reloader, err := newConfigReloader(cmdline)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cfg, err := probeConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	probe.CreateTimeout = cfg.CreateTimeout
+	probe.DetectTimeout = cfg.DetectTimeout
+	probe.TeardownTimeout = cfg.TeardownTimeout
+	if *reapTTL != 0 && *reapTTL <= cfg.RunTimeout {
+		fmt.Fprintf(os.Stderr, "--reap-ttl %s must be longer than --timeout %s\n", *reapTTL, cfg.RunTimeout)
+		os.Exit(2)
+	}
+	payloadSizes, err := parsePayloadSizes()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	ipFamily, err := probe.ParseIPFamily(*ipFamilyFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	trafficPolicy, err := probe.ParseTrafficPolicy(*trafficPolicyFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	classes, claimSize, err := parseStorageClasses()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	webhook, err := newWebhookOverhead(*probeKind)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var pauseSchedule cron.Schedule
+	if *pauseCron != "" {
+		pauseSchedule, err = probe.ParseCron(*pauseCron)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	// Exit with a non-zero code once everything else is flushed
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	// The status file is written last, whatever the outcome, even when
+	// panicking.
+	statusOut := newStatusFile(*statusFilePath)
+	defer func() {
+		r := recover()
+		statusOut.write(exitCode, r)
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	// Create background context listening for cancellation on SIGTERM and
+	// SIGINT, each run gets its own timeout
+	ctx, cancelSig := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer cancelSig()
 
 	// Initialize OpenTelemetry
-	shutdown := initOpenTelemetry(ctx)
-	defer shutdown()
+	providers, shutdown, err := telemetry.Setup(ctx, telemetry.Config{
+		ServiceName:    "k8s-latency-probe",
+		ServiceVersion: "0.0.1",
+		Exporter:       *exporter,
+		Protocol:       *otlpProtocol,
+		Endpoint:       *otlpEndpoint,
+		Output:         os.Stderr, // stdout is reserved for the results
+		ClusterName:    *clusterName,
+		Prometheus:     *metricsAddr != "",
+		SetGlobal:      true,
+		ResourceAttributes: []attribute.KeyValue{
+			attribute.String("probe.field_manager", *fieldManager),
+		},
+	})
+	if err != nil {
+		exitCode = setupFailed(statusOut, fmt.Errorf("failed to initialize OpenTelemetry: %w", err))
+		return
+	}
+	// The process ends with the same sequence whatever the outcome, run by
+	// the defers below in reverse order, after the last run ended (see
+	// runner.run): the providers are flushed with a fresh bounded context,
+	// the status file is written and finally the process exits with
+	// exitCode.
+	defer func() {
+		// The probe context may already be done, flush with a fresh one.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Error("Failed to shutdown telemetry providers", "error", err)
+		}
+	}()
+
+	maxAge := *healthMaxAge
+	if maxAge <= 0 {
+		maxAge = 2*max(*interval, schedules.longestPeriod(time.Now())) + cfg.RunTimeout
+	}
+	runHealth := newHealth(maxAge, providers.ExportHealth, escalation)
+	routes := httpRoutes{}
+	if providers.MetricsHandler != nil {
+		routes.handle(*metricsAddr, "GET /metrics", providers.MetricsHandler)
+	}
+	if *healthAddr != "" {
+		routes.handle(*healthAddr, "GET /healthz", http.HandlerFunc(runHealth.healthz))
+		routes.handle(*healthAddr, "GET /readyz", http.HandlerFunc(runHealth.readyz))
+	}
+	sched := newScheduler(schedules, *scheduleJitter)
+	if *scheduleAddr != "" {
+		routes.handle(*scheduleAddr, "GET /schedules", http.HandlerFunc(sched.list))
+		routes.handle(*scheduleAddr, "POST /schedules/{probe}/enable", sched.toggle(true))
+		routes.handle(*scheduleAddr, "POST /schedules/{probe}/disable", sched.toggle(false))
+	}
+	var trig *trigger
+	if *triggerAddr != "" {
+		trig = &trigger{}
+		routes.handle(*triggerAddr, "POST /probe", http.HandlerFunc(trig.probe))
+	}
+	// Stopped before the providers are shut down, so that a last scrape
+	// never races the shutdown.
+	defer routes.serve()()
 
 	tracer := otel.Tracer("k8s-latency-probe")
+	runMetrics := must(telemetry.NewRunMetrics(otel.Meter("k8s-latency-probe")))
 
-	ctx, globalSpan := tracer.Start(ctx, "prober.main")
-	defer globalSpan.End()
+	cc, err := connect(ctx, *kubeContext, providers.TracerProvider)
+	if err != nil {
+		exitCode = setupFailed(statusOut, err)
+		return
+	}
+	config, clientset := cc.config, cc.clientset
 
-	// creates the in-cluster config
-	config := must(rest.InClusterConfig())
-	// creates the clientset
-	clientset := must(kubernetes.NewForConfig(config))
+	// --namespace may point the probes elsewhere than the prober's own
+	// namespace, which is then only needed to set owner references
+	podNamespace, podNamespaceErr := currentNamespace(cc.namespace)
+	namespace := cfg.Namespace
+	if namespace == "" {
+		if podNamespaceErr != nil {
+			exitCode = setupFailed(statusOut, podNamespaceErr)
+			return
+		}
+		namespace = podNamespace
+	}
+	metadataClient := cc.metadata
 
-	namespace := must(currentNamespace())
+	stopCalibration, err := webhook.serveCalibration(namespace)
+	if err != nil {
+		exitCode = setupFailed(statusOut, err)
+		return
+	}
+	defer stopCalibration()
 
-	buf := make([]byte, 8)
-	_ = must(rand.Read(buf))
-	instance := hex.EncodeToString(buf)
+	ledger, err := probe.NewLedger(*ledgerFile)
+	if err != nil {
+		exitCode = setupFailed(statusOut, err)
+		return
+	}
+	defer ledger.Close()
+	ctx = probe.WithLedger(ctx, ledger)
 
-	// Create a new pod with a unique name
-	_, createPodSpan := tracer.Start(ctx, "prober.create-pod")
-	createPodSpan.SetAttributes(
-		attribute.String("instance", instance),
-	)
+	resultStore, err := openStore()
+	if err != nil {
+		exitCode = setupFailed(statusOut, err)
+		return
+	}
+	if resultStore != nil {
+		defer resultStore.Close()
+	}
 
-	pod := must(clientset.CoreV1().Pods(namespace).Create(ctx, &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: fmt.Sprintf("probe-%s", instance),
-			Labels: map[string]string{
-				"app": "probe",
-			},
-		},
-		Spec: corev1.PodSpec{
-			Containers: []corev1.Container{
-				{
-					Name:  "probe",
-					Image: "busybox",
-					Args:  []string{"sh", "-c", "while true; do echo hello; sleep 10;done"},
-				},
-			},
-		},
-	}, metav1.CreateOptions{}))
+	pause := &probe.PauseChecker{
+		Client:     clientset,
+		Namespace:  namespace,
+		Annotation: *pauseAnnotation,
+		ConfigMap:  *pauseConfigMap,
+		Duration:   *pauseDuration,
+	}
+	if *pauseCron != "" {
+		pause.Schedule = pauseSchedule
+	}
+
+	r := &runner{
+		cfg:            cfg,
+		tracer:         tracer,
+		metrics:        runMetrics,
+		activeSpans:    providers.ActiveSpans,
+		clientset:      clientset,
+		config:         config,
+		identityConfig: cc.identityConfig,
+		namespace:      namespace,
+		kind:           *probeKind,
+		pause:          pause,
+		statusOut:      statusOut,
+		history:        newHistoryConfigMap(*resultsConfigMap, *resultsHistory),
+		health:         runHealth,
+		slos:           slos,
+		alerter:        alerter,
+		escalation:     escalation,
+		summary:        newRunSummary(),
+		store:          resultStore,
+		uploader:       uploader,
+		reloader:       reloader,
+		namespaces:     namespaces,
+		payloadSizes:   payloadSizes,
+		ipFamily:       ipFamily,
+		trafficPolicy:  trafficPolicy,
+		storageClasses: classes,
+		pvcSize:        claimSize,
+		webhook:        webhook,
+		nodes:          nodes,
+		zones:          probe.NewZoneResolver(clientset),
+		contentClients: cc.contentClients,
+		thresholds:     maxLatency,
+	}
+	if podNamespaceErr == nil {
+		r.owners = proberOwner(podNamespace, namespace)
+	}
+	if *resultEvents {
+		ref := proberReference(podNamespace, namespace)
+		r.eventTarget = &ref
+	}
+	if *clusterContextSampling {
+		r.pending = &probe.PendingSampler{Client: metadataClient}
+	}
+	dumpStatusOnSignal(r.currentStatus)
+
+	var op *operator
+	active := r.activeRuns
+	if *operatorMode {
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			exitCode = setupFailed(statusOut, err)
+			return
+		}
+		op = newOperator(r, dynamicClient)
+		active = op.activeRuns
+	}
+	if sched != nil {
+		sched.bind(r)
+		active = sched.activeRuns
+	}
+	if trig != nil {
+		trig.bind(ctx, r)
+		daemonActive := active
+		active = func() []string { return append(daemonActive(), trig.activeRuns()...) }
+	}
+
+	clusters, err := newClusterSet(ctx, r, clusterTargets, providers.TracerProvider)
+	if err != nil {
+		exitCode = setupFailed(statusOut, err)
+		return
+	}
+	if clusters != nil {
+		active = clusters.activeRuns
+	}
+
+	// Clean up after earlier runs that never got to, e.g. killed ones
+	if *reap {
+		daemon := *interval > 0 || op != nil || sched != nil
+		if clusters == nil {
+			r.reapWith(ctx, metadataClient, active, daemon)
+		}
+		for _, c := range clusters.list() {
+			c.runner.reapWith(ctx, c.metadata, active, daemon)
+		}
+	}
+
+	// Summarized once the last run ended, before telemetry is flushed
+	defer r.summary.write()
+
+	if *interval <= 0 && op == nil && sched == nil {
+		exitCode = r.run(ctx)
+		return
+	}
+	work := func(ctx context.Context) {
+		if op != nil {
+			op.run(ctx)
+			return
+		}
+		if sched != nil {
+			sched.run(ctx)
+			return
+		}
+		if clusters != nil {
+			clusters.loop(ctx, *interval)
+			return
+		}
+		r.reloader.watch(ctx)
+		r.loop(ctx, *interval)
+	}
+	if !*leaderElect {
+		work(ctx)
+		return
+	}
+	if err := r.lead(ctx, namespace, work); err != nil {
+		exitCode = setupFailed(statusOut, err)
+	}
+}
+
+// runner holds what every run of the prober shares.
+type runner struct {
+	cfg            ProbeConfig
+	tracer         trace.Tracer
+	metrics        *telemetry.RunMetrics
+	activeSpans    *telemetry.ActiveSpans
+	clientset      kubernetes.Interface
+	config         *rest.Config
+	identityConfig *rest.Config
+	namespace      string
+	kind           string
+	// cluster is the name of the cluster probed, set when probing several,
+	// see --contexts. namespaces, if set, are those every run probes instead
+	// of namespace alone, see --namespaces.
+	cluster        string
+	namespaces     *namespaceFanOut
+	pause          *probe.PauseChecker
+	statusOut      *statusFile
+	history        *historyConfigMap
+	health         *health
+	slos           *sloMonitor
+	alerter        *probe.Alerter
+	escalation     *probe.Escalation
+	summary        *runSummary
+	store          *store.Store
+	uploader       *resultUploader
+	pending        *probe.PendingSampler
+	nodes          *probe.NodeSampler
+	zones          *probe.ZoneResolver
+	contentClients map[string]kubernetes.Interface
+	owners         []metav1.OwnerReference
+
+	payloadSizes  []int
+	ipFamily      probe.IPFamily
+	trafficPolicy corev1.ServiceInternalTrafficPolicy
+
+	storageClasses []string
+	pvcSize        resource.Quantity
+
+	webhook *webhookOverhead
+
+	// eventTarget, if set, is the object result Events are emitted on, see
+	// --result-events. thresholds are the longest acceptable durations of
+	// the phases, by name, see --max-latency.
+	eventTarget *corev1.ObjectReference
+	thresholds  map[string]time.Duration
+
+	// report, if set, is called with the results of every run once it
+	// ended, see operator mode.
+	report func(ctx context.Context, run results.Run, exitCode int)
+
+	// reloader, if set, reloads the config between runs, see --config-watch.
+	reloader *configReloader
+
+	// status and runID are the status and ID of the run in progress.
+	status atomic.Pointer[probe.Status]
+	runID  atomic.Pointer[string]
+}
 
-	fmt.Printf("Created pod %s\n", pod.Name)
-	createPodSpan.End()
+// forProbe returns a runner sharing r's clients and configuration, running
+// probes of the given kind in namespace.
+func (r *runner) forProbe(kind, namespace string) *runner {
+	owners := r.owners
+	if namespace != r.namespace {
+		// Owners must be in the same namespace as their dependents
+		owners = nil
+	}
+	nodes := r.nodes
+	if !slices.Contains(samplingKinds(), kind) {
+		nodes = nil
+	}
+	return &runner{
+		cfg:            r.cfg,
+		tracer:         r.tracer,
+		metrics:        r.metrics,
+		activeSpans:    r.activeSpans,
+		clientset:      r.clientset,
+		config:         r.config,
+		identityConfig: r.identityConfig,
+		namespace:      namespace,
+		kind:           kind,
+		cluster:        r.cluster,
+		namespaces:     r.namespaces,
+		pause:          r.pause,
+		statusOut:      r.statusOut,
+		history:        r.history,
+		health:         r.health,
+		slos:           r.slos,
+		alerter:        r.alerter,
+		escalation:     r.escalation,
+		summary:        r.summary,
+		store:          r.store,
+		uploader:       r.uploader,
+		pending:        r.pending,
+		nodes:          nodes,
+		zones:          r.zones,
+		contentClients: r.contentClients,
+		owners:         owners,
+		payloadSizes:   r.payloadSizes,
+		ipFamily:       r.ipFamily,
+		trafficPolicy:  r.trafficPolicy,
+		storageClasses: r.storageClasses,
+		pvcSize:        r.pvcSize,
+		webhook:        r.webhook,
+		eventTarget:    r.eventTarget,
+		thresholds:     r.thresholds,
+	}
+}
+
+// currentStatus returns the status of the run in progress, or nil.
+func (r *runner) currentStatus() *probe.Status {
+	return r.status.Load()
+}
+
+// run runs the probe once, with its own root span and timeout, and returns
+// the exit code matching its outcome. Everything the run creates is torn
+// down before it returns.
+func (r *runner) run(ctx context.Context) (exitCode int) {
+	defer func() { r.health.record(exitCode) }()
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.RunTimeout)
+	defer cancel()
 
-	found := make(chan struct{})
-	go func(ctx context.Context) {
-		ctx, span := tracer.Start(ctx, "prober.wait-for-pod")
-		defer span.End()
+	runID := must(newID())
+	instance := must(newID())
+	r.runID.Store(&runID)
+	defer r.runID.Store(nil)
 
-		ticker := time.NewTicker(100 * time.Millisecond)
-		defer ticker.Stop()
+	// Carry the probe's identity on every span started within the run
+	identity := map[string]string{
+		telemetry.BaggageRunID:      runID,
+		telemetry.BaggageInstanceID: instance,
+		telemetry.BaggageKind:       r.kind,
+	}
+	if r.cluster != "" {
+		identity[telemetry.BaggageCluster] = r.cluster
+	}
+	ctx = must(telemetry.ContextWithBaggage(ctx, identity))
 
-		for {
-			// get pods in all the namespaces by omitting namespace
-			// Or specify namespace to get pods in particular namespace
-			pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-				LabelSelector: fmt.Sprintf("probe-instance=%s", instance),
+	// Every run ends with the same sequence, run by the defers below in
+	// reverse order: the run is finalized and its metrics recorded by
+	// p.finalize, what it created is torn down, spans left open by an
+	// aborted probe are ended and the root span ends. Each run is its own
+	// trace.
+	ctx, globalSpan := r.tracer.Start(ctx, "prober.main", trace.WithNewRoot())
+	defer func() {
+		if n := r.activeSpans.EndOpen(globalSpan, errSpanAbandoned); n > 0 {
+			slog.WarnContext(ctx, "Ended spans left open by the probe", "run_id", runID, "spans", n)
+		}
+		globalSpan.End()
+	}()
+
+	run := results.Run{ID: runID, Start: time.Now()}
+	if r.report != nil {
+		defer func() { r.report(ctx, run, exitCode) }()
+	}
+
+	if !*cleanup {
+		ctx = probe.WithoutTeardown(ctx)
+	}
+
+	status := probe.NewStatus()
+	ctx = probe.WithStatus(ctx, status)
+	r.status.Store(status)
+
+	// The objects of the run, checked for leftovers as it ends
+	ledger := probe.LedgerFromContext(ctx).Run()
+	ctx = probe.WithLedger(ctx, ledger)
+
+	// After a streak of failures, collect every diagnostic until it recovers
+	escalated := r.escalation.Escalated(r.kind)
+	if escalated {
+		ctx = telemetry.WithRequestSpans(ctx)
+		globalSpan.SetAttributes(attribute.Bool("probe.escalated", true))
+	}
+
+	p := &prober{
+		cfg:            r.cfg,
+		tracer:         r.tracer,
+		clients:        probe.SingleClient(r.clientset),
+		contentClients: r.contentClients,
+		namespace:      r.namespace,
+		kind:           r.kind,
+		cluster:        r.cluster,
+		runID:          runID,
+		instance:       instance,
+		start:          run.Start,
+		metrics:        r.metrics,
+		artifacts:      newArtifacts(failureArtifactsDir(escalated), *artifactsRetention, *artifactsObservations, r.clientset, r.namespace),
+		status:         status,
+		progress:       startProgress(status, *showProgress),
+		statusOut:      r.statusOut,
+		history:        r.history,
+		slos:           r.slos,
+		alerter:        r.alerter,
+		escalation:     r.escalation,
+		escalated:      escalated,
+		ledger:         ledger,
+		summary:        r.summary,
+		store:          r.store,
+		uploader:       r.uploader,
+		pending:        r.pending,
+		owners:         r.owners,
+		events:         r.eventTarget,
+		thresholds:     r.thresholds,
+		log:            probeLogger(runID, instance, r.namespace, r.kind, r.cluster),
+	}
+
+	paused, reason, err := r.pause.Paused(ctx, time.Now())
+	if err != nil {
+		p.log.WarnContext(ctx, "Failed to check whether probing is paused, probing anyway", "error", err)
+	}
+	if paused {
+		p.log.InfoContext(ctx, "Probing is paused, skipping", "reason", reason)
+		globalSpan.SetAttributes(attribute.String("probe.outcome", string(results.OutcomeSkippedPaused)))
+		run.Probes = append(run.Probes, results.Probe{
+			Kind:    r.kind,
+			Outcome: results.OutcomeSkippedPaused,
+			Attributes: map[string]string{
+				"namespace":    r.namespace,
+				"pause.reason": reason,
+			},
+		})
+		p.finalize(ctx, &run)
+		return 0
+	}
+
+	var setupPhases []results.Phase
+	if *apiServerURL != "" {
+		ph, failed := p.checkAPIServer(ctx, r.config)
+		if failed != nil {
+			p.log.ErrorContext(ctx, "API server check failed", "error", failed.Errors[0])
+			run.Probes = append(run.Probes, *failed)
+			p.finalize(ctx, &run)
+			return 1
+		}
+		setupPhases = append(setupPhases, ph)
+	}
+
+	if err := r.preflight(ctx); err != nil {
+		p.log.ErrorContext(ctx, "Preflight failed", "error", err)
+		run.Probes = append(run.Probes, results.Probe{
+			Kind:       r.kind,
+			Outcome:    results.OutcomeError,
+			Attributes: map[string]string{"namespace": r.namespace},
+			Errors:     []string{err.Error()},
+		})
+		p.finalize(ctx, &run)
+		return 1
+	}
+
+	if *exclusive {
+		lock := p.newLock()
+		wait, err := p.acquireLock(ctx, lock)
+		run.Lock = &wait
+		if err != nil {
+			outcome := results.OutcomeError
+			if errors.Is(err, probe.ErrLocked) {
+				outcome = results.OutcomeSkippedLocked
+			} else {
+				exitCode = 1
+			}
+			p.log.ErrorContext(ctx, "Failed to acquire lock", "error", err)
+			globalSpan.SetAttributes(attribute.String("probe.outcome", string(outcome)))
+			run.Probes = append(run.Probes, results.Probe{
+				Kind:       r.kind,
+				Outcome:    outcome,
+				Attributes: map[string]string{"namespace": r.namespace, "lock.holder": wait.Holder},
+				Errors:     []string{err.Error()},
 			})
-			if err != nil {
-				panic(err.Error())
+			p.finalize(ctx, &run)
+			return exitCode
+		}
+		if wait.Contended {
+			globalSpan.SetAttributes(attribute.Bool("lock.contended", true))
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), probe.TeardownTimeout)
+			defer cancel()
+			if err := lock.Release(ctx); err != nil {
+				p.log.ErrorContext(ctx, "Failed to release lock", "error", err)
 			}
+		}()
+	}
 
-			if len(pods.Items) > 0 {
-				span.AddEvent("Pod found")
-				found <- struct{}{}
-				close(found)
-				return
+	var (
+		namespacePhases []results.Phase
+		deleteNamespace func() (*results.Phase, error)
+	)
+	if *ephemeralNamespace {
+		phases, teardown, err := p.useEphemeralNamespace(ctx)
+		namespacePhases, deleteNamespace = phases, teardown
+		if err != nil {
+			p.log.ErrorContext(ctx, "Failed to set up ephemeral namespace", "error", err)
+			result := results.Probe{
+				Kind:       r.kind,
+				Outcome:    probe.OutcomeFor(err),
+				Phases:     phases,
+				Attributes: map[string]string{"namespace": r.namespace},
+				Errors:     []string{err.Error()},
 			}
+			if ph, err := teardown(); ph != nil {
+				result.Phases = append(result.Phases, *ph)
+				if err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("delete-namespace: %v", err))
+				}
+			}
+			run.Probes = append(run.Probes, result)
+			p.finalize(ctx, &run)
+			return 1
+		}
+	}
 
-			select {
-			case <-ctx.Done():
-				span.SetStatus(codes.Error, "context deadline exceeded")
-				fmt.Println("Context done, exiting...")
-				return
-			case <-ticker.C:
+	var (
+		identityPhases   []results.Phase
+		teardownIdentity = func() {}
+	)
+	if *ephemeralSA {
+		phases, teardown, err := p.useEphemeralIdentity(ctx, r.identityConfig)
+		// Torn down before finalize checks for leftovers, or as the run
+		// returns if it panics
+		teardownIdentity = sync.OnceFunc(teardown)
+		defer teardownIdentity()
+		identityPhases = phases
+		if err != nil {
+			teardownIdentity()
+			p.log.ErrorContext(ctx, "Failed to set up ephemeral identity", "error", err)
+			run.Probes = append(run.Probes, results.Probe{
+				Kind:       r.kind,
+				Outcome:    probe.OutcomeFor(err),
+				Phases:     phases,
+				Attributes: map[string]string{"namespace": p.namespace},
+				Errors:     []string{err.Error()},
+			})
+			p.finalize(ctx, &run)
+			return 1
+		}
+	}
+
+	probes, failed := r.runSamples(ctx, p, run.Start)
+	probes[0].Phases = slices.Concat(setupPhases, namespacePhases, identityPhases, probes[0].Phases)
+	if deleteNamespace != nil {
+		// Everything the probes left in the namespace goes with it
+		ph, err := deleteNamespace()
+		if ph != nil {
+			probes[0].Phases = append(probes[0].Phases, *ph)
+		}
+		if err != nil {
+			p.log.ErrorContext(ctx, "Failed to delete ephemeral namespace", "error", err)
+			probes[0].Errors = append(probes[0].Errors, fmt.Sprintf("delete-namespace: %v", err))
+			if probes[0].Outcome == results.OutcomeSuccess {
+				probes[0].Outcome = probe.OutcomeFor(err)
 			}
+			failed = true
+		}
+	}
+	if run.Lock != nil && run.Lock.Contended {
+		// The run started late, waiting for another one
+		for i := range probes {
+			probes[i].Attributes["lock.contended"] = "true"
 		}
-	}(ctx)
+	}
+	if failed {
+		exitCode = exitCodeFor(probes)
+	}
+	run.Probes = append(run.Probes, probes...)
+	teardownIdentity()
+	p.finalize(ctx, &run)
+	return exitCode
+}
 
-	// Update the pod's labels
-	_, updatePodSpan := tracer.Start(ctx, "prober.update-pod")
-	_ = must(clientset.CoreV1().Pods(namespace).Patch(
-		ctx,
-		pod.Name,
-		types.MergePatchType,
-		fmt.Appendf(nil, "{\"metadata\":{\"labels\":{\"probe-instance\":\"%s\"}}}", instance),
-		metav1.PatchOptions{},
-	))
-	updatePodSpan.End()
+// finalize is the single path through which every run ends. It computes the
+// run's aggregates, records exactly one outcome per probe and writes the
+// results.
+func (p *prober) finalize(ctx context.Context, run *results.Run) {
+	// The run's context may be done already, but the outcome must be recorded
+	ctx = context.WithoutCancel(ctx)
 
-	select {
-	case <-found:
-		break
-	case <-ctx.Done():
-		fmt.Println("Context done, cleaning up and exiting...")
-		break
+	// Never interleave the progress display with the results
+	p.progress.stop()
+
+	run.Duration = time.Since(run.Start)
+	if p.cluster != "" {
+		for i := range run.Probes {
+			if run.Probes[i].Attributes == nil {
+				run.Probes[i].Attributes = map[string]string{}
+			}
+			run.Probes[i].Attributes[telemetry.BaggageCluster] = p.cluster
+		}
 	}
+	run.Aggregate()
 
-	_, cleanupSpan := tracer.Start(ctx, "prober.cleanup")
+	for _, pr := range run.Probes {
+		p.metrics.RecordRun(ctx, pr.Kind, pr.Outcome)
+		var attrs []attribute.KeyValue
+		if node := pr.Attributes[probe.AttrNodeName]; *perNode && node != "" {
+			attrs = append(attrs, attribute.String(probe.AttrNodeName, node))
+		}
+		if zone := pr.Attributes[probe.AttrZone]; zone != "" {
+			attrs = append(attrs, attribute.String(probe.AttrZone, zone))
+		}
+		if ns := pr.Attributes["namespace"]; fansOutNamespaces() && ns != "" {
+			attrs = append(attrs, attribute.String(attrNamespace, ns))
+		}
+		p.metrics.RecordPhases(ctx, pr.Kind, pr.Phases, attrs...)
+		p.logProbe(ctx, pr)
+		p.recordResult(ctx, pr)
+	}
+	if len(run.Probes) > 1 {
+		p.logPercentiles(ctx, run.Aggregates)
+		p.logAvailability(ctx, run.Aggregates)
+	}
+	p.slos.observe(ctx, p, run.Probes)
+	p.alertLatency(ctx, run.Probes)
+	p.recordEscalation(ctx, run.Probes)
+	p.reportOutstanding(ctx)
+	p.summary.add(*run)
 
-	err := clientset.CoreV1().Pods(namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	res := results.Results{SchemaVersion: results.SchemaVersion, Run: *run}
+	write := func() error { return res.Encode(os.Stdout, *resultsSchema) }
+	if *resultsFormat == resultsText {
+		write = func() error { return results.WriteRunText(os.Stdout, *run) }
+	}
+	if err := write(); err != nil {
+		p.log.ErrorContext(ctx, "Failed to write results", "error", err)
+	}
+	p.statusOut.record(&res, trace.SpanContextFromContext(ctx).TraceID().String())
+	hctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	if err := p.history.record(hctx, p.clients.Cleanup, p.namespace, *run); err != nil {
+		p.log.ErrorContext(ctx, "Failed to record results in ConfigMap", "configmap", *resultsConfigMap, "error", err)
+	}
+	cancel()
+	if err := recordStore(ctx, p.store, *run); err != nil {
+		p.log.ErrorContext(ctx, "Failed to record results in store", "path", *storePath, "error", err)
+	}
+	if key, err := p.uploader.upload(ctx, &res); err != nil {
+		p.log.ErrorContext(ctx, "Failed to upload results", "url", *uploadURL, "key", key, "error", err)
+	}
+
+	if *timelinePath != "" {
+		if err := writeTimeline(*timelinePath, *run); err != nil {
+			p.log.ErrorContext(ctx, "Failed to write timeline", "error", err)
+		}
+	}
+
+	if p.artifacts.enabled() && run.Aggregates.Failed > 0 {
+		traceID := trace.SpanContextFromContext(ctx).TraceID().String()
+		if err := p.artifacts.write(ctx, &res, traceID); err != nil {
+			p.log.ErrorContext(ctx, "Failed to write failure artifacts", "error", err)
+		}
+	}
+}
+
+// logProbe logs a line per phase of the probe's result, with its duration,
+// then the probe's outcome and errors.
+func (p *prober) logProbe(ctx context.Context, pr results.Probe) {
+	for _, ph := range pr.Phases {
+		p.log.InfoContext(ctx, "Phase finished", "kind", pr.Kind, "phase", ph.Name, "outcome", ph.Outcome, durationMS("duration_ms", ph.Duration))
+	}
+	p.log.InfoContext(ctx, "Probe finished", "kind", pr.Kind, "outcome", pr.Outcome, "errors", pr.Errors)
+}
+
+// recordResult emits an Event reporting the result of pr, if enabled.
+func (p *prober) recordResult(ctx context.Context, pr results.Probe) {
+	if p.events == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := probe.RecordResult(ctx, p.clients.Cleanup, *p.events, pr, p.thresholds); err != nil {
+		p.log.WarnContext(ctx, "Failed to record result event", "error", err)
+	}
+}
+
+// logPercentiles logs the percentiles of each phase across the probes of a
+// run.
+func (p *prober) logPercentiles(ctx context.Context, agg results.Aggregates) {
+	for _, key := range slices.Sorted(maps.Keys(agg.Phases)) {
+		pa := agg.Phases[key]
+		p.log.InfoContext(ctx, "Phase percentiles", "phase", key, "count", pa.Count,
+			durationMS("p50_ms", pa.P50), durationMS("p90_ms", pa.P90), durationMS("p99_ms", pa.P99))
+	}
+}
+
+// logAvailability logs the success ratio of every probe kind and phase
+// attempted during the run.
+func (p *prober) logAvailability(ctx context.Context, agg results.Aggregates) {
+	for _, key := range slices.Sorted(maps.Keys(agg.Availability)) {
+		a := agg.Availability[key]
+		p.log.InfoContext(ctx, "Availability", "phase", key, "attempts", a.Attempts, "succeeded", a.Succeeded, "ratio", a.Ratio)
+	}
+}
+
+// writeTimeline renders the run's timeline to path.
+func writeTimeline(path string, run results.Run) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := results.WriteTimeline(f, run, results.TimelineFormat(path)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parsePayloadSizes returns the payload sizes to measure from --payload-size
+// and --payload-sweep.
+func parsePayloadSizes() ([]int, error) {
+	if *payloadSweep != "" {
+		return probe.ParsePayloadSweep(*payloadSweep)
+	}
+	size, err := probe.ParsePayloadSize(*payloadSize)
+	if err != nil {
+		return nil, err
+	}
+	return []int{size}, nil
+}
+
+// preflight checks the permissions of the run's probe in its namespace,
+// unless it fans out to several, each checked by its own probe. With
+// --ephemeral-namespace, or for the namespace-deletion probe, the namespace
+// doesn't exist yet: the permissions are checked in all of them.
+func (r *runner) preflight(ctx context.Context) error {
+	switch {
+	case r.namespaces != nil:
+		return nil
+	case *ephemeralNamespace:
+		return checkPermissions(ctx, r.clientset, "", ephemeralNamespacePermissions(r.kind))
+	case r.kind == "namespace-deletion":
+		// The probe works in a namespace of its own
+		return preflight(ctx, r.clientset, "", r.kind)
+	case r.kind == "webhook-overhead" && r.webhook.calibration != nil:
+		if err := checkPermissions(ctx, r.clientset, "", probe.WebhookPermissions()); err != nil {
+			return err
+		}
+		return preflight(ctx, r.clientset, r.namespace, r.kind)
+	case r.kind == "token-request" && *tokenReview:
+		if err := checkPermissions(ctx, r.clientset, "", probe.TokenReviewPermissions()); err != nil {
+			return err
+		}
+		return preflight(ctx, r.clientset, r.namespace, r.kind)
+	case r.kind == "e2e" && r.nodes != nil:
+		if err := checkPermissions(ctx, r.clientset, r.namespace, probe.DataPathPermissions()); err != nil {
+			return err
+		}
+		return preflight(ctx, r.clientset, r.namespace, r.kind)
+	default:
+		return preflight(ctx, r.clientset, r.namespace, r.kind)
+	}
+}
+
+// preflight checks that the prober has the permissions needed by the probe.
+func preflight(ctx context.Context, clientset kubernetes.Interface, namespace, kind string) error {
+	return checkPermissions(ctx, clientset, namespace, probe.ProbePermissions(kind))
+}
+
+// checkPermissions checks that the prober has perms in namespace. Missing
+// measure permissions are an error. Missing cleanup permissions only warn,
+// unless --require-cleanup-rbac is set, since measuring still works but
+// objects will leak whenever a run fails.
+func checkPermissions(ctx context.Context, clientset kubernetes.Interface, namespace string, perms probe.Permissions) error {
+	missing, err := probe.MissingPermissions(ctx, clientset, namespace, perms.Measure)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing permissions needed to measure: %v", missing)
+	}
+
+	missing, err = probe.MissingPermissions(ctx, clientset, namespace, perms.Cleanup)
 	if err != nil {
-		panic(err.Error())
+		return err
+	}
+	if len(missing) > 0 {
+		if *requireCleanupRBAC {
+			return fmt.Errorf("missing permissions needed to clean up: %v", missing)
+		}
+		slog.WarnContext(ctx, "Missing permissions needed to clean up, objects will leak if a run fails", "permissions", missing)
+	}
+
+	return nil
+}
+
+// prober holds what every probe needs to run.
+type prober struct {
+	cfg       ProbeConfig
+	tracer    trace.Tracer
+	clients   probe.Clients
+	namespace string
+	kind      string
+	cluster   string
+	runID     string
+	instance  string
+	start     time.Time
+	metrics   *telemetry.RunMetrics
+	artifacts *artifacts
+	status    *probe.Status
+	progress  *progress
+	statusOut *statusFile
+	history   *historyConfigMap
+	slos      *sloMonitor
+	alerter   *probe.Alerter
+	summary   *runSummary
+	store     *store.Store
+	uploader  *resultUploader
+	pending   *probe.PendingSampler
+	owners    []metav1.OwnerReference
+	log       *slog.Logger
+
+	// escalation tracks the failure streaks of every probe kind. escalated
+	// is set when the run's kind was escalated as it started, the run then
+	// collecting every diagnostic.
+	escalation *probe.Escalation
+	escalated  bool
+
+	// ledger records the objects created by the run, see reportOutstanding.
+	ledger *probe.Ledger
+
+	// node, if set, is the node the probe pods are pinned to, see --per-node.
+	// zone, if set, is the zone they are restricted to, see --per-zone.
+	node string
+	zone string
+
+	// contentClients, if set, make requests of each content type, see
+	// --compare-content-types.
+	contentClients map[string]kubernetes.Interface
+
+	// events, if set, is the object result Events are emitted on.
+	events     *corev1.ObjectReference
+	thresholds map[string]time.Duration
+}
+
+// labels returns extra merged with the configured labels and the labels
+// marking objects as created by this run.
+func (p *prober) labels(extra map[string]string) map[string]string {
+	labels := maps.Clone(p.cfg.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	maps.Copy(labels, extra)
+	return probe.ManagedLabels(p.runID, labels)
+}
+
+// annotations returns the annotations set on every object the run creates,
+// so that a reaper can delete them if the run fails to.
+func (p *prober) annotations() map[string]string {
+	return probe.ExpiryAnnotations(p.start, p.cfg.RunTimeout)
+}
+
+// phase returns a result phase that started at start and ends now.
+func phase(name string, start time.Time, outcome results.Outcome) results.Phase {
+	return results.Phase{
+		Name:     name,
+		Start:    start,
+		Duration: time.Since(start),
+		Outcome:  outcome,
 	}
-	fmt.Printf("Deleted pod %s\n", pod.Name)
-	cleanupSpan.End()
 }
 
+// must returns v, panicking if e is set. It is only meant for errors that
+// can't happen short of a bug, e.g. creating an instrument; errors from the
+// API server or the environment are handled and reported in the results.
 func must[V any](v V, e error) V {
 	if e != nil {
 		panic(e)
@@ -151,55 +1264,53 @@ func must[V any](v V, e error) V {
 	return v
 }
 
-// currentNamespace returns the namespace of the current pod.
-func currentNamespace() (string, error) {
+// newID returns a random identifier suitable for object names and labels.
+func newID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// setupFailed reports err, which prevented the prober from starting, and
+// returns the exit code to exit with.
+func setupFailed(statusOut *statusFile, err error) int {
+	slog.Error("Failed to start", "error", err)
+	statusOut.fail(err)
+	return 2
+}
+
+// currentNamespace returns the namespace of the current pod, or kubeNamespace,
+// the kubeconfig context's, when running out of the cluster.
+func currentNamespace(kubeNamespace string) (string, error) {
 	// Get the namespace from the environment variable
-	ns := os.Getenv("K8S_NAMESPACE_NAME")
-	if ns != "" {
-		return ns, nil
+	if ns, ok := os.LookupEnv(namespaceEnv); ok {
+		return validNamespace(ns, "the "+namespaceEnv+" environment variable")
+	}
+	if kubeNamespace != "" {
+		return validNamespace(kubeNamespace, "the kubeconfig")
 	}
 
 	// If the environment variable is not set, read the namespace from the file
-	data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	data, err := os.ReadFile(namespaceFile)
 	if err != nil {
 		return "", fmt.Errorf("failed to read namespace: %w", err)
 	}
 
-	return string(data), nil
+	return validNamespace(string(data), namespaceFile)
 }
 
-// initOpenTelemetry initializes the OTLP exporter and tracer provider.
-func initOpenTelemetry(ctx context.Context) func() {
-	// Create OTLP trace exporter
-	exporter, err := otlptrace.New(ctx, otlptracegrpc.NewClient())
-	if err != nil {
-		panic(fmt.Sprintf("failed to create OTLP trace exporter: %v", err))
+// validNamespace returns ns, read from source, stripped of surrounding
+// whitespace. An empty namespace would silently turn the probes' lists into
+// cluster-wide ones, so anything but a valid namespace name is an error.
+func validNamespace(ns, source string) (string, error) {
+	ns = strings.TrimSpace(ns)
+	if ns == "" {
+		return "", fmt.Errorf("empty namespace read from %s", source)
 	}
-
-	// Create a resource to describe this application
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String("k8s-latency-probe"),
-			semconv.ServiceVersionKey.String("0.0.1"),
-		),
-	)
-	if err != nil {
-		panic(fmt.Sprintf("failed to create resource: %v", err))
-	}
-
-	// Create a trace provider with the exporter and resource
-	tp := trace.NewTracerProvider(
-		trace.WithBatcher(exporter),
-		trace.WithResource(res),
-	)
-
-	// Set the global tracer provider
-	otel.SetTracerProvider(tp)
-
-	// Return a shutdown function to flush and clean up
-	return func() {
-		if err := tp.Shutdown(ctx); err != nil {
-			fmt.Printf("failed to shutdown tracer provider: %v\n", err)
-		}
+	if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+		return "", fmt.Errorf("invalid namespace %q read from %s: %s", ns, source, strings.Join(errs, ", "))
 	}
+	return ns, nil
 }
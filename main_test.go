@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidNamespace(t *testing.T) {
+	tests := []struct {
+		ns      string
+		want    string
+		wantErr string
+	}{
+		{"probes", "probes", ""},
+		{"probes\n", "probes", ""},
+		{"  probes \t", "probes", ""},
+		{"", "", "empty namespace read from test"},
+		{" \n\t", "", "empty namespace read from test"},
+		{"Probes", "", `invalid namespace "Probes" read from test`},
+		{"probes/other", "", `invalid namespace "probes/other" read from test`},
+	}
+	for _, tt := range tests {
+		got, err := validNamespace(tt.ns, "test")
+		if tt.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("validNamespace(%q) error = %v, want %q", tt.ns, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("validNamespace(%q) = %q, %v, want %q", tt.ns, got, err, tt.want)
+		}
+	}
+}
+
+func TestCurrentNamespace(t *testing.T) {
+	t.Setenv(namespaceEnv, " probes\n")
+	if got, err := currentNamespace("kube-ns"); err != nil || got != "probes" {
+		t.Errorf("currentNamespace() = %q, %v, want the environment's probes", got, err)
+	}
+
+	// Set but empty is an error, not a fallback on a cluster-wide list
+	for _, ns := range []string{"", "   "} {
+		t.Setenv(namespaceEnv, ns)
+		_, err := currentNamespace("kube-ns")
+		if err == nil || !strings.Contains(err.Error(), namespaceEnv) {
+			t.Errorf("currentNamespace() with %s=%q error = %v, want one naming the variable", namespaceEnv, ns, err)
+		}
+	}
+}
+
+func TestCurrentNamespaceKubeconfig(t *testing.T) {
+	t.Setenv(namespaceEnv, "")
+	os.Unsetenv(namespaceEnv)
+	if got, err := currentNamespace(" kube-ns "); err != nil || got != "kube-ns" {
+		t.Errorf("currentNamespace() = %q, %v, want the kubeconfig's kube-ns", got, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runConfigMapMount measures how long an update to a ConfigMap takes to show
+// up in a volume mounting it in a running pod, which is paced by the
+// kubelet's sync period rather than by the API server.
+func (p *prober) runConfigMapMount(ctx context.Context) results.Probe {
+	mountResult := results.Probe{
+		Kind:    "configmap-mount",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+
+	name := fmt.Sprintf("probe-mount-%s", p.instance)
+	labels := p.labels(map[string]string{
+		"app":            "probe-mount",
+		"probe-instance": p.instance,
+	})
+	want, err := probe.Payload(32)
+	if err != nil {
+		mountResult.Outcome = results.OutcomeError
+		mountResult.Errors = append(mountResult.Errors, err.Error())
+		return mountResult
+	}
+
+	cm := probe.NewObjectWriter(p.clients, "configmap", probe.ObjectOptions{
+		Name:         name,
+		Namespace:    p.namespace,
+		Labels:       labels,
+		Annotations:  p.annotations(),
+		FieldManager: *fieldManager,
+	})
+	opts := probe.PodOptions{
+		Name:            name,
+		Namespace:       p.namespace,
+		Image:           p.cfg.Image,
+		FieldManager:    *fieldManager,
+		Labels:          labels,
+		Annotations:     p.annotations(),
+		Template:        p.cfg.PodTemplate,
+		Mutators:        append([]probe.PodMutator{probe.MountWatcher(name, want)}, p.cfg.Mutators...),
+		OwnerReferences: p.owners,
+	}
+
+	var (
+		created, observed corev1.Pod
+		observedAt        time.Time
+		updated           time.Time
+		skew              time.Duration
+		propagation       []results.Phase
+	)
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		{
+			Name:     "create-configmap",
+			Run:      func(ctx context.Context) error { return cm.Create(ctx, "initial") },
+			Teardown: cm.Delete,
+		},
+		probe.CreatePod(p.clients, opts, &created, &skew),
+		probe.WaitPodRunning(p.clients.Measure, p.namespace, name, p.cfg.PollInterval, &observed, &observedAt),
+		{
+			Name: "update-configmap",
+			Run: func(ctx context.Context) error {
+				err := cm.Update(ctx, want)
+				updated = time.Now()
+				return err
+			},
+		},
+		probe.WaitMountUpdated(p.clients.Measure, p.tracer, p.namespace, name, p.cfg.PollInterval, &updated, &skew, &propagation),
+	})
+	mountResult.Phases = append(phases, propagation...)
+	mountResult.Attributes["clock_skew_ms"] = strconv.FormatInt(skew.Milliseconds(), 10)
+	if observed.Spec.NodeName != "" {
+		mountResult.Attributes["node"] = observed.Spec.NodeName
+	}
+	if err != nil {
+		mountResult.Outcome = probe.OutcomeFor(err)
+		mountResult.Errors = append(mountResult.Errors, err.Error())
+	}
+
+	return mountResult
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var namespaceObjects = flag.Int("namespace-objects", 10, "number of ConfigMaps the namespace-deletion probe creates in its namespace, deleted by the namespace controller when deleting the namespace")
+
+// runNamespaceDeletion measures how long a namespace takes to be deleted:
+// a namespace is created with --namespace-objects ConfigMaps in it, then
+// deleted, until it is gone, the namespace controller having deleted its
+// content and removed its finalizer.
+func (p *prober) runNamespaceDeletion(ctx context.Context) results.Probe {
+	opts := probe.NamespaceOptions{
+		Name: fmt.Sprintf("probe-ns-%s", p.instance),
+		Labels: p.labels(map[string]string{
+			"app":            "probe",
+			"probe-instance": p.instance,
+		}),
+		Annotations:  p.annotations(),
+		FieldManager: *fieldManager,
+	}
+	nsResult := results.Probe{
+		Kind:    "namespace-deletion",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": opts.Name,
+			"instance":  p.instance,
+			"objects":   strconv.Itoa(*namespaceObjects),
+		},
+	}
+
+	// The teardown of create-namespace only deletes the namespace if the
+	// probe failed before delete-namespace did
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		probe.CreateNamespace(p.clients, opts),
+		probe.CreateNamespaceContent(p.clients.Measure, opts, *namespaceObjects),
+		probe.DeleteNamespace(p.clients.Measure, opts.Name, p.cfg.PollInterval),
+	})
+	nsResult.Phases = phases
+	if err != nil {
+		nsResult.Outcome = probe.OutcomeFor(err)
+		nsResult.Errors = append(nsResult.Errors, err.Error())
+	}
+
+	return nsResult
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	namespaceList     = flag.String("namespaces", "", "comma-separated namespaces in which every run probes concurrently, --count probes of the --probe kind in each, to compare their latencies, e.g. with different admission webhooks or quotas")
+	namespaceSelector = flag.String("namespace-selector", "", "label selector of the namespaces in which every run probes concurrently, listed at the start of every run, instead of --namespaces")
+)
+
+// attrNamespace is the attribute of the spans and metrics of the probes of a
+// run fanning out to several namespaces.
+const attrNamespace = "k8s.namespace.name"
+
+// namespaceFanOut is the set of namespaces every run probes, see --namespaces
+// and --namespace-selector.
+type namespaceFanOut struct {
+	names    []string
+	selector labels.Selector
+}
+
+// newNamespaceFanOut returns the namespaces of --namespaces or
+// --namespace-selector, or nil without either.
+func newNamespaceFanOut() (*namespaceFanOut, error) {
+	if !fansOutNamespaces() {
+		return nil, nil
+	}
+	var errs []error
+	if *namespaceList != "" && *namespaceSelector != "" {
+		errs = append(errs, errors.New("--namespaces and --namespace-selector are mutually exclusive"))
+	}
+	if *perNode || *perZone {
+		errs = append(errs, errors.New("--namespaces and --namespace-selector can't be used with --per-node or --per-zone"))
+	}
+	if *probeKind == "namespace-deletion" {
+		errs = append(errs, errors.New("--namespaces and --namespace-selector don't apply to the namespace-deletion probe, probing in a namespace of its own"))
+	}
+	if *ephemeralSA {
+		errs = append(errs, errors.New("--namespaces and --namespace-selector can't be used with --ephemeral-serviceaccount, bound in the prober's namespace"))
+	}
+	if *operatorMode {
+		errs = append(errs, errors.New("--namespaces and --namespace-selector can't be used with --operator, each LatencyProbe probing its own namespace"))
+	}
+	f := &namespaceFanOut{}
+	if *namespaceSelector != "" {
+		selector, err := labels.Parse(*namespaceSelector)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid --namespace-selector %q: %w", *namespaceSelector, err))
+		}
+		f.selector = selector
+	}
+	for ns := range strings.SplitSeq(*namespaceList, ",") {
+		if *namespaceList == "" {
+			break
+		}
+		ns, err := validNamespace(ns, "--namespaces")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if slices.Contains(f.names, ns) {
+			errs = append(errs, fmt.Errorf("namespace %q is listed twice in --namespaces", ns))
+			continue
+		}
+		f.names = append(f.names, ns)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// fansOutNamespaces reports whether runs probe several namespaces.
+func fansOutNamespaces() bool {
+	return *namespaceList != "" || *namespaceSelector != ""
+}
+
+// list returns the namespaces to probe, in order: those of --namespaces, or
+// those matching --namespace-selector at the time.
+func (f *namespaceFanOut) list(ctx context.Context, client kubernetes.Interface) ([]string, error) {
+	if f.selector == nil {
+		return f.names, nil
+	}
+	list, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: f.selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the namespaces matching %q: %w", f.selector, err)
+	}
+	var names []string
+	for _, ns := range list.Items {
+		if ns.DeletionTimestamp == nil {
+			names = append(names, ns.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no namespace matches %q", f.selector)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// inNamespace returns p probing in namespace instead. Owner references only
+// apply within the prober's namespace, home.
+func (p *prober) inNamespace(namespace, home string) *prober {
+	p.namespace = namespace
+	p.log = probeLogger(p.runID, p.instance, namespace, p.kind, p.cluster)
+	if namespace != home {
+		p.owners = nil
+	}
+	return p
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runObject measures create and update latency of a ConfigMap or Secret
+// carrying a payload of each of the requested sizes, then deletes it.
+func (p *prober) runObject(ctx context.Context, kind string, sizes []int) results.Probe {
+	objResult := results.Probe{
+		Kind:    kind,
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+
+	writes := must(otel.Meter("k8s-latency-probe").Float64Histogram("probe.write.duration",
+		metric.WithDescription("Duration of object writes, by payload size class."),
+		metric.WithUnit("ms"),
+	))
+
+	for i, size := range sizes {
+		class := probe.SizeClass(size)
+		w := probe.NewObjectWriter(p.clients, kind, probe.ObjectOptions{
+			Name:      fmt.Sprintf("probe-%s-%s-%d", kind, p.instance, i),
+			Namespace: p.namespace,
+			Labels: p.labels(map[string]string{
+				"app":            "probe",
+				"probe-instance": p.instance,
+			}),
+			Annotations:  p.annotations(),
+			FieldManager: *fieldManager,
+		})
+
+		write := func(fn func(context.Context, string) error) func(context.Context) error {
+			return func(ctx context.Context) error {
+				trace.SpanFromContext(ctx).SetAttributes(
+					attribute.Int("payload.size", size),
+					attribute.String("payload.size_class", class),
+				)
+				payload, err := probe.Payload(size)
+				if err != nil {
+					return err
+				}
+				return fn(ctx, payload)
+			}
+		}
+
+		phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+			{Name: "create", Run: write(w.Create), Teardown: w.Delete},
+			{Name: "update", Run: write(w.Update)},
+		})
+		for _, ph := range phases {
+			if ph.Outcome == results.OutcomeSuccess {
+				writes.Record(ctx, float64(ph.Duration.Microseconds())/1000, metric.WithAttributes(
+					attribute.String("probe.kind", kind),
+					attribute.String("probe.verb", ph.Name),
+					attribute.String("payload.size_class", class),
+				))
+			}
+			ph.Name = fmt.Sprintf("%s@%s", ph.Name, probe.FormatSize(size))
+			objResult.Phases = append(objResult.Phases, ph)
+		}
+		if err != nil {
+			objResult.Outcome = probe.OutcomeFor(err)
+			objResult.Errors = append(objResult.Errors, err.Error())
+			break
+		}
+	}
+
+	return objResult
+}
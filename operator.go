@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	operatorMode   = flag.Bool("operator", false, "run the probes described by LatencyProbe resources, each on its own interval, and write their results to their status, instead of the --probe")
+	operatorResync = flag.Duration("operator-resync", 10*time.Minute, "interval at which every LatencyProbe is reconciled again in operator mode, even if unchanged")
+)
+
+// latencyProbes are the LatencyProbe custom resources driving the probes in
+// operator mode, defined in crd.yaml.
+var latencyProbes = schema.GroupVersionResource{Group: "k8slatencyprobe.wperron.io", Version: "v1alpha1", Resource: "latencyprobes"}
+
+// defaultProbeInterval is the interval of a LatencyProbe that sets none.
+const defaultProbeInterval = time.Minute
+
+// LatencyProbe describes a probe run on its own interval in operator mode.
+type LatencyProbe struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LatencyProbeSpec   `json:"spec"`
+	Status LatencyProbeStatus `json:"status,omitempty"`
+}
+
+// LatencyProbeSpec is the desired state of a LatencyProbe.
+type LatencyProbeSpec struct {
+	// Probe is the kind of probe to run, as with --probe.
+	Probe string `json:"probe"`
+	// Interval is the interval between runs, as with --interval. Defaults
+	// to a minute.
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Namespace is the namespace the probe operates in. Defaults to the
+	// LatencyProbe's.
+	Namespace string `json:"namespace,omitempty"`
+	// Thresholds are the longest acceptable durations of the probe's phases,
+	// by phase name.
+	Thresholds map[string]metav1.Duration `json:"thresholds,omitempty"`
+}
+
+// LatencyProbeStatus is the observed state of a LatencyProbe, updated after
+// every run.
+type LatencyProbeStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Error is why the spec can't be run, if it can't.
+	Error string `json:"error,omitempty"`
+
+	LastRunID   string          `json:"lastRunID,omitempty"`
+	LastRunTime *metav1.Time    `json:"lastRunTime,omitempty"`
+	LastOutcome results.Outcome `json:"lastOutcome,omitempty"`
+	// LastLatency holds the duration of each successful phase of the last
+	// run, the longest one when it took several probes.
+	LastLatency map[string]metav1.Duration `json:"lastLatency,omitempty"`
+	// ThresholdsExceeded lists the phases of the last run that took longer
+	// than their threshold.
+	ThresholdsExceeded []string `json:"thresholdsExceeded,omitempty"`
+
+	// Runs and Successes count the runs since the LatencyProbe was created,
+	// skipped ones aside, and SuccessRate is their ratio.
+	Runs        int64  `json:"runs,omitempty"`
+	Successes   int64  `json:"successes,omitempty"`
+	SuccessRate string `json:"successRate,omitempty"`
+
+	// History holds the last --results-history probe results, oldest
+	// first.
+	History []probeRecord `json:"history,omitempty"`
+}
+
+// validate returns an error unless the spec can be run.
+func (s LatencyProbeSpec) validate() error {
+	var errs []error
+	if !slices.Contains(probeKinds, s.Probe) {
+		errs = append(errs, fmt.Errorf("unknown probe %q, must be one of %s", s.Probe, strings.Join(probeKinds, ", ")))
+	}
+	if s.Interval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("interval must not be negative, got %s", s.Interval.Duration))
+	}
+	if s.Namespace != "" {
+		if _, err := validNamespace(s.Namespace, "spec.namespace"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// observe updates the status with the results of a run.
+func (s *LatencyProbeStatus) observe(run results.Run, kind string, thresholds map[string]metav1.Duration) {
+	outcome := results.OutcomeSuccess
+	for _, pr := range run.Probes {
+		if pr.Outcome != results.OutcomeSuccess {
+			outcome = pr.Outcome
+			break
+		}
+	}
+	s.LastRunID = run.ID
+	s.LastRunTime = &metav1.Time{Time: run.Start}
+	s.LastOutcome = outcome
+	s.History = appendHistory(s.History, *resultsHistory, probeRecords(run)...)
+
+	s.LastLatency = map[string]metav1.Duration{}
+	for key, pa := range run.Aggregates.Phases {
+		if name, ok := strings.CutPrefix(key, kind+"/"); ok {
+			s.LastLatency[name] = metav1.Duration{Duration: pa.Max}
+		}
+	}
+	s.ThresholdsExceeded = nil
+	for _, name := range slices.Sorted(maps.Keys(thresholds)) {
+		if d, ok := s.LastLatency[name]; ok && d.Duration > thresholds[name].Duration {
+			s.ThresholdsExceeded = append(s.ThresholdsExceeded, name)
+		}
+	}
+
+	if outcome.Skipped() {
+		return
+	}
+	s.Runs++
+	if outcome == results.OutcomeSuccess {
+		s.Successes++
+	}
+	s.SuccessRate = strconv.FormatFloat(float64(s.Successes)/float64(s.Runs), 'f', 3, 64)
+}
+
+// operator runs the probes described by LatencyProbe resources, each with
+// its own runner and interval, restarting a probe whenever its spec changes.
+type operator struct {
+	base   *runner
+	client dynamic.Interface
+
+	mu      sync.Mutex
+	workers map[string]*probeWorker
+	wg      sync.WaitGroup
+}
+
+// probeWorker runs the probe of a single LatencyProbe.
+type probeWorker struct {
+	generation int64
+	runner     *runner
+	cancel     context.CancelFunc
+}
+
+func newOperator(base *runner, client dynamic.Interface) *operator {
+	return &operator{base: base, client: client, workers: map[string]*probeWorker{}}
+}
+
+// run watches the LatencyProbe resources of every namespace until ctx is
+// done, then waits for the runs in flight to end.
+func (o *operator) run(ctx context.Context) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(o.client, *operatorResync)
+	informer := factory.ForResource(latencyProbes).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { o.sync(ctx, obj) },
+		UpdateFunc: func(_, obj any) { o.sync(ctx, obj) },
+		DeleteFunc: func(obj any) { o.remove(ctx, obj) },
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to watch LatencyProbe resources", "error", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "Watching LatencyProbe resources", "resource", latencyProbes.String())
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+
+	slog.InfoContext(ctx, "Stopping, waiting for the runs in flight")
+	o.wg.Wait()
+
+	// Start from scratch if run again, e.g. on becoming the leader again
+	o.mu.Lock()
+	clear(o.workers)
+	o.mu.Unlock()
+}
+
+// sync starts running the probe of the LatencyProbe obj, or restarts it if
+// its spec changed since.
+func (o *operator) sync(ctx context.Context, obj any) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	key := u.GetNamespace() + "/" + u.GetName()
+	var lp LatencyProbe
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &lp); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode LatencyProbe", "latencyprobe", key, "error", err)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if w := o.workers[key]; w != nil {
+		if w.generation == lp.Generation {
+			return
+		}
+		slog.InfoContext(ctx, "LatencyProbe changed, restarting its probe", "latencyprobe", key)
+		w.cancel()
+		delete(o.workers, key)
+	}
+
+	status := lp.Status
+	status.ObservedGeneration = lp.Generation
+	status.Error = ""
+	if err := lp.Spec.validate(); err != nil {
+		slog.ErrorContext(ctx, "Invalid LatencyProbe, not running it", "latencyprobe", key, "error", err)
+		status.Error = err.Error()
+		o.applyStatus(ctx, &lp, status)
+		return
+	}
+
+	if lp.Status.Error != "" || lp.Status.ObservedGeneration != lp.Generation {
+		o.applyStatus(ctx, &lp, status)
+	}
+
+	namespace := lp.Spec.Namespace
+	if namespace == "" {
+		namespace = lp.Namespace
+	}
+	interval := lp.Spec.Interval.Duration
+	if interval == 0 {
+		interval = defaultProbeInterval
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	w := &probeWorker{
+		generation: lp.Generation,
+		runner:     o.base.forProbe(lp.Spec.Probe, namespace),
+		cancel:     cancel,
+	}
+	if w.runner.eventTarget != nil {
+		w.runner.eventTarget = &corev1.ObjectReference{
+			APIVersion: latencyProbes.GroupVersion().String(),
+			Kind:       "LatencyProbe",
+			Namespace:  lp.Namespace,
+			Name:       lp.Name,
+			UID:        lp.UID,
+		}
+	}
+	w.runner.thresholds = maps.Clone(o.base.thresholds)
+	if w.runner.thresholds == nil {
+		w.runner.thresholds = map[string]time.Duration{}
+	}
+	for name, d := range lp.Spec.Thresholds {
+		w.runner.thresholds[name] = d.Duration
+	}
+	// A run cut short by the LatencyProbe changing or going away isn't
+	// reported, its successor's will be.
+	w.runner.report = func(_ context.Context, run results.Run, _ int) {
+		if wctx.Err() != nil {
+			return
+		}
+		status.observe(run, lp.Spec.Probe, lp.Spec.Thresholds)
+		o.applyStatus(wctx, &lp, status)
+	}
+	o.workers[key] = w
+
+	slog.InfoContext(ctx, "Running LatencyProbe", "latencyprobe", key, "probe", lp.Spec.Probe, "namespace", namespace, "interval", interval.String())
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		w.runner.loop(wctx, interval)
+	}()
+}
+
+// remove stops running the probe of the deleted LatencyProbe obj.
+func (o *operator) remove(ctx context.Context, obj any) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if w := o.workers[key]; w != nil {
+		slog.InfoContext(ctx, "LatencyProbe deleted, stopping its probe", "latencyprobe", key)
+		w.cancel()
+		delete(o.workers, key)
+	}
+}
+
+// applyStatus replaces the status of lp with status, logging failures: the
+// next run will try again.
+func (o *operator) applyStatus(ctx context.Context, lp *LatencyProbe, status LatencyProbeStatus) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode LatencyProbe status", "latencyprobe", lp.Namespace+"/"+lp.Name, "error", err)
+		return
+	}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(latencyProbes.GroupVersion().WithKind("LatencyProbe"))
+	u.SetNamespace(lp.Namespace)
+	u.SetName(lp.Name)
+	u.Object["status"] = content
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	_, err = o.client.Resource(latencyProbes).Namespace(lp.Namespace).ApplyStatus(ctx, lp.Name, u, metav1.ApplyOptions{
+		FieldManager: *fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to update LatencyProbe status", "latencyprobe", lp.Namespace+"/"+lp.Name, "error", err)
+	}
+}
+
+// activeRuns returns the IDs of the runs in flight, whose objects the reaper
+// must leave alone.
+func (o *operator) activeRuns() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ids := o.base.activeRuns()
+	for _, w := range o.workers {
+		ids = append(ids, w.runner.activeRuns()...)
+	}
+	return ids
+}
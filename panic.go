@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runPanic is a panic recovered in the run path, along with the stack of the
+// goroutine it happened in.
+type runPanic struct {
+	value any
+	stack []byte
+}
+
+func (p *runPanic) Error() string {
+	return fmt.Sprintf("panic: %v", p.value)
+}
+
+// newRunPanic wraps a recovered value, capturing the current stack unless it
+// was already recovered once in another goroutine, here or by a
+// probe.Prober.
+func newRunPanic(r any) *runPanic {
+	switch rp := r.(type) {
+	case *runPanic:
+		return rp
+	case *probe.PanicError:
+		return &runPanic{value: rp.Value, stack: rp.Stack}
+	}
+	return &runPanic{value: r, stack: debug.Stack()}
+}
+
+// forwardPanic recovers a panic in a probe's helper goroutine and sends it to
+// panicked, so the probe can re-raise it in the run path. It must be
+// deferred.
+func forwardPanic(panicked chan<- *runPanic) {
+	if r := recover(); r != nil {
+		panicked <- newRunPanic(r)
+	}
+}
+
+// runProbe runs the probe of the given kind. A panic in the probe is
+// recovered and turned into an error result, recorded on the span in ctx,
+// so that the run still ends through the regular shutdown sequence with a
+// complete trace. The returned error is only set in that case.
+func (p *prober) runProbe(ctx context.Context, kind string, run func(context.Context) results.Probe) (result results.Probe, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		rp := newRunPanic(r)
+		p.log.ErrorContext(ctx, "Probe panicked", "error", rp, "stack", string(rp.stack))
+
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(rp, trace.WithAttributes(attribute.String("exception.stacktrace", string(rp.stack))))
+		span.SetStatus(codes.Error, rp.Error())
+
+		// Keep the outcome class of a panic with an error
+		outcome := results.OutcomeError
+		if err, ok := rp.value.(error); ok {
+			outcome = probe.OutcomeFor(err)
+		}
+		result = results.Probe{
+			Kind:       kind,
+			Outcome:    outcome,
+			Attributes: map[string]string{"namespace": p.namespace, "instance": p.instance, attrPanic: "true"},
+			Errors:     []string{rp.Error()},
+		}
+		err = rp
+	}()
+
+	return run(ctx), nil
+}
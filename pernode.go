@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+var (
+	perNode      = flag.Bool("per-node", false, "run --count probes on each schedulable, ready node, or on a --node-sample of them, with a node.name attribute, to find slow kubelets and container runtimes")
+	nodeSelector = flag.String("node-selector", "", "label selector restricting the nodes probed by --per-node and --per-zone, e.g. pool=general")
+	nodeSample   = flag.Int("node-sample", 0, "number of nodes --per-node probes in each run, rotating through the whole fleet across runs and spread across zones; 0 for all of them")
+	nodePinning  = flag.String("node-pinning", pinNodeName, "how --per-node pins the probe pods to their node, either node-name (bypassing the scheduler) or affinity (a required node affinity, going through the scheduler)")
+)
+
+// Ways --per-node pins the probe pods to their node.
+const (
+	pinNodeName = "node-name"
+	pinAffinity = "affinity"
+)
+
+// perZoneKinds are the probes creating their pods from the probe.PodOptions
+// mutators, which --per-node and --per-zone pin.
+var perZoneKinds = []string{"pod", "pod-status", "pod-ready", "endpoints", "configmap-mount", "dns", "pvc", "image-pull"}
+
+// perNodeKinds are the probes --per-node applies to: the perZoneKinds, and
+// the e2e probe, which measures the data path of each node.
+var perNodeKinds = append(slices.Clone(perZoneKinds), "e2e")
+
+// samplingKinds returns the probes --per-node, or --per-zone when set,
+// applies to.
+func samplingKinds() []string {
+	if *perZone {
+		return perZoneKinds
+	}
+	return perNodeKinds
+}
+
+// newNodeSampler returns the sampler picking the nodes or zones of each run,
+// or nil without --per-node or --per-zone.
+func newNodeSampler(kind string) (*probe.NodeSampler, error) {
+	if !*perNode && !*perZone {
+		return nil, nil
+	}
+	var errs []error
+	mode := "--per-node"
+	if *perZone {
+		mode = "--per-zone"
+	}
+	if *perNode && *perZone {
+		errs = append(errs, errors.New("--per-node and --per-zone are mutually exclusive"))
+	}
+	if !slices.Contains(samplingKinds(), kind) {
+		errs = append(errs, fmt.Errorf("%s doesn't apply to the %s probe", mode, kind))
+	}
+	if *perZone && *nodeSample != 0 {
+		errs = append(errs, errors.New("--node-sample only applies to --per-node"))
+	}
+	if *nodeSample < 0 {
+		errs = append(errs, fmt.Errorf("--node-sample must not be negative, got %d", *nodeSample))
+	}
+	if *nodePinning != pinNodeName && *nodePinning != pinAffinity {
+		errs = append(errs, fmt.Errorf("invalid --node-pinning %q, must be one of %s or %s", *nodePinning, pinNodeName, pinAffinity))
+	}
+	selector, err := labels.Parse(*nodeSelector)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid --node-selector: %w", err))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return probe.NewNodeSampler(*nodeSample, selector), nil
+}
+
+// Attribute keys describing the --node-sample of a run, set on the run's
+// span and on the result of each of its probes.
+const (
+	// attrNodeSampleSeed is the seed the nodes were shuffled with.
+	attrNodeSampleSeed = "node.sample.seed"
+	// attrNodeSampleCovered is the number of nodes sampled in the current
+	// rotation so far, this run's included, and attrNodeSampleCandidates
+	// the number of nodes the rotation goes through.
+	attrNodeSampleCovered    = "node.sample.covered"
+	attrNodeSampleCandidates = "node.sample.candidates"
+)
+
+// runNodeSample is the sample of nodes a run probes with --per-node.
+type runNodeSample struct {
+	names      []string
+	seed       int64
+	covered    int
+	candidates int
+}
+
+// attributes returns the attributes describing the sample.
+func (s runNodeSample) attributes() map[string]string {
+	return map[string]string{
+		attrNodeSampleSeed:       strconv.FormatInt(s.seed, 10),
+		attrNodeSampleCovered:    strconv.Itoa(s.covered),
+		attrNodeSampleCandidates: strconv.Itoa(s.candidates),
+	}
+}
+
+// sampleNodes returns the nodes to probe in this run. With
+// --results-configmap, the rotation picks up where the last run saved it,
+// whichever replica or process took it, and is saved for the next one.
+func (r *runner) sampleNodes(ctx context.Context, log *slog.Logger) (runNodeSample, error) {
+	nodes, err := r.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: r.nodes.Selector.String(),
+	})
+	if err != nil {
+		return runNodeSample{}, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	if covered, ok, err := r.history.nodeCoverage(ctx, r.clientset, r.namespace); err != nil {
+		log.WarnContext(ctx, "Failed to load the node rotation, going on with this process's", "error", err)
+	} else if ok {
+		r.nodes.Restore(covered)
+	}
+	sample := runNodeSample{
+		seed:       time.Now().UnixNano(),
+		candidates: len(r.nodes.Candidates(nodes.Items)),
+	}
+	sample.names = r.nodes.Sample(nodes.Items, sample.seed)
+	if len(sample.names) == 0 {
+		return runNodeSample{}, fmt.Errorf("no schedulable, ready node matches --node-selector %q", *nodeSelector)
+	}
+	covered := r.nodes.Covered()
+	sample.covered = len(covered)
+	if err := r.history.saveNodeCoverage(ctx, r.clientset, r.namespace, covered); err != nil {
+		log.WarnContext(ctx, "Failed to save the node rotation", "error", err)
+	}
+
+	log.InfoContext(ctx, "Sampled nodes", "nodes", sample.names, "seed", sample.seed, "covered", sample.covered, "candidates", sample.candidates)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int64(attrNodeSampleSeed, sample.seed),
+		attribute.Int(attrNodeSampleCovered, sample.covered),
+		attribute.Int(attrNodeSampleCandidates, sample.candidates),
+	)
+	return sample, nil
+}
+
+// onNode returns a copy of the sample prober p whose pods are pinned to the
+// named node.
+func (p *prober) onNode(node string) *prober {
+	pin := probe.PinToNode(node)
+	if *nodePinning == pinAffinity {
+		pin = probe.RequireNode(node)
+	}
+	p.cfg.Mutators = slices.Concat(p.cfg.Mutators, []probe.PodMutator{pin})
+	p.node = node
+	return p
+}
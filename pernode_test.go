@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+func TestSampleNodesRotationSaved(t *testing.T) {
+	client := fake.NewClientset()
+	ctx := context.Background()
+	for i := range 4 {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)},
+			Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			}},
+		}
+		if _, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newRunner := func() *runner {
+		return &runner{
+			clientset: client,
+			namespace: "probes",
+			history:   newHistoryConfigMap("probe-results", 10),
+			nodes:     probe.NewNodeSampler(2, labels.Everything()),
+		}
+	}
+
+	first, err := newRunner().sampleNodes(ctx, slog.Default())
+	if err != nil {
+		t.Fatalf("sampleNodes() error = %v", err)
+	}
+	if first.covered != 2 || first.candidates != 4 {
+		t.Errorf("first sample covered %d of %d nodes, want 2 of 4", first.covered, first.candidates)
+	}
+
+	// A new process picks the rotation up from the ConfigMap
+	second, err := newRunner().sampleNodes(ctx, slog.Default())
+	if err != nil {
+		t.Fatalf("sampleNodes() error = %v", err)
+	}
+	for _, name := range second.names {
+		if slices.Contains(first.names, name) {
+			t.Errorf("second sample %v repeats a node of the first %v", second.names, first.names)
+		}
+	}
+	if second.covered != 4 {
+		t.Errorf("second sample covered %d nodes, want the whole fleet", second.covered)
+	}
+
+	attrs := second.attributes()
+	if attrs[attrNodeSampleSeed] != fmt.Sprint(second.seed) || attrs[attrNodeSampleCovered] != "4" || attrs[attrNodeSampleCandidates] != "4" {
+		t.Errorf("attributes = %v, want the seed and the coverage", attrs)
+	}
+}
@@ -0,0 +1,213 @@
+// Package objectstore uploads objects to buckets through the S3 API, which
+// both S3 and GCS, through its interoperability API and HMAC keys, serve.
+// Requests are signed with AWS Signature Version 4.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default endpoints of the bucket URL schemes. The S3 one is formatted with
+// the region.
+const (
+	s3Endpoint  = "https://s3.%s.amazonaws.com"
+	gcsEndpoint = "https://storage.googleapis.com"
+)
+
+// Credentials sign the requests to the bucket.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set with temporary credentials.
+	SessionToken string
+}
+
+// CredentialsFromEnv returns the credentials set in AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, the HMAC key for GCS.
+func CredentialsFromEnv() (Credentials, error) {
+	creds := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return Credentials{}, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return creds, nil
+}
+
+// Bucket uploads objects under a prefix of a bucket.
+type Bucket struct {
+	// Endpoint is the base URL of the S3 API, the bucket being the first
+	// segment of the path of objects.
+	Endpoint string
+	Region   string
+	Name     string
+	// Prefix is prepended to the key of every object, without a trailing
+	// slash.
+	Prefix      string
+	Credentials Credentials
+	Client      *http.Client
+}
+
+// ParseBucket parses a bucket URL, s3://bucket/prefix or gs://bucket/prefix.
+// An empty endpoint defaults to the one of the URL's scheme, in the region
+// for S3.
+func ParseBucket(rawURL, endpoint, region string) (*Bucket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bucket URL %q: %w", rawURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid bucket URL %q: missing bucket name", rawURL)
+	}
+	switch u.Scheme {
+	case "s3":
+		if region == "" {
+			region = "us-east-1"
+		}
+		if endpoint == "" {
+			endpoint = fmt.Sprintf(s3Endpoint, region)
+		}
+	case "gs":
+		// GCS accepts any region with HMAC keys, "auto" is the documented one
+		if region == "" {
+			region = "auto"
+		}
+		if endpoint == "" {
+			endpoint = gcsEndpoint
+		}
+	default:
+		return nil, fmt.Errorf("invalid bucket URL %q: scheme must be s3 or gs", rawURL)
+	}
+	return &Bucket{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Region:   region,
+		Name:     u.Host,
+		Prefix:   strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+// Key returns the key of the object named name under the bucket's prefix.
+func (b *Bucket) Key(name string) string {
+	return path.Join(b.Prefix, name)
+}
+
+// Put uploads body as the object key, replacing it if it exists.
+func (b *Bucket) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	u, err := url.Parse(b.Endpoint + "/" + escapePath(b.Name+"/"+key))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	b.sign(req, body, time.Now())
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to upload %s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 authorization of req to its headers.
+func (b *Bucket) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.Credentials.SessionToken)
+	}
+
+	var names []string
+	headers := make(map[string]string)
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		names = append(names, name)
+		headers[name] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + b.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+b.Credentials.SecretAccessKey), date)
+	for _, part := range []string{b.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.Credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// escapePath escapes every character of p but the unreserved ones of RFC
+// 3986 and slashes, as Signature Version 4 expects of canonical URIs.
+func escapePath(p string) string {
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~', c == '/':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,189 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AlertStatus is the status carried by an alert notification.
+type AlertStatus string
+
+const (
+	AlertFiring   AlertStatus = "firing"
+	AlertResolved AlertStatus = "resolved"
+)
+
+// Sample is a single measurement of a phase checked against its SLO
+// threshold.
+type Sample struct {
+	Kind      string
+	Phase     string
+	Measured  time.Duration
+	Threshold time.Duration
+	// TraceID is the ID of the trace the sample was measured in.
+	TraceID string
+}
+
+// Violated reports whether the sample exceeds its threshold.
+func (s Sample) Violated() bool {
+	return s.Measured > s.Threshold
+}
+
+// Alert is the payload posted to the alert webhook.
+type Alert struct {
+	Status    AlertStatus `json:"status"`
+	Kind      string      `json:"kind"`
+	Phase     string      `json:"phase"`
+	Cluster   string      `json:"cluster,omitempty"`
+	Measured  string      `json:"measured"`
+	Threshold string      `json:"threshold"`
+	// Streak is the number of violating samples so far, including the ones
+	// interleaved with good samples while the alert was firing.
+	Streak int       `json:"streak"`
+	Since  time.Time `json:"since"`
+	// TraceID is the trace of the worst sample of the streak.
+	TraceID string    `json:"trace_id,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Alerter notifies a webhook of SLO violations, deduplicated per probe kind
+// and phase: the first violation of a streak fires an alert, and a
+// resolution is sent once ResolveAfter consecutive samples are good again.
+// Violations in between, including flapping ones, only extend the streak. A
+// nil *Alerter never notifies.
+type Alerter struct {
+	URL string
+	// Client defaults to a client with a 10 seconds timeout.
+	Client *http.Client
+	// ResolveAfter is the number of consecutive good samples resolving a
+	// firing alert. Values below 1 resolve on the first good sample.
+	ResolveAfter int
+	// Cluster identifies the probed cluster in alerts.
+	Cluster string
+
+	mu      sync.Mutex
+	streaks map[string]*alertStreak
+}
+
+// alertStreak is the state of a single kind and phase.
+type alertStreak struct {
+	firing     bool
+	since      time.Time
+	violations int
+	good       int
+	worst      Sample
+}
+
+// NewAlerter returns an alerter posting to url, resolving alerts after
+// resolveAfter consecutive good samples.
+func NewAlerter(url string, resolveAfter int, cluster string) *Alerter {
+	return &Alerter{
+		URL:          url,
+		ResolveAfter: resolveAfter,
+		Cluster:      cluster,
+		streaks:      make(map[string]*alertStreak),
+	}
+}
+
+// Observe records s and returns the alert it triggered, if any. The
+// returned alert still needs to be sent with Send.
+func (a *Alerter) Observe(s Sample) *Alert {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := s.Kind + "/" + s.Phase
+	st := a.streaks[key]
+	if st == nil {
+		st = &alertStreak{}
+		a.streaks[key] = st
+	}
+
+	if s.Violated() {
+		st.good = 0
+		st.violations++
+		if st.violations == 1 || s.Measured > st.worst.Measured {
+			st.worst = s
+		}
+		if st.firing {
+			return nil
+		}
+		st.firing = true
+		st.since = time.Now()
+		return a.alert(AlertFiring, st)
+	}
+
+	if !st.firing {
+		st.violations = 0
+		return nil
+	}
+	st.good++
+	if st.good < a.ResolveAfter {
+		return nil
+	}
+	alert := a.alert(AlertResolved, st)
+	alert.Measured = s.Measured.String()
+	delete(a.streaks, key)
+	return alert
+}
+
+func (a *Alerter) alert(status AlertStatus, st *alertStreak) *Alert {
+	return &Alert{
+		Status:    status,
+		Kind:      st.worst.Kind,
+		Phase:     st.worst.Phase,
+		Cluster:   a.Cluster,
+		Measured:  st.worst.Measured.String(),
+		Threshold: st.worst.Threshold.String(),
+		Streak:    st.violations,
+		Since:     st.since,
+		TraceID:   st.worst.TraceID,
+		Time:      time.Now(),
+	}
+}
+
+// Send posts alert to the webhook as JSON.
+func (a *Alerter) Send(ctx context.Context, alert *Alert) error {
+	if a == nil || alert == nil {
+		return nil
+	}
+	if err := postJSON(ctx, a.Client, a.URL, alert); err != nil {
+		return fmt.Errorf("failed to send %s alert: %w", alert.Status, err)
+	}
+	return nil
+}
+
+// postJSON posts payload as JSON to the webhook at url with client, one with
+// a 10 seconds timeout when nil, and fails unless it answers with a 2xx.
+func postJSON(ctx context.Context, client *http.Client, url string, payload any) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlerterFlapping(t *testing.T) {
+	a := NewAlerter("http://alerts", 3, "prod")
+	sample := func(measured time.Duration, traceID string) Sample {
+		return Sample{Kind: "pod", Phase: "wait-for-pod", Measured: measured, Threshold: 2 * time.Second, TraceID: traceID}
+	}
+	steps := []struct {
+		sample Sample
+		want   AlertStatus
+	}{
+		{sample(time.Second, "t0"), ""},
+		{sample(3*time.Second, "t1"), AlertFiring},
+		{sample(5*time.Second, "t2"), ""},
+		// Flapping: good samples short of the hysteresis don't resolve it
+		{sample(time.Second, "t3"), ""},
+		{sample(time.Second, "t4"), ""},
+		{sample(4*time.Second, "t5"), ""},
+		{sample(time.Second, "t6"), ""},
+		{sample(time.Second, "t7"), ""},
+		{sample(1500*time.Millisecond, "t8"), AlertResolved},
+		{sample(time.Second, "t9"), ""},
+		// A new streak fires again
+		{sample(3*time.Second, "t10"), AlertFiring},
+	}
+	var alerts []*Alert
+	for i, s := range steps {
+		alert := a.Observe(s.sample)
+		var got AlertStatus
+		if alert != nil {
+			got = alert.Status
+			alerts = append(alerts, alert)
+		}
+		if got != s.want {
+			t.Errorf("step %d: Observe(%s) = %q, want %q", i, s.sample.Measured, got, s.want)
+		}
+	}
+	if len(alerts) != 3 {
+		t.Fatalf("got %d alerts, want 3", len(alerts))
+	}
+
+	firing, resolved := alerts[0], alerts[1]
+	if firing.Measured != "3s" || firing.Threshold != "2s" || firing.Streak != 1 || firing.TraceID != "t1" || firing.Cluster != "prod" {
+		t.Errorf("firing alert = %+v, want the first violation", firing)
+	}
+	// The streak counts every violation, and the worst one is reported
+	if resolved.Streak != 3 || resolved.TraceID != "t2" || resolved.Measured != "1.5s" || !resolved.Since.Equal(firing.Since) {
+		t.Errorf("resolved alert = %+v, want 3 violations, the worst in t2 and the last measurement", resolved)
+	}
+	if alerts[2].Streak != 1 || alerts[2].TraceID != "t10" {
+		t.Errorf("second firing alert = %+v, want a new streak", alerts[2])
+	}
+}
+
+func TestAlerterPerPhase(t *testing.T) {
+	a := NewAlerter("http://alerts", 1, "")
+	slow := Sample{Kind: "pod", Phase: "wait-for-pod", Measured: 3 * time.Second, Threshold: 2 * time.Second}
+	if alert := a.Observe(slow); alert == nil || alert.Status != AlertFiring {
+		t.Fatalf("Observe() = %+v, want a firing alert", alert)
+	}
+	other := slow
+	other.Kind = "dns"
+	if alert := a.Observe(other); alert == nil || alert.Status != AlertFiring || alert.Kind != "dns" {
+		t.Errorf("Observe() of another kind = %+v, want its own firing alert", alert)
+	}
+	good := slow
+	good.Measured = time.Second
+	if alert := a.Observe(good); alert == nil || alert.Status != AlertResolved || alert.Kind != "pod" {
+		t.Errorf("Observe() = %+v, want the pod alert resolved by a single good sample", alert)
+	}
+}
+
+func TestAlerterDisabled(t *testing.T) {
+	var a *Alerter
+	if alert := a.Observe(Sample{Measured: time.Second}); alert != nil {
+		t.Errorf("nil Observe() = %+v, want nil", alert)
+	}
+	if err := a.Send(context.Background(), &Alert{}); err != nil {
+		t.Errorf("nil Send() error = %v", err)
+	}
+}
+
+func TestAlerterSend(t *testing.T) {
+	var got Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode alert: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	a := NewAlerter(server.URL, 3, "prod")
+	alert := a.Observe(Sample{Kind: "pod", Phase: "wait-for-pod", Measured: 3 * time.Second, Threshold: 2 * time.Second, TraceID: "abc"})
+	if err := a.Send(context.Background(), alert); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got.Status != AlertFiring || got.Kind != "pod" || got.Phase != "wait-for-pod" || got.TraceID != "abc" {
+		t.Errorf("posted %+v, want the firing alert", got)
+	}
+
+	server.Config.Handler = http.NotFoundHandler()
+	if err := a.Send(context.Background(), alert); err == nil {
+		t.Error("Send() to a failing webhook error = nil, want one")
+	}
+}
@@ -0,0 +1,86 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// apfRefreshInterval bounds how often APFNames lists the FlowSchemas and
+// PriorityLevelConfigurations again when meeting an unknown UID.
+const apfRefreshInterval = 30 * time.Second
+
+// APFNames resolves the UIDs of the FlowSchemas and
+// PriorityLevelConfigurations the API server reports having classified a
+// request under, in its API Priority and Fairness response headers, to
+// their names. Its cache is refreshed in the background when it meets an
+// unknown UID, so that resolving never adds a request to the one being
+// recorded.
+type APFNames struct {
+	client kubernetes.Interface
+
+	mu          sync.Mutex
+	names       map[types.UID]string
+	refreshing  bool
+	lastRefresh time.Time
+}
+
+// NewAPFNames returns a resolver listing the FlowSchemas and
+// PriorityLevelConfigurations with client, which must not record the API
+// Priority and Fairness headers itself.
+func NewAPFNames(client kubernetes.Interface) *APFNames {
+	return &APFNames{client: client, names: map[types.UID]string{}}
+}
+
+// Refresh lists the FlowSchemas and PriorityLevelConfigurations, replacing
+// the cached names.
+func (n *APFNames) Refresh(ctx context.Context) error {
+	names := map[types.UID]string{}
+	flowSchemas, fsErr := n.client.FlowcontrolV1().FlowSchemas().List(ctx, metav1.ListOptions{})
+	if fsErr == nil {
+		for _, fs := range flowSchemas.Items {
+			names[fs.UID] = fs.Name
+		}
+	}
+	priorityLevels, plErr := n.client.FlowcontrolV1().PriorityLevelConfigurations().List(ctx, metav1.ListOptions{})
+	if plErr == nil {
+		for _, pl := range priorityLevels.Items {
+			names[pl.UID] = pl.Name
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.lastRefresh = time.Now()
+	if fsErr == nil && plErr == nil {
+		n.names = names
+	}
+	return errors.Join(fsErr, plErr)
+}
+
+// Name returns the name of the FlowSchema or PriorityLevelConfiguration of
+// the given UID, if known. An unknown UID, e.g. of a FlowSchema created
+// since the last refresh, triggers a refresh in the background, at most
+// every 30s.
+func (n *APFNames) Name(uid string) (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	name, ok := n.names[types.UID(uid)]
+	if !ok && !n.refreshing && time.Since(n.lastRefresh) >= apfRefreshInterval {
+		n.refreshing = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = n.Refresh(ctx)
+			n.mu.Lock()
+			n.refreshing = false
+			n.mu.Unlock()
+		}()
+	}
+	return name, ok
+}
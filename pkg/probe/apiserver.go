@@ -0,0 +1,171 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ParseHeader parses a "key=value" static header.
+func ParseHeader(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid header %q, must be key=value", s)
+	}
+	return http.CanonicalHeaderKey(key), value, nil
+}
+
+// HeaderWrapper returns a function setting header on every request, it can
+// be used as a rest.Config's WrapTransport, e.g. for headers expected by an
+// authenticating proxy in front of the API server.
+func HeaderWrapper(header http.Header) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return headerTransport{header: header, next: rt}
+	}
+}
+
+type headerTransport struct {
+	header http.Header
+	next   http.RoundTripper
+}
+
+func (rt headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range rt.header {
+		req.Header[key] = values
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// API server connection layers, as reported by CheckAPIServer.
+const (
+	LayerDNS       = "dns"
+	LayerConnect   = "connect"
+	LayerTLS       = "tls"
+	LayerProxyAuth = "proxy-auth"
+	LayerProxy     = "proxy"
+	LayerBackend   = "backend"
+)
+
+// LayerError is returned by CheckAPIServer, naming the layer of the
+// connection to the API server that failed.
+type LayerError struct {
+	Layer string
+	Err   error
+}
+
+func (e *LayerError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Layer, e.Err)
+}
+
+func (e *LayerError) Unwrap() error {
+	return e.Err
+}
+
+// CheckAPIServer requests host's /version endpoint with client, recording
+// the time spent in each connection layer as attributes of the span in ctx.
+// When it fails, it returns a LayerError telling whether name resolution,
+// the connection, the TLS handshake, an authenticating proxy or the API
+// server behind it is at fault.
+func CheckAPIServer(ctx context.Context, client *http.Client, host string) error {
+	span := trace.SpanFromContext(ctx)
+
+	var (
+		start                      = time.Now()
+		dnsStart, connStart, tlsAt time.Time
+		tlsFailed, reached         bool
+	)
+	ct := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			span.SetAttributes(attribute.Int64("http.dns_ms", time.Since(dnsStart).Milliseconds()))
+		},
+		ConnectStart: func(string, string) { connStart = time.Now() },
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				span.SetAttributes(attribute.Int64("http.connect_ms", time.Since(connStart).Milliseconds()))
+			}
+		},
+		TLSHandshakeStart: func() { tlsAt = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err != nil {
+				tlsFailed = true
+				return
+			}
+			span.SetAttributes(attribute.Int64("http.tls_ms", time.Since(tlsAt).Milliseconds()))
+		},
+		GotFirstResponseByte: func() {
+			reached = true
+			span.SetAttributes(attribute.Int64("http.first_byte_ms", time.Since(start).Milliseconds()))
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, ct), http.MethodGet, strings.TrimSuffix(host, "/")+"/version", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &LayerError{Layer: transportLayer(err, tlsFailed, reached), Err: err}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	err = fmt.Errorf("GET /version: %s", resp.Status)
+
+	// Only the API server answers with a Status object, anything else is
+	// the proxy's own response.
+	var status metav1.Status
+	if json.Unmarshal(body, &status) == nil && status.Kind == "Status" {
+		return &LayerError{Layer: LayerBackend, Err: fmt.Errorf("%w: %s", err, status.Message)}
+	}
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusProxyAuthRequired:
+		return &LayerError{Layer: LayerProxyAuth, Err: err}
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		// The proxy couldn't reach the API server
+		return &LayerError{Layer: LayerBackend, Err: err}
+	default:
+		return &LayerError{Layer: LayerProxy, Err: err}
+	}
+}
+
+// transportLayer returns the layer a request failing with err before getting
+// a response failed at.
+func transportLayer(err error, tlsFailed, reached bool) string {
+	var (
+		dnsErr    *net.DNSError
+		certErr   *tls.CertificateVerificationError
+		unknownCA x509.UnknownAuthorityError
+		hostErr   x509.HostnameError
+		recordErr tls.RecordHeaderError
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return LayerDNS
+	case tlsFailed, errors.As(err, &certErr), errors.As(err, &unknownCA), errors.As(err, &hostErr), errors.As(err, &recordErr):
+		return LayerTLS
+	case reached:
+		return LayerProxy
+	default:
+		return LayerConnect
+	}
+}
@@ -0,0 +1,68 @@
+package probe
+
+import (
+	"errors"
+	"regexp"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// managerPattern extracts the manager name from a FieldManagerConflict
+// cause message, e.g. `conflict with "kubectl" using v1: .spec.replicas`.
+var managerPattern = regexp.MustCompile(`conflict with "([^"]*)"`)
+
+// Conflict is a single field owned by another manager that caused an Apply
+// to fail.
+type Conflict struct {
+	Manager string
+	Field   string
+}
+
+// Conflicts returns the conflicting managers and fields of a server-side
+// apply conflict error, or nil if err isn't one.
+func Conflicts(err error) []Conflict {
+	var status apierrors.APIStatus
+	if !apierrors.IsConflict(err) || !errors.As(err, &status) {
+		return nil
+	}
+	details := status.Status().Details
+	if details == nil {
+		return nil
+	}
+
+	var out []Conflict
+	for _, cause := range details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		c := Conflict{Field: cause.Field}
+		if m := managerPattern.FindStringSubmatch(cause.Message); m != nil {
+			c.Manager = m[1]
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// RecordConflicts adds a span event listing the conflicting managers and
+// fields when err is a server-side apply conflict.
+func RecordConflicts(span trace.Span, err error) {
+	conflicts := Conflicts(err)
+	if len(conflicts) == 0 {
+		return
+	}
+
+	managers := make([]string, 0, len(conflicts))
+	fields := make([]string, 0, len(conflicts))
+	for _, c := range conflicts {
+		managers = append(managers, c.Manager)
+		fields = append(fields, c.Field)
+	}
+	span.AddEvent("apply conflict", trace.WithAttributes(
+		attribute.StringSlice("conflict.managers", managers),
+		attribute.StringSlice("conflict.fields", fields),
+	))
+}
@@ -0,0 +1,170 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// ParseTrafficPolicy parses "local" or "cluster" into a Service internal
+// traffic policy. An empty string leaves the cluster's default.
+func ParseTrafficPolicy(s string) (corev1.ServiceInternalTrafficPolicy, error) {
+	switch s {
+	case "":
+		return "", nil
+	case "local":
+		return corev1.ServiceInternalTrafficPolicyLocal, nil
+	case "cluster":
+		return corev1.ServiceInternalTrafficPolicyCluster, nil
+	default:
+		return "", fmt.Errorf("invalid traffic policy %q, must be one of local or cluster", s)
+	}
+}
+
+// firstSuccessPrefix starts the line a data path client pod logs once its
+// first request succeeded.
+const firstSuccessPrefix = "first-success "
+
+// DataPathOptions describes a client pod pinned to a node, requesting a
+// Service until it answers. It measures when the node's data path to the
+// Service is programmed, which differs per node with a Local traffic policy
+// or topology aware routing.
+type DataPathOptions struct {
+	Name      string
+	Namespace string
+	Node      string
+	URL       string
+	Labels    map[string]string
+
+	// Annotations are set on the pod.
+	Annotations map[string]string
+
+	// Image must provide a shell, wget and date. Defaults to busybox.
+	Image string
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+// Build returns the client pod described by the options. It requests URL
+// every 100ms, then logs the time of the first successful request and exits.
+func (o DataPathOptions) Build() *corev1.Pod {
+	image := o.Image
+	if image == "" {
+		image = "busybox"
+	}
+	script := fmt.Sprintf(`until wget -q -O /dev/null -T 1 %q; do sleep 0.1; done; echo "%s$(date -u +%%Y-%%m-%%dT%%H:%%M:%%S.%%NZ)"`, o.URL, firstSuccessPrefix)
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        o.Name,
+			Namespace:   o.Namespace,
+			Labels:      o.Labels,
+			Annotations: o.Annotations,
+		},
+		Spec: corev1.PodSpec{
+			// Bypass the scheduler, the node is the point.
+			NodeName:      o.Node,
+			RestartPolicy: corev1.RestartPolicyNever,
+			Tolerations:   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers: []corev1.Container{
+				{
+					Name:    "client",
+					Image:   image,
+					Command: []string{"sh", "-c", script},
+				},
+			},
+			TerminationGracePeriodSeconds: ptr.To[int64](0),
+		},
+	}
+}
+
+// DataPathPermissions are the permissions needed to run the client pods of
+// WaitDataPath and read their logs.
+func DataPathPermissions() Permissions {
+	return Permissions{
+		Measure: []Permission{
+			{Resource: "pods", Verb: "create"},
+			{Resource: "pods", Verb: "get"},
+			{Resource: "pods/log", Verb: "get"},
+		},
+		Cleanup: []Permission{
+			{Resource: "pods", Verb: "delete"},
+		},
+	}
+}
+
+// WaitDataPath returns a stage creating the client pod and waiting until it
+// reports its first successful request, whose time as seen by the node is
+// stored in ready. Comparing it with the Service's creation time gives the
+// node's data path readiness, within the clock skew between nodes. Its
+// teardown deletes the pod.
+func WaitDataPath(clients Clients, opts DataPathOptions, interval time.Duration, ready *time.Time) Stage {
+	return Stage{
+		Name: "wait-data-path@" + opts.Node,
+		Run: func(ctx context.Context) error {
+			pods := clients.Measure.CoreV1().Pods(opts.Namespace)
+			pod, err := pods.Create(ctx, opts.Build(), metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("pods", pod)
+
+			err = Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				pod, err := pods.Get(ctx, opts.Name, metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				StatusFromContext(ctx).Observe(string(pod.Status.Phase))
+				switch pod.Status.Phase {
+				case corev1.PodSucceeded:
+					return true, nil
+				case corev1.PodFailed:
+					return false, fmt.Errorf("client pod %s on node %s failed: %s", opts.Name, opts.Node, pod.Status.Message)
+				default:
+					return false, nil
+				}
+			})
+			if err != nil {
+				return err
+			}
+
+			logs, err := pods.GetLogs(opts.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read client pod logs: %w", err)
+			}
+			at, err := parseLoggedTime(logs, firstSuccessPrefix)
+			if err != nil {
+				return err
+			}
+			*ready = at
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			return DeleteOwned(ctx, "pods", opts.Namespace, opts.Name, metav1.DeleteOptions{}, clients.Cleanup.CoreV1().Pods(opts.Namespace).Delete)
+		},
+	}
+}
+
+// parseLoggedTime returns the time logged by a probe pod on the first line of
+// its logs starting with prefix.
+func parseLoggedTime(logs []byte, prefix string) (time.Time, error) {
+	sc := bufio.NewScanner(bytes.NewReader(logs))
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), prefix); ok {
+			at, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("failed to parse %s time %q: %w", strings.TrimSpace(prefix), v, err)
+			}
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("pod logs have no %q line", strings.TrimSpace(prefix))
+}
@@ -0,0 +1,39 @@
+package probe
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDataPathBuild(t *testing.T) {
+	pod := DataPathOptions{Name: "probe-abc-client", Namespace: "probes", Node: "node-a", URL: "http://probe-abc.probes.svc:80/"}.Build()
+	if pod.Spec.NodeName != "node-a" {
+		t.Errorf("nodeName = %q, want node-a", pod.Spec.NodeName)
+	}
+	c := pod.Spec.Containers[0]
+	if c.Image != "busybox" {
+		t.Errorf("image = %q, want busybox by default", c.Image)
+	}
+	if script := c.Command[2]; !strings.Contains(script, `"http://probe-abc.probes.svc:80/"`) || !strings.Contains(script, firstSuccessPrefix) {
+		t.Errorf("script = %q, want it to request the URL and log its first success", script)
+	}
+}
+
+func TestParseLoggedTime(t *testing.T) {
+	want := time.Date(2026, 10, 15, 2, 42, 28, 123456789, time.UTC)
+	logs := "Connecting to probe-abc.probes.svc\nfirst-success 2026-10-15T02:42:28.123456789Z\n"
+	got, err := parseLoggedTime([]byte(logs), firstSuccessPrefix)
+	if err != nil {
+		t.Fatalf("parseLoggedTime() error = %v", err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("parseLoggedTime() = %s, want %s", got, want)
+	}
+
+	for _, logs := range []string{"", "wget: timed out\n", "first-success yesterday\n"} {
+		if _, err := parseLoggedTime([]byte(logs), firstSuccessPrefix); err == nil {
+			t.Errorf("parseLoggedTime(%q) error = nil, want one", logs)
+		}
+	}
+}
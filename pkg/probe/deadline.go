@@ -0,0 +1,117 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PhaseClass groups the phases of the probes bounded by the same timeout.
+type PhaseClass string
+
+const (
+	// ClassCreate is the class of the phases creating objects.
+	ClassCreate PhaseClass = "create"
+	// ClassDetect is the class of the phases waiting to observe a change.
+	ClassDetect PhaseClass = "detect"
+	// ClassDelete is the class of the phases deleting objects, bounded by
+	// TeardownTimeout.
+	ClassDelete PhaseClass = "delete"
+)
+
+// CreateTimeout and DetectTimeout bound each phase of their class, within
+// the run's own timeout. Zero leaves the phases bounded by the run's only.
+var (
+	CreateTimeout time.Duration
+	DetectTimeout time.Duration
+)
+
+// StageClass returns the class of the named stage, or "" if it belongs to
+// none: stages named create-* create objects, those named wait-* or
+// *-propagation* detect changes, and teardowns and stages named delete-*
+// delete objects.
+func StageClass(name string) PhaseClass {
+	switch {
+	case strings.HasPrefix(name, "teardown-"), strings.HasPrefix(name, "delete-"):
+		return ClassDelete
+	case strings.HasPrefix(name, "create-"):
+		return ClassCreate
+	case strings.HasPrefix(name, "wait-"), strings.Contains(name, "-propagation"):
+		return ClassDetect
+	default:
+		return ""
+	}
+}
+
+// Timeout returns the timeout bounding the phases of the class, or zero if
+// they are only bounded by the run's.
+func (c PhaseClass) Timeout() time.Duration {
+	switch c {
+	case ClassCreate:
+		return CreateTimeout
+	case ClassDetect:
+		return DetectTimeout
+	case ClassDelete:
+		return TeardownTimeout
+	default:
+		return 0
+	}
+}
+
+// PhaseTimeoutError is the cause of a phase running out of the timeout of
+// its class, rather than out of the run's. It matches
+// context.DeadlineExceeded.
+type PhaseTimeoutError struct {
+	Class   PhaseClass
+	Timeout time.Duration
+}
+
+func (e *PhaseTimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout of %s exceeded", e.Class, e.Timeout)
+}
+
+func (e *PhaseTimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// WithPhaseTimeout returns a context bounded by the timeout of the class, if
+// any, whose cause is then a PhaseTimeoutError.
+func WithPhaseTimeout(ctx context.Context, class PhaseClass) (context.Context, context.CancelFunc) {
+	timeout := class.Timeout()
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, timeout, &PhaseTimeoutError{Class: class, Timeout: timeout})
+}
+
+// PhaseTimeout returns err, prefixed with the PhaseTimeoutError that caused
+// ctx to be done, if any, so that a phase running out of its own timeout
+// can be told apart from the run running out of its.
+func PhaseTimeout(ctx context.Context, err error) error {
+	var timeout *PhaseTimeoutError
+	if err == nil || ctx.Err() == nil || !errors.As(context.Cause(ctx), &timeout) {
+		return err
+	}
+	if errors.Is(err, timeout) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", timeout, err)
+}
+
+// RecordPhaseTimeout records on span the class and value of the timeout err
+// ran out of, if it is a PhaseTimeoutError.
+func RecordPhaseTimeout(span trace.Span, err error) {
+	var timeout *PhaseTimeoutError
+	if !errors.As(err, &timeout) {
+		return
+	}
+	span.SetAttributes(
+		attribute.String("timeout.class", string(timeout.Class)),
+		attribute.Int64("timeout.ms", timeout.Timeout.Milliseconds()),
+	)
+}
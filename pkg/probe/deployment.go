@@ -0,0 +1,135 @@
+package probe
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// DefaultHTTPImage is a small image serving HTTP on DefaultHTTPPort.
+const (
+	DefaultHTTPImage = "registry.k8s.io/e2e-test-images/agnhost:2.53"
+	DefaultHTTPPort  = 8080
+)
+
+// DeploymentOptions describes a single replica Deployment serving HTTP.
+type DeploymentOptions struct {
+	Name      string
+	Namespace string
+	Labels    map[string]string
+
+	// Annotations are set on the Deployment.
+	Annotations map[string]string
+
+	// Image and Args default to an agnhost netexec server listening on Port.
+	Image string
+	Args  []string
+	Port  int32
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+func (o DeploymentOptions) build() *appsv1.Deployment {
+	image, args, port := o.Image, o.Args, o.Port
+	if port == 0 {
+		port = DefaultHTTPPort
+	}
+	if image == "" {
+		image = DefaultHTTPImage
+		args = []string{"netexec", "--http-port=8080"}
+	}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        o.Name,
+			Namespace:   o.Namespace,
+			Labels:      o.Labels,
+			Annotations: o.Annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To[int32](1),
+			Selector: &metav1.LabelSelector{MatchLabels: o.Labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: o.Labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "probe",
+							Image: image,
+							Args:  args,
+							Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: port}},
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(port)},
+								},
+								PeriodSeconds: 1,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// CreateDeployment returns a stage creating the Deployment. Its teardown
+// deletes the Deployment in the foreground and waits until it is gone.
+func CreateDeployment(clients Clients, opts DeploymentOptions, interval time.Duration) Stage {
+	deployments := clients.Cleanup.AppsV1().Deployments(opts.Namespace)
+	return Stage{
+		Name: "create-deployment",
+		Run: func(ctx context.Context) error {
+			d, err := clients.Measure.AppsV1().Deployments(opts.Namespace).Create(ctx, opts.build(), metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("deployments", d)
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			err := DeleteOwned(ctx, "deployments", opts.Namespace, opts.Name, metav1.DeleteOptions{
+				PropagationPolicy: ptr.To(metav1.DeletePropagationForeground),
+			}, deployments.Delete)
+			if err != nil {
+				return err
+			}
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				_, err := deployments.Get(ctx, opts.Name, metav1.GetOptions{})
+				if apierrors.IsNotFound(err) {
+					return true, nil
+				}
+				return false, err
+			})
+		},
+	}
+}
+
+// WaitDeploymentAvailable returns a stage waiting until the named Deployment
+// has all its replicas available.
+func WaitDeploymentAvailable(client kubernetes.Interface, namespace, name string, interval time.Duration) Stage {
+	deployments := client.AppsV1().Deployments(namespace)
+	return Stage{
+		Name: "wait-deployment-available",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				d, err := deployments.Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				want := int32(1)
+				if d.Spec.Replicas != nil {
+					want = *d.Spec.Replicas
+				}
+				return d.Status.ObservedGeneration >= d.Generation && d.Status.AvailableReplicas >= want, nil
+			})
+		},
+	}
+}
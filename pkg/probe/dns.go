@@ -0,0 +1,135 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+// DNSOptions controls how WaitDNS resolves its host.
+type DNSOptions struct {
+	Interval time.Duration
+	// Resolver defaults to net.DefaultResolver, i.e. the cluster DNS when
+	// the prober runs in the cluster.
+	Resolver *net.Resolver
+	// IPFamily restricts the lookups to a single address family, its name
+	// is then appended to the stage name, e.g. "dns-propagation-ipv6".
+	IPFamily corev1.IPFamily
+
+	// EventBurst and EventWindow configure the limiter bounding the number
+	// of poll attempt span events.
+	EventBurst  int
+	EventWindow time.Duration
+}
+
+// WaitDNS returns a stage resolving host until it resolves to the IP of pod,
+// as observed by an earlier stage, of the requested family. Lookups failing
+// or missing the IP are expected while the record propagates and are
+// recorded as span events rather than failing the stage. When restricted to
+// an address family, the stage fails with a FamilyError carrying the last
+// attempt's answer.
+func WaitDNS(host string, pod *corev1.Pod, opts DNSOptions) Stage {
+	resolver := opts.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	name := "dns-propagation"
+	if suffix := familySuffix(opts.IPFamily); suffix != "" {
+		name += "-" + suffix
+	}
+	network := familyLookupNetwork(opts.IPFamily)
+
+	return Stage{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			span := trace.SpanFromContext(ctx)
+			span.SetAttributes(attribute.String("dns.question.name", host))
+			if suffix := familySuffix(opts.IPFamily); suffix != "" {
+				span.SetAttributes(attribute.String("network.type", suffix))
+			}
+
+			want, err := podIP(pod, opts.IPFamily)
+			if err != nil {
+				return err
+			}
+			span.SetAttributes(attribute.String("dns.want", want))
+
+			polls := telemetry.NewEventLimiter("dns lookups", opts.EventBurst, opts.EventWindow)
+			defer polls.Flush(span)
+
+			var last error
+			err = Poll(ctx, opts.Interval, func(ctx context.Context) (bool, error) {
+				lctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+				ips, err := resolver.LookupIP(lctx, network, host)
+				cancel()
+
+				attrs := []attribute.KeyValue{attribute.Int("poll.attempt", polls.Count()+1)}
+				found := slices.ContainsFunc(ips, func(ip net.IP) bool { return ip.String() == want })
+				switch {
+				case err != nil:
+					last = err
+					attrs = append(attrs, attribute.String("error", err.Error()))
+					StatusFromContext(ctx).Observe(dnsObservation(err))
+				case !found:
+					last = fmt.Errorf("%s resolves to %v, not %s", host, ips, want)
+					attrs = append(attrs, attribute.String("dns.answers", fmt.Sprint(ips)))
+					StatusFromContext(ctx).Observe("stale")
+				default:
+					StatusFromContext(ctx).Observe("resolved")
+				}
+				polls.Record(span, attrs...)
+				return found, nil
+			})
+			if err != nil && last != nil {
+				err = fmt.Errorf("%w, last lookup: %v", err, last)
+			}
+			if err != nil && opts.IPFamily != "" {
+				return &FamilyError{Family: opts.IPFamily, Err: err}
+			}
+			return err
+		},
+	}
+}
+
+// podIP returns the IP of pod of the given family, or its primary IP.
+func podIP(pod *corev1.Pod, family corev1.IPFamily) (string, error) {
+	for _, ip := range pod.Status.PodIPs {
+		parsed := net.ParseIP(ip.IP)
+		if parsed == nil {
+			continue
+		}
+		v4 := parsed.To4() != nil
+		if family == "" || (family == corev1.IPv4Protocol) == v4 {
+			return ip.IP, nil
+		}
+	}
+	if family == "" && pod.Status.PodIP != "" {
+		return pod.Status.PodIP, nil
+	}
+	return "", fmt.Errorf("pod %s has no IP of family %q", pod.Name, family)
+}
+
+// dnsObservation summarizes a failed lookup for the poll timeline.
+func dnsObservation(err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case !errors.As(err, &dnsErr):
+		return "error"
+	case dnsErr.IsNotFound:
+		return "not found"
+	case dnsErr.IsTimeout:
+		return "timeout"
+	default:
+		return "error"
+	}
+}
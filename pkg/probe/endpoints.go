@@ -0,0 +1,111 @@
+package probe
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// WaitEndpoints returns a stage polling the EndpointSlices and the Endpoints
+// of the named Service until both list the IP of pod, as observed ready by
+// an earlier stage, as a ready address. The time each of them took, from the
+// start of the stage, is stored in phases and recorded as child spans of the
+// stage's.
+func WaitEndpoints(client kubernetes.Interface, tracer trace.Tracer, namespace, service string, interval time.Duration, pod *corev1.Pod, phases *[]results.Phase) Stage {
+	return Stage{
+		Name: "wait-endpoints",
+		Run: func(ctx context.Context) error {
+			start := time.Now()
+			var sliceAt, endpointsAt time.Time
+			err := Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				ip := pod.Status.PodIP
+				if sliceAt.IsZero() {
+					listed, err := sliceListsIP(ctx, client, namespace, service, ip)
+					if err != nil {
+						return false, err
+					}
+					if listed {
+						sliceAt = time.Now()
+					}
+				}
+				if endpointsAt.IsZero() {
+					listed, err := endpointsListIP(ctx, client, namespace, service, ip)
+					if err != nil {
+						return false, err
+					}
+					if listed {
+						endpointsAt = time.Now()
+					}
+				}
+				return !sliceAt.IsZero() && !endpointsAt.IsZero(), nil
+			})
+
+			for _, obs := range []struct {
+				name string
+				at   time.Time
+			}{{"endpointslice", sliceAt}, {"endpoints", endpointsAt}} {
+				if !obs.at.IsZero() {
+					*phases = append(*phases, results.Phase{
+						Name:     obs.name,
+						Start:    start,
+						Duration: obs.at.Sub(start),
+						Outcome:  results.OutcomeSuccess,
+					})
+				}
+			}
+			RecordPhaseSpans(ctx, tracer, *phases)
+			return err
+		},
+	}
+}
+
+// sliceListsIP reports whether one of the EndpointSlices of the service lists
+// ip as a ready endpoint.
+func sliceListsIP(ctx context.Context, client kubernetes.Interface, namespace, service, ip string) (bool, error) {
+	list, err := client.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + service,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, slice := range list.Items {
+		for _, ep := range slice.Endpoints {
+			// A nil ready condition is to be interpreted as ready.
+			ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+			if ready && slices.Contains(ep.Addresses, ip) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// endpointsListIP reports whether the Endpoints of the service list ip as a
+// ready address. They don't exist until the controller first syncs the
+// service.
+func endpointsListIP(ctx context.Context, client kubernetes.Interface, namespace, service, ip string) (bool, error) {
+	eps, err := client.CoreV1().Endpoints(namespace).Get(ctx, service, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, subset := range eps.Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.IP == ip {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
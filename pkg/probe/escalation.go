@@ -0,0 +1,146 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// Transition is a change of a probe kind's escalation state.
+type Transition int
+
+const (
+	TransitionNone Transition = iota
+	// TransitionEscalated means the kind crossed the consecutive failures
+	// threshold and subsequent runs should collect every diagnostic.
+	TransitionEscalated
+	// TransitionDeescalated means the kind recovered and subsequent runs
+	// should go back to the configured level of detail.
+	TransitionDeescalated
+)
+
+func (t Transition) String() string {
+	switch t {
+	case TransitionEscalated:
+		return "escalated"
+	case TransitionDeescalated:
+		return "deescalated"
+	default:
+		return "none"
+	}
+}
+
+// Escalation tracks the streaks of consecutive failures and successes of
+// each probe kind across runs of a long-lived prober. A single failure is
+// noise, but a streak of them is an incident worth the cost of the expensive
+// diagnostics. A nil *Escalation never escalates.
+type Escalation struct {
+	// After is the number of consecutive failures escalating a kind.
+	After int
+	// ClearAfter is the number of consecutive successes deescalating an
+	// escalated kind.
+	ClearAfter int
+
+	mu    sync.Mutex
+	kinds map[string]*EscalationState
+}
+
+// EscalationState is the escalation state of a single probe kind.
+type EscalationState struct {
+	Escalated            bool      `json:"escalated"`
+	Since                time.Time `json:"since,omitzero"`
+	ConsecutiveFailures  int       `json:"consecutive_failures"`
+	ConsecutiveSuccesses int       `json:"consecutive_successes"`
+}
+
+// NewEscalation returns a tracker escalating after the given number of
+// consecutive failures and deescalating after clearAfter consecutive
+// successes.
+func NewEscalation(after, clearAfter int) *Escalation {
+	return &Escalation{
+		After:      after,
+		ClearAfter: clearAfter,
+		kinds:      make(map[string]*EscalationState),
+	}
+}
+
+// Record records the outcome of a run of kind and returns the resulting
+// transition, if any. Skipped runs neither break nor extend a streak.
+func (e *Escalation) Record(kind string, outcome results.Outcome) Transition {
+	if e == nil || outcome.Skipped() {
+		return TransitionNone
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	st := e.kinds[kind]
+	if st == nil {
+		st = &EscalationState{}
+		e.kinds[kind] = st
+	}
+
+	if outcome == results.OutcomeSuccess {
+		st.ConsecutiveFailures = 0
+		st.ConsecutiveSuccesses++
+		if st.Escalated && st.ConsecutiveSuccesses >= e.ClearAfter {
+			st.Escalated = false
+			st.Since = time.Now()
+			return TransitionDeescalated
+		}
+		return TransitionNone
+	}
+
+	st.ConsecutiveSuccesses = 0
+	st.ConsecutiveFailures++
+	if !st.Escalated && e.After > 0 && st.ConsecutiveFailures >= e.After {
+		st.Escalated = true
+		st.Since = time.Now()
+		return TransitionEscalated
+	}
+	return TransitionNone
+}
+
+// Escalated reports whether runs of kind should collect every diagnostic.
+func (e *Escalation) Escalated(kind string) bool {
+	if e == nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.kinds[kind]
+	return st != nil && st.Escalated
+}
+
+// Snapshot returns a copy of the state of every kind recorded so far.
+func (e *Escalation) Snapshot() map[string]EscalationState {
+	out := map[string]EscalationState{}
+	if e == nil {
+		return out
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for kind, st := range e.kinds {
+		out[kind] = *st
+	}
+	return out
+}
+
+// RecordTransition emits a Kubernetes Event on namespace marking the
+// transition of kind, so that it shows up next to the objects the probes
+// create.
+func RecordTransition(ctx context.Context, client kubernetes.Interface, namespace, kind string, t Transition, st EscalationState) error {
+	eventType, reason := corev1.EventTypeWarning, "ProbeEscalated"
+	message := fmt.Sprintf("%s probe failed %d times in a row, collecting every diagnostic", kind, st.ConsecutiveFailures)
+	if t == TransitionDeescalated {
+		eventType, reason = corev1.EventTypeNormal, "ProbeDeescalated"
+		message = fmt.Sprintf("%s probe succeeded %d times in a row, back to the configured diagnostics", kind, st.ConsecutiveSuccesses)
+	}
+
+	return recordEvent(ctx, client, namespace, "probe-"+kind+"-", eventType, reason, message)
+}
@@ -0,0 +1,128 @@
+package probe
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+func TestEscalationTransitions(t *testing.T) {
+	e := NewEscalation(3, 2)
+	steps := []struct {
+		outcome   results.Outcome
+		want      Transition
+		escalated bool
+	}{
+		{results.OutcomeError, TransitionNone, false},
+		{results.OutcomeTimeout, TransitionNone, false},
+		// A success breaks the streak
+		{results.OutcomeSuccess, TransitionNone, false},
+		{results.OutcomeError, TransitionNone, false},
+		{results.OutcomeError, TransitionNone, false},
+		// Skipped runs neither break nor extend it
+		{results.OutcomeSkippedLocked, TransitionNone, false},
+		{results.OutcomeBudgetExceeded, TransitionEscalated, true},
+		{results.OutcomeError, TransitionNone, true},
+		{results.OutcomeSuccess, TransitionNone, true},
+		// A failure starts the successes over
+		{results.OutcomeError, TransitionNone, true},
+		{results.OutcomeSuccess, TransitionNone, true},
+		{results.OutcomeSkippedPaused, TransitionNone, true},
+		{results.OutcomeSuccess, TransitionDeescalated, false},
+		{results.OutcomeSuccess, TransitionNone, false},
+	}
+	for i, s := range steps {
+		if got := e.Record("pod", s.outcome); got != s.want {
+			t.Errorf("step %d: Record(%s) = %s, want %s", i, s.outcome, got, s.want)
+		}
+		if got := e.Escalated("pod"); got != s.escalated {
+			t.Errorf("step %d: Escalated() = %v, want %v", i, got, s.escalated)
+		}
+	}
+}
+
+func TestEscalationPerKind(t *testing.T) {
+	e := NewEscalation(2, 1)
+	e.Record("pod", results.OutcomeError)
+	e.Record("dns", results.OutcomeError)
+	e.Record("pod", results.OutcomeError)
+
+	if !e.Escalated("pod") || e.Escalated("dns") {
+		t.Errorf("escalated pod=%v dns=%v, want only pod", e.Escalated("pod"), e.Escalated("dns"))
+	}
+	snapshot := e.Snapshot()
+	if st := snapshot["pod"]; !st.Escalated || st.ConsecutiveFailures != 2 || st.Since.IsZero() {
+		t.Errorf("pod state = %+v, want escalated after 2 failures", st)
+	}
+	if st := snapshot["dns"]; st.Escalated || st.ConsecutiveFailures != 1 {
+		t.Errorf("dns state = %+v, want 1 failure", st)
+	}
+
+	// The snapshot is a copy
+	snapshot["pod"] = EscalationState{}
+	if !e.Escalated("pod") {
+		t.Error("modifying the snapshot deescalated pod")
+	}
+}
+
+func TestEscalationDisabled(t *testing.T) {
+	var nilEscalation *Escalation
+	if got := nilEscalation.Record("pod", results.OutcomeError); got != TransitionNone {
+		t.Errorf("nil Record() = %s, want none", got)
+	}
+	if nilEscalation.Escalated("pod") || len(nilEscalation.Snapshot()) != 0 {
+		t.Error("nil escalation escalated")
+	}
+
+	e := NewEscalation(0, 1)
+	for range 10 {
+		if got := e.Record("pod", results.OutcomeError); got != TransitionNone {
+			t.Fatalf("Record() = %s with a threshold of 0, want none", got)
+		}
+	}
+}
+
+func TestRecordTransition(t *testing.T) {
+	client := fake.NewClientset()
+	client.PrependReactor("create", "events", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	ctx := context.Background()
+	if err := RecordTransition(ctx, client, "probes", "pod", TransitionEscalated, EscalationState{ConsecutiveFailures: 5}); err != nil {
+		t.Fatalf("RecordTransition() error = %v", err)
+	}
+	if err := RecordTransition(ctx, client, "probes", "pod", TransitionDeescalated, EscalationState{ConsecutiveSuccesses: 3}); err != nil {
+		t.Fatalf("RecordTransition() error = %v", err)
+	}
+
+	// The fake API server doesn't generate names, the Events are only seen
+	// as they are created
+	var events []*corev1.Event
+	for _, action := range client.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok {
+			events = append(events, create.GetObject().(*corev1.Event))
+		}
+	}
+	want := []struct{ eventType, reason string }{
+		{corev1.EventTypeWarning, "ProbeEscalated"},
+		{corev1.EventTypeNormal, "ProbeDeescalated"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %d", events, len(want))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Type != w.eventType || e.Reason != w.reason {
+			t.Errorf("event %d = %s %s, want %s %s", i, e.Type, e.Reason, w.eventType, w.reason)
+		}
+		if e.InvolvedObject.Kind != "Namespace" || e.InvolvedObject.Name != "probes" {
+			t.Errorf("event %d is about %v, want the probes namespace", i, e.InvolvedObject)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// Reasons of the Events reporting probe results, see RecordResult.
+const (
+	ReasonProbeSucceeded         = "ProbeSucceeded"
+	ReasonProbeThresholdExceeded = "ProbeThresholdExceeded"
+	ReasonProbeFailed            = "ProbeFailed"
+)
+
+// recordEvent emits a Kubernetes Event about namespace itself, which is where
+// the prober reports on its own behavior.
+func recordEvent(ctx context.Context, client kubernetes.Interface, namespace, generateName, eventType, reason, message string) error {
+	return emitEvent(ctx, client, NamespaceReference(namespace), generateName, eventType, reason, message)
+}
+
+// NamespaceReference returns a reference to namespace, the object Events
+// about the prober's own behavior are about.
+func NamespaceReference(namespace string) corev1.ObjectReference {
+	return corev1.ObjectReference{APIVersion: "v1", Kind: "Namespace", Name: namespace}
+}
+
+// emitEvent emits a Kubernetes Event about the involved object, in its
+// namespace, or in the namespace itself when it is one.
+func emitEvent(ctx context.Context, client kubernetes.Interface, involved corev1.ObjectReference, generateName, eventType, reason, message string) error {
+	namespace := involved.Namespace
+	if involved.Kind == "Namespace" {
+		namespace = involved.Name
+	}
+	now := metav1.Now()
+	_, err := client.CoreV1().Events(namespace).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: generateName,
+			Namespace:    namespace,
+		},
+		InvolvedObject: involved,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: ManagedBy},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return err
+}
+
+// RecordResult emits a Kubernetes Event on the involved object reporting the
+// result of a probe, with the duration of its phases: ProbeFailed if it
+// failed, ProbeThresholdExceeded if any of its phases took longer than its
+// threshold, keyed by phase name, whether or not that gave it the
+// budget_exceeded outcome, and ProbeSucceeded otherwise. Nothing is
+// emitted for skipped probes.
+func RecordResult(ctx context.Context, client kubernetes.Interface, involved corev1.ObjectReference, pr results.Probe, thresholds map[string]time.Duration) error {
+	if pr.Outcome.Skipped() {
+		return nil
+	}
+
+	var durations, exceeded []string
+	for _, ph := range pr.Phases {
+		if ph.Outcome != results.OutcomeSuccess {
+			continue
+		}
+		d := ph.Duration.Round(time.Millisecond)
+		durations = append(durations, fmt.Sprintf("%s %s", ph.Name, d))
+		if limit, ok := thresholds[ph.Name]; ok && ph.Duration > limit {
+			exceeded = append(exceeded, fmt.Sprintf("%s took %s, over %s", ph.Name, d, limit))
+		}
+	}
+
+	eventType, reason := corev1.EventTypeNormal, ReasonProbeSucceeded
+	message := fmt.Sprintf("%s probe succeeded: %s", pr.Kind, strings.Join(durations, ", "))
+	switch {
+	case pr.Outcome != results.OutcomeSuccess && pr.Outcome != results.OutcomeBudgetExceeded:
+		eventType, reason = corev1.EventTypeWarning, ReasonProbeFailed
+		message = fmt.Sprintf("%s probe failed (%s)", pr.Kind, pr.Outcome)
+		if len(pr.Errors) > 0 {
+			message += ": " + pr.Errors[0]
+		}
+	case len(exceeded) > 0:
+		eventType, reason = corev1.EventTypeWarning, ReasonProbeThresholdExceeded
+		message = fmt.Sprintf("%s probe exceeded its thresholds: %s", pr.Kind, strings.Join(exceeded, ", "))
+	}
+	return emitEvent(ctx, client, involved, "probe-"+pr.Kind+"-", eventType, reason, message)
+}
@@ -0,0 +1,214 @@
+package probe
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+)
+
+// Labels and annotation stamped on every object created by the probes.
+const (
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	ManagedBy      = "k8s-latency-probe"
+	LabelRunID     = "probe.wperron.io/run-id"
+
+	// AnnotationExpiresAt holds the RFC 3339 time after which the object
+	// is considered leaked and may be deleted by a reaper.
+	AnnotationExpiresAt = "probe.wperron.io/expires-at"
+)
+
+// ExpiryMargin is added to a run's timeout to compute the expiry of the
+// objects it creates, so that a slow cleanup is never mistaken for a leak.
+const ExpiryMargin = 10 * time.Minute
+
+// ManagedLabels returns labels merged with the labels marking an object as
+// created by the given run.
+func ManagedLabels(runID string, labels map[string]string) map[string]string {
+	out := map[string]string{
+		LabelManagedBy: ManagedBy,
+		LabelRunID:     runID,
+	}
+	maps.Copy(out, labels)
+	return out
+}
+
+// ExpiryAnnotations returns the annotations of an object created by a run
+// started at start and bounded by timeout.
+func ExpiryAnnotations(start time.Time, timeout time.Duration) map[string]string {
+	return map[string]string{
+		AnnotationExpiresAt: start.Add(timeout + ExpiryMargin).UTC().Format(time.RFC3339),
+	}
+}
+
+// ReapedResources are the kinds of objects the probes create, which Reaper
+// looks for.
+var ReapedResources = []schema.GroupVersionResource{
+	{Version: "v1", Resource: "pods"},
+	{Version: "v1", Resource: "services"},
+	{Version: "v1", Resource: "configmaps"},
+	{Version: "v1", Resource: "secrets"},
+	{Version: "v1", Resource: "serviceaccounts"},
+	{Version: "v1", Resource: "persistentvolumeclaims"},
+	{Group: "apps", Version: "v1", Resource: "deployments"},
+	{Group: "batch", Version: "v1", Resource: "jobs"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"},
+}
+
+// Reaper deletes the objects left behind by runs that never cleaned up after
+// themselves. It only ever considers objects labeled as managed by the
+// prober, and only deletes them once their expiry has passed.
+type Reaper struct {
+	Client     metadata.Interface
+	Namespaces []string
+	// ListNamespaces, when set, returns the namespaces of every pass,
+	// instead of Namespaces, e.g. those matching a selector at the time.
+	ListNamespaces func(context.Context) ([]string, error)
+
+	// Active returns the IDs of the runs in flight, whose objects are never
+	// reaped regardless of their expiry.
+	Active func() []string
+
+	// ClusterResources are the cluster-scoped resources also reaped, e.g.
+	// NamespaceResource, the namespaces created for runs, along with
+	// everything left in them.
+	ClusterResources []schema.GroupVersionResource
+
+	// TTL, when set, also reaps the objects created more than TTL ago,
+	// whatever their expiry, and the ones missing it. It must be longer than
+	// any run.
+	TTL time.Duration
+}
+
+// Reap deletes every expired object and returns the number deleted per
+// resource. It keeps going on errors and returns them joined.
+func (r *Reaper) Reap(ctx context.Context, now time.Time) (map[string]int, error) {
+	selector := labels.NewSelector()
+	managed, err := labels.NewRequirement(LabelManagedBy, selection.Equals, []string{ManagedBy})
+	if err != nil {
+		return nil, err
+	}
+	selector = selector.Add(*managed)
+	if r.Active != nil {
+		if active := r.Active(); len(active) > 0 {
+			notActive, err := labels.NewRequirement(LabelRunID, selection.NotIn, active)
+			if err != nil {
+				return nil, err
+			}
+			selector = selector.Add(*notActive)
+		}
+	}
+
+	namespaces := r.Namespaces
+	if r.ListNamespaces != nil {
+		if namespaces, err = r.ListNamespaces(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	counts := map[string]int{}
+	var errs []error
+	for _, ns := range namespaces {
+		for _, gvr := range ReapedResources {
+			errs = append(errs, r.reap(ctx, r.Client.Resource(gvr).Namespace(ns), gvr.Resource, ns, selector, now, counts)...)
+		}
+	}
+	for _, gvr := range r.ClusterResources {
+		errs = append(errs, r.reap(ctx, r.Client.Resource(gvr), gvr.Resource, "", selector, now, counts)...)
+	}
+	return counts, errors.Join(errs...)
+}
+
+// reap deletes the expired objects of client, of the given resource in
+// namespace, empty for cluster-scoped ones, matching selector. It counts them
+// in counts and returns the errors it ran into.
+func (r *Reaper) reap(ctx context.Context, client metadata.ResourceInterface, resource, namespace string, selector labels.Selector, now time.Time, counts map[string]int) []error {
+	list, err := client.List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return []error{fmt.Errorf("failed to list %s in %s: %w", resource, cmp.Or(namespace, "the cluster"), err)}
+	}
+	var errs []error
+	for _, obj := range list.Items {
+		// A namespace being deleted is only waiting for its finalizer
+		if resource == NamespaceResource.Resource && obj.DeletionTimestamp != nil {
+			continue
+		}
+		if !r.expired(&obj.ObjectMeta, now) {
+			continue
+		}
+		// The UID precondition makes sure a new object with the same
+		// name is never deleted in its place.
+		err := client.Delete(ctx, obj.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &obj.UID},
+		})
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			errs = append(errs, fmt.Errorf("failed to delete %s %s: %w", resource, path.Join(namespace, obj.Name), err))
+			continue
+		}
+		if err == nil {
+			counts[resource]++
+		}
+	}
+	return errs
+}
+
+// Run reaps every interval until ctx is done, calling report with the result
+// of each pass.
+func (r *Reaper) Run(ctx context.Context, interval time.Duration, report func(map[string]int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			report(r.Reap(ctx, now))
+		}
+	}
+}
+
+// RecordReaped emits a Kubernetes Event on namespace reporting the objects
+// deleted by a reaper pass. Nothing is emitted when nothing was deleted.
+func RecordReaped(ctx context.Context, client kubernetes.Interface, namespace string, counts map[string]int) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	resources := slices.Sorted(maps.Keys(counts))
+	parts := make([]string, 0, len(resources))
+	for _, res := range resources {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[res], res))
+	}
+	message := "deleted expired objects left behind by probe runs: " + strings.Join(parts, ", ")
+	return recordEvent(ctx, client, namespace, "probe-reaper-", corev1.EventTypeNormal, "ObjectsReaped", message)
+}
+
+// expired reports whether the object's expiry is before now, or it is older
+// than the reaper's TTL. Without a TTL, objects with no or a malformed expiry
+// never expire.
+func (r *Reaper) expired(meta *metav1.ObjectMeta, now time.Time) bool {
+	if r.TTL > 0 && now.Sub(meta.CreationTimestamp.Time) > r.TTL {
+		return true
+	}
+	v, ok := meta.Annotations[AnnotationExpiresAt]
+	if !ok {
+		return false
+	}
+	at, err := time.Parse(time.RFC3339, v)
+	return err == nil && at.Before(now)
+}
@@ -0,0 +1,215 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testFieldManager = "test-manager"
+
+// writeFieldManager returns the field manager of a write action, and false
+// if the action isn't a write.
+func writeFieldManager(action k8stesting.Action) (string, bool) {
+	switch a := action.(type) {
+	case k8stesting.CreateActionImpl:
+		return a.CreateOptions.FieldManager, true
+	case k8stesting.UpdateActionImpl:
+		return a.UpdateOptions.FieldManager, true
+	case k8stesting.PatchActionImpl:
+		return a.PatchOptions.FieldManager, true
+	}
+	return "", false
+}
+
+func TestFieldManagerOnEveryWrite(t *testing.T) {
+	const ns = "probes"
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, client *fake.Clientset) error
+	}{
+		{
+			name: "namespace",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				opts := NamespaceOptions{Name: ns, FieldManager: testFieldManager}
+				return runAll(ctx, CreateNamespace(SingleClient(client), opts), CreateNamespaceContent(client, opts, 3))
+			},
+		},
+		{
+			name: "pod",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				var (
+					created corev1.Pod
+					skew    time.Duration
+				)
+				opts := PodOptions{Name: "probe-abc", Namespace: ns, Image: "busybox", FieldManager: testFieldManager}
+				return CreatePod(SingleClient(client), opts, &created, &skew).Run(ctx)
+			},
+		},
+		{
+			name: "configmap",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				return writeObject(ctx, NewObjectWriter(SingleClient(client), "configmap", ObjectOptions{Name: "probe-abc", Namespace: ns, FieldManager: testFieldManager}))
+			},
+		},
+		{
+			name: "secret",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				return writeObject(ctx, NewObjectWriter(SingleClient(client), "secret", ObjectOptions{Name: "probe-abc", Namespace: ns, FieldManager: testFieldManager}))
+			},
+		},
+		{
+			name: "verbs",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				return runAll(ctx, VerbStages(SingleClient(client), ObjectOptions{Name: "probe-abc", Namespace: ns, FieldManager: testFieldManager})...)
+			},
+		},
+		{
+			name: "service",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				err := CreateService(SingleClient(client), ServiceOptions{Name: "probe-abc", Namespace: ns, Port: 80, TargetPort: 8080, FieldManager: testFieldManager}).Run(ctx)
+				// The fake API server doesn't allocate cluster IPs
+				var family *FamilyError
+				if errors.As(err, &family) {
+					return nil
+				}
+				return err
+			},
+		},
+		{
+			name: "deployment",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				return CreateDeployment(SingleClient(client), DeploymentOptions{Name: "probe-abc", Namespace: ns, FieldManager: testFieldManager}, time.Millisecond).Run(ctx)
+			},
+		},
+		{
+			name: "job",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				var skew time.Duration
+				return CreateJob(SingleClient(client), JobOptions{Name: "probe-abc", Namespace: ns, FieldManager: testFieldManager}, time.Millisecond, &skew).Run(ctx)
+			},
+		},
+		{
+			name: "pvc",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				return CreatePVC(SingleClient(client), PVCOptions{Name: "probe-abc", Namespace: ns, FieldManager: testFieldManager}).Run(ctx)
+			},
+		},
+		{
+			name: "identity",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				// The fake API server doesn't issue tokens
+				client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					if action.GetSubresource() != "token" {
+						return false, nil, nil
+					}
+					return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{Token: "token"}}, nil
+				})
+				var token string
+				opts := IdentityOptions{
+					Name:            "probe-abc",
+					Namespace:       ns,
+					RoleBinding:     ClusterRoleBinding("view"),
+					TokenExpiration: 10 * time.Minute,
+					FieldManager:    testFieldManager,
+				}
+				return runAll(ctx,
+					CreateServiceAccount(client, opts),
+					CreateRole(SingleClient(client), opts, []rbacv1.PolicyRule{RBACRule(opts)}),
+					CreateRoleBinding(client, opts),
+					RequestToken(client, opts, &token),
+				)
+			},
+		},
+		{
+			name: "lock",
+			run: func(ctx context.Context, client *fake.Clientset) error {
+				lock := &Lock{Client: client, Namespace: ns, Name: "probe-lock", Holder: "a", Duration: time.Minute, FieldManager: testFieldManager}
+				if _, err := lock.Acquire(ctx, 0, time.Millisecond, nil); err != nil {
+					return err
+				}
+				// Taking it again updates the Lease
+				_, err := lock.Acquire(ctx, 0, time.Millisecond, nil)
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewClientset()
+			if err := tt.run(context.Background(), client); err != nil {
+				t.Fatalf("probe error = %v", err)
+			}
+
+			writes := 0
+			for _, action := range client.Actions() {
+				manager, ok := writeFieldManager(action)
+				if !ok {
+					continue
+				}
+				writes++
+				if manager != testFieldManager {
+					t.Errorf("%s %s field manager = %q, want %q", action.GetVerb(), action.GetResource().Resource, manager, testFieldManager)
+				}
+			}
+			if writes == 0 {
+				t.Error("no writes made")
+			}
+		})
+	}
+}
+
+// runAll runs the stages in order, without their teardowns.
+func runAll(ctx context.Context, stages ...Stage) error {
+	for _, s := range stages {
+		if err := s.Run(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeObject creates then updates an object through w.
+func writeObject(ctx context.Context, w ObjectWriter) error {
+	if err := w.Create(ctx, "created"); err != nil {
+		return err
+	}
+	return w.Update(ctx, "updated")
+}
+
+func TestConflicts(t *testing.T) {
+	err := apierrors.NewApplyConflict([]metav1.StatusCause{
+		{Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "kubectl" using v1: .spec.replicas`, Field: ".spec.replicas"},
+		{Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "helm" using apps/v1`, Field: ".spec.template"},
+		{Type: metav1.CauseTypeFieldValueInvalid, Field: ".spec.selector"},
+	}, "Apply failed with 2 conflicts")
+
+	got := Conflicts(err)
+	want := []Conflict{{Manager: "kubectl", Field: ".spec.replicas"}, {Manager: "helm", Field: ".spec.template"}}
+	if len(got) != len(want) {
+		t.Fatalf("Conflicts() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Conflicts()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if got := Conflicts(apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, "probe-abc", errors.New("stale"))); got != nil {
+		t.Errorf("Conflicts() of an optimistic concurrency conflict = %v, want nil", got)
+	}
+	if got := Conflicts(errors.New("boom")); got != nil {
+		t.Errorf("Conflicts() of a non-API error = %v, want nil", got)
+	}
+}
@@ -0,0 +1,124 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+// HTTPOptions controls how WaitHTTP polls its URL.
+type HTTPOptions struct {
+	Interval time.Duration
+	// Client defaults to a client with a 2 seconds timeout.
+	Client *http.Client
+	// IPFamily restricts connections to a single address family, its name
+	// is then appended to the stage name, e.g. "wait-http-ipv6". Ignored
+	// when Client is set.
+	IPFamily corev1.IPFamily
+
+	// EventBurst and EventWindow configure the limiter bounding the number
+	// of poll attempt span events.
+	EventBurst  int
+	EventWindow time.Duration
+}
+
+// WaitHTTP returns a stage polling url until it answers with a 2xx status.
+// Failed attempts are expected while the backend comes up and are recorded
+// as span events rather than failing the stage. When restricted to an
+// address family, the stage fails with a FamilyError carrying the last
+// attempt's error.
+func WaitHTTP(url string, opts HTTPOptions) Stage {
+	client := opts.Client
+	if client == nil {
+		client = familyClient(opts.IPFamily)
+	}
+
+	name := "wait-http"
+	if suffix := familySuffix(opts.IPFamily); suffix != "" {
+		name += "-" + suffix
+	}
+
+	return Stage{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			span := trace.SpanFromContext(ctx)
+			span.SetAttributes(attribute.String("http.url", url))
+			if suffix := familySuffix(opts.IPFamily); suffix != "" {
+				span.SetAttributes(attribute.String("network.type", suffix))
+			}
+
+			polls := telemetry.NewEventLimiter("http attempts", opts.EventBurst, opts.EventWindow)
+			defer polls.Flush(span)
+
+			var lastErr error
+			err := Poll(ctx, opts.Interval, func(ctx context.Context) (bool, error) {
+				status, err := get(ctx, client, url)
+				if err != nil {
+					lastErr = err
+				} else if status < 200 || status >= 300 {
+					lastErr = fmt.Errorf("unexpected HTTP status %d", status)
+				}
+				attrs := []attribute.KeyValue{
+					attribute.Int("poll.attempt", polls.Count()+1),
+					attribute.Int("http.status_code", status),
+				}
+				if err != nil {
+					attrs = append(attrs, attribute.String("error", err.Error()))
+				}
+				polls.Record(span, attrs...)
+				if err != nil {
+					StatusFromContext(ctx).Observe(err.Error())
+				} else {
+					StatusFromContext(ctx).Observe(fmt.Sprintf("HTTP %d", status))
+				}
+
+				return err == nil && status >= 200 && status < 300, nil
+			})
+			if err != nil && opts.IPFamily != "" {
+				if lastErr != nil {
+					err = fmt.Errorf("%w, last attempt: %v", err, lastErr)
+				}
+				return &FamilyError{Family: opts.IPFamily, Err: err}
+			}
+			return err
+		},
+	}
+}
+
+// familyClient returns an HTTP client with a 2 seconds timeout only dialing
+// addresses of family, or any family if empty.
+func familyClient(family corev1.IPFamily) *http.Client {
+	if family == "" {
+		return &http.Client{Timeout: 2 * time.Second}
+	}
+
+	dialer := &net.Dialer{}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, familyNetwork(family), addr)
+	}
+	return &http.Client{Timeout: 2 * time.Second, Transport: transport}
+}
+
+func get(ctx context.Context, client *http.Client, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
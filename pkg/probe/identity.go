@@ -0,0 +1,218 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+// IdentityOptions describes a throwaway ServiceAccount the probes can
+// measure as, instead of the prober's own identity.
+type IdentityOptions struct {
+	Name      string
+	Namespace string
+	Labels    map[string]string
+
+	// Annotations are set on every object created.
+	Annotations map[string]string
+
+	// RoleBinding, if set, is created binding the ServiceAccount, with its
+	// name, namespace and subjects overwritten.
+	RoleBinding *rbacv1.RoleBinding
+
+	// TokenExpiration is the lifetime requested for the token.
+	TokenExpiration time.Duration
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+// RoleBindingFromFile reads a RoleBinding template from a YAML file.
+func RoleBindingFromFile(path string) (*rbacv1.RoleBinding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rolebinding template: %w", err)
+	}
+	var rb rbacv1.RoleBinding
+	if err := yaml.UnmarshalStrict(data, &rb); err != nil {
+		return nil, fmt.Errorf("failed to parse rolebinding template: %w", err)
+	}
+	return &rb, nil
+}
+
+// ClusterRoleBinding returns a RoleBinding template granting the given
+// ClusterRole.
+func ClusterRoleBinding(clusterRole string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     clusterRole,
+		},
+	}
+}
+
+// CreateServiceAccount returns a stage creating the ServiceAccount. Its
+// teardown deletes it.
+func CreateServiceAccount(client kubernetes.Interface, opts IdentityOptions) Stage {
+	sas := client.CoreV1().ServiceAccounts(opts.Namespace)
+	return Stage{
+		Name: "create-serviceaccount",
+		Run: func(ctx context.Context) error {
+			sa, err := sas.Create(ctx, &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        opts.Name,
+					Namespace:   opts.Namespace,
+					Labels:      opts.Labels,
+					Annotations: opts.Annotations,
+				},
+			}, metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("serviceaccounts", sa)
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			return DeleteOwned(ctx, "serviceaccounts", opts.Namespace, opts.Name, metav1.DeleteOptions{}, sas.Delete)
+		},
+	}
+}
+
+// CreateRoleBinding returns a stage binding the ServiceAccount using the
+// options' RoleBinding template. Its teardown deletes the binding.
+func CreateRoleBinding(client kubernetes.Interface, opts IdentityOptions) Stage {
+	bindings := client.RbacV1().RoleBindings(opts.Namespace)
+	return Stage{
+		Name: "create-rolebinding",
+		Run: func(ctx context.Context) error {
+			rb := opts.RoleBinding.DeepCopy()
+			annotations := maps.Clone(rb.Annotations)
+			if len(opts.Annotations) > 0 {
+				if annotations == nil {
+					annotations = make(map[string]string)
+				}
+				maps.Copy(annotations, opts.Annotations)
+			}
+			rb.ObjectMeta = metav1.ObjectMeta{
+				Name:        opts.Name,
+				Namespace:   opts.Namespace,
+				Labels:      opts.Labels,
+				Annotations: annotations,
+			}
+			rb.Subjects = []rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      opts.Name,
+				Namespace: opts.Namespace,
+			}}
+			rb, err := bindings.Create(ctx, rb, metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("rolebindings", rb)
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			return DeleteOwned(ctx, "rolebindings", opts.Namespace, opts.Name, metav1.DeleteOptions{}, bindings.Delete)
+		},
+	}
+}
+
+// RequestToken returns a stage issuing a token for the ServiceAccount with
+// the TokenRequest API, and storing it in token.
+func RequestToken(client kubernetes.Interface, opts IdentityOptions, token *string) Stage {
+	return Stage{
+		Name: "request-token",
+		Run: func(ctx context.Context) error {
+			tr, err := client.CoreV1().ServiceAccounts(opts.Namespace).CreateToken(ctx, opts.Name, &authenticationv1.TokenRequest{
+				Spec: authenticationv1.TokenRequestSpec{
+					ExpirationSeconds: ptr.To(int64(opts.TokenExpiration.Seconds())),
+				},
+			}, metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			*token = tr.Status.Token
+			return nil
+		},
+	}
+}
+
+// TokenReviewPermissions are the permissions needed to validate tokens with
+// ReviewToken.
+func TokenReviewPermissions() Permissions {
+	return Permissions{
+		Measure: []Permission{
+			{Group: "authentication.k8s.io", Resource: "tokenreviews", Verb: "create"},
+		},
+	}
+}
+
+// ReviewToken returns a stage validating token, as stored by RequestToken,
+// with the TokenReview API, as the API server authenticates the requests
+// made with it. It fails unless the token authenticates the ServiceAccount.
+func ReviewToken(client kubernetes.Interface, opts IdentityOptions, token *string) Stage {
+	return Stage{
+		Name: "review-token",
+		Run: func(ctx context.Context) error {
+			review, err := client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+				Spec: authenticationv1.TokenReviewSpec{Token: *token},
+			}, metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			if !review.Status.Authenticated {
+				return fmt.Errorf("token of %s not authenticated: %s", opts.Name, review.Status.Error)
+			}
+			username := fmt.Sprintf("system:serviceaccount:%s:%s", opts.Namespace, opts.Name)
+			if review.Status.User.Username != username {
+				return fmt.Errorf("token of %s authenticated as %q", opts.Name, review.Status.User.Username)
+			}
+			return nil
+		},
+	}
+}
+
+// TokenClient returns a clientset authenticating with token against the
+// same API server as config.
+func TokenClient(config *rest.Config, token string) (kubernetes.Interface, error) {
+	cfg := rest.AnonymousClientConfig(config)
+	cfg.BearerToken = token
+	return kubernetes.NewForConfig(cfg)
+}
+
+// FirstUse returns a stage measuring how long it takes for a new identity to
+// be usable: it lists pods as that identity until the request is neither
+// unauthorized nor forbidden, which covers authentication and authorization
+// cache warm-up.
+func FirstUse(client kubernetes.Interface, namespace string, interval time.Duration) Stage {
+	return Stage{
+		Name: "first-use",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				_, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{Limit: 1})
+				switch {
+				case err == nil:
+					return true, nil
+				case apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+					StatusFromContext(ctx).Observe(err.Error())
+					return false, nil
+				default:
+					return false, err
+				}
+			})
+		},
+	}
+}
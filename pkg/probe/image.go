@@ -0,0 +1,144 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// AttrImageCached records whether the probe's pods started from images
+// already present on their node.
+const AttrImageCached = results.AttrImageCached
+
+// imagePresentMessage is the kubelet's Pulled event message suffix when the
+// image was already on the node and nothing was pulled.
+const imagePresentMessage = "already present on machine"
+
+// ImageCached reports whether every image of the pods whose name starts with
+// podPrefix was already present on its node, from the kubelet's Pulled
+// events. known is false when no Pulled event was found, e.g. because the
+// events were not recorded yet or have expired.
+func ImageCached(ctx context.Context, client kubernetes.Interface, namespace, podPrefix string) (cached, known bool, err error) {
+	events, err := client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": "Pod",
+			"reason":              "Pulled",
+		}.String(),
+	})
+	if err != nil {
+		return false, false, err
+	}
+
+	cached = true
+	for _, e := range events.Items {
+		if !strings.HasPrefix(e.InvolvedObject.Name, podPrefix) {
+			continue
+		}
+		known = true
+		if !strings.HasSuffix(e.Message, imagePresentMessage) {
+			cached = false
+		}
+	}
+	return cached && known, known, nil
+}
+
+// PullAlways is a PodMutator setting the Always image pull policy on every
+// container of the pod, so that the kubelet pulls its image whether or not
+// it is already on the node.
+func PullAlways(pod *corev1.Pod) error {
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].ImagePullPolicy = corev1.PullAlways
+	}
+	return nil
+}
+
+// pulledDuration matches the duration the kubelet reports in its Pulled
+// event message, e.g. `Successfully pulled image "busybox" in 1.234s (1.5s
+// including waiting)`.
+var pulledDuration = regexp.MustCompile(`^Successfully pulled image ".*" in ([0-9.]+[a-zµ]+)`)
+
+// PullDuration returns the time the kubelet reports it spent pulling an
+// image in the message of its Pulled event, which is more precise than the
+// event's timestamps. ok is false when the image was already present, or
+// the message isn't understood.
+func PullDuration(message string) (d time.Duration, ok bool) {
+	m := pulledDuration.FindStringSubmatch(message)
+	if m == nil {
+		return 0, false
+	}
+	d, err := time.ParseDuration(m[1])
+	return d, err == nil
+}
+
+// WaitImagePulled returns a stage polling the kubelet's Events about the
+// named pod until it reports having pulled its image. The pull, from the
+// Pulling event to the end of the duration reported in the Pulled event,
+// shifted by *skew, is then stored in phases as image-pull and recorded as a
+// prober.image-pull child span of the stage's. The stage fails if the image
+// was already present on the node, since nothing was pulled.
+func WaitImagePulled(client kubernetes.Interface, tracer trace.Tracer, namespace, pod string, interval time.Duration, skew *time.Duration, phases *[]results.Phase) Stage {
+	return Stage{
+		Name: "wait-image-pulled",
+		Run: func(ctx context.Context) error {
+			var pulling, pulled *corev1.Event
+			err := Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				events, err := client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+					FieldSelector: fields.Set{
+						"involvedObject.kind": "Pod",
+						"involvedObject.name": pod,
+					}.String(),
+				})
+				if err != nil {
+					return false, err
+				}
+				for _, e := range events.Items {
+					switch e.Reason {
+					case "Pulling":
+						pulling = &e
+					case "Pulled":
+						pulled = &e
+					}
+				}
+				switch {
+				case pulled == nil:
+					StatusFromContext(ctx).Observe("not pulled")
+					return false, nil
+				case strings.HasSuffix(pulled.Message, imagePresentMessage):
+					return false, fmt.Errorf("pod %s: %s, nothing was pulled", pod, pulled.Message)
+				default:
+					return pulling != nil, nil
+				}
+			})
+			if err != nil {
+				return err
+			}
+
+			start := EventTime(*pulling).Add(-*skew)
+			d, ok := PullDuration(pulled.Message)
+			if !ok {
+				d = max(EventTime(*pulled).Sub(EventTime(*pulling)), 0)
+			}
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("image.pull.message", pulled.Message))
+			ph := results.Phase{
+				Name:     "image-pull",
+				Start:    start,
+				Duration: d,
+				Outcome:  results.OutcomeSuccess,
+			}
+			*phases = append(*phases, ph)
+			RecordPhaseSpans(ctx, tracer, []results.Phase{ph})
+			return nil
+		},
+	}
+}
@@ -0,0 +1,115 @@
+package probe
+
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// IPFamily selects the address families a probe connects over.
+type IPFamily string
+
+const (
+	// IPFamilyPrimary connects over whichever family the cluster resolves
+	// first, which is its primary family.
+	IPFamilyPrimary IPFamily = ""
+	IPFamilyIPv4    IPFamily = "ipv4"
+	IPFamilyIPv6    IPFamily = "ipv6"
+	// IPFamilyDual connects over both families in turn.
+	IPFamilyDual IPFamily = "dual"
+)
+
+// ParseIPFamily parses one of "ipv4", "ipv6" or "dual". An empty string
+// selects the cluster's primary family.
+func ParseIPFamily(s string) (IPFamily, error) {
+	switch f := IPFamily(s); f {
+	case IPFamilyPrimary, IPFamilyIPv4, IPFamilyIPv6, IPFamilyDual:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid IP family %q, must be one of ipv4, ipv6 or dual", s)
+	}
+}
+
+// Families returns the families to connect over, or nil for the primary
+// family.
+func (f IPFamily) Families() []corev1.IPFamily {
+	switch f {
+	case IPFamilyIPv4:
+		return []corev1.IPFamily{corev1.IPv4Protocol}
+	case IPFamilyIPv6:
+		return []corev1.IPFamily{corev1.IPv6Protocol}
+	case IPFamilyDual:
+		return []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}
+	default:
+		return nil
+	}
+}
+
+// FamilyError is returned when a probe fails for a single address family,
+// so that e.g. a missing A record on a dual-stack Service is not reported as
+// a generic failure.
+type FamilyError struct {
+	Family corev1.IPFamily
+	Err    error
+}
+
+func (e *FamilyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Family, e.Err)
+}
+
+func (e *FamilyError) Unwrap() error {
+	return e.Err
+}
+
+// familySuffix returns the lowercase family name used in phase names, e.g.
+// "ipv6".
+func familySuffix(family corev1.IPFamily) string {
+	switch family {
+	case corev1.IPv4Protocol:
+		return string(IPFamilyIPv4)
+	case corev1.IPv6Protocol:
+		return string(IPFamilyIPv6)
+	default:
+		return ""
+	}
+}
+
+// familyNetwork returns the network to dial to only use family.
+func familyNetwork(family corev1.IPFamily) string {
+	switch family {
+	case corev1.IPv4Protocol:
+		return "tcp4"
+	case corev1.IPv6Protocol:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// familyLookupNetwork returns the network to look up to only get addresses
+// of family.
+func familyLookupNetwork(family corev1.IPFamily) string {
+	switch family {
+	case corev1.IPv4Protocol:
+		return "ip4"
+	case corev1.IPv6Protocol:
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+// checkFamilies returns a FamilyError for the first of families the Service
+// did not get a cluster IP for.
+func checkFamilies(svc *corev1.Service, families []corev1.IPFamily) error {
+	for _, family := range families {
+		if !slices.Contains(svc.Spec.IPFamilies, family) {
+			return &FamilyError{
+				Family: family,
+				Err:    fmt.Errorf("service %s has no cluster IP of this family, is the cluster %s enabled?", svc.Name, family),
+			}
+		}
+	}
+	return nil
+}
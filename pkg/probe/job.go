@@ -0,0 +1,210 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// JobOptions describes a Job running a single pod that exits right away.
+type JobOptions struct {
+	Name      string
+	Namespace string
+	// Labels and Annotations are set on the Job and on its pod.
+	Labels      map[string]string
+	Annotations map[string]string
+
+	// Image must provide true. Defaults to busybox.
+	Image string
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+func (o JobOptions) build() *batchv1.Job {
+	image := o.Image
+	if image == "" {
+		image = "busybox"
+	}
+	meta := metav1.ObjectMeta{
+		Name:        o.Name,
+		Namespace:   o.Namespace,
+		Labels:      o.Labels,
+		Annotations: o.Annotations,
+	}
+	return &batchv1.Job{
+		ObjectMeta: meta,
+		Spec: batchv1.JobSpec{
+			BackoffLimit: ptr.To[int32](0),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: o.Labels, Annotations: o.Annotations},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "probe",
+							Image:   image,
+							Command: []string{"true"},
+						},
+					},
+					TerminationGracePeriodSeconds: ptr.To[int64](0),
+				},
+			},
+		},
+	}
+}
+
+// CreateJob returns a stage creating the Job, storing an estimate of the API
+// server's clock skew relative to the prober in skew. Its teardown deletes
+// the Job and its pod in the foreground and waits until the Job is gone.
+func CreateJob(clients Clients, opts JobOptions, interval time.Duration, skew *time.Duration) Stage {
+	jobs := clients.Cleanup.BatchV1().Jobs(opts.Namespace)
+	return Stage{
+		Name: "create-job",
+		Run: func(ctx context.Context) error {
+			sent := time.Now()
+			job, err := clients.Measure.BatchV1().Jobs(opts.Namespace).Create(ctx, opts.build(), metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("jobs", job)
+			*skew = EstimateSkew(sent, time.Now(), job.CreationTimestamp)
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			err := DeleteOwned(ctx, "jobs", opts.Namespace, opts.Name, metav1.DeleteOptions{
+				PropagationPolicy: ptr.To(metav1.DeletePropagationForeground),
+			}, jobs.Delete)
+			if err != nil {
+				return err
+			}
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				_, err := jobs.Get(ctx, opts.Name, metav1.GetOptions{})
+				if apierrors.IsNotFound(err) {
+					return true, nil
+				}
+				return false, err
+			})
+		},
+	}
+}
+
+// WaitJobPod returns a stage polling the pods of the named Job until the job
+// controller created one, which is stored in pod.
+func WaitJobPod(client kubernetes.Interface, namespace, job string, interval time.Duration, pod *corev1.Pod) Stage {
+	return Stage{
+		Name: "wait-job-pod",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+					LabelSelector: batchv1.JobNameLabel + "=" + job,
+				})
+				if err != nil {
+					return false, err
+				}
+				if len(pods.Items) == 0 {
+					StatusFromContext(ctx).Observe("no pod")
+					return false, nil
+				}
+				*pod = pods.Items[0]
+				return true, nil
+			})
+		},
+	}
+}
+
+// WaitJobPodStarted returns a stage polling pod, as created by the job
+// controller, until its container started, whether it is still running or
+// already exited.
+func WaitJobPodStarted(client kubernetes.Interface, pod *corev1.Pod, interval time.Duration) Stage {
+	return Stage{
+		Name: "wait-pod-started",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				p, err := client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				StatusFromContext(ctx).Observe(string(p.Status.Phase))
+				*pod = *p
+				for _, cs := range p.Status.ContainerStatuses {
+					if cs.State.Running != nil || cs.State.Terminated != nil {
+						return true, nil
+					}
+				}
+				return false, nil
+			})
+		},
+	}
+}
+
+// WaitJobComplete returns a stage polling the named Job until it is
+// complete, failing if it failed. The time from its pod's container exiting,
+// shifted by *skew, to the prober observing the Job complete is then stored
+// in phases as completion-propagation, i.e. the kubelet reporting the pod's
+// status and the job controller noticing it, and recorded as a child span of
+// the stage's.
+func WaitJobComplete(client kubernetes.Interface, tracer trace.Tracer, namespace, name string, interval time.Duration, pod *corev1.Pod, skew *time.Duration, phases *[]results.Phase) Stage {
+	return Stage{
+		Name: "wait-job-complete",
+		Run: func(ctx context.Context) error {
+			err := Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				job, err := client.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				for _, c := range job.Status.Conditions {
+					if c.Status != corev1.ConditionTrue {
+						continue
+					}
+					switch c.Type {
+					case batchv1.JobComplete:
+						return true, nil
+					case batchv1.JobFailed:
+						return false, fmt.Errorf("job %s failed: %s", name, c.Message)
+					}
+				}
+				StatusFromContext(ctx).Observe(fmt.Sprintf("%d active, %d succeeded", job.Status.Active, job.Status.Succeeded))
+				return false, nil
+			})
+			if err != nil {
+				return err
+			}
+			completed := time.Now()
+
+			p, err := client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			if err != nil {
+				// The breakdown is a bonus, the Job did complete.
+				trace.SpanFromContext(ctx).RecordError(err)
+				return nil
+			}
+			*pod = *p
+			for _, cs := range p.Status.ContainerStatuses {
+				if cs.State.Terminated == nil {
+					continue
+				}
+				finished := cs.State.Terminated.FinishedAt.Add(-*skew)
+				ph := results.Phase{
+					Name:     "completion-propagation",
+					Start:    finished,
+					Duration: max(completed.Sub(finished), 0),
+					Outcome:  results.OutcomeSuccess,
+				}
+				*phases = append(*phases, ph)
+				RecordPhaseSpans(ctx, tracer, []results.Phase{ph})
+				break
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,255 @@
+package probe
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// LedgerEntry records an object created by the prober. A later entry for
+// the same UID with Deleted set marks it as deleted.
+type LedgerEntry struct {
+	Resource  string    `json:"resource"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid"`
+	Time      time.Time `json:"time"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// UIDMismatchError is returned when deleting an object the prober created
+// finds another object with the same name in its place, e.g. because the
+// original was deleted and recreated by someone else. That object is left
+// alone.
+type UIDMismatchError struct {
+	Entry LedgerEntry
+	Err   error
+}
+
+func (e *UIDMismatchError) Error() string {
+	return fmt.Sprintf("%s %s/%s is no longer the object created with UID %s, leaving it alone: %v", e.Entry.Resource, e.Entry.Namespace, e.Entry.Name, e.Entry.UID, e.Err)
+}
+
+func (e *UIDMismatchError) Unwrap() error {
+	return e.Err
+}
+
+// Ledger records the UID of every object created during a run, so that
+// cleanup only ever deletes exactly those objects. It may also append its
+// entries to a session file, read back with ReadLedger. A nil *Ledger
+// records nothing and deletes by name.
+type Ledger struct {
+	mu      sync.Mutex
+	entries map[string]LedgerEntry
+	file    *os.File
+	// parent, if set, is the ledger this one was derived from with Run.
+	parent *Ledger
+}
+
+// NewLedger returns a ledger also appending its entries to the session file
+// at path, unless it is empty.
+func NewLedger(path string) (*Ledger, error) {
+	l := &Ledger{entries: make(map[string]LedgerEntry)}
+	if path == "" {
+		return l, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	l.file = f
+	return l, nil
+}
+
+// OpenLedger returns a ledger holding the objects recorded in the session
+// file at path and not deleted yet, appending its new entries to it.
+func OpenLedger(path string) (*Ledger, error) {
+	entries, err := ReadLedger(path)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewLedger(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		l.entries[ledgerKey(e.Resource, e.Namespace, e.Name)] = e
+	}
+	return l, nil
+}
+
+// Run returns a ledger of the objects created by a single run, whose
+// entries are also recorded in l. Objects l recorded for other runs are
+// still deleted with their UID precondition.
+func (l *Ledger) Run() *Ledger {
+	if l == nil {
+		return nil
+	}
+	return &Ledger{entries: make(map[string]LedgerEntry), parent: l}
+}
+
+// Close closes the session file.
+func (l *Ledger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+func ledgerKey(resource, namespace, name string) string {
+	return resource + "/" + namespace + "/" + name
+}
+
+// Record records obj, of the given resource, as created by the prober.
+func (l *Ledger) Record(resource string, obj metav1.Object) {
+	if l == nil {
+		return
+	}
+	l.append(LedgerEntry{
+		Resource:  resource,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		UID:       obj.GetUID(),
+		Time:      time.Now(),
+	})
+}
+
+// Outstanding returns the objects recorded and not deleted yet, in the
+// order they were created.
+func (l *Ledger) Outstanding() []LedgerEntry {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]LedgerEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		out = append(out, e)
+	}
+	slices.SortFunc(out, func(a, b LedgerEntry) int {
+		return cmp.Or(a.Time.Compare(b.Time), cmp.Compare(ledgerKey(a.Resource, a.Namespace, a.Name), ledgerKey(b.Resource, b.Namespace, b.Name)))
+	})
+	return out
+}
+
+func (l *Ledger) append(e LedgerEntry) {
+	l.mu.Lock()
+	key := ledgerKey(e.Resource, e.Namespace, e.Name)
+	switch {
+	case !e.Deleted:
+		l.entries[key] = e
+	case e.Resource == "namespaces":
+		// Everything in the namespace goes with it
+		maps.DeleteFunc(l.entries, func(k string, entry LedgerEntry) bool {
+			return k == key || entry.Namespace == e.Name
+		})
+	default:
+		delete(l.entries, key)
+	}
+	if l.file != nil {
+		// The session file is best effort, the in-memory ledger is what
+		// cleanup relies on.
+		if data, err := json.Marshal(e); err == nil {
+			l.file.Write(append(data, '\n'))
+		}
+	}
+	l.mu.Unlock()
+
+	if l.parent != nil {
+		l.parent.append(e)
+	}
+}
+
+func (l *Ledger) lookup(resource, namespace, name string) (LedgerEntry, bool) {
+	if l == nil {
+		return LedgerEntry{}, false
+	}
+	l.mu.Lock()
+	e, ok := l.entries[ledgerKey(resource, namespace, name)]
+	l.mu.Unlock()
+	if !ok && l.parent != nil {
+		return l.parent.lookup(resource, namespace, name)
+	}
+	return e, ok
+}
+
+// ReadLedger reads a session file and returns the objects recorded in it
+// that were not deleted.
+func ReadLedger(path string) ([]LedgerEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+	defer f.Close()
+
+	l := &Ledger{entries: make(map[string]LedgerEntry)}
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		var e LedgerEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("ledger %s line %d: %w", path, line, err)
+		}
+		l.append(e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+	return l.Outstanding(), nil
+}
+
+type ledgerKeyType struct{}
+
+// WithLedger returns a copy of ctx carrying ledger.
+func WithLedger(ctx context.Context, ledger *Ledger) context.Context {
+	return context.WithValue(ctx, ledgerKeyType{}, ledger)
+}
+
+// LedgerFromContext returns the ledger carried by ctx, or nil.
+func LedgerFromContext(ctx context.Context) *Ledger {
+	l, _ := ctx.Value(ledgerKeyType{}).(*Ledger)
+	return l
+}
+
+// DeleteOwned deletes the named object with del, with a UID precondition if
+// the ledger carried by ctx recorded it. An object that is already gone is
+// not an error, while one recreated by someone else in the meantime is left
+// alone and reported with a UIDMismatchError.
+func DeleteOwned(ctx context.Context, resource, namespace, name string, opts metav1.DeleteOptions, del func(context.Context, string, metav1.DeleteOptions) error) error {
+	ledger := LedgerFromContext(ctx)
+	entry, owned := ledger.lookup(resource, namespace, name)
+	if owned {
+		opts.Preconditions = &metav1.Preconditions{UID: &entry.UID}
+	}
+
+	err := del(ctx, name, opts)
+	switch {
+	case err == nil, apierrors.IsNotFound(err):
+		if owned {
+			entry.Deleted, entry.Time = true, time.Now()
+			ledger.append(entry)
+		}
+		return nil
+	case owned && apierrors.IsConflict(err):
+		return &UIDMismatchError{Entry: entry, Err: err}
+	default:
+		return err
+	}
+}
+
+// IsUIDMismatch reports whether err is, or wraps, a UIDMismatchError.
+func IsUIDMismatch(err error) bool {
+	var mismatch *UIDMismatchError
+	return errors.As(err, &mismatch)
+}
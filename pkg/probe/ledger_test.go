@@ -0,0 +1,150 @@
+package probe
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeDelete returns a delete func for an API server holding a single
+// object, with the given UID, enforcing UID preconditions. The options of
+// every call are appended to calls.
+func fakeDelete(uid types.UID, calls *[]metav1.DeleteOptions) func(context.Context, string, metav1.DeleteOptions) error {
+	gone := false
+	return func(_ context.Context, name string, opts metav1.DeleteOptions) error {
+		*calls = append(*calls, opts)
+		gr := schema.GroupResource{Resource: "pods"}
+		switch {
+		case gone:
+			return apierrors.NewNotFound(gr, name)
+		case opts.Preconditions != nil && opts.Preconditions.UID != nil && *opts.Preconditions.UID != uid:
+			return apierrors.NewConflict(gr, name, nil)
+		}
+		gone = true
+		return nil
+	}
+}
+
+func testPod(name string, uid types.UID) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "probes", Name: name, UID: uid}}
+}
+
+func TestDeleteOwnedRecreated(t *testing.T) {
+	ledger, _ := NewLedger("")
+	ledger.Record("pods", testPod("probe-abc", "uid-1"))
+	ctx := WithLedger(context.Background(), ledger)
+
+	// Someone else deleted the pod and created another with the same name
+	var calls []metav1.DeleteOptions
+	err := DeleteOwned(ctx, "pods", "probes", "probe-abc", metav1.DeleteOptions{}, fakeDelete("uid-2", &calls))
+	if !IsUIDMismatch(err) {
+		t.Fatalf("DeleteOwned() error = %v, want a UID mismatch", err)
+	}
+	if len(calls) != 1 || calls[0].Preconditions == nil || *calls[0].Preconditions.UID != "uid-1" {
+		t.Errorf("deleted with %+v, want a single delete with the recorded UID precondition", calls)
+	}
+	if got := ledger.Outstanding(); len(got) != 1 || got[0].UID != "uid-1" {
+		t.Errorf("Outstanding() = %+v, want the pod still recorded", got)
+	}
+}
+
+func TestDeleteOwned(t *testing.T) {
+	ledger, _ := NewLedger("")
+	ledger.Record("pods", testPod("probe-abc", "uid-1"))
+	ctx := WithLedger(context.Background(), ledger)
+
+	var calls []metav1.DeleteOptions
+	del := fakeDelete("uid-1", &calls)
+	if err := DeleteOwned(ctx, "pods", "probes", "probe-abc", metav1.DeleteOptions{}, del); err != nil {
+		t.Fatalf("DeleteOwned() error = %v", err)
+	}
+	if got := ledger.Outstanding(); len(got) != 0 {
+		t.Errorf("Outstanding() = %+v, want none", got)
+	}
+	// Deleting it again is idempotent, and by name now that it's gone
+	if err := DeleteOwned(ctx, "pods", "probes", "probe-abc", metav1.DeleteOptions{}, del); err != nil {
+		t.Errorf("DeleteOwned() of a deleted pod error = %v, want nil", err)
+	}
+	if calls[1].Preconditions != nil {
+		t.Errorf("second delete preconditions = %+v, want none", calls[1].Preconditions)
+	}
+}
+
+func TestLedgerSessionFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, err := NewLedger(path)
+	if err != nil {
+		t.Fatalf("NewLedger() error = %v", err)
+	}
+	ledger.Record("pods", testPod("probe-a", "uid-a"))
+	ledger.Record("pods", testPod("probe-b", "uid-b"))
+	var calls []metav1.DeleteOptions
+	if err := DeleteOwned(WithLedger(context.Background(), ledger), "pods", "probes", "probe-a", metav1.DeleteOptions{}, fakeDelete("uid-a", &calls)); err != nil {
+		t.Fatalf("DeleteOwned() error = %v", err)
+	}
+	ledger.Close()
+
+	entries, err := ReadLedger(path)
+	if err != nil {
+		t.Fatalf("ReadLedger() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "probe-b" || entries[0].UID != "uid-b" {
+		t.Fatalf("ReadLedger() = %+v, want probe-b alone", entries)
+	}
+
+	// A later session, e.g. the cleanup subcommand, picks up from there
+	ledger, err = OpenLedger(path)
+	if err != nil {
+		t.Fatalf("OpenLedger() error = %v", err)
+	}
+	if err := DeleteOwned(WithLedger(context.Background(), ledger), "pods", "probes", "probe-b", metav1.DeleteOptions{}, fakeDelete("uid-b", &calls)); err != nil {
+		t.Fatalf("DeleteOwned() error = %v", err)
+	}
+	if *calls[1].Preconditions.UID != "uid-b" {
+		t.Errorf("deleted with %+v, want the UID read back", calls[1].Preconditions)
+	}
+	ledger.Close()
+	if entries, err := ReadLedger(path); err != nil || len(entries) != 0 {
+		t.Errorf("ReadLedger() = %+v, %v, want none left", entries, err)
+	}
+}
+
+func TestLedgerRun(t *testing.T) {
+	session, _ := NewLedger("")
+	session.Record("pods", testPod("probe-earlier", "uid-0"))
+	run := session.Run()
+	run.Record("namespaces", &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "probes", UID: "uid-ns"}})
+	run.Record("pods", testPod("probe-abc", "uid-1"))
+
+	if got := run.Outstanding(); len(got) != 2 || got[0].Resource != "namespaces" || got[1].Name != "probe-abc" {
+		t.Errorf("run Outstanding() = %+v, want the run's namespace then pod", got)
+	}
+	if got := session.Outstanding(); len(got) != 3 {
+		t.Errorf("session Outstanding() = %+v, want the run's objects as well", got)
+	}
+
+	// Objects of earlier runs are still deleted with their UID
+	var calls []metav1.DeleteOptions
+	ctx := WithLedger(context.Background(), run)
+	err := DeleteOwned(ctx, "pods", "probes", "probe-earlier", metav1.DeleteOptions{}, fakeDelete("uid-other", &calls))
+	if !IsUIDMismatch(err) {
+		t.Errorf("DeleteOwned() of an earlier run's pod error = %v, want a UID mismatch", err)
+	}
+
+	// Deleting the namespace deletes everything in it
+	if err := DeleteOwned(ctx, "namespaces", "", "probes", metav1.DeleteOptions{}, fakeDelete("uid-ns", &calls)); err != nil {
+		t.Fatalf("DeleteOwned() error = %v", err)
+	}
+	if got := run.Outstanding(); len(got) != 0 {
+		t.Errorf("run Outstanding() = %+v, want none", got)
+	}
+	if got := session.Outstanding(); len(got) != 0 {
+		t.Errorf("session Outstanding() = %+v, want none", got)
+	}
+}
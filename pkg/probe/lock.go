@@ -0,0 +1,137 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// ErrLocked is returned by Lock.Acquire when another prober still held the
+// lock once the wait was over.
+var ErrLocked = errors.New("lock held by another prober")
+
+// Lock is an exclusive lock backed by a Lease, making sure overlapping runs,
+// e.g. of two CronJobs, don't skew each other's measurements. The Lease is
+// taken for a fixed duration covering a whole run rather than renewed; an
+// expired Lease is free to take.
+type Lock struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	// Holder identifies this prober in the Lease.
+	Holder   string
+	Duration time.Duration
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+// Acquire takes the lock, trying every interval while another prober holds
+// it, for up to wait. It returns ErrLocked if the lock is still held after
+// that. onContended is called every time the lock is found held by another
+// prober, with that prober's identity.
+func (l *Lock) Acquire(ctx context.Context, wait, interval time.Duration, onContended func(holder string)) (results.LockWait, error) {
+	start := time.Now()
+	var lw results.LockWait
+
+	for {
+		holder, err := l.tryAcquire(ctx)
+		lw.Duration = time.Since(start)
+		if err != nil {
+			return lw, err
+		}
+		if holder == "" {
+			return lw, nil
+		}
+
+		lw.Contended = true
+		lw.Holder = holder
+		StatusFromContext(ctx).Observe("held by " + holder)
+		if onContended != nil {
+			onContended(holder)
+		}
+		if lw.Duration >= wait {
+			return lw, fmt.Errorf("%w: %s", ErrLocked, holder)
+		}
+
+		select {
+		case <-ctx.Done():
+			return lw, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// tryAcquire takes the lock if it's free, and otherwise returns its holder.
+func (l *Lock) tryAcquire(ctx context.Context) (string, error) {
+	leases := l.Client.CoordinationV1().Leases(l.Namespace)
+	now := metav1.NewMicroTime(time.Now())
+	spec := coordinationv1.LeaseSpec{
+		HolderIdentity:       ptr.To(l.Holder),
+		LeaseDurationSeconds: ptr.To(int32(l.Duration.Seconds())),
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+
+	lease, err := leases.Get(ctx, l.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: l.Name, Namespace: l.Namespace},
+			Spec:       spec,
+		}, metav1.CreateOptions{FieldManager: l.FieldManager})
+		if apierrors.IsAlreadyExists(err) {
+			// Lost the race, find out to whom on the next attempt
+			return "another prober", nil
+		}
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if holder := ptr.Deref(lease.Spec.HolderIdentity, ""); holder != "" && holder != l.Holder && !leaseExpired(lease, now.Time) {
+		return holder, nil
+	}
+
+	lease.Spec = spec
+	// The resource version makes sure nobody took it in the meantime.
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{FieldManager: l.FieldManager})
+	if apierrors.IsConflict(err) {
+		return "another prober", nil
+	}
+	return "", err
+}
+
+// Release frees the lock if it's still held by this prober.
+func (l *Lock) Release(ctx context.Context) error {
+	leases := l.Client.CoordinationV1().Leases(l.Namespace)
+	lease, err := leases.Get(ctx, l.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != l.Holder {
+		return nil
+	}
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{FieldManager: l.FieldManager})
+	return err
+}
+
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expiry)
+}
@@ -0,0 +1,100 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// mountUpdatedPrefix starts the line a mount watcher pod logs once it sees
+// the value it waits for.
+const mountUpdatedPrefix = "updated "
+
+// mountPath is where mount watcher pods mount their ConfigMap.
+const mountPath = "/etc/probe"
+
+// MountWatcher returns a mutator turning the probe pod into a mount watcher:
+// it mounts the named ConfigMap and reads its PayloadKey every 100ms until
+// it holds want, then logs the time it saw it and exits. The image must
+// provide a shell, cat, sleep and date.
+func MountWatcher(configMap, want string) PodMutator {
+	return func(pod *corev1.Pod) error {
+		if len(pod.Spec.Containers) == 0 {
+			return fmt.Errorf("pod has no container")
+		}
+		pod.Spec.RestartPolicy = corev1.RestartPolicyNever
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "probe-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: configMap},
+				},
+			},
+		})
+
+		c := &pod.Spec.Containers[0]
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: "probe-config", MountPath: mountPath, ReadOnly: true})
+		c.Env = append(c.Env, corev1.EnvVar{Name: "WANT", Value: want})
+		c.Command = nil
+		c.Args = []string{"sh", "-c", fmt.Sprintf(`until [ "$(cat %s/%s 2>/dev/null)" = "$WANT" ]; do sleep 0.1; done; echo "%s$(date -u +%%Y-%%m-%%dT%%H:%%M:%%S.%%NZ)"`, mountPath, PayloadKey, mountUpdatedPrefix)}
+		return nil
+	}
+}
+
+// WaitMountUpdated returns a stage polling the named mount watcher pod (see
+// MountWatcher) until it exits, then reading the time it saw the updated
+// value from its logs. The time it took from *since, with the node's clock
+// shifted by *skew, is stored in phases as mount-propagation and recorded as
+// a child span of the stage's. The kubelet refreshes ConfigMap volumes on
+// its sync period, so this is typically up to a minute or more.
+func WaitMountUpdated(client kubernetes.Interface, tracer trace.Tracer, namespace, name string, interval time.Duration, since *time.Time, skew *time.Duration, phases *[]results.Phase) Stage {
+	return Stage{
+		Name: "wait-mount",
+		Run: func(ctx context.Context) error {
+			pods := client.CoreV1().Pods(namespace)
+			err := Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				StatusFromContext(ctx).Observe(string(pod.Status.Phase))
+				switch pod.Status.Phase {
+				case corev1.PodSucceeded:
+					return true, nil
+				case corev1.PodFailed:
+					return false, fmt.Errorf("mount watcher pod %s failed: %s", name, pod.Status.Message)
+				default:
+					return false, nil
+				}
+			})
+			if err != nil {
+				return err
+			}
+
+			logs, err := pods.GetLogs(name, &corev1.PodLogOptions{}).DoRaw(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read mount watcher pod logs: %w", err)
+			}
+			at, err := parseLoggedTime(logs, mountUpdatedPrefix)
+			if err != nil {
+				return err
+			}
+			ph := results.Phase{
+				Name:     "mount-propagation",
+				Start:    *since,
+				Duration: max(at.Add(-*skew).Sub(*since), 0),
+				Outcome:  results.OutcomeSuccess,
+			}
+			*phases = append(*phases, ph)
+			RecordPhaseSpans(ctx, tracer, []results.Phase{ph})
+			return nil
+		},
+	}
+}
@@ -0,0 +1,160 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NamespaceResource is the resource of the namespaces created for a run, see
+// CreateNamespace.
+var NamespaceResource = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// NamespaceOptions describes a namespace created for a single run, isolating
+// its probes from the workloads of the cluster.
+type NamespaceOptions struct {
+	Name   string
+	Labels map[string]string
+
+	// Annotations are set on the namespace.
+	Annotations map[string]string
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+// NamespacePermissions are the permissions needed to create the namespace of
+// a run or of the namespace-deletion probe and to delete it, along with the
+// leaked ones.
+func NamespacePermissions() Permissions {
+	return Permissions{
+		Measure: []Permission{
+			{Resource: "namespaces", Verb: "create"},
+			{Resource: "serviceaccounts", Verb: "get"},
+		},
+		Cleanup: []Permission{
+			{Resource: "namespaces", Verb: "delete"},
+			{Resource: "namespaces", Verb: "get"},
+			{Resource: "namespaces", Verb: "list"},
+		},
+	}
+}
+
+// CreateNamespace returns a stage creating the namespace. Measuring its
+// deletion is left to DeleteNamespace, its teardown only deletes it, without
+// waiting, if it is still around.
+func CreateNamespace(clients Clients, opts NamespaceOptions) Stage {
+	namespaces := clients.Cleanup.CoreV1().Namespaces()
+	return Stage{
+		Name: "create-namespace",
+		Run: func(ctx context.Context) error {
+			ns, err := clients.Measure.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        opts.Name,
+					Labels:      opts.Labels,
+					Annotations: opts.Annotations,
+				},
+			}, metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("namespaces", ns)
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			return DeleteOwned(ctx, "namespaces", "", opts.Name, metav1.DeleteOptions{}, namespaces.Delete)
+		},
+	}
+}
+
+// CreateNamespaceContent returns a stage creating n ConfigMaps in the
+// namespace, the content the namespace controller deletes along with it.
+// They are left to the namespace's deletion.
+func CreateNamespaceContent(client kubernetes.Interface, opts NamespaceOptions, n int) Stage {
+	configMaps := client.CoreV1().ConfigMaps(opts.Name)
+	return Stage{
+		Name: "create-namespace-content",
+		Run: func(ctx context.Context) error {
+			for i := range n {
+				_, err := configMaps.Create(ctx, &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        fmt.Sprintf("probe-content-%d", i),
+						Labels:      opts.Labels,
+						Annotations: opts.Annotations,
+					},
+					Data: map[string]string{"index": strconv.Itoa(i)},
+				}, metav1.CreateOptions{FieldManager: opts.FieldManager})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// WaitNamespaceReady returns a stage polling the namespace until its default
+// ServiceAccount exists: until then, the ServiceAccount admission plugin
+// rejects the pods created in it.
+func WaitNamespaceReady(client kubernetes.Interface, namespace string, interval time.Duration) Stage {
+	return Stage{
+		Name: "wait-namespace-ready",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				_, err := client.CoreV1().ServiceAccounts(namespace).Get(ctx, "default", metav1.GetOptions{})
+				if apierrors.IsNotFound(err) {
+					StatusFromContext(ctx).Observe("no default serviceaccount")
+					return false, nil
+				}
+				return err == nil, err
+			})
+		},
+	}
+}
+
+// DeleteNamespace returns a stage deleting the namespace and waiting until it
+// is gone, i.e. until the namespace controller deleted everything in it and
+// removed its finalizer.
+func DeleteNamespace(client kubernetes.Interface, namespace string, interval time.Duration) Stage {
+	namespaces := client.CoreV1().Namespaces()
+	return Stage{
+		Name: "delete-namespace",
+		Run: func(ctx context.Context) error {
+			err := DeleteOwned(ctx, "namespaces", "", namespace, metav1.DeleteOptions{}, namespaces.Delete)
+			if err != nil {
+				return err
+			}
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				ns, err := namespaces.Get(ctx, namespace, metav1.GetOptions{})
+				if apierrors.IsNotFound(err) {
+					return true, nil
+				}
+				if err == nil {
+					StatusFromContext(ctx).Observe(deletionProgress(ns))
+				}
+				return false, err
+			})
+		},
+	}
+}
+
+// deletionProgress returns what the deletion of ns is waiting for, according
+// to the conditions set by the namespace controller, or its phase.
+func deletionProgress(ns *corev1.Namespace) string {
+	for _, c := range ns.Status.Conditions {
+		switch c.Type {
+		case corev1.NamespaceContentRemaining, corev1.NamespaceFinalizersRemaining, corev1.NamespaceDeletionContentFailure:
+			if c.Status == corev1.ConditionTrue {
+				return fmt.Sprintf("%s: %s", c.Type, c.Message)
+			}
+		}
+	}
+	return string(ns.Status.Phase)
+}
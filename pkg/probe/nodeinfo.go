@@ -0,0 +1,88 @@
+package probe
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// Attribute keys describing the node a probe pod landed on.
+const (
+	AttrNodeName                = "node.name"
+	AttrKubeletVersion          = results.AttrKubeletVersion
+	AttrContainerRuntimeVersion = "node.container_runtime_version"
+	AttrKernelVersion           = "node.kernel_version"
+	AttrNodeReadyAge            = "node.ready_age"
+	// AttrZone is the availability zone of the node, named after the node
+	// label it is read from.
+	AttrZone = ZoneLabel
+)
+
+// NodeAttributes returns the attributes describing the named node. When the
+// node can't be fetched, e.g. for lack of permissions, only its name is
+// returned along with the error, so callers can still attribute results.
+func NodeAttributes(ctx context.Context, client kubernetes.Interface, name string) (map[string]string, error) {
+	attrs := map[string]string{AttrNodeName: name}
+
+	node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return attrs, err
+	}
+
+	if zone := node.Labels[ZoneLabel]; zone != "" {
+		attrs[AttrZone] = zone
+	}
+	info := node.Status.NodeInfo
+	attrs[AttrKubeletVersion] = info.KubeletVersion
+	attrs[AttrContainerRuntimeVersion] = info.ContainerRuntimeVersion
+	attrs[AttrKernelVersion] = info.KernelVersion
+
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady && c.Status == corev1.ConditionTrue {
+			attrs[AttrNodeReadyAge] = time.Since(c.LastTransitionTime.Time).Round(time.Second).String()
+		}
+	}
+
+	return attrs, nil
+}
+
+// ZoneResolver looks up the zones of nodes, caching them for the lifetime of
+// the process as nodes don't move across zones.
+type ZoneResolver struct {
+	client kubernetes.Interface
+
+	mu    sync.Mutex
+	zones map[string]string
+}
+
+// NewZoneResolver returns a ZoneResolver getting nodes with client.
+func NewZoneResolver(client kubernetes.Interface) *ZoneResolver {
+	return &ZoneResolver{client: client, zones: make(map[string]string)}
+}
+
+// Zone returns the zone of the named node, empty when the node has no zone
+// label.
+func (z *ZoneResolver) Zone(ctx context.Context, name string) (string, error) {
+	z.mu.Lock()
+	zone, ok := z.zones[name]
+	z.mu.Unlock()
+	if ok {
+		return zone, nil
+	}
+
+	node, err := z.client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	zone = node.Labels[ZoneLabel]
+	z.mu.Lock()
+	z.zones[name] = zone
+	z.mu.Unlock()
+	return zone, nil
+}
@@ -0,0 +1,224 @@
+package probe
+
+import (
+	"math/rand"
+	"slices"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ZoneLabel is the well-known label holding a node's availability zone.
+const ZoneLabel = "topology.kubernetes.io/zone"
+
+// NodeSampler picks a bounded sample of nodes to probe on each run. It keeps
+// track of the nodes it already picked so that consecutive samples rotate
+// through the whole fleet before any node is picked again.
+type NodeSampler struct {
+	// Size is the maximum number of nodes in a sample. Zero means every
+	// candidate node.
+	Size int
+	// Selector restricts the candidate nodes by labels.
+	Selector labels.Selector
+
+	mu      sync.Mutex
+	covered map[string]bool
+}
+
+// NewNodeSampler returns a sampler picking at most size nodes among those
+// matching selector. A nil selector matches every node.
+func NewNodeSampler(size int, selector labels.Selector) *NodeSampler {
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	return &NodeSampler{
+		Size:     size,
+		Selector: selector,
+		covered:  make(map[string]bool),
+	}
+}
+
+// Candidates returns the schedulable, ready nodes matching the selector.
+func (s *NodeSampler) Candidates(nodes []corev1.Node) []corev1.Node {
+	var out []corev1.Node
+	for _, n := range nodes {
+		if n.Spec.Unschedulable || !nodeReady(&n) {
+			continue
+		}
+		if !s.Selector.Matches(labels.Set(n.Labels)) {
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// Zones returns the zones of the candidate nodes, in order. Nodes without a
+// zone label are left out.
+func (s *NodeSampler) Zones(nodes []corev1.Node) []string {
+	var zones []string
+	for _, n := range s.Candidates(nodes) {
+		if zone := n.Labels[ZoneLabel]; zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(zones)
+	return slices.Compact(zones)
+}
+
+// Sample returns the names of the nodes to probe in this run, using seed to
+// shuffle the candidates. Nodes not yet covered by a previous sample are
+// preferred, and the sample is spread evenly across zones.
+func (s *NodeSampler) Sample(nodes []corev1.Node, seed int64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candidates := s.Candidates(nodes)
+	size := s.Size
+	if size <= 0 || size > len(candidates) {
+		size = len(candidates)
+	}
+
+	var fresh, seen []corev1.Node
+	for _, n := range candidates {
+		if s.covered[n.Name] {
+			seen = append(seen, n)
+		} else {
+			fresh = append(fresh, n)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	picked := stratify(fresh, size, rng)
+	if len(picked) < size {
+		// The whole fleet has been covered, start a new rotation.
+		s.covered = make(map[string]bool)
+		picked = append(picked, stratify(seen, size-len(picked), rng)...)
+	}
+
+	for _, name := range picked {
+		s.covered[name] = true
+	}
+	return picked
+}
+
+// Covered returns the names of the nodes sampled in the current rotation.
+func (s *NodeSampler) Covered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]string, 0, len(s.covered))
+	for name := range s.covered {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Restore seeds the rotation state, e.g. from a previously persisted
+// Covered list.
+func (s *NodeSampler) Restore(covered []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.covered = make(map[string]bool, len(covered))
+	for _, name := range covered {
+		s.covered[name] = true
+	}
+}
+
+// PinToNode returns a PodMutator binding the pod to the named node, bypassing
+// the scheduler, so that it measures the node's kubelet and container
+// runtime alone.
+func PinToNode(node string) PodMutator {
+	return func(pod *corev1.Pod) error {
+		pod.Spec.NodeName = node
+		return nil
+	}
+}
+
+// RequireNode returns a PodMutator restricting the pod to the named node
+// through a required node affinity on the node's name, as DaemonSets do, so
+// that it still goes through the scheduler.
+func RequireNode(node string) PodMutator {
+	return func(pod *corev1.Pod) error {
+		if pod.Spec.Affinity == nil {
+			pod.Spec.Affinity = &corev1.Affinity{}
+		}
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchFields: []corev1.NodeSelectorRequirement{{
+						Key:      "metadata.name",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{node},
+					}},
+				}},
+			},
+		}
+		return nil
+	}
+}
+
+// InZone returns a PodMutator restricting the pod to the nodes of zone
+// through a node selector on their zone label, leaving the choice of the
+// node to the scheduler.
+func InZone(zone string) PodMutator {
+	return func(pod *corev1.Pod) error {
+		if pod.Spec.NodeSelector == nil {
+			pod.Spec.NodeSelector = make(map[string]string)
+		}
+		pod.Spec.NodeSelector[ZoneLabel] = zone
+		return nil
+	}
+}
+
+// stratify picks up to n node names, round-robin across zones, each zone's
+// nodes being shuffled with rng.
+func stratify(nodes []corev1.Node, n int, rng *rand.Rand) []string {
+	byZone := map[string][]string{}
+	for _, node := range nodes {
+		zone := node.Labels[ZoneLabel]
+		byZone[zone] = append(byZone[zone], node.Name)
+	}
+
+	zones := make([]string, 0, len(byZone))
+	for zone, names := range byZone {
+		sort.Strings(names)
+		rng.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	rng.Shuffle(len(zones), func(i, j int) { zones[i], zones[j] = zones[j], zones[i] })
+
+	var out []string
+	for len(out) < n {
+		progress := false
+		for _, zone := range zones {
+			if len(out) == n {
+				break
+			}
+			if len(byZone[zone]) == 0 {
+				continue
+			}
+			out = append(out, byZone[zone][0])
+			byZone[zone] = byZone[zone][1:]
+			progress = true
+		}
+		if !progress {
+			break
+		}
+	}
+	return out
+}
+
+func nodeReady(n *corev1.Node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
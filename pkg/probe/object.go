@@ -0,0 +1,107 @@
+package probe
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PayloadKey is the data key holding the payload of ConfigMaps and Secrets
+// written by the probes.
+const PayloadKey = "payload"
+
+// ObjectWriter creates, updates and deletes a small object carrying a
+// payload. It abstracts over ConfigMaps and Secrets.
+type ObjectWriter interface {
+	Create(ctx context.Context, payload string) error
+	Update(ctx context.Context, payload string) error
+	Delete(ctx context.Context) error
+}
+
+// ObjectOptions describes the object written by an ObjectWriter.
+type ObjectOptions struct {
+	Name      string
+	Namespace string
+	Labels    map[string]string
+
+	// Annotations are set on the object.
+	Annotations map[string]string
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+// NewObjectWriter returns a writer for the given kind, "configmap" or
+// "secret".
+// Deletes go through the cleanup client.
+func NewObjectWriter(clients Clients, kind string, opts ObjectOptions) ObjectWriter {
+	meta := metav1.ObjectMeta{Name: opts.Name, Namespace: opts.Namespace, Labels: opts.Labels, Annotations: opts.Annotations}
+	if kind == "secret" {
+		return &secretWriter{clients: clients, meta: meta, fieldManager: opts.FieldManager}
+	}
+	return &configMapWriter{clients: clients, meta: meta, fieldManager: opts.FieldManager}
+}
+
+type configMapWriter struct {
+	clients      Clients
+	meta         metav1.ObjectMeta
+	fieldManager string
+}
+
+func (w *configMapWriter) object(payload string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: w.meta,
+		Data:       map[string]string{PayloadKey: payload},
+	}
+}
+
+func (w *configMapWriter) Create(ctx context.Context, payload string) error {
+	obj, err := w.clients.Measure.CoreV1().ConfigMaps(w.meta.Namespace).Create(ctx, w.object(payload), metav1.CreateOptions{FieldManager: w.fieldManager})
+	if err != nil {
+		return err
+	}
+	LedgerFromContext(ctx).Record("configmaps", obj)
+	return nil
+}
+
+func (w *configMapWriter) Update(ctx context.Context, payload string) error {
+	_, err := w.clients.Measure.CoreV1().ConfigMaps(w.meta.Namespace).Update(ctx, w.object(payload), metav1.UpdateOptions{FieldManager: w.fieldManager})
+	return err
+}
+
+func (w *configMapWriter) Delete(ctx context.Context) error {
+	return DeleteOwned(ctx, "configmaps", w.meta.Namespace, w.meta.Name, metav1.DeleteOptions{}, w.clients.Cleanup.CoreV1().ConfigMaps(w.meta.Namespace).Delete)
+}
+
+type secretWriter struct {
+	clients      Clients
+	meta         metav1.ObjectMeta
+	fieldManager string
+}
+
+func (w *secretWriter) object(payload string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: w.meta,
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{PayloadKey: []byte(payload)},
+	}
+}
+
+func (w *secretWriter) Create(ctx context.Context, payload string) error {
+	obj, err := w.clients.Measure.CoreV1().Secrets(w.meta.Namespace).Create(ctx, w.object(payload), metav1.CreateOptions{FieldManager: w.fieldManager})
+	if err != nil {
+		return err
+	}
+	LedgerFromContext(ctx).Record("secrets", obj)
+	return nil
+}
+
+func (w *secretWriter) Update(ctx context.Context, payload string) error {
+	_, err := w.clients.Measure.CoreV1().Secrets(w.meta.Namespace).Update(ctx, w.object(payload), metav1.UpdateOptions{FieldManager: w.fieldManager})
+	return err
+}
+
+func (w *secretWriter) Delete(ctx context.Context) error {
+	return DeleteOwned(ctx, "secrets", w.meta.Namespace, w.meta.Name, metav1.DeleteOptions{}, w.clients.Cleanup.CoreV1().Secrets(w.meta.Namespace).Delete)
+}
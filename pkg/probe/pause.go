@@ -0,0 +1,111 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PauseChecker decides whether the prober should skip an iteration because
+// measurements are paused, e.g. during a planned control-plane upgrade.
+type PauseChecker struct {
+	Client    kubernetes.Interface
+	Namespace string
+
+	// Annotation is the key holding the pause expression, read from the
+	// ConfigMap's data when ConfigMap is set, or from the namespace's
+	// annotations otherwise. Empty disables the check.
+	Annotation string
+	ConfigMap  string
+
+	// Schedule and Duration describe a recurring local pause window starting
+	// at every Schedule activation and lasting Duration.
+	Schedule cron.Schedule
+	Duration time.Duration
+}
+
+// ParseCron parses a standard 5-field cron expression.
+func ParseCron(expr string) (cron.Schedule, error) {
+	sched, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+	return sched, nil
+}
+
+// Paused reports whether measurements are paused at now, and why.
+func (c *PauseChecker) Paused(ctx context.Context, now time.Time) (bool, string, error) {
+	if c.Schedule != nil && c.Duration > 0 {
+		if start := c.Schedule.Next(now.Add(-c.Duration)); !start.After(now) {
+			return true, fmt.Sprintf("in recurring pause window started at %s", start.Format(time.RFC3339)), nil
+		}
+	}
+
+	if c.Annotation == "" {
+		return false, "", nil
+	}
+
+	var (
+		value  string
+		source string
+	)
+	if c.ConfigMap != "" {
+		cm, err := c.Client.CoreV1().ConfigMaps(c.Namespace).Get(ctx, c.ConfigMap, metav1.GetOptions{})
+		if err != nil {
+			return false, "", fmt.Errorf("failed to get pause configmap: %w", err)
+		}
+		value, source = cm.Data[c.Annotation], "configmap/"+c.ConfigMap
+	} else {
+		ns, err := c.Client.CoreV1().Namespaces().Get(ctx, c.Namespace, metav1.GetOptions{})
+		if err != nil {
+			return false, "", fmt.Errorf("failed to get namespace: %w", err)
+		}
+		value, source = ns.Annotations[c.Annotation], "namespace/"+c.Namespace
+	}
+
+	paused, err := ParsePauseExpression(value, now)
+	if err != nil {
+		return false, "", fmt.Errorf("%s: %w", source, err)
+	}
+	if !paused {
+		return false, "", nil
+	}
+	return true, fmt.Sprintf("%s=%q set on %s", c.Annotation, value, source), nil
+}
+
+// ParsePauseExpression evaluates a pause expression at now. The expression
+// is either empty or "false" (not paused), "true" (paused until removed), an
+// RFC 3339 timestamp (paused until then), or two RFC 3339 timestamps
+// separated by a slash (paused within that interval).
+func ParsePauseExpression(expr string, now time.Time) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	switch strings.ToLower(expr) {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	}
+
+	if from, to, ok := strings.Cut(expr, "/"); ok {
+		start, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return false, fmt.Errorf("invalid pause window start: %w", err)
+		}
+		end, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return false, fmt.Errorf("invalid pause window end: %w", err)
+		}
+		return !now.Before(start) && now.Before(end), nil
+	}
+
+	until, err := time.Parse(time.RFC3339, expr)
+	if err != nil {
+		return false, fmt.Errorf("invalid pause expression %q", expr)
+	}
+	return now.Before(until), nil
+}
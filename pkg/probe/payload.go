@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// MaxPayloadSize is the largest payload accepted, leaving headroom below the
+// 1MiB object size limit for the object's metadata.
+const MaxPayloadSize = 900 * 1024
+
+// ParsePayloadSize parses a size such as "64KiB" or "512Ki" and checks it is
+// within bounds.
+func ParsePayloadSize(s string) (int, error) {
+	q, err := resource.ParseQuantity(strings.TrimSuffix(strings.TrimSpace(s), "B"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid payload size %q: %w", s, err)
+	}
+	size, ok := q.AsInt64()
+	if !ok || size < 0 || size > MaxPayloadSize {
+		return 0, fmt.Errorf("payload size %q must be between 0 and %d bytes", s, MaxPayloadSize)
+	}
+	return int(size), nil
+}
+
+// ParsePayloadSweep parses a comma-separated list of payload sizes.
+func ParsePayloadSweep(s string) ([]int, error) {
+	var sizes []int
+	for _, part := range strings.Split(s, ",") {
+		size, err := ParsePayloadSize(part)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// Payload returns size bytes of random printable data. The content is
+// meaningless and must never be logged.
+func Payload(size int) (string, error) {
+	if size == 0 {
+		return "", nil
+	}
+	buf := make([]byte, base64.RawStdEncoding.DecodedLen(size)+1)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate payload: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(buf)[:size], nil
+}
+
+// SizeClass returns a label for size out of a bounded set of values: the
+// smallest power of two number of KiB at least as large as size, so it can
+// be used as a metric attribute.
+func SizeClass(size int) string {
+	if size <= 0 {
+		return "0"
+	}
+	class := 1024
+	for class < size {
+		class *= 2
+	}
+	return FormatSize(class)
+}
+
+// FormatSize formats size using binary units.
+func FormatSize(size int) string {
+	switch {
+	case size >= 1024*1024 && size%(1024*1024) == 0:
+		return fmt.Sprintf("%dMiB", size/(1024*1024))
+	case size >= 1024 && size%1024 == 0:
+		return fmt.Sprintf("%dKiB", size/1024)
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
@@ -0,0 +1,102 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+)
+
+// AttrPendingPods is the attribute holding the number of pods pending
+// cluster-wide when a probe pod was created.
+const AttrPendingPods = "probe.cluster.pending_pods"
+
+// DefaultPendingLimit bounds the pending pods list, beyond it the scheduler
+// is saturated anyway and the exact count doesn't matter.
+const DefaultPendingLimit = 500
+
+// PendingPods is a sample of the number of pods pending cluster-wide.
+type PendingPods struct {
+	Count int
+	// Capped is set when there were more pending pods than the sampler's
+	// limit, Count is then a lower bound.
+	Capped bool
+}
+
+func (p PendingPods) String() string {
+	if p.Capped {
+		return strconv.Itoa(p.Count) + "+"
+	}
+	return strconv.Itoa(p.Count)
+}
+
+// Class returns the order of magnitude of the count, suitable as a metric
+// attribute.
+func (p PendingPods) Class() string {
+	switch {
+	case p.Count == 0:
+		return "0"
+	case p.Count <= 10:
+		return "1-10"
+	case p.Count <= 100:
+		return "11-100"
+	case p.Capped:
+		return "saturated"
+	default:
+		return "100+"
+	}
+}
+
+// Attributes returns the sample as span attributes.
+func (p PendingPods) Attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int(AttrPendingPods, p.Count),
+		attribute.Bool(AttrPendingPods+".capped", p.Capped),
+	}
+}
+
+// PendingSampler counts the pods pending cluster-wide, which put a probe
+// pod's scheduling latency in context: behind a long queue it measures the
+// queue's depth more than the scheduler's speed. Listing pods cluster-wide
+// takes broader permissions than probing; once forbidden, the sampler stops
+// trying. A nil *PendingSampler never samples.
+type PendingSampler struct {
+	Client metadata.Interface
+	// Limit defaults to DefaultPendingLimit.
+	Limit int64
+
+	forbidden atomic.Bool
+}
+
+// Sample returns the number of pods pending cluster-wide. It returns false
+// if it couldn't sample, and an error only the first time it is forbidden or
+// when listing fails otherwise.
+func (s *PendingSampler) Sample(ctx context.Context) (PendingPods, bool, error) {
+	if s == nil || s.forbidden.Load() {
+		return PendingPods{}, false, nil
+	}
+	limit := s.Limit
+	if limit == 0 {
+		limit = DefaultPendingLimit
+	}
+
+	// Only metadata, and at most limit items, keep the list cheap.
+	list, err := s.Client.Resource(schema.GroupVersionResource{Version: "v1", Resource: "pods"}).List(ctx, metav1.ListOptions{
+		FieldSelector: "status.phase=Pending",
+		Limit:         limit,
+	})
+	if apierrors.IsForbidden(err) {
+		s.forbidden.Store(true)
+		return PendingPods{}, false, fmt.Errorf("not allowed to list pods cluster-wide, disabling pending pods sampling: %w", err)
+	}
+	if err != nil {
+		return PendingPods{}, false, fmt.Errorf("failed to sample pending pods: %w", err)
+	}
+	return PendingPods{Count: len(list.Items), Capped: list.Continue != ""}, true, nil
+}
@@ -0,0 +1,164 @@
+// Package probe contains the building blocks of the latency probes.
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// PodMutator adjusts the probe pod before it is created. Returning an error
+// aborts the probe before anything is created.
+type PodMutator func(*corev1.Pod) error
+
+// PodOptions describes the pod created by the pod probe.
+type PodOptions struct {
+	Name      string
+	Namespace string
+	Image     string
+	Labels    map[string]string
+
+	// Annotations are set on the pod.
+	Annotations map[string]string
+
+	// OwnerReferences are set on the pod, so that it is garbage collected
+	// along with its owner if the prober never gets to delete it.
+	OwnerReferences []metav1.OwnerReference
+
+	// FieldManager is set on every write to the pod.
+	FieldManager string
+
+	// Template, when set, is the base of the pod: its spec, e.g. resources,
+	// node selector, tolerations, security context or priority class, is
+	// used as is, and its labels and annotations are added to the pod's. The
+	// probe's own container is its container named "probe", added first if
+	// it has none; it gets the probe's command, and Image unless the
+	// template sets one.
+	Template *corev1.PodTemplateSpec
+
+	// Mutators are applied in order to the generated pod, right before it is
+	// created.
+	Mutators []PodMutator
+}
+
+// Build returns the pod described by the options, with all the mutators
+// applied.
+func (o PodOptions) Build() (*corev1.Pod, error) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            o.Name,
+			Namespace:       o.Namespace,
+			Labels:          o.Labels,
+			Annotations:     o.Annotations,
+			OwnerReferences: o.OwnerReferences,
+		},
+	}
+	if o.Template != nil {
+		pod.Spec = *o.Template.Spec.DeepCopy()
+		pod.Labels = mergeMeta(o.Template.Labels, o.Labels)
+		pod.Annotations = mergeMeta(o.Template.Annotations, o.Annotations)
+	}
+	i := slices.IndexFunc(pod.Spec.Containers, func(c corev1.Container) bool { return c.Name == "probe" })
+	if i < 0 {
+		pod.Spec.Containers = slices.Insert(pod.Spec.Containers, 0, corev1.Container{Name: "probe"})
+		i = 0
+	}
+	c := &pod.Spec.Containers[i]
+	if c.Image == "" {
+		c.Image = o.Image
+	}
+	c.Command = nil
+	c.Args = []string{"sh", "-c", "while true; do echo hello; sleep 10;done"}
+
+	for i, mutate := range o.Mutators {
+		if err := mutate(pod); err != nil {
+			return nil, fmt.Errorf("pod mutator %d failed: %w", i, err)
+		}
+	}
+
+	return pod, nil
+}
+
+// mergeMeta returns the labels or annotations of a template with the pod's
+// own, which take precedence.
+func mergeMeta(template, own map[string]string) map[string]string {
+	if len(template) == 0 {
+		return own
+	}
+	merged := maps.Clone(template)
+	maps.Copy(merged, own)
+	return merged
+}
+
+// ParsePodTemplate parses a YAML (or JSON) PodTemplateSpec, i.e. a pod's
+// metadata and spec. Unknown fields are an error, to catch typos.
+func ParsePodTemplate(data []byte) (*corev1.PodTemplateSpec, error) {
+	var template corev1.PodTemplateSpec
+	if err := yaml.UnmarshalStrict(data, &template); err != nil {
+		return nil, fmt.Errorf("failed to parse pod template: %w", err)
+	}
+	return &template, nil
+}
+
+// PodTemplateFromFile reads the YAML PodTemplateSpec at path, see
+// ParsePodTemplate.
+func PodTemplateFromFile(path string) (*corev1.PodTemplateSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pod template: %w", err)
+	}
+	return ParsePodTemplate(data)
+}
+
+// CreateOptions returns the options to create the pod with.
+func (o PodOptions) CreateOptions() metav1.CreateOptions {
+	return metav1.CreateOptions{FieldManager: o.FieldManager}
+}
+
+// PatchOptions returns the options to patch the pod with.
+func (o PodOptions) PatchOptions() metav1.PatchOptions {
+	return metav1.PatchOptions{FieldManager: o.FieldManager}
+}
+
+// PatchMutator returns a mutator applying patch to the pod as a strategic
+// merge patch. The patch may be either YAML or JSON.
+func PatchMutator(patch []byte) (PodMutator, error) {
+	patchJSON, err := yaml.YAMLToJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pod patch: %w", err)
+	}
+
+	return func(pod *corev1.Pod) error {
+		original, err := json.Marshal(pod)
+		if err != nil {
+			return fmt.Errorf("failed to encode pod: %w", err)
+		}
+		patched, err := strategicpatch.StrategicMergePatch(original, patchJSON, corev1.Pod{})
+		if err != nil {
+			return fmt.Errorf("failed to apply pod patch: %w", err)
+		}
+
+		var out corev1.Pod
+		if err := json.Unmarshal(patched, &out); err != nil {
+			return fmt.Errorf("failed to decode patched pod: %w", err)
+		}
+		*pod = out
+		return nil
+	}, nil
+}
+
+// PatchMutatorFromFile returns a PatchMutator reading its patch from path.
+func PatchMutatorFromFile(path string) (PodMutator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pod patch: %w", err)
+	}
+	return PatchMutator(data)
+}
@@ -0,0 +1,155 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestBuildAppliesMutatorsInOrder(t *testing.T) {
+	var order []string
+	mutator := func(name string) PodMutator {
+		return func(pod *corev1.Pod) error {
+			order = append(order, name)
+			pod.Labels["last"] = name
+			return nil
+		}
+	}
+	opts := PodOptions{
+		Name:     "probe-abc",
+		Image:    "busybox",
+		Labels:   map[string]string{"app": "probe"},
+		Mutators: []PodMutator{mutator("first"), mutator("second"), mutator("third")},
+	}
+
+	pod, err := opts.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if want := []string{"first", "second", "third"}; !slices.Equal(order, want) {
+		t.Errorf("mutators ran in order %v, want %v", order, want)
+	}
+	if got := pod.Labels["last"]; got != "third" {
+		t.Errorf("last label = %q, want the last mutator's", got)
+	}
+}
+
+func TestBuildMutatorsSeeTemplate(t *testing.T) {
+	// Mutators are applied after the template and the options are merged
+	opts := PodOptions{
+		Name:  "probe-abc",
+		Image: "busybox",
+		Template: &corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "sidecar", Image: "envoy"}},
+			},
+		},
+		Mutators: []PodMutator{func(pod *corev1.Pod) error {
+			if len(pod.Spec.Containers) != 2 || pod.Spec.Containers[0].Name != "probe" {
+				return errors.New("mutator ran before the probe container was added")
+			}
+			return nil
+		}},
+	}
+	if _, err := opts.Build(); err != nil {
+		t.Errorf("Build() error = %v", err)
+	}
+}
+
+func TestBuildMutatorError(t *testing.T) {
+	errBroken := errors.New("broken")
+	ran := false
+	opts := PodOptions{
+		Name:  "probe-abc",
+		Image: "busybox",
+		Mutators: []PodMutator{
+			func(*corev1.Pod) error { return nil },
+			func(*corev1.Pod) error { return errBroken },
+			func(*corev1.Pod) error { ran = true; return nil },
+		},
+	}
+
+	_, err := opts.Build()
+	if !errors.Is(err, errBroken) {
+		t.Fatalf("Build() error = %v, want %v", err, errBroken)
+	}
+	if err.Error() != "pod mutator 1 failed: broken" {
+		t.Errorf("Build() error = %q, want the failing mutator's index", err)
+	}
+	if ran {
+		t.Error("the mutator after the failing one ran")
+	}
+}
+
+func TestCreatePodMutatorErrorAbortsBeforeCreate(t *testing.T) {
+	client := fake.NewClientset()
+	opts := PodOptions{
+		Name:      "probe-abc",
+		Namespace: "default",
+		Image:     "busybox",
+		Mutators:  []PodMutator{func(*corev1.Pod) error { return errors.New("broken") }},
+	}
+
+	var (
+		created corev1.Pod
+		skew    time.Duration
+	)
+	if err := CreatePod(SingleClient(client), opts, &created, &skew).Run(context.Background()); err == nil {
+		t.Fatal("create-pod error = nil, want the mutator's")
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("API calls = %v, want none", actions)
+	}
+}
+
+func TestPatchMutator(t *testing.T) {
+	mutate, err := PatchMutator([]byte(`
+metadata:
+  annotations:
+    company.example/team: sre
+spec:
+  runtimeClassName: gvisor
+  containers:
+    - name: sidecar
+      image: envoy
+`))
+	if err != nil {
+		t.Fatalf("PatchMutator() error = %v", err)
+	}
+	opts := PodOptions{
+		Name:     "probe-abc",
+		Image:    "busybox",
+		Mutators: []PodMutator{mutate},
+	}
+
+	pod, err := opts.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if got := pod.Annotations["company.example/team"]; got != "sre" {
+		t.Errorf("annotation = %q, want sre", got)
+	}
+	if !ptr.Equal(pod.Spec.RuntimeClassName, ptr.To("gvisor")) {
+		t.Errorf("runtimeClassName = %v, want gvisor", pod.Spec.RuntimeClassName)
+	}
+	// Containers are merged by name
+	var names []string
+	for _, c := range pod.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	if !slices.Contains(names, "probe") || !slices.Contains(names, "sidecar") {
+		t.Errorf("containers = %v, want probe and sidecar", names)
+	}
+}
+
+func TestPatchMutatorInvalid(t *testing.T) {
+	if _, err := PatchMutator([]byte("spec: [")); err == nil {
+		t.Error("PatchMutator() error = nil, want a parse error")
+	}
+}
@@ -0,0 +1,144 @@
+package probe
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EventWatch streams the Events involving an object, e.g. a probe pod's
+// Scheduled, Pulling, Pulled, Started or FailedScheduling, which explain why
+// a run was slow. Watching is best effort: its failure is only reported by
+// Stop.
+type EventWatch struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	events map[types.UID]corev1.Event
+	err    error
+}
+
+// WatchEvents starts watching the Events involving the object of the given
+// kind and name in namespace, which may not exist yet, until ctx is done or
+// Stop is called.
+func WatchEvents(ctx context.Context, client kubernetes.Interface, namespace, kind, name string) *EventWatch {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &EventWatch{cancel: cancel, done: make(chan struct{}), events: map[types.UID]corev1.Event{}}
+	go func() {
+		defer close(w.done)
+		w.watch(ctx, client, namespace, fields.AndSelectors(
+			fields.OneTermEqualSelector("involvedObject.kind", kind),
+			fields.OneTermEqualSelector("involvedObject.name", name),
+		).String())
+	}()
+	return w
+}
+
+func (w *EventWatch) watch(ctx context.Context, client kubernetes.Interface, namespace, selector string) {
+	watcher, err := client.CoreV1().Events(namespace).Watch(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+			switch ev.Type {
+			case watch.Added, watch.Modified:
+				if e, ok := ev.Object.(*corev1.Event); ok {
+					w.mu.Lock()
+					w.events[e.UID] = *e
+					w.mu.Unlock()
+				}
+			case watch.Error:
+				w.fail(apierrors.FromObject(ev.Object))
+				return
+			}
+		}
+	}
+}
+
+func (w *EventWatch) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+}
+
+// Stop stops watching, and returns the Events seen so far in the order they
+// last occurred, along with the error that stopped the watch early, if any.
+// Repeated Events, e.g. FailedScheduling, are reported once with their count.
+func (w *EventWatch) Stop() ([]corev1.Event, error) {
+	w.cancel()
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := make([]corev1.Event, 0, len(w.events))
+	for _, e := range w.events {
+		events = append(events, e)
+	}
+	slices.SortStableFunc(events, func(a, b corev1.Event) int {
+		return EventTime(a).Compare(EventTime(b))
+	})
+	return events, w.err
+}
+
+// EventTime returns the time e last occurred, whichever of its timestamps
+// its reporter set.
+func EventTime(e corev1.Event) time.Time {
+	switch {
+	case e.Series != nil && !e.Series.LastObservedTime.IsZero():
+		return e.Series.LastObservedTime.Time
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.FirstTimestamp.Time
+	}
+}
+
+// RecordEvents records events as events of the span in ctx, named after
+// their reason, at the time they last occurred shifted by skew to the
+// prober's clock.
+func RecordEvents(ctx context.Context, events []corev1.Event, skew time.Duration) {
+	span := trace.SpanFromContext(ctx)
+	for _, e := range events {
+		count := max(e.Count, 1)
+		if e.Series != nil {
+			count = max(count, e.Series.Count)
+		}
+		source := e.ReportingController
+		if source == "" {
+			source = e.Source.Component
+		}
+		span.AddEvent(e.Reason,
+			trace.WithTimestamp(EventTime(e).Add(-skew)),
+			trace.WithAttributes(
+				attribute.String("k8s.event.reason", e.Reason),
+				attribute.String("k8s.event.type", e.Type),
+				attribute.String("k8s.event.message", e.Message),
+				attribute.String("k8s.event.source", source),
+				attribute.Int("k8s.event.count", int(count)),
+			),
+		)
+	}
+}
@@ -0,0 +1,65 @@
+package probe
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// WaitPodReady returns a stage polling the named pod until its Ready
+// condition is true, storing the pod as first observed ready in observed.
+// The phases of its startup (see ReadyPhases) are then recorded as child
+// spans of the stage's, with the cluster's timestamps shifted by *skew.
+func WaitPodReady(client kubernetes.Interface, tracer trace.Tracer, namespace, name string, interval time.Duration, skew *time.Duration, observed *corev1.Pod) Stage {
+	return Stage{
+		Name: "wait-ready",
+		Run: func(ctx context.Context) error {
+			err := Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				StatusFromContext(ctx).Observe(string(pod.Status.Phase))
+				if _, ok := conditionTime(pod, corev1.PodReady); !ok {
+					return false, nil
+				}
+				*observed = *pod
+				return true, nil
+			})
+			if err != nil {
+				return err
+			}
+			RecordPhaseSpans(ctx, tracer, ReadyPhases(observed, *skew))
+			return nil
+		},
+	}
+}
+
+// ReadyPhases breaks down the startup of a ready pod into scheduling,
+// container start (see startupPhases) and readiness (the last container
+// starting to the Ready condition, i.e. readiness probes and the kubelet
+// noticing them pass). Timestamps set by the cluster are shifted by skew to
+// the prober's clock; they have a one second precision, so readiness may be
+// rounded down to zero.
+func ReadyPhases(pod *corev1.Pod, skew time.Duration) []results.Phase {
+	phases, started, ok := startupPhases(pod, skew)
+	if !ok {
+		return nil
+	}
+	ready, ok := conditionTime(pod, corev1.PodReady)
+	if !ok {
+		return phases
+	}
+	return append(phases, results.Phase{
+		Name:     "readiness",
+		Start:    started,
+		Duration: max(ready.Add(-skew).Sub(started), 0),
+		Outcome:  results.OutcomeSuccess,
+	})
+}
@@ -0,0 +1,264 @@
+package probe
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// CreatePod returns a stage creating the pod described by opts, storing the
+// created pod in created and an estimate of the API server's clock skew
+// relative to the prober in skew. Its teardown deletes the pod.
+func CreatePod(clients Clients, opts PodOptions, created *corev1.Pod, skew *time.Duration) Stage {
+	return Stage{
+		Name: "create-pod",
+		Run: func(ctx context.Context) error {
+			pod, err := opts.Build()
+			if err != nil {
+				return err
+			}
+			sent := time.Now()
+			pod, err = clients.Measure.CoreV1().Pods(opts.Namespace).Create(ctx, pod, opts.CreateOptions())
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("pods", pod)
+			*created = *pod
+			*skew = EstimateSkew(sent, time.Now(), pod.CreationTimestamp)
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			return DeleteOwned(ctx, "pods", opts.Namespace, opts.Name, metav1.DeleteOptions{}, clients.Cleanup.CoreV1().Pods(opts.Namespace).Delete)
+		},
+	}
+}
+
+// EstimateSkew estimates how far ahead of the local clock the API server's
+// clock is, from a timestamp it set while handling a request sent at sent
+// and answered at received. Server timestamps have a one second precision,
+// so the estimate is only meaningful beyond that.
+func EstimateSkew(sent, received time.Time, server metav1.Time) time.Duration {
+	mid := sent.Add(received.Sub(sent) / 2)
+	// The server's timestamp is truncated, compare it with the middle of its
+	// second.
+	return server.Add(500 * time.Millisecond).Sub(mid)
+}
+
+// WaitPodRunning returns a stage polling the named pod until its containers
+// are all running, storing the pod as first observed running in observed
+// and the time of that observation in observedAt.
+func WaitPodRunning(client kubernetes.Interface, namespace, name string, interval time.Duration, observed *corev1.Pod, observedAt *time.Time) Stage {
+	return Stage{
+		Name: "wait-running",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				now := time.Now()
+				StatusFromContext(ctx).Observe(string(pod.Status.Phase))
+				if _, ok := containersStarted(pod); !ok {
+					return false, nil
+				}
+				*observed = *pod
+				*observedAt = now
+				return true, nil
+			})
+		},
+	}
+}
+
+// WaitPodScheduled returns a stage polling the named pod until its
+// PodScheduled condition is true, i.e. the scheduler bound it to a node,
+// storing the pod as first observed scheduled in observed and the time it
+// was observed in observedAt. A pod the scheduler can't place yet is waited
+// for until the stage times out.
+func WaitPodScheduled(client kubernetes.Interface, namespace, name string, interval time.Duration, observed *corev1.Pod, observedAt *time.Time) Stage {
+	return Stage{
+		Name: "wait-scheduled",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				now := time.Now()
+				StatusFromContext(ctx).Observe(string(pod.Status.Phase))
+				if _, ok := conditionTime(pod, corev1.PodScheduled); !ok {
+					return false, nil
+				}
+				*observed = *pod
+				*observedAt = now
+				return true, nil
+			})
+		},
+	}
+}
+
+// containersStarted returns the time the last of the pod's containers
+// started, if they are all running.
+func containersStarted(pod *corev1.Pod) (time.Time, bool) {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return time.Time{}, false
+	}
+	var last time.Time
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Running == nil {
+			return time.Time{}, false
+		}
+		if t := cs.State.Running.StartedAt.Time; t.After(last) {
+			last = t
+		}
+	}
+	return last, true
+}
+
+// conditionTime returns the time the pod's condition of type t last turned
+// true, if it is true.
+func conditionTime(pod *corev1.Pod, t corev1.PodConditionType) (time.Time, bool) {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == t && c.Status == corev1.ConditionTrue {
+			return c.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// PodEvents returns the key instants of pod reported by the cluster: when it
+// was scheduled, when its last container started and when it became ready,
+// as far as they are known. They have a one second precision.
+func PodEvents(pod *corev1.Pod) []results.Event {
+	var events []results.Event
+	if scheduled, ok := conditionTime(pod, corev1.PodScheduled); ok {
+		events = append(events, results.Event{Name: "scheduled", Time: scheduled})
+	}
+	if started, ok := containersStarted(pod); ok {
+		events = append(events, results.Event{Name: "running", Time: started})
+	}
+	if ready, ok := conditionTime(pod, corev1.PodReady); ok {
+		events = append(events, results.Event{Name: "ready", Time: ready})
+	}
+	return events
+}
+
+// lifecycleEvent is an instant of a pod's lifecycle, as recorded by
+// RecordPodLifecycle.
+type lifecycleEvent struct {
+	name  string
+	time  time.Time
+	attrs []attribute.KeyValue
+}
+
+// RecordPodLifecycle records the lifecycle of pod, as last observed, as
+// events of the span in ctx at the times the cluster reported, shifted by
+// skew to the prober's clock: its creation, the transition to true of each
+// of its conditions, e.g. PodScheduled, Initialized, ContainersReady and
+// Ready, and the start and termination of each of its containers. This gives
+// a breakdown of scheduling and of the kubelet's work within the probe's
+// trace. Cluster timestamps have a one second precision.
+func RecordPodLifecycle(ctx context.Context, pod *corev1.Pod, skew time.Duration) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	events := []lifecycleEvent{{name: "pod.created", time: pod.CreationTimestamp.Time}}
+	for _, c := range pod.Status.Conditions {
+		if c.Status != corev1.ConditionTrue || c.LastTransitionTime.IsZero() {
+			continue
+		}
+		events = append(events, lifecycleEvent{
+			name:  "pod.condition",
+			time:  c.LastTransitionTime.Time,
+			attrs: []attribute.KeyValue{attribute.String("pod.condition.type", string(c.Type))},
+		})
+	}
+	for _, cs := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		attrs := []attribute.KeyValue{
+			attribute.String("container.name", cs.Name),
+			attribute.Int("container.restart_count", int(cs.RestartCount)),
+		}
+		switch {
+		case cs.State.Running != nil:
+			events = append(events, lifecycleEvent{name: "container.started", time: cs.State.Running.StartedAt.Time, attrs: attrs})
+		case cs.State.Terminated != nil:
+			t := cs.State.Terminated
+			events = append(events,
+				lifecycleEvent{name: "container.started", time: t.StartedAt.Time, attrs: attrs},
+				lifecycleEvent{name: "container.terminated", time: t.FinishedAt.Time, attrs: append(slices.Clip(attrs),
+					attribute.Int("container.exit_code", int(t.ExitCode)),
+					attribute.String("container.reason", t.Reason),
+				)},
+			)
+		}
+	}
+
+	slices.SortStableFunc(events, func(a, b lifecycleEvent) int { return a.time.Compare(b.time) })
+	for _, ev := range events {
+		if ev.time.IsZero() {
+			continue
+		}
+		span.AddEvent(ev.name, trace.WithTimestamp(ev.time.Add(-skew)), trace.WithAttributes(ev.attrs...))
+	}
+}
+
+// startupPhases returns the scheduling (creation to the PodScheduled
+// condition) and container start (scheduling to the last container's
+// startedAt, including image pulls) phases of pod, and the time its last
+// container started, all shifted by skew to the prober's clock. It returns
+// false while the containers are not all running.
+func startupPhases(pod *corev1.Pod, skew time.Duration) (phases []results.Phase, started time.Time, ok bool) {
+	started, ok = containersStarted(pod)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	started = started.Add(-skew)
+
+	if scheduled, ok := conditionTime(pod, corev1.PodScheduled); ok {
+		created := pod.CreationTimestamp.Add(-skew)
+		scheduled = scheduled.Add(-skew)
+		phases = append(phases,
+			results.Phase{Name: "scheduling", Start: created, Duration: scheduled.Sub(created), Outcome: results.OutcomeSuccess},
+			results.Phase{Name: "container-start", Start: scheduled, Duration: started.Sub(scheduled), Outcome: results.OutcomeSuccess},
+		)
+	}
+	return phases, started, true
+}
+
+// StatusPhases breaks down the startup of pod, observed running at
+// observedAt, into scheduling, container start (see startupPhases) and
+// status report lag (container start to the prober observing it running
+// through the API, i.e. kubelet status sync, the API server write and the
+// read path). Timestamps set by the cluster are shifted by skew to the
+// prober's clock.
+//
+// A negative status report lag can only be clock skew beyond what skew
+// accounts for; the lag is then left out and skewed is set.
+func StatusPhases(pod *corev1.Pod, observedAt time.Time, skew time.Duration) (phases []results.Phase, skewed bool) {
+	phases, started, ok := startupPhases(pod, skew)
+	if !ok {
+		return nil, false
+	}
+
+	// startedAt is truncated to the second, its true value may be up to a
+	// second later.
+	lag := observedAt.Sub(started)
+	if lag < -time.Second {
+		return phases, true
+	}
+	return append(phases, results.Phase{
+		Name:     "status-report-lag",
+		Start:    started,
+		Duration: max(lag, 0),
+		Outcome:  results.OutcomeSuccess,
+	}), false
+}
@@ -0,0 +1,139 @@
+package probe
+
+import (
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// PollState is the state of a Poller.
+type PollState string
+
+const (
+	// PollFresh is the state right after the change being waited for was
+	// made, when it usually becomes visible: polls are the most frequent.
+	PollFresh PollState = "fresh"
+	// PollWaiting is the state once the change took longer than usual to
+	// become visible, with the API server answering normally.
+	PollWaiting PollState = "waiting"
+	// PollDegraded is the state while the API server answers with errors:
+	// polls back off so as not to add to its load.
+	PollDegraded PollState = "degraded"
+)
+
+// PollIntervals configures a Poller.
+type PollIntervals struct {
+	// Fresh is the interval for the first FreshFor of polling.
+	Fresh    time.Duration
+	FreshFor time.Duration
+	// Waiting is the interval once FreshFor elapsed.
+	Waiting time.Duration
+	// Degraded is the first interval after an error. It doubles with every
+	// consecutive error, up to MaxDegraded.
+	Degraded    time.Duration
+	MaxDegraded time.Duration
+}
+
+// DefaultPollIntervals are the intervals used by the pod probe.
+var DefaultPollIntervals = PollIntervals{
+	Fresh:       25 * time.Millisecond,
+	FreshFor:    time.Second,
+	Waiting:     100 * time.Millisecond,
+	Degraded:    250 * time.Millisecond,
+	MaxDegraded: 5 * time.Second,
+}
+
+// Poller paces a poll loop with a small state machine driven by the result
+// of each attempt, and keeps the timeline of the states it went through so
+// that a slow run can be told apart from a run spent polling a failing API
+// server.
+type Poller struct {
+	intervals PollIntervals
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    PollState
+	since    time.Time
+	start    time.Time
+	backoff  time.Duration
+	timeline []results.PollPeriod
+}
+
+// NewPoller returns a poller in the fresh state.
+func NewPoller(intervals PollIntervals) *Poller {
+	now := time.Now()
+	return &Poller{
+		intervals: intervals,
+		now:       time.Now,
+		state:     PollFresh,
+		since:     now,
+		start:     now,
+	}
+}
+
+// Next records the error of an attempt, nil if it succeeded even though
+// what's waited for was not visible yet, and returns how long to wait before
+// the next attempt. State transitions are recorded as "poll state" events on
+// span.
+func (p *Poller) Next(span trace.Span, err error) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	switch {
+	case err != nil:
+		if p.state != PollDegraded {
+			p.transition(span, PollDegraded, now, attribute.String("error", err.Error()))
+			p.backoff = p.intervals.Degraded
+		} else {
+			p.backoff = min(2*p.backoff, p.intervals.MaxDegraded)
+		}
+		return p.backoff
+	case p.state == PollFresh && now.Sub(p.start) < p.intervals.FreshFor:
+		return p.intervals.Fresh
+	default:
+		if p.state != PollWaiting {
+			p.transition(span, PollWaiting, now)
+		}
+		return p.intervals.Waiting
+	}
+}
+
+// State returns the current state.
+func (p *Poller) State() PollState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// Timeline returns the states the poller went through so far, the current
+// one ending now.
+func (p *Poller) Timeline() []results.PollPeriod {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := append([]results.PollPeriod(nil), p.timeline...)
+	return append(out, results.PollPeriod{
+		State:    string(p.state),
+		Start:    p.since,
+		Duration: p.now().Sub(p.since),
+	})
+}
+
+func (p *Poller) transition(span trace.Span, to PollState, now time.Time, attrs ...attribute.KeyValue) {
+	p.timeline = append(p.timeline, results.PollPeriod{
+		State:    string(p.state),
+		Start:    p.since,
+		Duration: now.Sub(p.since),
+	})
+	span.AddEvent("poll state", trace.WithAttributes(append([]attribute.KeyValue{
+		attribute.String("poll.state.from", string(p.state)),
+		attribute.String("poll.state.to", string(to)),
+		attribute.Int64("poll.state.duration_ms", now.Sub(p.since).Milliseconds()),
+	}, attrs...)...))
+	p.state, p.since = to, now
+}
@@ -0,0 +1,87 @@
+package probe
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// VictimLabel labels the pods the preemption probe has preempted, with the
+// probe's instance ID as value.
+const VictimLabel = "probe-victim"
+
+// WithPriorityClass returns a PodMutator giving the pod the priority of the
+// named PriorityClass.
+func WithPriorityClass(name string) PodMutator {
+	return func(pod *corev1.Pod) error {
+		pod.Spec.PriorityClassName = name
+		return nil
+	}
+}
+
+// NoGracePeriod is a PodMutator killing the pod's containers as soon as it
+// is deleted, without a grace period, so that the scheduler doesn't wait for
+// it when preempting it.
+func NoGracePeriod(pod *corev1.Pod) error {
+	pod.Spec.TerminationGracePeriodSeconds = ptr.To[int64](0)
+	return nil
+}
+
+// AvoidPods returns a PodMutator keeping the pod off the nodes running pods
+// matching selector, through a required pod anti-affinity on their hostname.
+// Given a higher priority than theirs and a node running one of them, the
+// scheduler preempts it to make room for the pod, whatever the node's
+// capacity.
+func AvoidPods(selector map[string]string) PodMutator {
+	return func(pod *corev1.Pod) error {
+		if pod.Spec.Affinity == nil {
+			pod.Spec.Affinity = &corev1.Affinity{}
+		}
+		pod.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: selector},
+				TopologyKey:   corev1.LabelHostname,
+			}},
+		}
+		return nil
+	}
+}
+
+// WaitPodPreempted returns a stage polling the named pod until it is being
+// deleted, or gone, storing the time that was first observed in observedAt.
+// The scheduler sets a DisruptionTarget condition on the pods it preempts
+// before deleting them, preempted is then set when the condition says so.
+func WaitPodPreempted(client kubernetes.Interface, namespace, name string, interval time.Duration, observedAt *time.Time, preempted *bool) Stage {
+	return Stage{
+		Name: "wait-preempted",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+				switch {
+				case apierrors.IsNotFound(err):
+					*observedAt = time.Now()
+					return true, nil
+				case err != nil:
+					return false, err
+				}
+				now := time.Now()
+				StatusFromContext(ctx).Observe(string(pod.Status.Phase))
+				for _, c := range pod.Status.Conditions {
+					if c.Type == corev1.DisruptionTarget && c.Status == corev1.ConditionTrue && c.Reason == corev1.PodReasonPreemptionByScheduler {
+						*preempted = true
+					}
+				}
+				if pod.DeletionTimestamp == nil && !*preempted {
+					return false, nil
+				}
+				*observedAt = now
+				return true, nil
+			})
+		},
+	}
+}
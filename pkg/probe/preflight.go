@@ -0,0 +1,343 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is a single RBAC permission the prober relies on.
+type Permission struct {
+	Group    string
+	Resource string
+	Verb     string
+}
+
+func (p Permission) String() string {
+	if p.Group == "" {
+		return p.Verb + " " + p.Resource
+	}
+	return p.Verb + " " + p.Resource + "." + p.Group
+}
+
+// Permissions lists what a probe kind needs, split between what is needed to
+// take the measurement and what is needed to clean up after it. Missing
+// cleanup permissions don't prevent measuring but guarantee leaked objects
+// whenever a run fails.
+type Permissions struct {
+	Measure []Permission
+	Cleanup []Permission
+}
+
+// ProbePermissions returns the permissions needed by each probe kind. Those
+// of registered probes are the ones they declare, if they implement
+// PermissionedProbe, none otherwise.
+func ProbePermissions(kind string) Permissions {
+	if p, ok := Registered(kind); ok {
+		if pp, ok := p.(PermissionedProbe); ok {
+			return pp.Permissions()
+		}
+		return Permissions{}
+	}
+	switch kind {
+	case "e2e":
+		return Permissions{
+			Measure: []Permission{
+				{Group: "apps", Resource: "deployments", Verb: "create"},
+				{Group: "apps", Resource: "deployments", Verb: "get"},
+				{Resource: "services", Verb: "create"},
+			},
+			Cleanup: []Permission{
+				{Group: "apps", Resource: "deployments", Verb: "delete"},
+				{Group: "apps", Resource: "deployments", Verb: "list"},
+				{Resource: "services", Verb: "delete"},
+				{Resource: "services", Verb: "list"},
+			},
+		}
+	case "configmap", "secret":
+		resource := "configmaps"
+		if kind == "secret" {
+			resource = "secrets"
+		}
+		return Permissions{
+			Measure: []Permission{
+				{Resource: resource, Verb: "create"},
+				{Resource: resource, Verb: "update"},
+			},
+			Cleanup: []Permission{
+				{Resource: resource, Verb: "delete"},
+				{Resource: resource, Verb: "list"},
+			},
+		}
+	case "verbs":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "configmaps", Verb: "create"},
+				{Resource: "configmaps", Verb: "get"},
+				{Resource: "configmaps", Verb: "list"},
+				{Resource: "configmaps", Verb: "patch"},
+				{Resource: "configmaps", Verb: "watch"},
+				{Resource: "configmaps", Verb: "delete"},
+			},
+			Cleanup: []Permission{
+				{Resource: "configmaps", Verb: "delete"},
+				{Resource: "configmaps", Verb: "list"},
+			},
+		}
+	case "reads":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "configmaps", Verb: "create"},
+				{Resource: "configmaps", Verb: "get"},
+				{Resource: "configmaps", Verb: "list"},
+			},
+			Cleanup: []Permission{
+				{Resource: "configmaps", Verb: "delete"},
+				{Resource: "configmaps", Verb: "list"},
+			},
+		}
+	case "namespace-deletion":
+		perms := NamespacePermissions()
+		perms.Measure = append(perms.Measure, Permission{Resource: "configmaps", Verb: "create"})
+		return perms
+	case "rbac-propagation":
+		return Permissions{
+			// Creating a Role needs its permissions
+			Measure: []Permission{
+				{Resource: "serviceaccounts", Verb: "create"},
+				{Group: "rbac.authorization.k8s.io", Resource: "roles", Verb: "create"},
+				{Group: "rbac.authorization.k8s.io", Resource: "rolebindings", Verb: "create"},
+				{Resource: "configmaps", Verb: "get"},
+			},
+			Cleanup: []Permission{
+				{Resource: "serviceaccounts", Verb: "delete"},
+				{Resource: "serviceaccounts", Verb: "list"},
+				{Group: "rbac.authorization.k8s.io", Resource: "roles", Verb: "delete"},
+				{Group: "rbac.authorization.k8s.io", Resource: "roles", Verb: "list"},
+				{Group: "rbac.authorization.k8s.io", Resource: "rolebindings", Verb: "delete"},
+				{Group: "rbac.authorization.k8s.io", Resource: "rolebindings", Verb: "list"},
+			},
+		}
+	case "token-request":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "serviceaccounts", Verb: "create"},
+			},
+			Cleanup: []Permission{
+				{Resource: "serviceaccounts", Verb: "delete"},
+				{Resource: "serviceaccounts", Verb: "list"},
+			},
+		}
+	case "job":
+		return Permissions{
+			Measure: []Permission{
+				{Group: "batch", Resource: "jobs", Verb: "create"},
+				{Group: "batch", Resource: "jobs", Verb: "get"},
+				{Resource: "pods", Verb: "list"},
+				{Resource: "pods", Verb: "get"},
+			},
+			Cleanup: []Permission{
+				{Group: "batch", Resource: "jobs", Verb: "delete"},
+				{Group: "batch", Resource: "jobs", Verb: "list"},
+				{Resource: "pods", Verb: "delete"},
+				{Resource: "pods", Verb: "list"},
+			},
+		}
+	case "pvc":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "persistentvolumeclaims", Verb: "create"},
+				{Resource: "persistentvolumeclaims", Verb: "get"},
+				{Resource: "pods", Verb: "create"},
+				{Resource: "pods", Verb: "get"},
+			},
+			Cleanup: []Permission{
+				{Resource: "persistentvolumeclaims", Verb: "delete"},
+				{Resource: "persistentvolumeclaims", Verb: "list"},
+				{Resource: "pods", Verb: "delete"},
+				{Resource: "pods", Verb: "list"},
+			},
+		}
+	case "dns":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "pods", Verb: "create"},
+				{Resource: "pods", Verb: "get"},
+				{Resource: "services", Verb: "create"},
+			},
+			Cleanup: []Permission{
+				{Resource: "pods", Verb: "delete"},
+				{Resource: "pods", Verb: "list"},
+				{Resource: "services", Verb: "delete"},
+				{Resource: "services", Verb: "list"},
+			},
+		}
+	case "endpoints":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "pods", Verb: "create"},
+				{Resource: "pods", Verb: "get"},
+				{Resource: "services", Verb: "create"},
+				{Group: "discovery.k8s.io", Resource: "endpointslices", Verb: "list"},
+				{Resource: "endpoints", Verb: "get"},
+			},
+			Cleanup: []Permission{
+				{Resource: "pods", Verb: "delete"},
+				{Resource: "pods", Verb: "list"},
+				{Resource: "services", Verb: "delete"},
+				{Resource: "services", Verb: "list"},
+			},
+		}
+	case "configmap-mount":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "configmaps", Verb: "create"},
+				{Resource: "configmaps", Verb: "update"},
+				{Resource: "pods", Verb: "create"},
+				{Resource: "pods", Verb: "get"},
+				{Resource: "pods/log", Verb: "get"},
+			},
+			Cleanup: []Permission{
+				{Resource: "configmaps", Verb: "delete"},
+				{Resource: "configmaps", Verb: "list"},
+				{Resource: "pods", Verb: "delete"},
+				{Resource: "pods", Verb: "list"},
+			},
+		}
+	case "image-pull":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "pods", Verb: "create"},
+				{Resource: "pods", Verb: "get"},
+				{Resource: "events", Verb: "list"},
+			},
+			Cleanup: []Permission{
+				{Resource: "pods", Verb: "delete"},
+				{Resource: "pods", Verb: "list"},
+			},
+		}
+	case "webhook-overhead":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "pods", Verb: "create"},
+			},
+			Cleanup: []Permission{
+				{Resource: "pods", Verb: "delete"},
+				{Resource: "pods", Verb: "list"},
+			},
+		}
+	case "pod-status", "pod-ready", "scheduler", "preemption":
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "pods", Verb: "create"},
+				{Resource: "pods", Verb: "get"},
+			},
+			Cleanup: []Permission{
+				{Resource: "pods", Verb: "delete"},
+				{Resource: "pods", Verb: "list"},
+			},
+		}
+	default:
+		return Permissions{
+			Measure: []Permission{
+				{Resource: "pods", Verb: "create"},
+				{Resource: "pods", Verb: "patch"},
+				{Resource: "pods", Verb: "list"},
+				{Resource: "pods", Verb: "watch"},
+			},
+			Cleanup: []Permission{
+				{Resource: "pods", Verb: "delete"},
+				{Resource: "pods", Verb: "list"},
+			},
+		}
+	}
+}
+
+// ReaperPermissions returns the permissions needed by Reaper.
+func ReaperPermissions() []Permission {
+	perms := make([]Permission, 0, 2*len(ReapedResources))
+	for _, gvr := range ReapedResources {
+		perms = append(perms,
+			Permission{Group: gvr.Group, Resource: gvr.Resource, Verb: "list"},
+			Permission{Group: gvr.Group, Resource: gvr.Resource, Verb: "delete"},
+		)
+	}
+	return perms
+}
+
+// MissingPermissions returns the permissions the current identity is not
+// allowed in namespace, using SelfSubjectAccessReviews.
+func MissingPermissions(ctx context.Context, client kubernetes.Interface, namespace string, perms []Permission) ([]Permission, error) {
+	var missing []Permission
+	for _, p := range perms {
+		review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Group:     p.Group,
+					Resource:  p.Resource,
+					Verb:      p.Verb,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to review permission to %s: %w", p, err)
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// RBACManifest returns a ClusterRole manifest named name granting both the
+// measure and the cleanup permissions of the given probe kinds, and the
+// reaper's.
+func RBACManifest(name string, kinds ...string) string {
+	verbs := map[[2]string]map[string]bool{}
+	all := ReaperPermissions()
+	for _, kind := range kinds {
+		perms := ProbePermissions(kind)
+		all = append(all, perms.Measure...)
+		all = append(all, perms.Cleanup...)
+	}
+	for _, p := range all {
+		key := [2]string{p.Group, p.Resource}
+		if verbs[key] == nil {
+			verbs[key] = map[string]bool{}
+		}
+		verbs[key][p.Verb] = true
+	}
+
+	keys := make([][2]string, 0, len(verbs))
+	for k := range verbs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: %s\nrules:\n", name)
+	for _, k := range keys {
+		vs := make([]string, 0, len(verbs[k]))
+		for v := range verbs[k] {
+			vs = append(vs, v)
+		}
+		sort.Strings(vs)
+
+		fmt.Fprintf(&b, "  - apiGroups:\n      - '%s'\n    resources:\n      - %s\n    verbs:\n", k[0], k[1])
+		for _, v := range vs {
+			fmt.Fprintf(&b, "      - %s\n", v)
+		}
+	}
+	return b.String()
+}
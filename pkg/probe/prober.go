@@ -0,0 +1,571 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"runtime/debug"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+// Result is the result of a probe: its phases, outcome and errors.
+type Result = results.Probe
+
+// Phase is a timed step of a probe.
+type Phase = results.Phase
+
+// The ways the pod probe can detect the label change.
+const (
+	DetectionWatch = "watch"
+	DetectionPoll  = "poll"
+)
+
+// snapshotTimeout bounds the Get of a failed probe's pod for Prober.Snapshot.
+const snapshotTimeout = 5 * time.Second
+
+// Prober runs the pod probe, measuring how long it takes for a label change
+// on a freshly created pod to become visible to a Watch or a List, for
+// programs embedding the probe rather than running the k8s-latency-probe
+// binary. Clients, Namespace and Instance must be set; the other fields are
+// optional.
+type Prober struct {
+	// Clients make the requests of the probe, measured or not.
+	Clients   Clients
+	Namespace string
+	// Instance identifies the probe, in the name and labels of its pod.
+	Instance string
+	// Pod is the probe pod. Its Name defaults to probe-<Instance>, its
+	// Namespace is always Namespace.
+	Pod PodOptions
+	// Detection is how the label change is detected, DetectionWatch, the
+	// default, falling back to polling if the watch fails, or DetectionPoll.
+	Detection string
+	// PollIntervals pace the polls, DefaultPollIntervals if zero.
+	PollIntervals PollIntervals
+	// EventBurst and EventWindow limit the span events recorded for the
+	// watch events and polls, see telemetry.NewEventLimiter. They default to
+	// 10 and 5s.
+	EventBurst  int
+	EventWindow time.Duration
+	// PodEvents records the Events involving the probe pod, e.g. Scheduled,
+	// on the span in the context of Run.
+	PodEvents bool
+	// Pending, if set, samples the pods pending cluster-wide before the probe
+	// pod is created.
+	Pending *PendingSampler
+
+	// Tracer starts the spans of the phases, none if nil.
+	Tracer trace.Tracer
+	// Log, if set, logs the progress of the probe.
+	Log *slog.Logger
+	// Status, if set, reports the progress of the probe as it runs.
+	Status *Status
+	// Observe, if set, is called with every watch event or poll of the wait
+	// for the label change.
+	Observe func(PodObservation)
+	// Snapshot, if set, is called with the final state of the pod of a probe
+	// that didn't succeed, right before it is deleted.
+	Snapshot func(*corev1.Pod)
+	// Now returns the current time, time.Now if nil. The update-pod and
+	// wait-for-pod phases are measured with it.
+	Now func() time.Time
+}
+
+// PodObservation is a single watch event or poll of the pod probe's wait.
+type PodObservation struct {
+	Time            time.Time `json:"time"`
+	Attempt         int       `json:"attempt"`
+	ResourceVersion string    `json:"resource_version"`
+	Visible         bool      `json:"visible"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// PanicError is a panic recovered in a goroutine of a probe, re-raised by
+// Run in its caller's goroutine with the stack of the original panic.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// visibility is the first watch event or List response of the pod probe's
+// wait including the pod, received at the given time.
+type visibility struct {
+	pod *corev1.Pod
+	at  time.Time
+}
+
+// Run runs the probe: it creates the pod, changes its labels, waits for the
+// change to be visible and deletes the pod, unless teardowns are skipped in
+// ctx. The phases are create-pod, update-pod, wait-for-pod and cleanup.
+func (p *Prober) Run(ctx context.Context) Result {
+	p.setDefaults()
+	podResult := Result{
+		Kind:    "pod",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.Namespace,
+			"instance":  p.Instance,
+			"detection": p.Detection,
+		},
+	}
+
+	newPod, err := p.Pod.Build()
+	if err != nil {
+		podResult.Outcome = results.OutcomeError
+		podResult.Errors = append(podResult.Errors, err.Error())
+		return podResult
+	}
+
+	pending, sampled := p.samplePending(ctx, &podResult)
+	recordEvents := p.watchPodEvents(ctx)
+	defer recordEvents(ctx)
+
+	// Create a new pod with a unique name
+	start := time.Now()
+	p.Status.SetPhase("create-pod")
+	createCtx, createPodSpan := p.Tracer.Start(ctx, "prober.create-pod")
+	createCtx, throttle := telemetry.TrackThrottle(createCtx)
+	createCtx, cancelCreate := WithPhaseTimeout(createCtx, ClassCreate)
+	defer cancelCreate()
+	createPodSpan.SetAttributes(
+		attribute.String("instance", p.Instance),
+	)
+	if sampled {
+		createPodSpan.SetAttributes(pending.Attributes()...)
+	}
+
+	pod, err := p.Clients.Measure.CoreV1().Pods(p.Namespace).Create(createCtx, newPod, p.Pod.CreateOptions())
+	err = PhaseTimeout(createCtx, err)
+	throttle.Record(createPodSpan)
+	if err != nil {
+		// Nothing was created, there is nothing to clean up.
+		RecordPhaseTimeout(createPodSpan, err)
+		createPodSpan.RecordError(err)
+		createPodSpan.SetStatus(codes.Error, err.Error())
+		createPodSpan.End()
+		p.Log.ErrorContext(createCtx, "Failed to create pod", "error", err)
+		podResult.Phases = append(podResult.Phases, phaseSince("create-pod", start, OutcomeFor(err)))
+		podResult.Outcome = OutcomeFor(err)
+		podResult.Errors = append(podResult.Errors, fmt.Sprintf("create-pod: %v", err))
+		return podResult
+	}
+
+	LedgerFromContext(ctx).Record("pods", pod)
+	p.Log.InfoContext(createCtx, "Created pod", "pod", pod.Name)
+	createPodSpan.End()
+	podResult.Phases = append(podResult.Phases, phaseSince("create-pod", start, results.OutcomeSuccess))
+	podResult.Attributes["pod"] = pod.Name
+	podResult.AddEvent("created", time.Now())
+
+	// The wait is armed before the label change so that it observes it right
+	// away, but visibility is measured from the moment the Patch returned:
+	// the wait-for-pod phase is the time between that moment and the first
+	// watch event (or List response) including the pod. It can't include the
+	// pod before the change is persisted, but it can arrive before the Patch
+	// returns; the phase is then zero.
+	waitCtx, cancelWait := context.WithCancelCause(ctx)
+	defer cancelWait(nil)
+
+	// Poll fast right after the label change, when it usually becomes
+	// visible, and back off while the API server is erroring.
+	poller := NewPoller(p.PollIntervals)
+	found := make(chan visibility, 1)
+	panicked := make(chan *PanicError, 1)
+	go func(ctx context.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked <- &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+
+		ctx, span := p.Tracer.Start(ctx, "prober.wait-for-pod")
+		defer span.End()
+		span.SetAttributes(attribute.String("wait.detection", p.Detection))
+		ctx, throttle := telemetry.TrackThrottle(ctx)
+		defer throttle.Record(span)
+
+		var v *visibility
+		if p.Detection == DetectionWatch {
+			v = p.watchForPod(ctx, span, pod.ResourceVersion)
+		}
+		if v == nil && ctx.Err() == nil {
+			v = p.pollForPod(ctx, span, poller)
+		}
+		if v == nil {
+			RecordPhaseTimeout(span, context.Cause(ctx))
+			span.SetStatus(codes.Error, context.Cause(ctx).Error())
+			p.Log.InfoContext(ctx, "Context done, no longer waiting for the pod")
+			return
+		}
+		span.AddEvent("Pod found")
+		found <- *v
+		close(found)
+	}(waitCtx)
+
+	// Update the pod's labels
+	start = p.Now()
+	p.Status.SetPhase("update-pod")
+	updateCtx, updatePodSpan := p.Tracer.Start(ctx, "prober.update-pod")
+	updateCtx, throttle = telemetry.TrackThrottle(updateCtx)
+	_, err = p.Clients.Measure.CoreV1().Pods(p.Namespace).Patch(
+		updateCtx,
+		pod.Name,
+		types.MergePatchType,
+		fmt.Appendf(nil, "{\"metadata\":{\"labels\":{\"probe-instance\":\"%s\"}}}", p.Instance),
+		p.Pod.PatchOptions(),
+	)
+	patched := p.Now()
+	throttle.Record(updatePodSpan)
+	if err != nil {
+		// The label will never show up, stop waiting for it right away.
+		cancelWait(nil)
+		updatePodSpan.RecordError(err)
+		updatePodSpan.SetStatus(codes.Error, err.Error())
+		updatePodSpan.End()
+		p.Log.ErrorContext(updateCtx, "Failed to update pod", "pod", pod.Name, "error", err)
+		podResult.Phases = append(podResult.Phases,
+			Phase{Name: "update-pod", Start: start, Duration: patched.Sub(start), Outcome: OutcomeFor(err)},
+			Phase{Name: "wait-for-pod", Start: patched, Outcome: results.OutcomeAborted},
+		)
+		podResult.Outcome = OutcomeFor(err)
+		podResult.Errors = append(podResult.Errors, fmt.Sprintf("update-pod: %v", err))
+		return p.cleanupPod(ctx, podResult, pod)
+	}
+	updatePodSpan.End()
+	p.Status.SetPhase("wait-for-pod")
+	// The detection timeout runs from the moment the Patch returned.
+	detectCtx, cancelDetect := WithPhaseTimeout(ctx, ClassDetect)
+	defer cancelDetect()
+	podResult.Phases = append(podResult.Phases, Phase{
+		Name:     "update-pod",
+		Start:    start,
+		Duration: patched.Sub(start),
+		Outcome:  results.OutcomeSuccess,
+	})
+
+	select {
+	case pe := <-panicked:
+		panic(pe)
+	case v := <-found:
+		observed := v.pod
+		podResult.Phases = append(podResult.Phases, Phase{
+			Name:     "wait-for-pod",
+			Start:    patched,
+			Duration: max(v.at.Sub(patched), 0),
+			Outcome:  results.OutcomeSuccess,
+		})
+		podResult.AddEvent("visible", v.at)
+		for _, ev := range PodEvents(observed) {
+			podResult.AddEvent(ev.Name, ev.Time)
+		}
+		RecordPodLifecycle(ctx, observed, 0)
+
+		if observed.Spec.NodeName != "" {
+			attrs, err := NodeAttributes(ctx, p.Clients.Cleanup, observed.Spec.NodeName)
+			if err != nil {
+				p.Log.WarnContext(ctx, "Failed to get node, only recording its name", "node", observed.Spec.NodeName, "error", err)
+			}
+			maps.Copy(podResult.Attributes, attrs)
+		}
+	case <-detectCtx.Done():
+		err := PhaseTimeout(detectCtx, detectCtx.Err())
+		// Fail the wait's span with the timeout it ran out of.
+		cancelWait(err)
+		p.Log.WarnContext(ctx, "Context done, cleaning up", "error", err)
+		podResult.Phases = append(podResult.Phases, Phase{
+			Name:     "wait-for-pod",
+			Start:    patched,
+			Duration: p.Now().Sub(patched),
+			Outcome:  results.OutcomeTimeout,
+		})
+		podResult.Outcome = results.OutcomeTimeout
+		podResult.Errors = append(podResult.Errors, err.Error())
+		podResult.PollTimeline = poller.Timeline()
+	}
+
+	return p.cleanupPod(ctx, podResult, pod)
+}
+
+// setDefaults fills in the optional fields left unset.
+func (p *Prober) setDefaults() {
+	if p.Pod.Name == "" {
+		p.Pod.Name = fmt.Sprintf("probe-%s", p.Instance)
+	}
+	p.Pod.Namespace = p.Namespace
+	if p.Detection == "" {
+		p.Detection = DetectionWatch
+	}
+	if p.PollIntervals == (PollIntervals{}) {
+		p.PollIntervals = DefaultPollIntervals
+	}
+	if p.EventBurst == 0 {
+		p.EventBurst = 10
+	}
+	if p.EventWindow == 0 {
+		p.EventWindow = 5 * time.Second
+	}
+	if p.Tracer == nil {
+		p.Tracer = noop.NewTracerProvider().Tracer("")
+	}
+	if p.Log == nil {
+		p.Log = slog.New(slog.DiscardHandler)
+	}
+	if p.Now == nil {
+		p.Now = time.Now
+	}
+}
+
+// instanceSelector returns the label selector matching the probe pod once its
+// labels were updated.
+func (p *Prober) instanceSelector() string {
+	return fmt.Sprintf("probe-instance=%s", p.Instance)
+}
+
+// observe reports an observation of the wait to Observe and Status.
+func (p *Prober) observe(obs PodObservation, status string) {
+	if p.Observe != nil {
+		p.Observe(obs)
+	}
+	p.Status.Observe(status)
+}
+
+// watchForPod watches the pods matching the instance selector, starting at
+// resourceVersion so that no change made since is missed, and returns when
+// the first event including the probe pod arrives. It returns nil when ctx
+// is done, or when the watch failed for good and the caller should fall back
+// to polling.
+func (p *Prober) watchForPod(ctx context.Context, span trace.Span, resourceVersion string) *visibility {
+	pods := p.Clients.Measure.CoreV1().Pods(p.Namespace)
+	w, err := watchtools.NewRetryWatcher(resourceVersion, &cache.ListWatch{
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.LabelSelector = p.instanceSelector()
+			return pods.Watch(ctx, opts)
+		},
+	})
+	if err != nil {
+		p.Log.WarnContext(ctx, "Failed to watch pods, falling back to polling", "error", err)
+		span.AddEvent("watch_fallback", trace.WithAttributes(attribute.String("error", err.Error())))
+		return nil
+	}
+	defer w.Stop()
+
+	events := telemetry.NewEventLimiter("watch events", p.EventBurst, p.EventWindow)
+	defer events.Flush(span)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.ResultChan():
+			received := p.Now()
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				p.Log.WarnContext(ctx, "Watch closed, falling back to polling")
+				span.AddEvent("watch_fallback")
+				return nil
+			}
+			if ev.Type == watch.Error {
+				err := apierrors.FromObject(ev.Object)
+				p.observe(PodObservation{
+					Time:    received,
+					Attempt: events.Count() + 1,
+					Error:   err.Error(),
+				}, err.Error())
+				p.Log.WarnContext(ctx, "Watch failed, falling back to polling", "error", err)
+				span.AddEvent("watch_fallback", trace.WithAttributes(attribute.String("error", err.Error())))
+				return nil
+			}
+
+			observed, ok := ev.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			visible := ev.Type != watch.Deleted && observed.Labels["probe-instance"] == p.Instance
+			p.observe(PodObservation{
+				Time:            received,
+				Attempt:         events.Count() + 1,
+				ResourceVersion: observed.ResourceVersion,
+				Visible:         visible,
+			}, fmt.Sprintf("event=%s rv=%s visible=%t", ev.Type, observed.ResourceVersion, visible))
+			events.Record(span,
+				attribute.String("watch.event_type", string(ev.Type)),
+				attribute.String("watch.resource_version", observed.ResourceVersion),
+				attribute.Bool("watch.visible", visible),
+			)
+			if visible {
+				return &visibility{pod: observed, at: received}
+			}
+		}
+	}
+}
+
+// pollForPod lists the pods matching the instance selector until the probe
+// pod is included, and returns when it is. It returns nil when ctx is done.
+func (p *Prober) pollForPod(ctx context.Context, span trace.Span, poller *Poller) *visibility {
+	polls := telemetry.NewEventLimiter("poll attempts", p.EventBurst, p.EventWindow)
+	defer polls.Flush(span)
+
+	for {
+		pods, err := p.Clients.Measure.CoreV1().Pods(p.Namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: p.instanceSelector(),
+		})
+		listed := p.Now()
+		if err != nil {
+			// The API server erroring is not the pod being invisible,
+			// keep polling, backing off, until the deadline.
+			p.observe(PodObservation{
+				Time:    listed,
+				Attempt: polls.Count() + 1,
+				Error:   err.Error(),
+			}, err.Error())
+			polls.Record(span,
+				attribute.Int("poll.attempt", polls.Count()+1),
+				attribute.String("poll.state", string(poller.State())),
+				attribute.String("error", err.Error()),
+			)
+		} else {
+			p.observe(PodObservation{
+				Time:            listed,
+				Attempt:         polls.Count() + 1,
+				ResourceVersion: pods.ResourceVersion,
+				Visible:         len(pods.Items) > 0,
+			}, fmt.Sprintf("rv=%s visible=%t", pods.ResourceVersion, len(pods.Items) > 0))
+			polls.Record(span,
+				attribute.Int("poll.attempt", polls.Count()+1),
+				attribute.String("poll.state", string(poller.State())),
+				attribute.String("poll.resource_version", pods.ResourceVersion),
+				attribute.Bool("poll.visible", len(pods.Items) > 0),
+			)
+
+			if len(pods.Items) > 0 {
+				return &visibility{pod: &pods.Items[0], at: listed}
+			}
+		}
+
+		timer := time.NewTimer(poller.Next(span, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// cleanupPod deletes the probe pod, unless teardowns are skipped, and records
+// the cleanup phase in podResult, after snapshotting the pod for failed
+// probes.
+func (p *Prober) cleanupPod(ctx context.Context, podResult Result, pod *corev1.Pod) Result {
+	if p.Snapshot != nil && podResult.Outcome != results.OutcomeSuccess {
+		sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), snapshotTimeout)
+		final, err := p.Clients.Cleanup.CoreV1().Pods(p.Namespace).Get(sctx, pod.Name, metav1.GetOptions{})
+		cancel()
+		if err == nil {
+			p.Snapshot(final)
+		}
+	}
+
+	if TeardownSkipped(ctx) {
+		p.Log.InfoContext(ctx, "Leaving pod behind", "pod", pod.Name)
+		return podResult
+	}
+
+	start := time.Now()
+	p.Status.SetPhase("cleanup")
+	// Like teardowns, the cleanup runs even if the run timed out, bounded by
+	// the delete timeout.
+	cleanupCtx, cleanupSpan := p.Tracer.Start(context.WithoutCancel(ctx), "prober.cleanup")
+	cleanupCtx, throttle := telemetry.TrackThrottle(cleanupCtx)
+	cleanupCtx, cancel := WithPhaseTimeout(cleanupCtx, ClassDelete)
+	defer cancel()
+
+	err := DeleteOwned(cleanupCtx, "pods", p.Namespace, pod.Name, metav1.DeleteOptions{}, p.Clients.Cleanup.CoreV1().Pods(p.Namespace).Delete)
+	err = PhaseTimeout(cleanupCtx, err)
+	RecordPhaseTimeout(cleanupSpan, err)
+	cleanupOutcome := results.OutcomeSuccess
+	switch {
+	case IsUIDMismatch(err):
+		// Someone else's pod, it's an anomaly worth reporting but not one
+		// to clean up.
+		p.Log.ErrorContext(cleanupCtx, "Anomaly", "error", err)
+		cleanupSpan.RecordError(err)
+		cleanupSpan.SetStatus(codes.Error, err.Error())
+		podResult.Errors = append(podResult.Errors, err.Error())
+		cleanupOutcome = results.OutcomeError
+	case err != nil:
+		// The pod is left behind for the reaper, the run failed.
+		p.Log.ErrorContext(cleanupCtx, "Failed to delete pod", "pod", pod.Name, "error", err)
+		cleanupSpan.RecordError(err)
+		cleanupSpan.SetStatus(codes.Error, err.Error())
+		podResult.Errors = append(podResult.Errors, fmt.Sprintf("cleanup: %v", err))
+		cleanupOutcome = OutcomeFor(err)
+		if podResult.Outcome == results.OutcomeSuccess {
+			podResult.Outcome = cleanupOutcome
+		}
+	default:
+		p.Log.InfoContext(cleanupCtx, "Deleted pod", "pod", pod.Name)
+		podResult.AddEvent("deleted", time.Now())
+	}
+	throttle.Record(cleanupSpan)
+	cleanupSpan.End()
+	podResult.Phases = append(podResult.Phases, phaseSince("cleanup", start, cleanupOutcome))
+
+	return podResult
+}
+
+// samplePending samples the number of pods pending cluster-wide, if enabled,
+// and records it in result's attributes.
+func (p *Prober) samplePending(ctx context.Context, result *Result) (PendingPods, bool) {
+	pending, ok, err := p.Pending.Sample(ctx)
+	if err != nil {
+		p.Log.WarnContext(ctx, "Failed to sample pending pods", "error", err)
+	}
+	if ok {
+		result.Attributes[AttrPendingPods] = pending.String()
+	}
+	return pending, ok
+}
+
+// watchPodEvents starts watching the Events involving the probe pod, if
+// PodEvents is set. The returned function stops watching and records them
+// on the span in ctx.
+func (p *Prober) watchPodEvents(ctx context.Context) func(context.Context) {
+	if !p.PodEvents {
+		return func(context.Context) {}
+	}
+	w := WatchEvents(ctx, p.Clients.Cleanup, p.Namespace, "Pod", p.Pod.Name)
+	return func(ctx context.Context) {
+		events, err := w.Stop()
+		if err != nil {
+			p.Log.WarnContext(ctx, "Failed to watch the probe pod's Events", "pod", p.Pod.Name, "error", err)
+		}
+		RecordEvents(ctx, events, 0)
+	}
+}
+
+// phaseSince returns the phase name that started at start and ends now.
+func phaseSince(name string, start time.Time, outcome results.Outcome) Phase {
+	return Phase{Name: name, Start: start, Duration: time.Since(start), Outcome: outcome}
+}
@@ -0,0 +1,185 @@
+package probe
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// fakeClock is a clock only moved by the test, sending every time it is
+// read on reads.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	reads chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 10, 15, 2, 0, 0, 0, time.UTC), reads: make(chan time.Time, 16)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	now := c.now
+	c.mu.Unlock()
+	c.reads <- now
+	return now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newVisibilityProber returns a pod prober whose watch only sees the events
+// sent on the returned watcher, and the fake API server it probes.
+func newVisibilityProber(clock *fakeClock) (*Prober, *fake.Clientset, *watch.FakeWatcher) {
+	client := fake.NewClientset()
+	// The watch starts at the created pod's resource version
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).ResourceVersion = "1"
+		return false, nil, nil
+	})
+	watcher := watch.NewFakeWithChanSize(1, false)
+	client.PrependWatchReactor("pods", func(k8stesting.Action) (bool, watch.Interface, error) {
+		return true, watcher, nil
+	})
+	p := &Prober{
+		Clients:   SingleClient(client),
+		Namespace: "probes",
+		Instance:  "abc",
+		Pod:       PodOptions{Image: "busybox"},
+		Now:       clock.Now,
+	}
+	return p, client, watcher
+}
+
+// labeledPod is the probe pod as the watch sees it once its labels changed.
+func labeledPod() *corev1.Pod {
+	pod := testPod("probe-abc", "uid-1")
+	pod.ResourceVersion = "2"
+	pod.Labels = map[string]string{"probe-instance": "abc"}
+	return pod
+}
+
+// phase returns the named phase of result.
+func phase(t *testing.T, result Result, name string) Phase {
+	t.Helper()
+	for _, ph := range result.Phases {
+		if ph.Name == name {
+			return ph
+		}
+	}
+	t.Fatalf("no %s phase in %+v", name, result.Phases)
+	return Phase{}
+}
+
+func TestProberVisibility(t *testing.T) {
+	clock := newFakeClock()
+	p, client, watcher := newVisibilityProber(clock)
+	client.PrependReactor("patch", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		clock.Advance(time.Second)
+		return false, nil, nil
+	})
+
+	done := make(chan Result)
+	go func() { done <- p.Run(context.Background()) }()
+	// The update-pod phase starts, then the Patch returns a second later
+	<-clock.reads
+	patched := <-clock.reads
+	// The change shows up on the watch 2 seconds after that
+	clock.Advance(2 * time.Second)
+	watcher.Modify(labeledPod())
+	result := <-done
+
+	if result.Outcome != results.OutcomeSuccess {
+		t.Fatalf("outcome = %s, want success: %v", result.Outcome, result.Errors)
+	}
+	if ph := phase(t, result, "update-pod"); ph.Duration != time.Second {
+		t.Errorf("update-pod = %s, want 1s", ph.Duration)
+	}
+	wait := phase(t, result, "wait-for-pod")
+	if !wait.Start.Equal(patched) || wait.Duration != 2*time.Second {
+		t.Errorf("wait-for-pod = %s from %s, want 2s from the Patch returning at %s", wait.Duration, wait.Start, patched)
+	}
+}
+
+func TestProberVisibleBeforePatchReturns(t *testing.T) {
+	clock := newFakeClock()
+	p, client, watcher := newVisibilityProber(clock)
+	// Reactors run one at a time: the Patch's can only block on the watch
+	// once it is established
+	watching := make(chan struct{})
+	var once sync.Once
+	client.PrependWatchReactor("pods", func(k8stesting.Action) (bool, watch.Interface, error) {
+		once.Do(func() { close(watching) })
+		return false, nil, nil
+	})
+	p.Now = func() time.Time {
+		<-watching
+		return clock.Now()
+	}
+	client.PrependReactor("patch", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		// The change is persisted and seen by the watch before the Patch's
+		// response makes it back
+		<-clock.reads
+		clock.Advance(time.Second)
+		watcher.Modify(labeledPod())
+		<-clock.reads
+		clock.Advance(500 * time.Millisecond)
+		return false, nil, nil
+	})
+
+	result := p.Run(context.Background())
+
+	if result.Outcome != results.OutcomeSuccess {
+		t.Fatalf("outcome = %s, want success: %v", result.Outcome, result.Errors)
+	}
+	if ph := phase(t, result, "wait-for-pod"); ph.Duration != 0 || ph.Outcome != results.OutcomeSuccess {
+		t.Errorf("wait-for-pod = %s %s, want a successful phase clamped at zero", ph.Duration, ph.Outcome)
+	}
+}
+
+func TestProberPatchFailed(t *testing.T) {
+	clock := newFakeClock()
+	p, client, _ := newVisibilityProber(clock)
+	client.PrependReactor("patch", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		clock.Advance(time.Second)
+		return true, nil, apierrors.NewInternalError(context.DeadlineExceeded)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	result := p.Run(ctx)
+	// Nothing is sent on the watch: the wait is abandoned rather than
+	// running until the deadline
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() took %s, want the wait abandoned right away", elapsed)
+	}
+
+	if result.Outcome != results.OutcomeError {
+		t.Errorf("outcome = %s, want the Patch's error", result.Outcome)
+	}
+	if ph := phase(t, result, "update-pod"); ph.Duration != time.Second || ph.Outcome != results.OutcomeError {
+		t.Errorf("update-pod = %s %s, want a failed 1s phase", ph.Duration, ph.Outcome)
+	}
+	if ph := phase(t, result, "wait-for-pod"); ph.Outcome != results.OutcomeAborted || ph.Duration != 0 {
+		t.Errorf("wait-for-pod = %s %s, want aborted", ph.Duration, ph.Outcome)
+	}
+	if _, err := client.CoreV1().Pods("probes").Get(ctx, "probe-abc", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Get() of the probe pod error = %v, want it deleted", err)
+	}
+}
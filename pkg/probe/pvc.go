@@ -0,0 +1,117 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PVCOptions describes a PersistentVolumeClaim dynamically provisioned by a
+// StorageClass.
+type PVCOptions struct {
+	Name      string
+	Namespace string
+	Labels    map[string]string
+
+	// Annotations are set on the claim.
+	Annotations map[string]string
+
+	// StorageClass provisions the volume. The default leaves it to the
+	// cluster's default StorageClass.
+	StorageClass string
+	Size         resource.Quantity
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+func (o PVCOptions) build() *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        o.Name,
+			Namespace:   o.Namespace,
+			Labels:      o.Labels,
+			Annotations: o.Annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: o.Size},
+			},
+		},
+	}
+	if o.StorageClass != "" {
+		pvc.Spec.StorageClassName = &o.StorageClass
+	}
+	return pvc
+}
+
+// CreatePVC returns a stage creating the claim. Its teardown deletes it,
+// leaving the volume to its reclaim policy.
+func CreatePVC(clients Clients, opts PVCOptions) Stage {
+	return Stage{
+		Name: "create-pvc",
+		Run: func(ctx context.Context) error {
+			pvc, err := clients.Measure.CoreV1().PersistentVolumeClaims(opts.Namespace).Create(ctx, opts.build(), metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("persistentvolumeclaims", pvc)
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			return DeleteOwned(ctx, "persistentvolumeclaims", opts.Namespace, opts.Name, metav1.DeleteOptions{}, clients.Cleanup.CoreV1().PersistentVolumeClaims(opts.Namespace).Delete)
+		},
+	}
+}
+
+// WaitPVCBound returns a stage polling the named claim until it is bound to
+// a volume.
+func WaitPVCBound(client kubernetes.Interface, namespace, name string, interval time.Duration) Stage {
+	return Stage{
+		Name: "wait-bound",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				StatusFromContext(ctx).Observe(string(pvc.Status.Phase))
+				switch pvc.Status.Phase {
+				case corev1.ClaimBound:
+					return true, nil
+				case corev1.ClaimLost:
+					return false, fmt.Errorf("claim %s lost its volume", name)
+				default:
+					return false, nil
+				}
+			})
+		},
+	}
+}
+
+// MountPVC returns a mutator mounting the named claim in the probe pod's
+// first container, so that the pod only runs once the volume is attached
+// and mounted. With a WaitForFirstConsumer StorageClass, the pod is also
+// what gets the volume provisioned.
+func MountPVC(claim string) PodMutator {
+	return func(pod *corev1.Pod) error {
+		if len(pod.Spec.Containers) == 0 {
+			return fmt.Errorf("pod has no container")
+		}
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "probe-data",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			},
+		})
+		c := &pod.Spec.Containers[0]
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: "probe-data", MountPath: "/data"})
+		return nil
+	}
+}
@@ -0,0 +1,156 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Ways of checking that a permission propagated, see RBACPropagation.
+const (
+	// RBACCheckReview asks the API server with a SelfSubjectAccessReview.
+	RBACCheckReview = "review"
+	// RBACCheckRequest makes the request the permission allows.
+	RBACCheckRequest = "request"
+)
+
+// RBACRule returns the rule of the Role of the rbac-propagation probe of
+// opts: getting a ConfigMap named after it, which never exists, so that no
+// other permission grants it and checking it reads nothing.
+func RBACRule(opts IdentityOptions) rbacv1.PolicyRule {
+	return rbacv1.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"configmaps"},
+		ResourceNames: []string{opts.Name},
+		Verbs:         []string{"get"},
+	}
+}
+
+// CreateRole returns a stage creating a Role named after the ServiceAccount
+// of opts, granting rules. Its teardown deletes it.
+func CreateRole(clients Clients, opts IdentityOptions, rules []rbacv1.PolicyRule) Stage {
+	roles := clients.Cleanup.RbacV1().Roles(opts.Namespace)
+	return Stage{
+		Name: "create-role",
+		Run: func(ctx context.Context) error {
+			role, err := clients.Measure.RbacV1().Roles(opts.Namespace).Create(ctx, &rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        opts.Name,
+					Namespace:   opts.Namespace,
+					Labels:      opts.Labels,
+					Annotations: opts.Annotations,
+				},
+				Rules: rules,
+			}, metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("roles", role)
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			return DeleteOwned(ctx, "roles", opts.Namespace, opts.Name, metav1.DeleteOptions{}, roles.Delete)
+		},
+	}
+}
+
+// RoleTemplate returns a RoleBinding template granting the named Role.
+func RoleTemplate(role string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role,
+		},
+	}
+}
+
+// reviewRule asks whether the identity of client is allowed rule in
+// namespace with a SelfSubjectAccessReview, which every authenticated
+// identity may create.
+func reviewRule(ctx context.Context, client kubernetes.Interface, namespace string, rule rbacv1.PolicyRule) (*authorizationv1.SelfSubjectAccessReview, error) {
+	return client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Resource:  rule.Resources[0],
+				Name:      rule.ResourceNames[0],
+				Verb:      rule.Verbs[0],
+			},
+		},
+	}, metav1.CreateOptions{})
+}
+
+// WaitAuthenticated returns a stage reviewing rule with token, as stored by
+// RequestToken, until the token is accepted, so that its authentication
+// doesn't count in the propagation of its permissions.
+func WaitAuthenticated(config *rest.Config, token *string, namespace string, rule rbacv1.PolicyRule, interval time.Duration) Stage {
+	return Stage{
+		Name: "wait-authenticated",
+		Run: func(ctx context.Context) error {
+			client, err := TokenClient(config, *token)
+			if err != nil {
+				return err
+			}
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				_, err := reviewRule(ctx, client, namespace, rule)
+				if apierrors.IsUnauthorized(err) {
+					StatusFromContext(ctx).Observe(err.Error())
+					return false, nil
+				}
+				return err == nil, err
+			})
+		},
+	}
+}
+
+// RBACPropagation returns a stage checking, with token, that its identity is
+// allowed rule in namespace, until it is: the RoleBinding granting it was
+// created, the stage measures how long the authorizers of the API server take
+// to observe it. check is RBACCheckReview or RBACCheckRequest.
+func RBACPropagation(config *rest.Config, token *string, namespace string, rule rbacv1.PolicyRule, check string, interval time.Duration) Stage {
+	allowed := func(ctx context.Context, client kubernetes.Interface) (bool, error) {
+		review, err := reviewRule(ctx, client, namespace, rule)
+		if err != nil {
+			return false, err
+		}
+		if !review.Status.Allowed {
+			StatusFromContext(ctx).Observe(fmt.Sprintf("denied: %s", review.Status.Reason))
+		}
+		return review.Status.Allowed, nil
+	}
+	if check == RBACCheckRequest {
+		allowed = func(ctx context.Context, client kubernetes.Interface) (bool, error) {
+			_, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, rule.ResourceNames[0], metav1.GetOptions{})
+			switch {
+			case err == nil, apierrors.IsNotFound(err):
+				// Authorized, the ConfigMap never existing
+				return true, nil
+			case apierrors.IsForbidden(err):
+				StatusFromContext(ctx).Observe(err.Error())
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+	}
+	return Stage{
+		Name: "rbac-propagation",
+		Run: func(ctx context.Context) error {
+			client, err := TokenClient(config, *token)
+			if err != nil {
+				return err
+			}
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				return allowed(ctx, client)
+			})
+		},
+	}
+}
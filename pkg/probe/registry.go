@@ -0,0 +1,203 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// Probe is a custom kind of probe, compiled into the binary and selected with
+// --probe=<Name>, e.g. to probe a company-specific CRD. The runner wraps it
+// with the same tracing, metrics, results and reporting as the built-in
+// kinds: Setup, Run and Teardown are each run under their own span and
+// recorded as phases.
+//
+// A new Probe is made for every probe of a run, so that it can keep what
+// Setup creates for Run and Teardown in its fields.
+type Probe interface {
+	// Name is the kind of the probe, unique among the registered ones.
+	Name() string
+	// Setup prepares what Run measures, e.g. the objects it depends on. An
+	// error fails the probe without running Run.
+	Setup(ctx context.Context, env Env) error
+	// Run takes the measurement and returns its phases, which must be
+	// timed by Run, e.g. with RunStages. Without phases, the whole of Run
+	// is recorded as a phase named "run". An error fails the probe, its
+	// outcome derived with OutcomeFor.
+	Run(ctx context.Context, env Env) ([]Phase, error)
+	// Teardown deletes what Setup and Run created. It runs once Setup
+	// succeeded, even if Run failed or the run timed out, unless teardowns
+	// are skipped.
+	Teardown(ctx context.Context, env Env) error
+}
+
+// PermissionedProbe is a Probe declaring the permissions it needs, checked
+// before the first run and included in the
+// ClusterRole printed by --print-rbac.
+type PermissionedProbe interface {
+	Probe
+	Permissions() Permissions
+}
+
+// Env is what a Probe is given to run in.
+type Env struct {
+	Clients   Clients
+	Namespace string
+	// Instance identifies the probe, unique across runs, to name what it
+	// creates.
+	Instance string
+	// Labels and Annotations must be set on every object the probe
+	// creates, so that the reaper deletes them if the run fails to, and
+	// OwnerReferences in operator mode.
+	Labels          map[string]string
+	Annotations     map[string]string
+	OwnerReferences []metav1.OwnerReference
+	Tracer          trace.Tracer
+	Log             *slog.Logger
+	// Status, if set, reports the progress of the probe as it runs.
+	Status *Status
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]func() Probe{}
+)
+
+// Register makes a kind of probe available, by the Name of the probes
+// newProbe returns. It is meant to be called from the init function of the
+// package implementing the probe, imported for its side effects by the
+// binary, and panics if the name is empty or already registered.
+func Register(newProbe func() Probe) {
+	name := newProbe().Name()
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if name == "" {
+		panic("probe: Register of a probe without a name")
+	}
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("probe: Register called twice for probe %q", name))
+	}
+	registry[name] = newProbe
+}
+
+// Registered returns a new probe of the registered kind name, if any.
+func Registered(name string) (Probe, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	newProbe, ok := registry[name]
+	if !ok {
+		return nil, false
+	}
+	return newProbe(), true
+}
+
+// RegisteredNames returns the names of the registered kinds of probes, in
+// order.
+func RegisteredNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// RunProbe runs p: Setup, Run, then Teardown, unless teardowns are skipped in
+// ctx, and returns its result with a setup phase, the phases of Run and a
+// teardown phase.
+func RunProbe(ctx context.Context, p Probe, env Env) Result {
+	if env.Tracer == nil {
+		env.Tracer = noop.NewTracerProvider().Tracer("")
+	}
+	if env.Log == nil {
+		env.Log = slog.New(slog.DiscardHandler)
+	}
+	result := Result{
+		Kind:    p.Name(),
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": env.Namespace,
+			"instance":  env.Instance,
+		},
+	}
+
+	env.Status.SetPhase("setup")
+	setup, err := runTimed(ctx, env.Tracer, "setup", func(ctx context.Context) error {
+		ctx, cancel := WithPhaseTimeout(ctx, ClassCreate)
+		defer cancel()
+		return PhaseTimeout(ctx, p.Setup(ctx, env))
+	})
+	result.Phases = append(result.Phases, setup)
+	if err != nil {
+		env.Log.ErrorContext(ctx, "Failed to set up probe", "error", err)
+		result = failProbe(result, "setup", err)
+		// Setup may have created some of what it needed before failing
+		return teardownProbe(ctx, p, env, result)
+	}
+
+	env.Status.SetPhase("run")
+	start := time.Now()
+	runCtx, span := env.Tracer.Start(ctx, "prober.run")
+	span.SetAttributes(attribute.String("instance", env.Instance))
+	phases, err := p.Run(runCtx, env)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	if len(phases) == 0 {
+		phases = []Phase{phaseSince("run", start, OutcomeFor(err))}
+	}
+	result.Phases = append(result.Phases, phases...)
+	if err != nil {
+		env.Log.ErrorContext(ctx, "Probe failed", "error", err)
+		result = failProbe(result, "run", err)
+	}
+	return teardownProbe(ctx, p, env, result)
+}
+
+// teardownProbe runs the Teardown of p, unless teardowns are skipped, and
+// records it as a phase of result.
+func teardownProbe(ctx context.Context, p Probe, env Env, result Result) Result {
+	if TeardownSkipped(ctx) {
+		env.Log.InfoContext(ctx, "Leaving the probe's objects behind")
+		return result
+	}
+	env.Status.SetPhase("teardown")
+	// Like the built-in probes' cleanups, the teardown runs even if the
+	// run timed out, bounded by the delete timeout.
+	teardown, err := runTimed(context.WithoutCancel(ctx), env.Tracer, "teardown", func(ctx context.Context) error {
+		ctx, cancel := WithPhaseTimeout(ctx, ClassDelete)
+		defer cancel()
+		return PhaseTimeout(ctx, p.Teardown(ctx, env))
+	})
+	result.Phases = append(result.Phases, teardown)
+	if err != nil {
+		env.Log.ErrorContext(ctx, "Failed to tear down probe", "error", err)
+		result = failProbe(result, "teardown", err)
+	}
+	return result
+}
+
+// failProbe records the error of the named step in result, whose outcome is
+// that of the first error.
+func failProbe(result Result, name string, err error) Result {
+	result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", name, err))
+	if result.Outcome == results.OutcomeSuccess {
+		result.Outcome = OutcomeFor(err)
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ServiceOptions describes a ClusterIP Service exposing probe pods.
+type ServiceOptions struct {
+	Name      string
+	Namespace string
+	Labels    map[string]string
+	Selector  map[string]string
+
+	// Annotations are set on the Service.
+	Annotations map[string]string
+
+	Port       int32
+	TargetPort int32
+
+	// IPFamily selects the families the Service gets cluster IPs for. The
+	// default leaves it to the cluster's primary family.
+	IPFamily IPFamily
+
+	// Headless creates the Service without a cluster IP, so that its DNS
+	// records resolve to the IPs of the pods it selects.
+	Headless bool
+
+	// TrafficPolicy is the Service's internal traffic policy. The default
+	// leaves the cluster's default, Cluster.
+	TrafficPolicy corev1.ServiceInternalTrafficPolicy
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+// Host returns the in-cluster DNS name of the Service, relative to the
+// cluster domain.
+func (o ServiceOptions) Host() string {
+	return fmt.Sprintf("%s.%s.svc", o.Name, o.Namespace)
+}
+
+// URL returns the in-cluster HTTP URL of the Service.
+func (o ServiceOptions) URL() string {
+	return fmt.Sprintf("http://%s:%d/", o.Host(), o.Port)
+}
+
+// CreateService returns a stage creating the Service. It fails with a
+// FamilyError if the Service did not get a cluster IP, or for a headless
+// Service an IP family, of every requested family. Its teardown deletes it.
+func CreateService(clients Clients, opts ServiceOptions) Stage {
+	spec := corev1.ServiceSpec{
+		Selector: opts.Selector,
+		Ports: []corev1.ServicePort{
+			{
+				Name:       "http",
+				Port:       opts.Port,
+				TargetPort: intstr.FromInt32(opts.TargetPort),
+			},
+		},
+	}
+	if opts.Headless {
+		spec.ClusterIP = corev1.ClusterIPNone
+	}
+	if opts.TrafficPolicy != "" {
+		spec.InternalTrafficPolicy = &opts.TrafficPolicy
+	}
+	switch opts.IPFamily {
+	case IPFamilyIPv4, IPFamilyIPv6:
+		policy := corev1.IPFamilyPolicySingleStack
+		spec.IPFamilyPolicy = &policy
+		spec.IPFamilies = opts.IPFamily.Families()
+	case IPFamilyDual:
+		policy := corev1.IPFamilyPolicyPreferDualStack
+		spec.IPFamilyPolicy = &policy
+	}
+
+	return Stage{
+		Name: "create-service",
+		Run: func(ctx context.Context) error {
+			svc, err := clients.Measure.CoreV1().Services(opts.Namespace).Create(ctx, &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        opts.Name,
+					Namespace:   opts.Namespace,
+					Labels:      opts.Labels,
+					Annotations: opts.Annotations,
+				},
+				Spec: spec,
+			}, metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("services", svc)
+			return checkFamilies(svc, opts.IPFamily.Families())
+		},
+		Teardown: func(ctx context.Context) error {
+			return DeleteOwned(ctx, "services", opts.Namespace, opts.Name, metav1.DeleteOptions{}, clients.Cleanup.CoreV1().Services(opts.Namespace).Delete)
+		},
+	}
+}
@@ -0,0 +1,367 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// SLO is a service level objective over the prober's own results: the
+// percentage of the attempts of a probe kind, or of one of its phases, that
+// must be good.
+type SLO struct {
+	Name string `json:"name"`
+	// Kind is the probe kind the SLO applies to, any when empty.
+	Kind string `json:"kind,omitempty"`
+	// Phase is the phase the SLO applies to. When empty, every attempted
+	// probe counts, good when it succeeded.
+	Phase string `json:"phase,omitempty"`
+	// Latency, if set, is the longest a successful phase may take to be
+	// good. It needs Phase.
+	Latency metav1.Duration `json:"latency,omitempty"`
+	// Objective is the percentage of good attempts, e.g. 99.5.
+	Objective float64 `json:"objective"`
+	// Windows are the burn rate alerting windows, DefaultBurnWindows when
+	// empty.
+	Windows []BurnWindow `json:"windows,omitempty"`
+}
+
+// BurnWindow is a multiwindow burn rate alerting condition: it is breached
+// when the error budget burns at least BurnRate times faster than the
+// objective allows over both the Long window and the Short one, the latter
+// making the alert resolve soon after the burn stops.
+type BurnWindow struct {
+	Long     metav1.Duration `json:"long"`
+	Short    metav1.Duration `json:"short"`
+	BurnRate float64         `json:"burnRate"`
+	// Severity is set as the severity label of the window's alerts.
+	Severity string `json:"severity,omitempty"`
+}
+
+// DefaultBurnWindows page when 2% of a 30 days error budget is spent within
+// an hour, or 5% within six hours.
+var DefaultBurnWindows = []BurnWindow{
+	{Long: metav1.Duration{Duration: time.Hour}, Short: metav1.Duration{Duration: 5 * time.Minute}, BurnRate: 14.4, Severity: "page"},
+	{Long: metav1.Duration{Duration: 6 * time.Hour}, Short: metav1.Duration{Duration: 30 * time.Minute}, BurnRate: 6, Severity: "page"},
+}
+
+// sloFile is the document read by LoadSLOs.
+type sloFile struct {
+	Objectives []SLO `json:"objectives"`
+}
+
+// LoadSLOs reads SLOs from a YAML file holding a list of objectives, filling
+// in their default windows.
+func LoadSLOs(path string) ([]SLO, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SLO config: %w", err)
+	}
+	var f sloFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse SLO config: %w", err)
+	}
+	if len(f.Objectives) == 0 {
+		return nil, fmt.Errorf("SLO config %s has no objectives", path)
+	}
+	names := map[string]bool{}
+	var errs []error
+	for i := range f.Objectives {
+		slo := &f.Objectives[i]
+		if len(slo.Windows) == 0 {
+			slo.Windows = DefaultBurnWindows
+		}
+		if err := slo.validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if names[slo.Name] {
+			errs = append(errs, fmt.Errorf("SLO %s: duplicate name", slo.Name))
+		}
+		names[slo.Name] = true
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return f.Objectives, nil
+}
+
+func (s SLO) validate() error {
+	if s.Name == "" {
+		return errors.New("SLO without a name")
+	}
+	if s.Objective <= 0 || s.Objective >= 100 {
+		return fmt.Errorf("SLO %s: objective must be a percentage between 0 and 100 exclusive, got %g", s.Name, s.Objective)
+	}
+	if s.Latency.Duration < 0 {
+		return fmt.Errorf("SLO %s: latency must not be negative, got %s", s.Name, s.Latency.Duration)
+	}
+	if s.Latency.Duration > 0 && s.Phase == "" {
+		return fmt.Errorf("SLO %s: latency needs a phase", s.Name)
+	}
+	for _, w := range s.Windows {
+		if w.Short.Duration <= 0 || w.Long.Duration <= w.Short.Duration {
+			return fmt.Errorf("SLO %s: windows need a positive short window shorter than the long one, got %s and %s", s.Name, w.Long.Duration, w.Short.Duration)
+		}
+		if w.BurnRate <= 0 {
+			return fmt.Errorf("SLO %s: burn rate must be positive, got %g", s.Name, w.BurnRate)
+		}
+	}
+	return nil
+}
+
+// errorBudget returns the ratio of bad attempts the SLO allows.
+func (s SLO) errorBudget() float64 {
+	return 1 - s.Objective/100
+}
+
+// attempts returns whether each attempt of probe counts as good toward s, in
+// order; none when s doesn't apply to it.
+func (s SLO) attempts(probe results.Probe) []bool {
+	if s.Kind != "" && probe.Kind != s.Kind {
+		return nil
+	}
+	if s.Phase == "" {
+		if !probe.Outcome.Attempted() {
+			return nil
+		}
+		return []bool{probe.Outcome == results.OutcomeSuccess}
+	}
+	var good []bool
+	for _, ph := range probe.Phases {
+		if ph.Name != s.Phase || !ph.Outcome.Attempted() {
+			continue
+		}
+		good = append(good, ph.Outcome == results.OutcomeSuccess &&
+			(s.Latency.Duration == 0 || ph.Duration <= s.Latency.Duration))
+	}
+	return good
+}
+
+// SLOStatus is the state of an SLO's windows at the time it was evaluated.
+type SLOStatus struct {
+	SLO     SLO
+	Windows []WindowStatus
+}
+
+// WindowStatus is the burn rate of the error budget over a window's long and
+// short durations.
+type WindowStatus struct {
+	Window BurnWindow
+	Long   float64
+	Short  float64
+	// Attempts is the number of attempts over the long window.
+	Attempts int
+	Breached bool
+}
+
+// sloAttempt is an attempt counted toward an SLO.
+type sloAttempt struct {
+	at   time.Time
+	good bool
+}
+
+// SLOEvaluator computes the burn rates of SLOs over the results it observed,
+// kept in memory for as long as their longest window. It is meant for the
+// daemon's repeated runs, a single run never breaches anything.
+type SLOEvaluator struct {
+	slos []SLO
+
+	mu       sync.Mutex
+	attempts map[string][]sloAttempt
+}
+
+// NewSLOEvaluator returns an evaluator of slos without any attempt yet.
+func NewSLOEvaluator(slos []SLO) *SLOEvaluator {
+	return &SLOEvaluator{slos: slos, attempts: make(map[string][]sloAttempt)}
+}
+
+// Observe counts the attempts of probes, which ended at the given time,
+// toward the SLOs they apply to.
+func (e *SLOEvaluator) Observe(probes []results.Probe, at time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, slo := range e.slos {
+		for _, pr := range probes {
+			for _, good := range slo.attempts(pr) {
+				// Runs of several kinds may end out of order, keep them sorted
+				attempts := e.attempts[slo.Name]
+				e.attempts[slo.Name] = slices.Insert(attempts, countBefore(attempts, at.Add(1)), sloAttempt{at: at, good: good})
+			}
+		}
+	}
+}
+
+// Evaluate returns the status of every SLO at now, forgetting the attempts
+// older than their longest window.
+func (e *SLOEvaluator) Evaluate(now time.Time) []SLOStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	statuses := make([]SLOStatus, 0, len(e.slos))
+	for _, slo := range e.slos {
+		var longest time.Duration
+		for _, w := range slo.Windows {
+			longest = max(longest, w.Long.Duration)
+		}
+		attempts := e.attempts[slo.Name]
+		attempts = attempts[countBefore(attempts, now.Add(-longest)):]
+		e.attempts[slo.Name] = attempts
+
+		status := SLOStatus{SLO: slo}
+		for _, w := range slo.Windows {
+			long, n := burnRate(attempts, now.Add(-w.Long.Duration), slo.errorBudget())
+			short, _ := burnRate(attempts, now.Add(-w.Short.Duration), slo.errorBudget())
+			status.Windows = append(status.Windows, WindowStatus{
+				Window:   w,
+				Long:     long,
+				Short:    short,
+				Attempts: n,
+				Breached: long >= w.BurnRate && short >= w.BurnRate,
+			})
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// countBefore returns the number of attempts, in chronological order, made
+// before since.
+func countBefore(attempts []sloAttempt, since time.Time) int {
+	i, _ := slices.BinarySearchFunc(attempts, since, func(a sloAttempt, t time.Time) int {
+		if a.at.Before(t) {
+			return -1
+		}
+		return 1
+	})
+	return i
+}
+
+// burnRate returns the rate at which the attempts made since the given time
+// burn the error budget, 1 burning it exactly as fast as the objective
+// allows, along with their number.
+func burnRate(attempts []sloAttempt, since time.Time, budget float64) (float64, int) {
+	attempts = attempts[countBefore(attempts, since):]
+	if len(attempts) == 0 {
+		return 0, 0
+	}
+	var bad int
+	for _, a := range attempts {
+		if !a.good {
+			bad++
+		}
+	}
+	return float64(bad) / float64(len(attempts)) / budget, len(attempts)
+}
+
+// AlertmanagerAlert is an alert as posted to Alertmanager's /api/v2/alerts.
+type AlertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt,omitzero"`
+	// EndsAt is only set on resolved alerts, Alertmanager resolves the
+	// others on its own once they stop being sent.
+	EndsAt time.Time `json:"endsAt,omitzero"`
+}
+
+// BurnRateAlerter posts the SLO windows being breached to an Alertmanager
+// compatible webhook as firing alerts, sent again on every evaluation as
+// Alertmanager expects, and those no longer breached as resolved once. A nil
+// *BurnRateAlerter never notifies.
+type BurnRateAlerter struct {
+	URL string
+	// Client defaults to a client with a 10 seconds timeout.
+	Client *http.Client
+	// Cluster identifies the probed cluster in alerts.
+	Cluster string
+
+	mu     sync.Mutex
+	firing map[string]time.Time
+}
+
+// NewBurnRateAlerter returns an alerter posting to url, e.g.
+// http://alertmanager:9093/api/v2/alerts.
+func NewBurnRateAlerter(url, cluster string) *BurnRateAlerter {
+	return &BurnRateAlerter{URL: url, Cluster: cluster, firing: make(map[string]time.Time)}
+}
+
+// Alerts returns the alerts to send for statuses evaluated at now, and
+// records the windows they fire for.
+func (a *BurnRateAlerter) Alerts(statuses []SLOStatus, now time.Time) []AlertmanagerAlert {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var alerts []AlertmanagerAlert
+	for _, st := range statuses {
+		for _, w := range st.Windows {
+			key := st.SLO.Name + "/" + w.Window.Long.Duration.String()
+			since, firing := a.firing[key]
+			switch {
+			case w.Breached && !firing:
+				since = now
+				a.firing[key] = since
+			case !w.Breached && firing:
+				delete(a.firing, key)
+			case !w.Breached:
+				continue
+			}
+			alert := a.alert(st.SLO, w, since)
+			if !w.Breached {
+				alert.EndsAt = now
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func (a *BurnRateAlerter) alert(slo SLO, w WindowStatus, since time.Time) AlertmanagerAlert {
+	labels := map[string]string{
+		"alertname": "ProbeSLOBurnRate",
+		"slo":       slo.Name,
+		"window":    w.Window.Long.Duration.String(),
+	}
+	for name, value := range map[string]string{
+		"probe_kind":  slo.Kind,
+		"probe_phase": slo.Phase,
+		"cluster":     a.Cluster,
+		"severity":    w.Window.Severity,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return AlertmanagerAlert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("SLO %s is burning its error budget %.1fx faster than its %g%% objective allows over %s",
+				slo.Name, w.Long, slo.Objective, w.Window.Long.Duration),
+			"burn_rate_long":  strconv.FormatFloat(w.Long, 'g', 4, 64),
+			"burn_rate_short": strconv.FormatFloat(w.Short, 'g', 4, 64),
+			"threshold":       strconv.FormatFloat(w.Window.BurnRate, 'g', 4, 64),
+		},
+		StartsAt: since,
+	}
+}
+
+// Send posts alerts to the webhook as a JSON array.
+func (a *BurnRateAlerter) Send(ctx context.Context, alerts []AlertmanagerAlert) error {
+	if a == nil || len(alerts) == 0 {
+		return nil
+	}
+	if err := postJSON(ctx, a.Client, a.URL, alerts); err != nil {
+		return fmt.Errorf("failed to send burn rate alerts: %w", err)
+	}
+	return nil
+}
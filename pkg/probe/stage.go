@@ -0,0 +1,206 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+// TeardownTimeout bounds the time given to each stage's teardown.
+var TeardownTimeout = 2 * time.Minute
+
+// Stage is a single timed step of a probe. Stages are the building blocks
+// composite probes are assembled from.
+type Stage struct {
+	// Name identifies the stage in spans and results.
+	Name string
+	// Run performs the stage. The context carries the stage's span.
+	Run func(ctx context.Context) error
+	// Teardown, if set, undoes what Run created. It is called even if Run
+	// failed.
+	Teardown func(ctx context.Context) error
+}
+
+// Clients are the clientsets used by the probes. Measured operations go
+// through Measure, while cleanup always goes through Cleanup, so that the
+// probe can measure as a different identity than the prober's own.
+type Clients struct {
+	Measure kubernetes.Interface
+	Cleanup kubernetes.Interface
+}
+
+// SingleClient returns Clients using client for everything.
+func SingleClient(client kubernetes.Interface) Clients {
+	return Clients{Measure: client, Cleanup: client}
+}
+
+type teardownKey struct{}
+
+// WithoutTeardown returns a context in which teardowns are skipped, leaving
+// the objects created behind, e.g. to inspect them.
+func WithoutTeardown(ctx context.Context) context.Context {
+	return context.WithValue(ctx, teardownKey{}, true)
+}
+
+// TeardownSkipped reports whether teardowns are skipped in ctx.
+func TeardownSkipped(ctx context.Context) bool {
+	skipped, _ := ctx.Value(teardownKey{}).(bool)
+	return skipped
+}
+
+// RunStages runs the stages in order, each in its own "prober.<name>" span,
+// stopping at the first failure. Every stage that was started is then torn
+// down in reverse order, with teardown phases named "teardown-<name>",
+// unless teardowns are skipped in ctx. It
+// returns the phases of all the stages and teardowns that ran, and the
+// errors they returned.
+func RunStages(ctx context.Context, tracer trace.Tracer, stages []Stage) ([]results.Phase, error) {
+	var (
+		phases  []results.Phase
+		errs    []error
+		started []Stage
+	)
+
+	for _, s := range stages {
+		started = append(started, s)
+		ph, err := runTimed(ctx, tracer, s.Name, s.Run)
+		phases = append(phases, ph)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+			break
+		}
+	}
+
+	// Teardowns must run even if the probe's context is done.
+	tctx := context.WithoutCancel(ctx)
+	for i := len(started) - 1; i >= 0; i-- {
+		s := started[i]
+		if s.Teardown == nil {
+			continue
+		}
+		if TeardownSkipped(ctx) {
+			trace.SpanFromContext(ctx).AddEvent("teardown skipped", trace.WithAttributes(attribute.String("stage", s.Name)))
+			continue
+		}
+		ph, err := runTimed(tctx, tracer, "teardown-"+s.Name, s.Teardown)
+		phases = append(phases, ph)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("teardown-%s: %w", s.Name, err))
+		}
+	}
+
+	return phases, errors.Join(errs...)
+}
+
+// RunStage runs a single stage in its own span and returns the resulting
+// phase. Unlike RunStages, tearing the stage down is left to the caller.
+func RunStage(ctx context.Context, tracer trace.Tracer, s Stage) (results.Phase, error) {
+	return runTimed(ctx, tracer, s.Name, s.Run)
+}
+
+// RecordPhaseSpans records phases that were derived from timestamps rather
+// than timed by the prober as "prober.<name>" spans, children of the span in
+// ctx, starting and ending at the phases' boundaries.
+func RecordPhaseSpans(ctx context.Context, tracer trace.Tracer, phases []results.Phase) {
+	for _, ph := range phases {
+		_, span := tracer.Start(ctx, "prober."+ph.Name, trace.WithTimestamp(ph.Start))
+		span.End(trace.WithTimestamp(ph.Start.Add(ph.Duration)))
+	}
+}
+
+// runTimed runs fn in a span, bounded by the timeout of the class of the
+// named stage, and returns the resulting phase.
+func runTimed(ctx context.Context, tracer trace.Tracer, name string, fn func(context.Context) error) (results.Phase, error) {
+	ctx, span := tracer.Start(ctx, "prober."+name)
+	defer span.End()
+	ctx, throttle := telemetry.TrackThrottle(ctx)
+	defer throttle.Record(span)
+	StatusFromContext(ctx).SetPhase(name)
+	ctx, cancel := WithPhaseTimeout(ctx, StageClass(name))
+	defer cancel()
+
+	start := time.Now()
+	err := PhaseTimeout(ctx, fn(ctx))
+	ph := results.Phase{
+		Name:     name,
+		Start:    start,
+		Duration: time.Since(start),
+		Outcome:  OutcomeFor(err),
+	}
+	if err != nil {
+		RecordConflicts(span, err)
+		RecordPhaseTimeout(span, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return ph, err
+}
+
+// OutcomeFor returns the outcome class matching err.
+func OutcomeFor(err error) results.Outcome {
+	switch {
+	case err == nil:
+		return results.OutcomeSuccess
+	case errors.Is(err, context.DeadlineExceeded):
+		return results.OutcomeTimeout
+	case apierrors.IsTooManyRequests(err):
+		return results.OutcomeThrottled
+	case apierrors.IsUnauthorized(err):
+		return results.OutcomeUnauthenticated
+	case apierrors.HasStatusCause(err, corev1.NamespaceTerminatingCause):
+		return results.OutcomeNamespaceTerminating
+	default:
+		return results.OutcomeError
+	}
+}
+
+// Throttled reports whether server-side throttling, rather than slowness, is
+// the dominant cause of a probe missing its budget: whether the time it
+// waited to retry requests rejected with a 429 is at least how much it went
+// over budget, meaning it would have made it without being throttled. A probe
+// that timed out has no known overage, throttling is then dominant when it
+// accounts for more than half of the elapsed time.
+func Throttled(outcome results.Outcome, elapsed, budget, retryWait time.Duration) bool {
+	switch outcome {
+	case results.OutcomeBudgetExceeded:
+		return retryWait > 0 && retryWait >= elapsed-budget
+	case results.OutcomeTimeout:
+		return retryWait > elapsed/2
+	default:
+		return false
+	}
+}
+
+// Poll calls cond every interval until it returns true, an error, or ctx is
+// done.
+func Poll(ctx context.Context, interval time.Duration, cond func(context.Context) (bool, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := cond(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/trace/noop"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+// namespaceTerminatingError is the error the API server returns when
+// creating an object in a namespace being deleted.
+func namespaceTerminatingError() error {
+	err := apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "probe-abc", errors.New("unable to create new content in namespace probes because it is being terminated"))
+	err.ErrStatus.Details.Causes = []metav1.StatusCause{{
+		Type:    corev1.NamespaceTerminatingCause,
+		Message: "namespace probes is being terminated",
+		Field:   "metadata.namespace",
+	}}
+	return err
+}
+
+func TestOutcomeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want results.Outcome
+	}{
+		{name: "success", err: nil, want: results.OutcomeSuccess},
+		{name: "deadline", err: context.DeadlineExceeded, want: results.OutcomeTimeout},
+		{name: "wrapped deadline", err: fmt.Errorf("wait-for-pod: %w", context.DeadlineExceeded), want: results.OutcomeTimeout},
+		{name: "throttled", err: apierrors.NewTooManyRequests("slow down", 1), want: results.OutcomeThrottled},
+		{name: "unauthorized", err: apierrors.NewUnauthorized("expired token"), want: results.OutcomeUnauthenticated},
+		{name: "namespace terminating", err: namespaceTerminatingError(), want: results.OutcomeNamespaceTerminating},
+		{name: "wrapped namespace terminating", err: fmt.Errorf("create-pod: %w", namespaceTerminatingError()), want: results.OutcomeNamespaceTerminating},
+		{name: "forbidden", err: apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "probe-abc", errors.New("denied")), want: results.OutcomeError},
+		{name: "other", err: errors.New("boom"), want: results.OutcomeError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OutcomeFor(tt.err); got != tt.want {
+				t.Errorf("OutcomeFor(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	tests := []struct {
+		name                       string
+		outcome                    results.Outcome
+		elapsed, budget, retryWait time.Duration
+		want                       bool
+	}{
+		{name: "over budget by less than the retry wait", outcome: results.OutcomeBudgetExceeded, elapsed: 3 * time.Second, budget: 2 * time.Second, retryWait: 1500 * time.Millisecond, want: true},
+		{name: "over budget by the retry wait", outcome: results.OutcomeBudgetExceeded, elapsed: 3 * time.Second, budget: 2 * time.Second, retryWait: time.Second, want: true},
+		{name: "over budget by more than the retry wait", outcome: results.OutcomeBudgetExceeded, elapsed: 5 * time.Second, budget: 2 * time.Second, retryWait: time.Second},
+		{name: "over budget without throttling", outcome: results.OutcomeBudgetExceeded, elapsed: 3 * time.Second, budget: 3 * time.Second},
+		{name: "timed out mostly throttled", outcome: results.OutcomeTimeout, elapsed: 10 * time.Second, retryWait: 6 * time.Second, want: true},
+		{name: "timed out mostly slow", outcome: results.OutcomeTimeout, elapsed: 10 * time.Second, retryWait: 4 * time.Second},
+		{name: "success", outcome: results.OutcomeSuccess, elapsed: time.Second, retryWait: time.Second},
+		{name: "error", outcome: results.OutcomeError, elapsed: time.Second, retryWait: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Throttled(tt.outcome, tt.elapsed, tt.budget, tt.retryWait); got != tt.want {
+				t.Errorf("Throttled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunStageThrottled(t *testing.T) {
+	// An API server rejecting every request with a 429, past the retries
+	rejections := 0
+	config := &rest.Config{
+		Host: "https://apiserver",
+		Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			rejections++
+			h := http.Header{"Content-Type": []string{"application/json"}, "Retry-After": []string{"0"}}
+			body := `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"TooManyRequests","code":429}`
+			return &http.Response{StatusCode: http.StatusTooManyRequests, Header: h, Body: io.NopCloser(strings.NewReader(body))}, nil
+		}),
+	}
+	reader := sdkmetric.NewManualReader()
+	recorder, err := telemetry.NewRejectionRecorder(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"))
+	if err != nil {
+		t.Fatalf("NewRejectionRecorder() error = %v", err)
+	}
+	recorder.MaxRetries, recorder.Backoff = 2, time.Millisecond
+	config.Wrap(recorder.Wrap)
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("NewForConfig() error = %v", err)
+	}
+
+	ph, err := RunStage(context.Background(), noop.NewTracerProvider().Tracer("test"), Stage{
+		Name: "get-pod",
+		Run: func(ctx context.Context) error {
+			_, err := client.CoreV1().Pods("default").Get(ctx, "probe-abc", metav1.GetOptions{})
+			return err
+		},
+	})
+	if !apierrors.IsTooManyRequests(err) {
+		t.Fatalf("RunStage() error = %v, want a 429", err)
+	}
+	if ph.Outcome != results.OutcomeThrottled {
+		t.Errorf("phase outcome = %s, want throttled", ph.Outcome)
+	}
+	// client-go doesn't retry it on top of the recorder
+	if rejections != 3 {
+		t.Errorf("sent %d requests, want the first and 2 retries", rejections)
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper calling itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
@@ -0,0 +1,88 @@
+package probe
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Status tracks the live progress of a run. It is shared by everything that
+// reports progress while the run is in flight, so they can't diverge. A nil
+// *Status ignores every update.
+type Status struct {
+	mu              sync.Mutex
+	runStart        time.Time
+	phase           string
+	phaseStart      time.Time
+	attempts        int
+	lastObservation string
+}
+
+// StatusSnapshot is a point-in-time copy of a Status.
+type StatusSnapshot struct {
+	Phase           string        `json:"phase"`
+	Elapsed         time.Duration `json:"elapsed"`
+	PhaseElapsed    time.Duration `json:"phase_elapsed"`
+	Attempts        int           `json:"attempts"`
+	LastObservation string        `json:"last_observation,omitempty"`
+}
+
+// NewStatus returns a status for a run starting now.
+func NewStatus() *Status {
+	now := time.Now()
+	return &Status{runStart: now, phaseStart: now}
+}
+
+// SetPhase records that the run entered the named phase.
+func (s *Status) SetPhase(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = name
+	s.phaseStart = time.Now()
+	s.attempts = 0
+	s.lastObservation = ""
+}
+
+// Observe records a poll attempt of the current phase and what it observed.
+func (s *Status) Observe(observation string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	s.lastObservation = observation
+}
+
+// Snapshot returns a copy of the current status.
+func (s *Status) Snapshot() StatusSnapshot {
+	if s == nil {
+		return StatusSnapshot{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	return StatusSnapshot{
+		Phase:           s.phase,
+		Elapsed:         now.Sub(s.runStart),
+		PhaseElapsed:    now.Sub(s.phaseStart),
+		Attempts:        s.attempts,
+		LastObservation: s.lastObservation,
+	}
+}
+
+type statusKey struct{}
+
+// WithStatus returns a copy of ctx carrying status.
+func WithStatus(ctx context.Context, status *Status) context.Context {
+	return context.WithValue(ctx, statusKey{}, status)
+}
+
+// StatusFromContext returns the status carried by ctx, or nil.
+func StatusFromContext(ctx context.Context) *Status {
+	s, _ := ctx.Value(statusKey{}).(*Status)
+	return s
+}
@@ -0,0 +1,106 @@
+package probe
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// TokenRefresher re-reads a rotating bound service account token when a
+// request is rejected as unauthenticated, and retries the request once with
+// the new token. client-go only re-reads the token file periodically, so
+// right after a rotation a long-lived prober could otherwise send the
+// expired token for a while, and its 401s would look like API server
+// failures. Every retry is counted in the probe.auth_retries_total counter.
+type TokenRefresher struct {
+	path    string
+	retries metric.Int64Counter
+
+	mu    sync.Mutex
+	token string
+}
+
+// NewTokenRefresher returns a refresher for the token file at path, creating
+// its counter on meter.
+func NewTokenRefresher(path string, meter metric.Meter) (*TokenRefresher, error) {
+	retries, err := meter.Int64Counter("probe.auth_retries_total",
+		metric.WithDescription("Number of requests retried with a re-read token after a 401, by retry outcome."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.auth_retries_total counter: %w", err)
+	}
+	return &TokenRefresher{path: path, retries: retries}, nil
+}
+
+// Wrap wraps rt, it can be used as a rest.Config's WrapTransport. The
+// wrapped transport must be the one authenticating with the token file.
+func (t *TokenRefresher) Wrap(rt http.RoundTripper) http.RoundTripper {
+	return tokenRefreshTransport{refresher: t, next: rt}
+}
+
+type tokenRefreshTransport struct {
+	refresher *TokenRefresher
+	next      http.RoundTripper
+}
+
+func (rt tokenRefreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := rt.refresher
+
+	// Once refreshed, keep using the new token until client-go catches up.
+	t.mu.Lock()
+	token := t.token
+	t.mu.Unlock()
+	if token != "" && req.Header.Get("Authorization") != "Bearer "+token {
+		req = withToken(req, token)
+	}
+
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// The request can't be replayed.
+		return resp, nil
+	}
+
+	data, rerr := os.ReadFile(t.path)
+	fresh := strings.TrimSpace(string(data))
+	if rerr != nil || fresh == "" || req.Header.Get("Authorization") == "Bearer "+fresh {
+		// The token didn't rotate, the 401 is genuine.
+		return resp, nil
+	}
+
+	retry := withToken(req, fresh)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	resp.Body.Close()
+
+	t.mu.Lock()
+	t.token = fresh
+	t.mu.Unlock()
+
+	resp, err = rt.next.RoundTrip(retry)
+	outcome := "success"
+	if err != nil || resp.StatusCode == http.StatusUnauthorized {
+		outcome = "failure"
+	}
+	t.retries.Add(req.Context(), 1, metric.WithAttributes(attribute.String("retry.outcome", outcome)))
+	return resp, err
+}
+
+func withToken(req *http.Request, token string) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
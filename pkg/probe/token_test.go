@@ -0,0 +1,152 @@
+package probe
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// tokenServer accepts only requests bearing its token, recording the
+// Authorization header and body of every request it got.
+type tokenServer struct {
+	token  string
+	auths  []string
+	bodies []string
+}
+
+func (s *tokenServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body string
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+	}
+	s.auths = append(s.auths, req.Header.Get("Authorization"))
+	s.bodies = append(s.bodies, body)
+	if req.Header.Get("Authorization") != "Bearer "+s.token {
+		return &http.Response{StatusCode: http.StatusUnauthorized, Body: http.NoBody}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+// newTestTokenRefresher returns a refresher for a token file holding token,
+// and the reader collecting its counter.
+func newTestTokenRefresher(t *testing.T, token string) (*TokenRefresher, *sdkmetric.ManualReader) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reader := sdkmetric.NewManualReader()
+	r, err := NewTokenRefresher(path, sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"))
+	if err != nil {
+		t.Fatalf("NewTokenRefresher() error = %v", err)
+	}
+	return r, reader
+}
+
+// authRetries returns probe.auth_retries_total by retry outcome.
+func authRetries(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	out := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok && m.Name == "probe.auth_retries_total" {
+				for _, dp := range sum.DataPoints {
+					outcome, _ := dp.Attributes.Value("retry.outcome")
+					out[outcome.AsString()] += dp.Value
+				}
+			}
+		}
+	}
+	return out
+}
+
+func TestTokenRefresherRotated(t *testing.T) {
+	refresher, reader := newTestTokenRefresher(t, "new")
+	server := &tokenServer{token: "new"}
+	rt := refresher.Wrap(server)
+
+	req, _ := http.NewRequest(http.MethodPost, "https://apiserver/api/v1/namespaces/default/pods", strings.NewReader(`{"kind":"Pod"}`))
+	req.Header.Set("Authorization", "Bearer old")
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200 after the retry", resp.StatusCode)
+	}
+	if want := []string{"Bearer old", "Bearer new"}; strings.Join(server.auths, ",") != strings.Join(want, ",") {
+		t.Errorf("sent %v, want %v", server.auths, want)
+	}
+	if server.bodies[1] != `{"kind":"Pod"}` {
+		t.Errorf("retry body = %q, want the original one", server.bodies[1])
+	}
+	if got := authRetries(t, reader); got["success"] != 1 || got["failure"] != 0 {
+		t.Errorf("probe.auth_retries_total = %v, want a single success", got)
+	}
+
+	// Until client-go re-reads the file, its requests still bear the old
+	// token and are sent with the new one right away
+	req, _ = http.NewRequest(http.MethodGet, "https://apiserver/api/v1/namespaces/default/pods", nil)
+	req.Header.Set("Authorization", "Bearer old")
+	if resp, err := rt.RoundTrip(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("RoundTrip() = %v, %v, want a 200", resp, err)
+	}
+	if len(server.auths) != 3 || server.auths[2] != "Bearer new" {
+		t.Errorf("sent %v, want the third request with the new token", server.auths)
+	}
+	if got := authRetries(t, reader); got["success"] != 1 {
+		t.Errorf("probe.auth_retries_total = %v, want no other retry", got)
+	}
+}
+
+func TestTokenRefresherNotRotated(t *testing.T) {
+	// The file holds the token that was rejected: the 401 is genuine
+	refresher, reader := newTestTokenRefresher(t, "old")
+	server := &tokenServer{token: "new"}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://apiserver/api/v1/namespaces/default/pods", nil)
+	req.Header.Set("Authorization", "Bearer old")
+	resp, err := refresher.Wrap(server).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want the 401", resp.StatusCode)
+	}
+	if len(server.auths) != 1 {
+		t.Errorf("sent %d requests, want no retry", len(server.auths))
+	}
+	if got := authRetries(t, reader); len(got) != 0 {
+		t.Errorf("probe.auth_retries_total = %v, want none", got)
+	}
+}
+
+func TestTokenRefresherStillRejected(t *testing.T) {
+	refresher, reader := newTestTokenRefresher(t, "rotated")
+	server := &tokenServer{token: "revoked"}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://apiserver/api/v1/namespaces/default/pods", nil)
+	req.Header.Set("Authorization", "Bearer old")
+	resp, err := refresher.Wrap(server).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized || len(server.auths) != 2 {
+		t.Errorf("status = %d after %d requests, want the retry's 401", resp.StatusCode, len(server.auths))
+	}
+	if got := authRetries(t, reader); got["failure"] != 1 {
+		t.Errorf("probe.auth_retries_total = %v, want a single failure", got)
+	}
+}
@@ -0,0 +1,142 @@
+package probe
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// VerbStages returns stages, named after the verbs, making a single request
+// of each API verb against a small ConfigMap described by opts, so that their latency is the
+// API server's and etcd's alone: its creation, a get, a list of the
+// namespace's ConfigMaps, a list of those matching opts.Labels, a merge
+// patch of its payload, a watch of it up to the watch being established,
+// and its deletion. The creation's teardown deletes the ConfigMap in case
+// the run ends before the delete stage.
+func VerbStages(clients Clients, opts ObjectOptions) []Stage {
+	cms := clients.Measure.CoreV1().ConfigMaps(opts.Namespace)
+	return []Stage{
+		{
+			Name: "create",
+			Run: func(ctx context.Context) error {
+				cm, err := cms.Create(ctx, &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: opts.Name, Namespace: opts.Namespace, Labels: opts.Labels, Annotations: opts.Annotations},
+					Data:       map[string]string{PayloadKey: "created"},
+				}, metav1.CreateOptions{FieldManager: opts.FieldManager})
+				if err != nil {
+					return err
+				}
+				LedgerFromContext(ctx).Record("configmaps", cm)
+				return nil
+			},
+			Teardown: func(ctx context.Context) error {
+				return DeleteOwned(ctx, "configmaps", opts.Namespace, opts.Name, metav1.DeleteOptions{}, clients.Cleanup.CoreV1().ConfigMaps(opts.Namespace).Delete)
+			},
+		},
+		{
+			Name: "get",
+			Run: func(ctx context.Context) error {
+				_, err := cms.Get(ctx, opts.Name, metav1.GetOptions{})
+				return err
+			},
+		},
+		{
+			Name: "list",
+			Run: func(ctx context.Context) error {
+				_, err := cms.List(ctx, metav1.ListOptions{})
+				return err
+			},
+		},
+		{
+			Name: "list-selected",
+			Run: func(ctx context.Context) error {
+				_, err := cms.List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(opts.Labels).String()})
+				return err
+			},
+		},
+		{
+			Name: "patch",
+			Run: func(ctx context.Context) error {
+				_, err := cms.Patch(ctx, opts.Name, types.MergePatchType, []byte(`{"data":{"`+PayloadKey+`":"patched"}}`), metav1.PatchOptions{FieldManager: opts.FieldManager})
+				return err
+			},
+		},
+		{
+			Name: "watch",
+			Run: func(ctx context.Context) error {
+				// Watch returns once the API server answered, with the
+				// watch established
+				w, err := cms.Watch(ctx, metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", opts.Name).String()})
+				if err != nil {
+					return err
+				}
+				w.Stop()
+				return nil
+			},
+		},
+		{
+			Name: "delete",
+			Run: func(ctx context.Context) error {
+				return DeleteOwned(ctx, "configmaps", opts.Namespace, opts.Name, metav1.DeleteOptions{}, cms.Delete)
+			},
+		},
+	}
+}
+
+// Read consistencies, as the read.consistency span attribute.
+const (
+	// ReadQuorum reads go through etcd with a quorum read, the default.
+	ReadQuorum = "quorum"
+	// ReadCache reads, with resourceVersion=0, are served from the API
+	// server's watch cache, possibly stale.
+	ReadCache = "cache"
+)
+
+// ReadStages returns stages making the same get and list of the ConfigMap
+// described by opts with each read consistency, named after the verb and
+// the consistency, e.g. "get-quorum" and "get-cache". The watch cache hides
+// a degraded etcd from most reads, while quorum reads still pay for it, so a
+// growing gap between the two is etcd's. The creation of the ConfigMap is
+// the first stage, whose teardown deletes it.
+func ReadStages(clients Clients, opts ObjectOptions) []Stage {
+	stages := VerbStages(clients, opts)[:1]
+	cms := clients.Measure.CoreV1().ConfigMaps(opts.Namespace)
+	for _, verb := range []string{"get", "list"} {
+		for _, consistency := range []string{ReadQuorum, ReadCache} {
+			var rv string
+			if consistency == ReadCache {
+				rv = "0"
+			}
+			stages = append(stages, Stage{
+				Name: verb + "-" + consistency,
+				Run: func(ctx context.Context) error {
+					start := time.Now()
+					var err error
+					if verb == "get" {
+						_, err = cms.Get(ctx, opts.Name, metav1.GetOptions{ResourceVersion: rv})
+					} else {
+						_, err = cms.List(ctx, metav1.ListOptions{
+							LabelSelector:   labels.SelectorFromSet(opts.Labels).String(),
+							ResourceVersion: rv,
+						})
+					}
+					// As an attribute too, for backends only querying those
+					trace.SpanFromContext(ctx).SetAttributes(
+						attribute.String("read.verb", verb),
+						attribute.String("read.consistency", consistency),
+						attribute.Float64("read.duration_ms", float64(time.Since(start).Microseconds())/1000),
+					)
+					return err
+				},
+			})
+		}
+	}
+	return stages
+}
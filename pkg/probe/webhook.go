@@ -0,0 +1,254 @@
+package probe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+)
+
+// LabelCalibrate labels the objects of the webhook-overhead probe matched by
+// its calibration webhook, with the probe's instance as value.
+const LabelCalibrate = "probe.wperron.io/calibrate"
+
+// WebhookConfigurationResource is the resource of the webhook configurations
+// registered by the webhook-overhead probe, see RegisterWebhook.
+var WebhookConfigurationResource = schema.GroupVersionResource{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "validatingwebhookconfigurations"}
+
+// WebhookPermissions are the permissions needed to register the calibration
+// webhook of the webhook-overhead probe and to delete it, along with the
+// leaked ones.
+func WebhookPermissions() Permissions {
+	return Permissions{
+		Measure: []Permission{
+			{Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations", Verb: "create"},
+		},
+		Cleanup: []Permission{
+			{Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations", Verb: "delete"},
+			{Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations", Verb: "list"},
+		},
+	}
+}
+
+// CreateAdmittedPod returns a stage named name creating the pod described by
+// opts, only to measure how long its admission takes. With dryRun, the pod
+// goes through admission, webhooks included, but is never persisted;
+// webhooks with side effects reject such requests. Otherwise, its teardown
+// deletes it.
+func CreateAdmittedPod(clients Clients, name string, opts PodOptions, dryRun bool) Stage {
+	s := Stage{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			pod, err := opts.Build()
+			if err != nil {
+				return err
+			}
+			create := opts.CreateOptions()
+			if dryRun {
+				create.DryRun = []string{metav1.DryRunAll}
+			}
+			pod, err = clients.Measure.CoreV1().Pods(opts.Namespace).Create(ctx, pod, create)
+			if err != nil {
+				return err
+			}
+			if !dryRun {
+				LedgerFromContext(ctx).Record("pods", pod)
+			}
+			return nil
+		},
+	}
+	if !dryRun {
+		s.Teardown = func(ctx context.Context) error {
+			return DeleteOwned(ctx, "pods", opts.Namespace, opts.Name, metav1.DeleteOptions{}, clients.Cleanup.CoreV1().Pods(opts.Namespace).Delete)
+		}
+	}
+	return s
+}
+
+// NoopWebhook is a validating admission webhook allowing every request, the
+// cheapest a webhook can be, to calibrate the overhead of the others
+// against. It remembers the values of LabelCalibrate of the objects it was
+// called for, so that a probe can tell when its registration took effect.
+type NoopWebhook struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// NewNoopWebhook returns a webhook that was never called.
+func NewNoopWebhook() *NoopWebhook {
+	return &NoopWebhook{seen: map[string]bool{}}
+}
+
+// ServeHTTP answers an AdmissionReview, allowing the request.
+func (h *NoopWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "expected an AdmissionReview request", http.StatusBadRequest)
+		return
+	}
+	var obj metav1.PartialObjectMetadata
+	if err := json.Unmarshal(review.Request.Object.Raw, &obj); err == nil {
+		if instance := obj.Labels[LabelCalibrate]; instance != "" {
+			h.mu.Lock()
+			h.seen[instance] = true
+			h.mu.Unlock()
+		}
+	}
+	review.Response = &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// Seen reports whether the webhook was called for an object of instance.
+func (h *NoopWebhook) Seen(instance string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.seen[instance]
+}
+
+// Forget forgets the calls for the objects of instance, once its probe is
+// done.
+func (h *NoopWebhook) Forget(instance string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.seen, instance)
+}
+
+// WebhookCertificate returns a self-signed serving certificate for the given
+// DNS names, valid for a year, and its PEM encoding, the CA bundle of the
+// webhook configurations using it.
+func WebhookCertificate(dnsNames ...string) (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to generate webhook key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to generate webhook certificate serial: %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:              dnsNames,
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		// Its own CA, as the webhook configuration's CA bundle
+		IsCA: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to create webhook certificate: %w", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// WebhookOptions describes the ValidatingWebhookConfiguration registering a
+// NoopWebhook for the pods labeled with LabelCalibrate set to Instance.
+type WebhookOptions struct {
+	Name     string
+	Instance string
+	Labels   map[string]string
+
+	// Annotations are set on the configuration.
+	Annotations map[string]string
+
+	// Service is the Service routing to the webhook, which serves a
+	// certificate of CABundle.
+	Service  admissionregistrationv1.ServiceReference
+	CABundle []byte
+
+	// FieldManager is set on every write.
+	FieldManager string
+}
+
+// RegisterWebhook returns a stage creating the webhook configuration. Its
+// teardown deletes it.
+func RegisterWebhook(clients Clients, opts WebhookOptions) Stage {
+	configs := clients.Cleanup.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	return Stage{
+		Name: "create-webhook",
+		Run: func(ctx context.Context) error {
+			config, err := clients.Measure.AdmissionregistrationV1().ValidatingWebhookConfigurations().Create(ctx, &admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        opts.Name,
+					Labels:      opts.Labels,
+					Annotations: opts.Annotations,
+				},
+				Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+					Name: "calibrate.probe.wperron.io",
+					ClientConfig: admissionregistrationv1.WebhookClientConfig{
+						Service:  &opts.Service,
+						CABundle: opts.CABundle,
+					},
+					Rules: []admissionregistrationv1.RuleWithOperations{{
+						Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods"},
+						},
+					}},
+					// Only ever called for the probe's own pods
+					ObjectSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{LabelCalibrate: opts.Instance},
+					},
+					FailurePolicy:           ptr.To(admissionregistrationv1.Fail),
+					SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+					AdmissionReviewVersions: []string{"v1"},
+					TimeoutSeconds:          ptr.To[int32](5),
+				}},
+			}, metav1.CreateOptions{FieldManager: opts.FieldManager})
+			if err != nil {
+				return err
+			}
+			LedgerFromContext(ctx).Record("validatingwebhookconfigurations", config)
+			return nil
+		},
+		Teardown: func(ctx context.Context) error {
+			return DeleteOwned(ctx, "validatingwebhookconfigurations", "", opts.Name, metav1.DeleteOptions{}, configs.Delete)
+		},
+	}
+}
+
+// WaitWebhookReady returns a stage calling create, creating a pod matched by
+// the webhook of instance, until hook was called for it: the API server
+// calls webhooks once it observed their configuration.
+func WaitWebhookReady(hook *NoopWebhook, instance string, interval time.Duration, create func(context.Context) error) Stage {
+	return Stage{
+		Name: "wait-webhook-ready",
+		Run: func(ctx context.Context) error {
+			return Poll(ctx, interval, func(ctx context.Context) (bool, error) {
+				if err := create(ctx); err != nil {
+					return false, err
+				}
+				if !hook.Seen(instance) {
+					StatusFromContext(ctx).Observe("webhook not called")
+					return false, nil
+				}
+				return true, nil
+			})
+		},
+	}
+}
@@ -0,0 +1,164 @@
+package results
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ErrNoResults is returned by ParseAll when the stream holds no results
+// document.
+var ErrNoResults = errors.New("no results document")
+
+// ParseAll reads a stream of results documents, e.g. the output of several
+// runs appended to the same file, each of any supported schema version. The
+// stream must hold at least one.
+func ParseAll(r io.Reader) ([]*Results, error) {
+	dec := json.NewDecoder(r)
+	var out []*Results
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode results: %w", err)
+		}
+		res, err := ParseResults(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, res)
+	}
+	if len(out) == 0 {
+		return nil, ErrNoResults
+	}
+	return out, nil
+}
+
+// Percentiles summarizes the durations of a phase.
+type Percentiles struct {
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+}
+
+// ComparisonRow compares a single phase, keyed by "<kind>/<phase>", between
+// two sets of results. Before or After is nil when the phase only appears on
+// one side.
+type ComparisonRow struct {
+	Key    string       `json:"key"`
+	Before *Percentiles `json:"before,omitempty"`
+	After  *Percentiles `json:"after,omitempty"`
+
+	// DeltaP50 and DeltaP95 are the relative changes in percent, only set
+	// when the phase appears on both sides.
+	DeltaP50 *float64 `json:"delta_p50,omitempty"`
+	DeltaP95 *float64 `json:"delta_p95,omitempty"`
+
+	// Significant is set when either delta is at least the comparison's
+	// threshold, in either direction. Regression is set when either
+	// increased by at least the threshold.
+	Significant bool `json:"significant"`
+	Regression  bool `json:"regression"`
+}
+
+// Comparison is the phase by phase comparison of two sets of results.
+type Comparison struct {
+	Threshold float64         `json:"threshold"`
+	Rows      []ComparisonRow `json:"rows"`
+}
+
+// Compare compares the successful phases of before and after. Each side may
+// hold any number of runs and probes; every occurrence of a phase counts as a
+// sample. A change of at least threshold percent is significant.
+func Compare(before, after []*Results, threshold float64) Comparison {
+	b, a := samples(before), samples(after)
+
+	keys := map[string]bool{}
+	for k := range b {
+		keys[k] = true
+	}
+	for k := range a {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	c := Comparison{Threshold: threshold}
+	for _, key := range sorted {
+		row := ComparisonRow{
+			Key:    key,
+			Before: percentiles(b[key]),
+			After:  percentiles(a[key]),
+		}
+		if row.Before != nil && row.After != nil {
+			row.DeltaP50 = delta(row.Before.P50, row.After.P50)
+			row.DeltaP95 = delta(row.Before.P95, row.After.P95)
+			for _, d := range []*float64{row.DeltaP50, row.DeltaP95} {
+				if d == nil {
+					continue
+				}
+				if math.Abs(*d) >= threshold {
+					row.Significant = true
+				}
+				if *d >= threshold {
+					row.Regression = true
+				}
+			}
+		}
+		c.Rows = append(c.Rows, row)
+	}
+	return c
+}
+
+// Regressed reports whether any phase regressed.
+func (c Comparison) Regressed() bool {
+	return slices.ContainsFunc(c.Rows, func(r ComparisonRow) bool { return r.Regression })
+}
+
+func samples(rs []*Results) map[string][]time.Duration {
+	out := map[string][]time.Duration{}
+	for _, r := range rs {
+		for _, p := range r.Run.Probes {
+			for _, ph := range p.Phases {
+				if ph.Outcome != OutcomeSuccess {
+					continue
+				}
+				key := p.Kind + "/" + ph.Name
+				out[key] = append(out[key], ph.Duration)
+			}
+		}
+	}
+	return out
+}
+
+// percentiles returns the nearest-rank percentiles of ds, nil if empty.
+func percentiles(ds []time.Duration) *Percentiles {
+	if len(ds) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(ds)
+	slices.Sort(sorted)
+	return &Percentiles{Count: len(sorted), P50: nearestRank(sorted, 0.5), P95: nearestRank(sorted, 0.95)}
+}
+
+// delta returns the change from before to after in percent, nil if before is
+// zero.
+func delta(before, after time.Duration) *float64 {
+	if before == 0 {
+		return nil
+	}
+	d := float64(after-before) / float64(before) * 100
+	return &d
+}
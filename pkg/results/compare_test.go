@@ -0,0 +1,33 @@
+package results
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseAll(t *testing.T) {
+	stream := `{"schema_version":2,"run":{"probes":[{"kind":"pod"}]}}
+{"schema_version":1}
+`
+	rs, err := ParseAll(strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("ParseAll() error = %v", err)
+	}
+	if len(rs) != 2 {
+		t.Fatalf("ParseAll() = %d documents, want 2", len(rs))
+	}
+	if got := rs[0].Run.Probes[0].Kind; got != "pod" {
+		t.Errorf("first document kind = %q, want pod", got)
+	}
+
+	// e.g. compare a.json /dev/null
+	for _, stream := range []string{"", " \n\t\n"} {
+		if _, err := ParseAll(strings.NewReader(stream)); !errors.Is(err, ErrNoResults) {
+			t.Errorf("ParseAll(%q) error = %v, want ErrNoResults", stream, err)
+		}
+	}
+	if _, err := ParseAll(strings.NewReader(`{"schema_version":2}{`)); err == nil {
+		t.Error("ParseAll() of a truncated document error = nil, want one")
+	}
+}
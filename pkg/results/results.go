@@ -0,0 +1,351 @@
+// Package results defines the machine-readable summary written by the
+// prober at the end of a run, and helpers to read it back.
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// SchemaVersion is the current version of the results schema.
+const SchemaVersion = 2
+
+// Outcome describes how a phase or a probe ended.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeError   Outcome = "error"
+	OutcomeTimeout Outcome = "timeout"
+	OutcomeSkipped Outcome = "skipped"
+
+	// OutcomeBudgetExceeded is used when the probe completed but took longer
+	// than its overall time budget.
+	OutcomeBudgetExceeded Outcome = "budget_exceeded"
+	// OutcomeSkippedLocked is used when the probe did not run because another
+	// prober held the lock.
+	OutcomeSkippedLocked Outcome = "skipped_locked"
+	// OutcomeNamespaceTerminating is used when the probe could not run
+	// because its namespace is being deleted.
+	OutcomeNamespaceTerminating Outcome = "namespace_terminating"
+	// OutcomeSkippedPaused is used when the probe did not run because
+	// measurements were paused.
+	OutcomeSkippedPaused Outcome = "skipped_paused"
+	// OutcomeThrottled is used when the probe failed mostly because the API
+	// server rejected its requests with 429s, rather than because it was
+	// slow.
+	OutcomeThrottled Outcome = "throttled"
+	// OutcomeUnauthenticated is used when the API server rejected the
+	// probe's credentials, which is a problem with the prober's identity
+	// rather than with the API server.
+	OutcomeUnauthenticated Outcome = "unauthenticated"
+	// OutcomeAborted is used for a phase abandoned right away because the
+	// one it depends on failed, e.g. waiting for a label change that was
+	// never made.
+	OutcomeAborted Outcome = "aborted"
+)
+
+// Outcomes lists every known outcome class.
+var Outcomes = []Outcome{
+	OutcomeSuccess,
+	OutcomeError,
+	OutcomeTimeout,
+	OutcomeSkipped,
+	OutcomeBudgetExceeded,
+	OutcomeSkippedLocked,
+	OutcomeNamespaceTerminating,
+	OutcomeSkippedPaused,
+	OutcomeThrottled,
+	OutcomeUnauthenticated,
+	OutcomeAborted,
+}
+
+// Skipped reports whether the outcome means the probe did not run at all.
+// Skipped probes are neither successes nor failures.
+func (o Outcome) Skipped() bool {
+	return o == OutcomeSkipped || o == OutcomeSkippedLocked || o == OutcomeSkippedPaused
+}
+
+// Attempted reports whether a probe or phase with the outcome counts toward
+// availability: skipped probes did not run and aborted phases were abandoned
+// before making any request.
+func (o Outcome) Attempted() bool {
+	return !o.Skipped() && o != OutcomeAborted
+}
+
+// Results is the top-level document written by the prober.
+type Results struct {
+	SchemaVersion int `json:"schema_version"`
+	Run           Run `json:"run"`
+}
+
+// Run describes a single invocation of the prober, which may contain one or
+// more probes of different kinds.
+type Run struct {
+	ID       string        `json:"id"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	// Lock is set when the run took the exclusive lock before probing.
+	Lock       *LockWait  `json:"lock,omitempty"`
+	Probes     []Probe    `json:"probes"`
+	Aggregates Aggregates `json:"aggregates"`
+}
+
+// LockWait describes how a run acquired the exclusive lock.
+type LockWait struct {
+	// Duration is the time spent acquiring the lock, including the time
+	// spent waiting for another run to release it.
+	Duration time.Duration `json:"duration"`
+	// Contended is set when the lock was held by another run at first.
+	Contended bool `json:"contended"`
+	// Holder is the last other holder seen while waiting.
+	Holder string `json:"holder,omitempty"`
+}
+
+// Probe is the result of a single probe within a run.
+type Probe struct {
+	Kind       string            `json:"kind"`
+	Outcome    Outcome           `json:"outcome"`
+	Phases     []Phase           `json:"phases"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Errors     []string          `json:"errors,omitempty"`
+
+	// PollTimeline is the sequence of states the probe's wait loop went
+	// through. It is only recorded for failed probes.
+	PollTimeline []PollPeriod `json:"poll_timeline,omitempty"`
+
+	// Events are the key instants of the probe, e.g. when its pod was
+	// scheduled, in chronological order.
+	Events []Event `json:"events,omitempty"`
+}
+
+// Event is a key instant of a probe.
+type Event struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// AddEvent records the named event at t, keeping events in chronological
+// order. A zero t is ignored.
+func (p *Probe) AddEvent(name string, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	i, _ := slices.BinarySearchFunc(p.Events, t, func(e Event, t time.Time) int {
+		return e.Time.Compare(t)
+	})
+	p.Events = slices.Insert(p.Events, i, Event{Name: name, Time: t})
+}
+
+// PollPeriod is a period a wait loop spent in a single state, such as
+// "waiting" or "degraded".
+type PollPeriod struct {
+	State    string        `json:"state"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Phase is a single timed step of a probe.
+type Phase struct {
+	Name     string        `json:"name"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Outcome  Outcome       `json:"outcome"`
+}
+
+// Aggregates summarizes all the probes of a run.
+type Aggregates struct {
+	Probes    int                       `json:"probes"`
+	Succeeded int                       `json:"succeeded"`
+	Failed    int                       `json:"failed"`
+	Skipped   int                       `json:"skipped"`
+	Phases    map[string]PhaseAggregate `json:"phases,omitempty"`
+
+	// KubeletVersions groups the phase aggregates by the kubelet version of
+	// the node each probe ran on, for probes where it is known.
+	KubeletVersions map[string]map[string]PhaseAggregate `json:"kubelet_versions,omitempty"`
+
+	// ImageCache groups the phase aggregates by whether the probe's images
+	// were already present on the node ("true") or had to be pulled
+	// ("false"), so startup latencies aren't blended across both.
+	ImageCache map[string]map[string]PhaseAggregate `json:"image_cache,omitempty"`
+
+	// Availability counts the attempts of every probe kind, keyed by
+	// "<kind>", and of every phase, keyed by "<kind>/<phase>", and how many
+	// of them succeeded. Unlike the phase aggregates, which only summarize
+	// successful phases, it shows error bursts.
+	Availability map[string]Availability `json:"availability,omitempty"`
+}
+
+// Availability is the success ratio of the attempts of a probe kind or phase.
+type Availability struct {
+	Attempts  int `json:"attempts"`
+	Succeeded int `json:"succeeded"`
+	// Ratio is Succeeded over Attempts, 0 without attempts.
+	Ratio float64 `json:"ratio"`
+}
+
+// Add returns the availability updated with one more attempt ending with
+// outcome, unless it wasn't Attempted.
+func (a Availability) Add(outcome Outcome) Availability {
+	if !outcome.Attempted() {
+		return a
+	}
+	a.Attempts++
+	if outcome == OutcomeSuccess {
+		a.Succeeded++
+	}
+	a.Ratio = float64(a.Succeeded) / float64(a.Attempts)
+	return a
+}
+
+// Probe attributes used to group aggregates.
+const (
+	AttrKubeletVersion = "node.kubelet_version"
+	AttrImageCached    = "probe.image.cached"
+)
+
+// PhaseAggregate summarizes every occurrence of a phase, keyed by
+// "<kind>/<phase>" in Aggregates.Phases.
+type PhaseAggregate struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+	Total time.Duration `json:"total"`
+
+	// P50, P90 and P99 are the nearest-rank percentiles of the
+	// occurrences. They are only set in Aggregates.Phases.
+	P50 time.Duration `json:"p50,omitempty"`
+	P90 time.Duration `json:"p90,omitempty"`
+	P99 time.Duration `json:"p99,omitempty"`
+}
+
+// Aggregate recomputes the run-level aggregates from the run's probes.
+func (r *Run) Aggregate() {
+	agg := Aggregates{
+		Probes:          len(r.Probes),
+		Phases:          make(map[string]PhaseAggregate),
+		KubeletVersions: make(map[string]map[string]PhaseAggregate),
+		ImageCache:      make(map[string]map[string]PhaseAggregate),
+		Availability:    make(map[string]Availability),
+	}
+	samples := map[string][]time.Duration{}
+	for _, p := range r.Probes {
+		if p.Outcome == OutcomeSuccess {
+			agg.Succeeded++
+		} else if p.Outcome.Skipped() {
+			agg.Skipped++
+		} else {
+			agg.Failed++
+		}
+		if p.Outcome.Attempted() {
+			agg.Availability[p.Kind] = agg.Availability[p.Kind].Add(p.Outcome)
+		}
+		for _, ph := range p.Phases {
+			key := p.Kind + "/" + ph.Name
+			if ph.Outcome.Attempted() {
+				agg.Availability[key] = agg.Availability[key].Add(ph.Outcome)
+			}
+			if ph.Outcome != OutcomeSuccess {
+				continue
+			}
+			agg.Phases[key] = agg.Phases[key].add(ph.Duration)
+			samples[key] = append(samples[key], ph.Duration)
+			addGrouped(agg.KubeletVersions, p.Attributes[AttrKubeletVersion], key, ph.Duration)
+			addGrouped(agg.ImageCache, p.Attributes[AttrImageCached], key, ph.Duration)
+		}
+	}
+	for key, ds := range samples {
+		slices.Sort(ds)
+		pa := agg.Phases[key]
+		pa.P50, pa.P90, pa.P99 = nearestRank(ds, 0.5), nearestRank(ds, 0.9), nearestRank(ds, 0.99)
+		agg.Phases[key] = pa
+	}
+	r.Aggregates = agg
+}
+
+// nearestRank returns the nearest-rank percentile p of sorted, which must
+// not be empty.
+func nearestRank(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+// addGrouped adds d to the aggregate of phase key in group, unless the
+// probe's group is unknown.
+func addGrouped(groups map[string]map[string]PhaseAggregate, group, key string, d time.Duration) {
+	if group == "" {
+		return
+	}
+	if groups[group] == nil {
+		groups[group] = make(map[string]PhaseAggregate)
+	}
+	groups[group][key] = groups[group][key].add(d)
+}
+
+// add returns the aggregate updated with one more occurrence of d.
+func (pa PhaseAggregate) add(d time.Duration) PhaseAggregate {
+	if pa.Count == 0 || d < pa.Min {
+		pa.Min = d
+	}
+	if d > pa.Max {
+		pa.Max = d
+	}
+	pa.Count++
+	pa.Total += d
+	return pa
+}
+
+// Encode writes the results to w using the requested schema version.
+func (r *Results) Encode(w io.Writer, version int) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	switch version {
+	case 1:
+		return enc.Encode(toV1(r))
+	case SchemaVersion:
+		out := *r
+		out.SchemaVersion = SchemaVersion
+		return enc.Encode(out)
+	default:
+		return fmt.Errorf("unsupported results schema version %d", version)
+	}
+}
+
+// ParseResults reads a results document of any supported schema version and
+// returns it in the current schema.
+func ParseResults(r io.Reader) (*Results, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read results: %w", err)
+	}
+
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %w", err)
+	}
+
+	switch header.SchemaVersion {
+	case 0, 1:
+		var v1 V1
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return nil, fmt.Errorf("failed to decode v1 results: %w", err)
+		}
+		return fromV1(&v1), nil
+	case SchemaVersion:
+		var res Results
+		if err := json.Unmarshal(data, &res); err != nil {
+			return nil, fmt.Errorf("failed to decode v2 results: %w", err)
+		}
+		return &res, nil
+	default:
+		return nil, fmt.Errorf("unsupported results schema version %d", header.SchemaVersion)
+	}
+}
@@ -0,0 +1,232 @@
+package results
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Summary formats.
+const (
+	SummaryJSON = "json"
+	SummaryCSV  = "csv"
+)
+
+// Summary summarizes every run of a prober process, so that CI pipelines
+// can check the results of a batch of runs without a trace backend.
+type Summary struct {
+	Runs      int `json:"runs"`
+	Probes    int `json:"probes"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+	// Outcomes counts the probes by outcome.
+	Outcomes map[Outcome]int `json:"outcomes"`
+	// Phases summarizes every phase, by "<kind>/<phase>" key in order.
+	Phases []PhaseSummary `json:"phases"`
+	// Instances lists every probe in the order they ended.
+	Instances []InstanceSummary `json:"instances"`
+}
+
+// PhaseSummary summarizes the occurrences of a phase across every run. The
+// durations only cover its successful occurrences.
+type PhaseSummary struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+	// Errors is the number of attempts of the phase that didn't succeed.
+	Errors int           `json:"errors"`
+	Min    time.Duration `json:"min"`
+	Mean   time.Duration `json:"mean"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P99    time.Duration `json:"p99"`
+	Max    time.Duration `json:"max"`
+	// FailedInstances are the instance IDs of the probes whose attempt of
+	// the phase didn't succeed.
+	FailedInstances []string `json:"failed_instances,omitempty"`
+}
+
+// InstanceSummary identifies a probe and how it ended.
+type InstanceSummary struct {
+	RunID    string  `json:"run_id"`
+	Instance string  `json:"instance,omitempty"`
+	Kind     string  `json:"kind"`
+	Outcome  Outcome `json:"outcome"`
+	Errors   int     `json:"errors"`
+}
+
+// SummaryBuilder accumulates runs into a Summary. It is not safe for
+// concurrent use.
+type SummaryBuilder struct {
+	summary Summary
+	phases  map[string]*phaseSamples
+}
+
+// phaseSamples are the occurrences of a phase added so far.
+type phaseSamples struct {
+	durations []time.Duration
+	errors    int
+	failed    []string
+}
+
+// NewSummaryBuilder returns a builder without any run.
+func NewSummaryBuilder() *SummaryBuilder {
+	return &SummaryBuilder{
+		summary: Summary{Outcomes: make(map[Outcome]int)},
+		phases:  make(map[string]*phaseSamples),
+	}
+}
+
+// Add adds the probes of run to the summary.
+func (b *SummaryBuilder) Add(run Run) {
+	s := &b.summary
+	s.Runs++
+	for _, p := range run.Probes {
+		s.Probes++
+		s.Outcomes[p.Outcome]++
+		switch {
+		case p.Outcome == OutcomeSuccess:
+			s.Succeeded++
+		case p.Outcome.Skipped():
+			s.Skipped++
+		default:
+			s.Failed++
+		}
+		instance := p.Attributes["instance"]
+		s.Instances = append(s.Instances, InstanceSummary{
+			RunID:    run.ID,
+			Instance: instance,
+			Kind:     p.Kind,
+			Outcome:  p.Outcome,
+			Errors:   len(p.Errors),
+		})
+
+		for _, ph := range p.Phases {
+			if !ph.Outcome.Attempted() {
+				continue
+			}
+			key := p.Kind + "/" + ph.Name
+			samples := b.phases[key]
+			if samples == nil {
+				samples = &phaseSamples{}
+				b.phases[key] = samples
+			}
+			if ph.Outcome == OutcomeSuccess {
+				samples.durations = append(samples.durations, ph.Duration)
+				continue
+			}
+			samples.errors++
+			if instance != "" && !slices.Contains(samples.failed, instance) {
+				samples.failed = append(samples.failed, instance)
+			}
+		}
+	}
+}
+
+// Summary returns the summary of the runs added so far.
+func (b *SummaryBuilder) Summary() Summary {
+	s := b.summary
+	s.Outcomes = maps.Clone(s.Outcomes)
+	s.Instances = slices.Clone(s.Instances)
+	s.Phases = nil
+	for _, key := range slices.Sorted(maps.Keys(b.phases)) {
+		samples := b.phases[key]
+		ps := PhaseSummary{
+			Key:             key,
+			Count:           len(samples.durations),
+			Errors:          samples.errors,
+			FailedInstances: slices.Clone(samples.failed),
+		}
+		if len(samples.durations) > 0 {
+			sorted := slices.Sorted(slices.Values(samples.durations))
+			var total time.Duration
+			for _, d := range sorted {
+				total += d
+			}
+			ps.Min, ps.Max = sorted[0], sorted[len(sorted)-1]
+			ps.Mean = total / time.Duration(len(sorted))
+			ps.P50, ps.P90, ps.P99 = nearestRank(sorted, 0.5), nearestRank(sorted, 0.9), nearestRank(sorted, 0.99)
+		}
+		s.Phases = append(s.Phases, ps)
+	}
+	return s
+}
+
+// SummaryFormat returns the format of the summary written to path, based on
+// its extension: csv for .csv, json otherwise.
+func SummaryFormat(path string) string {
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		return SummaryCSV
+	}
+	return SummaryJSON
+}
+
+// WriteSummary writes s to w in format, either json, the whole summary, or
+// csv, one row per phase with its durations in milliseconds and the
+// instance IDs of its failures separated by spaces.
+func WriteSummary(w io.Writer, s Summary, format string) error {
+	switch format {
+	case SummaryJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case SummaryCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"kind", "phase", "count", "errors", "min_ms", "mean_ms", "p50_ms", "p90_ms", "p99_ms", "max_ms", "failed_instances"})
+		for _, ps := range s.Phases {
+			kind, phase, _ := strings.Cut(ps.Key, "/")
+			cw.Write([]string{
+				kind, phase, strconv.Itoa(ps.Count), strconv.Itoa(ps.Errors),
+				formatMS(ps.Min), formatMS(ps.Mean), formatMS(ps.P50), formatMS(ps.P90), formatMS(ps.P99), formatMS(ps.Max),
+				strings.Join(ps.FailedInstances, " "),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown summary format %q", format)
+	}
+}
+
+// WriteSummaryText writes s to w as human-readable tables: the phases, then
+// the probes that didn't succeed.
+func WriteSummaryText(w io.Writer, s Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Runs: %d, probes: %d (%d succeeded, %d failed, %d skipped)\n\n", s.Runs, s.Probes, s.Succeeded, s.Failed, s.Skipped)
+	fmt.Fprintln(tw, "PHASE\tN\tERRORS\tMIN\tMEAN\tP50\tP90\tP99\tMAX\t")
+	for _, ps := range s.Phases {
+		d := func(d time.Duration) string {
+			if ps.Count == 0 {
+				return "-"
+			}
+			return formatDuration(d)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n", ps.Key, ps.Count, ps.Errors,
+			d(ps.Min), d(ps.Mean), d(ps.P50), d(ps.P90), d(ps.P99), d(ps.Max))
+	}
+	var failed []InstanceSummary
+	for _, in := range s.Instances {
+		if in.Outcome != OutcomeSuccess && !in.Outcome.Skipped() {
+			failed = append(failed, in)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintln(tw, "\nRUN\tINSTANCE\tKIND\tOUTCOME\tERRORS\t")
+		for _, in := range failed {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t\n", in.RunID, in.Instance, in.Kind, in.Outcome, in.Errors)
+		}
+	}
+	return tw.Flush()
+}
+
+// formatMS formats d in milliseconds.
+func formatMS(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64)
+}
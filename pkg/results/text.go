@@ -0,0 +1,53 @@
+package results
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// barWidth is the width of the bars of WriteRunText at 100%.
+const barWidth = 20
+
+// WriteRunText writes r to w as a human-readable breakdown of the phases of
+// each of its probes, with the share of the probe's time each took, followed
+// by the errors of the probes that didn't succeed.
+func WriteRunText(w io.Writer, r Run) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	a := r.Aggregates
+	fmt.Fprintf(tw, "Run %s, %s: %d probes (%d succeeded, %d failed, %d skipped)\n", r.ID, formatDuration(r.Duration), a.Probes, a.Succeeded, a.Failed, a.Skipped)
+	for _, p := range r.Probes {
+		fmt.Fprintf(tw, "\n%s: %s", p.Kind, p.Outcome)
+		if ns := p.Attributes["namespace"]; ns != "" {
+			fmt.Fprintf(tw, " (namespace %s)", ns)
+		}
+		fmt.Fprintln(tw)
+		if len(p.Phases) > 0 {
+			var total time.Duration
+			for _, ph := range p.Phases {
+				total += ph.Duration
+			}
+			fmt.Fprintln(tw, "  PHASE\tDURATION\tSHARE\t\tOUTCOME\t")
+			for _, ph := range p.Phases {
+				var share float64
+				if total > 0 {
+					share = float64(ph.Duration) / float64(total)
+				}
+				fmt.Fprintf(tw, "  %s\t%s\t%.1f%%\t%s\t%s\t\n", ph.Name, formatDuration(ph.Duration), 100*share, bar(share), ph.Outcome)
+			}
+			fmt.Fprintf(tw, "  total\t%s\t\t\t\t\n", formatDuration(total))
+		}
+		for _, err := range p.Errors {
+			fmt.Fprintf(tw, "  error: %s\n", err)
+		}
+	}
+	return tw.Flush()
+}
+
+// bar renders share, between 0 and 1, as a bar of up to barWidth blocks.
+func bar(share float64) string {
+	n := int(share*barWidth + 0.5)
+	return strings.Repeat("█", n) + strings.Repeat("·", barWidth-n)
+}
@@ -0,0 +1,151 @@
+package results
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Timeline formats.
+const (
+	TimelineSVG  = "svg"
+	TimelineHTML = "html"
+)
+
+// Timeline layout, in pixels.
+const (
+	timelineLabelWidth = 260
+	timelineBarWidth   = 700
+	timelineRowHeight  = 22
+	timelineMargin     = 10
+)
+
+// WriteTimeline renders the phases and events of every probe of run as a
+// horizontal timeline, either a standalone SVG image or an HTML page
+// embedding it. The output only depends on run and references no external
+// assets.
+func WriteTimeline(w io.Writer, run Run, format string) error {
+	if format != TimelineSVG && format != TimelineHTML {
+		return fmt.Errorf("unknown timeline format %q", format)
+	}
+
+	bw := bufio.NewWriter(w)
+	if format == TimelineHTML {
+		fmt.Fprintf(bw, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Run %s</title>\n</head>\n<body style=\"font-family: sans-serif\">\n", html.EscapeString(run.ID))
+		fmt.Fprintf(bw, "<h1>Run %s</h1>\n<p>Started %s, took %s.</p>\n", html.EscapeString(run.ID), run.Start.UTC().Format(time.RFC3339), formatDuration(run.Duration))
+	}
+	writeTimelineSVG(bw, run)
+	if format == TimelineHTML {
+		fmt.Fprint(bw, "</body>\n</html>\n")
+	}
+	return bw.Flush()
+}
+
+func writeTimelineSVG(w io.Writer, run Run) {
+	total := run.Duration
+	for _, p := range run.Probes {
+		for _, ph := range p.Phases {
+			total = max(total, ph.Start.Add(ph.Duration).Sub(run.Start))
+		}
+		for _, ev := range p.Events {
+			total = max(total, ev.Time.Sub(run.Start))
+		}
+	}
+	if total <= 0 {
+		total = time.Millisecond
+	}
+	x := func(t time.Time) float64 {
+		offset := min(max(t.Sub(run.Start), 0), total)
+		return timelineLabelWidth + float64(offset)/float64(total)*timelineBarWidth
+	}
+
+	rows := 0
+	for _, p := range run.Probes {
+		rows += 1 + len(p.Phases)
+		if len(p.Events) > 0 {
+			rows += 2
+		}
+	}
+	width := timelineLabelWidth + timelineBarWidth + 2*timelineMargin
+	height := (rows+1)*timelineRowHeight + 2*timelineMargin
+
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"12\">\n", width, height)
+	fmt.Fprintf(w, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", width, height)
+
+	// Axis, from the run's start to its end
+	y := timelineMargin + timelineRowHeight/2
+	fmt.Fprintf(w, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#888\"/>\n", timelineLabelWidth, y, timelineLabelWidth+timelineBarWidth, y)
+	fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\" fill=\"#555\">0</text>\n", timelineLabelWidth, y-4)
+	fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\" fill=\"#555\" text-anchor=\"end\">%s</text>\n", timelineLabelWidth+timelineBarWidth, y-4, formatDuration(total))
+
+	y = timelineMargin + timelineRowHeight
+	for _, p := range run.Probes {
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\" font-weight=\"bold\">%s (%s)</text>\n", timelineMargin, y+15, html.EscapeString(p.Kind), html.EscapeString(string(p.Outcome)))
+		y += timelineRowHeight
+
+		for _, ph := range p.Phases {
+			x1, x2 := x(ph.Start), x(ph.Start.Add(ph.Duration))
+			fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\">%s %s</text>\n", timelineMargin, y+15, html.EscapeString(ph.Name), formatDuration(ph.Duration))
+			fmt.Fprintf(w, "<rect x=\"%.1f\" y=\"%d\" width=\"%.1f\" height=\"%d\" fill=\"%s\"><title>%s: %s</title></rect>\n",
+				x1, y+3, max(x2-x1, 1), timelineRowHeight-6, outcomeColor(ph.Outcome), html.EscapeString(ph.Name), formatDuration(ph.Duration))
+			y += timelineRowHeight
+		}
+
+		if len(p.Events) == 0 {
+			continue
+		}
+		events := slices.Clone(p.Events)
+		slices.SortStableFunc(events, func(a, b Event) int { return a.Time.Compare(b.Time) })
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\">events</text>\n", timelineMargin, y+15)
+		for i, ev := range events {
+			ex := x(ev.Time)
+			// Alternate label heights so that close events stay readable
+			ly := y + 15 + (i%2)*timelineRowHeight
+			fmt.Fprintf(w, "<line x1=\"%.1f\" y1=\"%d\" x2=\"%.1f\" y2=\"%d\" stroke=\"#333\"/>\n", ex, y+2, ex, ly-10)
+			// Labels of late events extend to their left to stay in the image
+			lx, anchor := ex+2, "start"
+			if ex > timelineLabelWidth+timelineBarWidth*3/4 {
+				lx, anchor = ex-2, "end"
+			}
+			fmt.Fprintf(w, "<text x=\"%.1f\" y=\"%d\" fill=\"#333\" text-anchor=\"%s\">%s +%s</text>\n", lx, ly, anchor, html.EscapeString(ev.Name), formatDuration(ev.Time.Sub(run.Start)))
+		}
+		y += 2 * timelineRowHeight
+	}
+	fmt.Fprint(w, "</svg>\n")
+}
+
+func outcomeColor(o Outcome) string {
+	switch {
+	case o == OutcomeSuccess:
+		return "#4c9f70"
+	case o.Skipped():
+		return "#aaaaaa"
+	default:
+		return "#d9534f"
+	}
+}
+
+// formatDuration rounds d for display.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	default:
+		return d.String()
+	}
+}
+
+// TimelineFormat returns the timeline format matching the extension of path,
+// HTML unless it ends with ".svg".
+func TimelineFormat(path string) string {
+	if strings.HasSuffix(strings.ToLower(path), ".svg") {
+		return TimelineSVG
+	}
+	return TimelineHTML
+}
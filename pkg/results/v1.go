@@ -0,0 +1,111 @@
+package results
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// V1 is the original flat results schema.
+//
+// Deprecated: V1 is only written when explicitly requested and will be
+// removed once downstream tooling has migrated to the current schema.
+type V1 struct {
+	SchemaVersion int                      `json:"schema_version"`
+	Instance      string                   `json:"instance"`
+	Start         time.Time                `json:"start"`
+	Duration      time.Duration            `json:"duration"`
+	Success       bool                     `json:"success"`
+	Skipped       bool                     `json:"skipped,omitempty"`
+	Phases        map[string]time.Duration `json:"phases"`
+	Errors        []string                 `json:"errors,omitempty"`
+}
+
+// toV1 flattens the results. Phase names are prefixed with the probe kind
+// when the run contains more than one probe, to keep the keys unique.
+func toV1(r *Results) *V1 {
+	v1 := &V1{
+		SchemaVersion: 1,
+		Instance:      r.Run.ID,
+		Start:         r.Run.Start,
+		Duration:      r.Run.Duration,
+		Success:       true,
+		Phases:        make(map[string]time.Duration),
+	}
+
+	multi := len(r.Run.Probes) > 1
+	for _, p := range r.Run.Probes {
+		if p.Outcome.Skipped() {
+			v1.Skipped = true
+		} else if p.Outcome != OutcomeSuccess {
+			v1.Success = false
+		}
+		for _, ph := range p.Phases {
+			key := ph.Name
+			if multi {
+				key = p.Kind + "." + ph.Name
+			}
+			v1.Phases[key] = ph.Duration
+		}
+		v1.Errors = append(v1.Errors, p.Errors...)
+	}
+
+	return v1
+}
+
+// fromV1 upgrades a v1 document to the current schema. The v1 schema did not
+// record probe kinds or phase start times, so those are left empty.
+func fromV1(v1 *V1) *Results {
+	outcome := OutcomeSuccess
+	if !v1.Success {
+		outcome = OutcomeError
+	} else if v1.Skipped {
+		outcome = OutcomeSkipped
+	}
+
+	names := make([]string, 0, len(v1.Phases))
+	for name := range v1.Phases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	probes := map[string]*Probe{}
+	var order []string
+	for _, name := range names {
+		kind, phase := "", name
+		if k, p, ok := strings.Cut(name, "."); ok {
+			kind, phase = k, p
+		}
+		p, ok := probes[kind]
+		if !ok {
+			p = &Probe{Kind: kind, Outcome: outcome}
+			probes[kind] = p
+			order = append(order, kind)
+		}
+		p.Phases = append(p.Phases, Phase{
+			Name:     phase,
+			Duration: v1.Phases[name],
+			Outcome:  OutcomeSuccess,
+		})
+	}
+	if len(order) == 0 {
+		probes[""] = &Probe{Outcome: outcome}
+		order = append(order, "")
+	}
+	probes[order[0]].Errors = v1.Errors
+
+	res := &Results{
+		SchemaVersion: SchemaVersion,
+		Run: Run{
+			ID:       v1.Instance,
+			Start:    v1.Start,
+			Duration: v1.Duration,
+		},
+	}
+	for _, kind := range order {
+		res.Run.Probes = append(res.Run.Probes, *probes[kind])
+	}
+	res.Run.Aggregate()
+
+	return res
+}
@@ -0,0 +1,287 @@
+// Package store persists probe results to a local SQLite database, for
+// trend analysis on clusters without an observability stack.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the sqlite driver
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// attrInstance is the probe attribute holding its instance ID.
+const attrInstance = "instance"
+
+// schema creates the tables, if they don't exist yet. Times are Unix
+// milliseconds, durations milliseconds.
+const schema = `
+CREATE TABLE IF NOT EXISTS probes (
+	run_id   TEXT NOT NULL,
+	instance TEXT NOT NULL,
+	kind     TEXT NOT NULL,
+	outcome  TEXT NOT NULL,
+	start    INTEGER NOT NULL,
+	node     TEXT NOT NULL,
+	zone     TEXT NOT NULL,
+	errors   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS probes_kind_start ON probes (kind, start);
+CREATE TABLE IF NOT EXISTS phases (
+	run_id      TEXT NOT NULL,
+	instance    TEXT NOT NULL,
+	kind        TEXT NOT NULL,
+	phase       TEXT NOT NULL,
+	start       INTEGER NOT NULL,
+	duration_ms REAL NOT NULL,
+	outcome     TEXT NOT NULL,
+	node        TEXT NOT NULL,
+	zone        TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS phases_kind_phase_start ON phases (kind, phase, start);
+`
+
+// Store records the results of probe runs in a SQLite database.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it and its tables if needed.
+func Open(path string) (*Store, error) {
+	// Runs of several probes may end at once, wait on each other's writes
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results store %s: %w", path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create results store tables in %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Record adds every probe of run and their phases to the store.
+func (s *Store) Record(ctx context.Context, run results.Run) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, pr := range run.Probes {
+		instance, node, zone := pr.Attributes[attrInstance], probeNode(pr), pr.Attributes[probe.AttrZone]
+		start := run.Start
+		if len(pr.Phases) > 0 {
+			start = pr.Phases[0].Start
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO probes (run_id, instance, kind, outcome, start, node, zone, errors) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			run.ID, instance, pr.Kind, string(pr.Outcome), start.UnixMilli(), node, zone, strings.Join(pr.Errors, "\n"),
+		); err != nil {
+			return fmt.Errorf("failed to record probe: %w", err)
+		}
+		for _, ph := range pr.Phases {
+			phaseStart := ph.Start
+			if phaseStart.IsZero() {
+				phaseStart = start
+			}
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO phases (run_id, instance, kind, phase, start, duration_ms, outcome, node, zone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				run.ID, instance, pr.Kind, ph.Name, phaseStart.UnixMilli(), durationMS(ph.Duration), string(ph.Outcome), node, zone,
+			); err != nil {
+				return fmt.Errorf("failed to record phase: %w", err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// probeNode returns the node a probe ran on, if known.
+func probeNode(pr results.Probe) string {
+	if node := pr.Attributes[probe.AttrNodeName]; node != "" {
+		return node
+	}
+	return pr.Attributes["node"]
+}
+
+// Prune deletes the probes and phases that started before t, and returns
+// the number of probes deleted.
+func (s *Store) Prune(ctx context.Context, t time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `DELETE FROM probes WHERE start < ?`, t.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM phases WHERE start < ?`, t.UnixMilli()); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, tx.Commit()
+}
+
+// TrendOptions selects the phases a trend covers.
+type TrendOptions struct {
+	// Kind and Phase, if set, restrict the trend to a probe kind and phase.
+	Kind  string
+	Phase string
+	// Node, if set, restricts the trend to the probes that ran on a node.
+	Node string
+	// Since is the start of the trend, the first bucket starting at Since
+	// truncated to Bucket.
+	Since time.Time
+	// Bucket is the duration each row of the trend covers.
+	Bucket time.Duration
+}
+
+// TrendRow summarizes the occurrences of a phase within a bucket. Durations
+// only cover its successful occurrences.
+type TrendRow struct {
+	Bucket time.Time `json:"bucket"`
+	Kind   string    `json:"kind"`
+	Phase  string    `json:"phase"`
+	Count  int       `json:"count"`
+	// Errors is the number of attempts of the phase that didn't succeed.
+	Errors int           `json:"errors"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P99    time.Duration `json:"p99"`
+	Max    time.Duration `json:"max"`
+}
+
+// Trend returns the percentiles and error counts of every phase selected by
+// opts, by bucket, ordered by kind, phase and bucket.
+func (s *Store) Trend(ctx context.Context, opts TrendOptions) ([]TrendRow, error) {
+	if opts.Bucket <= 0 {
+		return nil, errors.New("trend bucket must be positive")
+	}
+	query := `SELECT kind, phase, start, duration_ms, outcome FROM phases WHERE start >= ?`
+	args := []any{opts.Since.Truncate(opts.Bucket).UnixMilli()}
+	for column, value := range map[string]string{"kind": opts.Kind, "phase": opts.Phase, "node": opts.Node} {
+		if value != "" {
+			query += " AND " + column + " = ?"
+			args = append(args, value)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY kind, phase, start`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		trend     []TrendRow
+		durations []time.Duration
+	)
+	flush := func() {
+		if len(trend) == 0 {
+			return
+		}
+		row := &trend[len(trend)-1]
+		if len(durations) > 0 {
+			slices.Sort(durations)
+			row.P50, row.P90, row.P99 = nearestRank(durations, 0.5), nearestRank(durations, 0.9), nearestRank(durations, 0.99)
+			row.Max = durations[len(durations)-1]
+		}
+		durations = durations[:0]
+	}
+	for rows.Next() {
+		var (
+			kind, phase, outcome string
+			start                int64
+			ms                   float64
+		)
+		if err := rows.Scan(&kind, &phase, &start, &ms, &outcome); err != nil {
+			return nil, err
+		}
+		if !results.Outcome(outcome).Attempted() {
+			continue
+		}
+		bucket := time.UnixMilli(start).Truncate(opts.Bucket)
+		if n := len(trend); n == 0 || trend[n-1].Kind != kind || trend[n-1].Phase != phase || !trend[n-1].Bucket.Equal(bucket) {
+			flush()
+			trend = append(trend, TrendRow{Bucket: bucket, Kind: kind, Phase: phase})
+		}
+		row := &trend[len(trend)-1]
+		if results.Outcome(outcome) != results.OutcomeSuccess {
+			row.Errors++
+			continue
+		}
+		row.Count++
+		durations = append(durations, time.Duration(ms*float64(time.Millisecond)))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return trend, nil
+}
+
+// Query runs a read-only SQL query, e.g. to aggregate results in ways
+// Trend doesn't, and returns its column names and rows, formatted as text.
+func (s *Store) Query(ctx context.Context, query string, args ...any) ([]string, [][]string, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `PRAGMA query_only = ON`); err != nil {
+		return nil, nil, err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), `PRAGMA query_only = OFF`)
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var out [][]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		row := make([]string, len(columns))
+		for i, v := range values {
+			row[i] = v.String
+		}
+		out = append(out, row)
+	}
+	return columns, out, rows.Err()
+}
+
+// durationMS returns d in milliseconds.
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// nearestRank returns the nearest-rank percentile p of sorted, which must
+// not be empty.
+func nearestRank(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
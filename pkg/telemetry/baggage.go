@@ -0,0 +1,82 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Baggage keys identifying the probe a span belongs to.
+const (
+	BaggageInstanceID = "probe.instance_id"
+	BaggageRunID      = "probe.run_id"
+	BaggageKind       = "probe.kind"
+	// BaggageCluster is the cluster probed, when a prober probes several.
+	BaggageCluster = "k8s.cluster.name"
+)
+
+// DefaultBaggageKeys are the baggage entries copied onto every span.
+var DefaultBaggageKeys = []string{
+	BaggageInstanceID,
+	BaggageRunID,
+	BaggageKind,
+	BaggageCluster,
+}
+
+// DefaultMetricBaggageKeys are the baggage entries added to the attributes
+// of every measurement. Unlike those of spans, they must have a low
+// cardinality.
+var DefaultMetricBaggageKeys = []string{
+	BaggageCluster,
+}
+
+// ContextWithBaggage returns a copy of ctx whose baggage also carries the
+// given key-value pairs, overriding any existing members with the same key.
+func ContextWithBaggage(ctx context.Context, kv map[string]string) (context.Context, error) {
+	b := baggage.FromContext(ctx)
+	for k, v := range kv {
+		m, err := baggage.NewMemberRaw(k, v)
+		if err != nil {
+			return ctx, fmt.Errorf("invalid baggage member %q: %w", k, err)
+		}
+		b, err = b.SetMember(m)
+		if err != nil {
+			return ctx, fmt.Errorf("failed to set baggage member %q: %w", k, err)
+		}
+	}
+	return baggage.ContextWithBaggage(ctx, b), nil
+}
+
+// BaggageSpanProcessor copies selected baggage entries from the parent
+// context onto every span as it starts, so spans created anywhere within a
+// run carry the probe's identity without the call site doing anything.
+type BaggageSpanProcessor struct {
+	keys []string
+}
+
+var _ sdktrace.SpanProcessor = (*BaggageSpanProcessor)(nil)
+
+// NewBaggageSpanProcessor returns a processor copying the given baggage keys.
+func NewBaggageSpanProcessor(keys ...string) *BaggageSpanProcessor {
+	return &BaggageSpanProcessor{keys: keys}
+}
+
+func (p *BaggageSpanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	b := baggage.FromContext(parent)
+	for _, k := range p.keys {
+		m := b.Member(k)
+		if m.Key() == "" {
+			continue
+		}
+		s.SetAttributes(attribute.String(k, m.Value()))
+	}
+}
+
+func (p *BaggageSpanProcessor) OnEnd(sdktrace.ReadOnlySpan) {}
+
+func (p *BaggageSpanProcessor) Shutdown(context.Context) error { return nil }
+
+func (p *BaggageSpanProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,117 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// roundTripperFunc is an http.RoundTripper calling itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// spanAttributes returns the attributes of the exported span named name.
+func spanAttributes(t *testing.T, spans tracetest.SpanStubs, name string) map[attribute.Key]string {
+	t.Helper()
+	for _, s := range spans {
+		if s.Name != name {
+			continue
+		}
+		attrs := map[attribute.Key]string{}
+		for _, kv := range s.Attributes {
+			attrs[kv.Key] = kv.Value.Emit()
+		}
+		return attrs
+	}
+	t.Fatalf("no span named %q in %v", name, spans)
+	return nil
+}
+
+func TestBaggageSpanProcessor(t *testing.T) {
+	providers, spans, _ := setupTest(t, Config{ServiceName: "k8s-latency-probe"})
+	tracer := providers.TracerProvider.Tracer("test")
+
+	ctx, err := ContextWithBaggage(context.Background(), map[string]string{
+		BaggageInstanceID: "abc123",
+		BaggageRunID:      "run-1",
+		BaggageKind:       "pod",
+		"unrelated":       "dropped",
+	})
+	if err != nil {
+		t.Fatalf("ContextWithBaggage() error = %v", err)
+	}
+
+	ctx, run := tracer.Start(ctx, "run")
+	done := make(chan struct{})
+	go func() {
+		// As in the wait loop: a goroutine given the run's context starting
+		// spans of its own, and making requests within them
+		defer close(done)
+		ctx, wait := tracer.Start(ctx, "wait")
+		defer wait.End()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://apiserver/api/v1/namespaces/default/pods", nil)
+		rt := NewRequestTracer(providers.TracerProvider).Wrap(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}))
+		if _, err := rt.RoundTrip(req); err != nil {
+			t.Errorf("RoundTrip() error = %v", err)
+		}
+	}()
+	<-done
+	run.End()
+	if err := providers.TracerProvider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush() error = %v", err)
+	}
+
+	for _, name := range []string{"run", "wait", "GET /api/v1/namespaces/{namespace}/pods"} {
+		attrs := spanAttributes(t, spans.GetSpans(), name)
+		want := map[attribute.Key]string{BaggageInstanceID: "abc123", BaggageRunID: "run-1", BaggageKind: "pod"}
+		for k, v := range want {
+			if attrs[k] != v {
+				t.Errorf("span %q attribute %s = %q, want %q", name, k, attrs[k], v)
+			}
+		}
+		if _, ok := attrs["unrelated"]; ok {
+			t.Errorf("span %q carries the unrelated baggage entry", name)
+		}
+	}
+}
+
+func TestBaggageSpanProcessorWithoutBaggage(t *testing.T) {
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(NewBaggageSpanProcessor(DefaultBaggageKeys...)),
+		sdktrace.WithSyncer(spans),
+	)
+	_, span := tp.Tracer("test").Start(context.Background(), "reap")
+	span.End()
+
+	if attrs := spanAttributes(t, spans.GetSpans(), "reap"); len(attrs) != 0 {
+		t.Errorf("span attributes = %v, want none", attrs)
+	}
+}
+
+func TestContextWithBaggageOverrides(t *testing.T) {
+	ctx, err := ContextWithBaggage(context.Background(), map[string]string{BaggageKind: "pod", BaggageRunID: "run-1"})
+	if err != nil {
+		t.Fatalf("ContextWithBaggage() error = %v", err)
+	}
+	ctx, err = ContextWithBaggage(ctx, map[string]string{BaggageKind: "dns"})
+	if err != nil {
+		t.Fatalf("ContextWithBaggage() error = %v", err)
+	}
+
+	b := baggage.FromContext(ctx)
+	if got := b.Member(BaggageKind).Value(); got != "dns" {
+		t.Errorf("%s = %q, want dns", BaggageKind, got)
+	}
+	if got := b.Member(BaggageRunID).Value(); got != "run-1" {
+		t.Errorf("%s = %q, want run-1", BaggageRunID, got)
+	}
+}
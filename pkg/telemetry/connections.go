@@ -0,0 +1,135 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ConnectionTracer records the connection-level timing of every request made
+// to the API server, from net/http/httptrace, as child spans of the span in
+// the request's context: "http.dns" for name resolution, "http.connect" for
+// each TCP connection attempt, "http.tls" for the TLS handshake and
+// "http.ttfb" from the request being written to the first byte of the
+// response, i.e. the server's processing plus a round trip. Requests reusing
+// a connection only get the latter, the span in their context then carrying
+// the http.connection.reused attribute, so that network and TLS problems
+// can be told apart from the server's processing time.
+type ConnectionTracer struct {
+	tracer trace.Tracer
+}
+
+// NewConnectionTracer starts the connection spans with a tracer of tp.
+func NewConnectionTracer(tp trace.TracerProvider) *ConnectionTracer {
+	return &ConnectionTracer{tracer: tp.Tracer("k8s-latency-probe/connections")}
+}
+
+// Wrap wraps rt, it can be used as a rest.Config's WrapTransport, wrapped by
+// the RequestTracer so that the connection spans are children of the
+// request's.
+func (t *ConnectionTracer) Wrap(rt http.RoundTripper) http.RoundTripper {
+	return connectionTransport{tracer: t.tracer, next: rt}
+}
+
+type connectionTransport struct {
+	tracer trace.Tracer
+	next   http.RoundTripper
+}
+
+func (t connectionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	ct := newConnectionTrace(ctx, t.tracer)
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, ct.clientTrace())))
+}
+
+// connectionTrace records the spans of one request's connection. Its hooks
+// may be called concurrently, e.g. when dialing several addresses at once.
+type connectionTrace struct {
+	ctx    context.Context
+	tracer trace.Tracer
+
+	mu       sync.Mutex
+	dnsStart time.Time
+	connects map[string]time.Time
+	tlsStart time.Time
+	wrote    time.Time
+}
+
+func newConnectionTrace(ctx context.Context, tracer trace.Tracer) *connectionTrace {
+	return &connectionTrace{ctx: ctx, tracer: tracer, connects: map[string]time.Time{}}
+}
+
+// span records a span from start to now, failed with err if not nil.
+func (c *connectionTrace) span(name string, start time.Time, err error, attrs ...attribute.KeyValue) {
+	_, span := c.tracer.Start(c.ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (c *connectionTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			trace.SpanFromContext(c.ctx).SetAttributes(attribute.Bool("http.connection.reused", info.Reused))
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.dnsStart = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			c.mu.Lock()
+			start := c.dnsStart
+			c.mu.Unlock()
+			c.span("http.dns", start, info.Err, attribute.Int("dns.addresses", len(info.Addrs)))
+		},
+		ConnectStart: func(_, addr string) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.connects[addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			c.mu.Lock()
+			start := c.connects[addr]
+			c.mu.Unlock()
+			c.span("http.connect", start, err,
+				attribute.String("network.transport", network),
+				attribute.String("network.peer.address", addr),
+			)
+		},
+		TLSHandshakeStart: func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			c.mu.Lock()
+			start := c.tlsStart
+			c.mu.Unlock()
+			c.span("http.tls", start, err,
+				attribute.String("tls.protocol.version", tls.VersionName(state.Version)),
+				attribute.Bool("tls.resumed", state.DidResume),
+			)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.wrote = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			c.mu.Lock()
+			start := c.wrote
+			c.mu.Unlock()
+			c.span("http.ttfb", start, nil)
+		},
+	}
+}
@@ -0,0 +1,89 @@
+package telemetry
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EventLimiter bounds the number of span events recorded for a repeated
+// occurrence, such as a poll attempt in a tight loop. The first Burst
+// occurrences are recorded verbatim; afterwards occurrences are counted and a
+// single aggregated event is recorded per Window, carrying the attributes of
+// the last suppressed occurrence.
+type EventLimiter struct {
+	name   string
+	burst  int
+	window time.Duration
+	now    func() time.Time
+
+	mu         sync.Mutex
+	seen       int
+	suppressed int
+	lastFlush  time.Time
+	lastAttrs  []attribute.KeyValue
+}
+
+// NewEventLimiter returns a limiter for events with the given name. A window
+// of zero aggregates every suppressed occurrence until Flush is called.
+func NewEventLimiter(name string, burst int, window time.Duration) *EventLimiter {
+	return &EventLimiter{
+		name:   name,
+		burst:  burst,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// Record records one occurrence of the event on span.
+func (l *EventLimiter) Record(span trace.Span, attrs ...attribute.KeyValue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seen++
+	if l.seen <= l.burst {
+		span.AddEvent(l.name, trace.WithAttributes(attrs...))
+		l.lastFlush = l.now()
+		return
+	}
+
+	l.suppressed++
+	l.lastAttrs = attrs
+	if l.window > 0 && l.now().Sub(l.lastFlush) >= l.window {
+		l.flush(span)
+	}
+}
+
+// Flush records an aggregated event for any occurrences suppressed since the
+// last one. It should be called before span ends.
+func (l *EventLimiter) Flush(span trace.Span) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flush(span)
+}
+
+// Count returns the total number of occurrences recorded so far, including
+// suppressed ones.
+func (l *EventLimiter) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seen
+}
+
+func (l *EventLimiter) flush(span trace.Span) {
+	l.lastFlush = l.now()
+	if l.suppressed == 0 {
+		return
+	}
+
+	attrs := append([]attribute.KeyValue{
+		attribute.Int("event.suppressed", l.suppressed),
+		attribute.Int("event.total", l.seen),
+	}, l.lastAttrs...)
+	span.AddEvent(fmt.Sprintf("%d more %s", l.suppressed, l.name), trace.WithAttributes(attrs...))
+	l.suppressed = 0
+	l.lastAttrs = nil
+}
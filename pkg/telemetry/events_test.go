@@ -0,0 +1,113 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordEvents starts a span, calls fn with it, ends it and returns the
+// events it recorded.
+func recordEvents(t *testing.T, fn func(trace.Span)) []sdktrace.Event {
+	t.Helper()
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	_, span := tp.Tracer("test").Start(context.Background(), "wait")
+	fn(span)
+	span.End()
+	return spans.GetSpans()[0].Events
+}
+
+// eventAttr returns the value of the attribute key of e.
+func eventAttr(e sdktrace.Event, key attribute.Key) attribute.Value {
+	for _, kv := range e.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestEventLimiterBurst(t *testing.T) {
+	l := NewEventLimiter("poll attempt", 3, 0)
+	events := recordEvents(t, func(span trace.Span) {
+		for i := range 10 {
+			l.Record(span, attribute.Int("attempt", i))
+		}
+		l.Flush(span)
+	})
+
+	if len(events) != 4 {
+		t.Fatalf("recorded %d events, want 3 verbatim and 1 aggregated: %v", len(events), events)
+	}
+	for i, e := range events[:3] {
+		if e.Name != "poll attempt" || eventAttr(e, "attempt").AsInt64() != int64(i) {
+			t.Errorf("event %d = %s %v, want poll attempt %d", i, e.Name, e.Attributes, i)
+		}
+	}
+	agg := events[3]
+	if agg.Name != "7 more poll attempt" {
+		t.Errorf("aggregated event name = %q, want 7 more poll attempt", agg.Name)
+	}
+	if got := eventAttr(agg, "event.suppressed").AsInt64(); got != 7 {
+		t.Errorf("event.suppressed = %d, want 7", got)
+	}
+	if got := eventAttr(agg, "event.total").AsInt64(); got != 10 {
+		t.Errorf("event.total = %d, want 10", got)
+	}
+	// The attributes of the last suppressed occurrence
+	if got := eventAttr(agg, "attempt").AsInt64(); got != 9 {
+		t.Errorf("aggregated attempt = %d, want 9", got)
+	}
+	if got := l.Count(); got != 10 {
+		t.Errorf("Count() = %d, want 10", got)
+	}
+}
+
+func TestEventLimiterWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewEventLimiter("poll attempt", 1, time.Second)
+	l.now = func() time.Time { return now }
+
+	events := recordEvents(t, func(span trace.Span) {
+		// One attempt every 100ms for 2.5s: the first is recorded, then an
+		// aggregate for each full second
+		for range 26 {
+			l.Record(span)
+			now = now.Add(100 * time.Millisecond)
+		}
+		l.Flush(span)
+	})
+
+	var names []string
+	for _, e := range events {
+		names = append(names, e.Name)
+	}
+	want := []string{"poll attempt", "10 more poll attempt", "10 more poll attempt", "5 more poll attempt"}
+	if len(names) != len(want) {
+		t.Fatalf("events = %q, want %q", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("events = %q, want %q", names, want)
+			break
+		}
+	}
+}
+
+func TestEventLimiterFlushWithoutSuppressed(t *testing.T) {
+	l := NewEventLimiter("poll attempt", 5, time.Second)
+	events := recordEvents(t, func(span trace.Span) {
+		l.Record(span)
+		l.Record(span)
+		l.Flush(span)
+	})
+	if len(events) != 2 {
+		t.Errorf("recorded %d events, want the 2 verbatim ones", len(events))
+	}
+}
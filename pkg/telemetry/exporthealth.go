@@ -0,0 +1,84 @@
+package telemetry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ExportHealth tracks the outcome of the latest exports of the OTLP
+// exporters. A nil *ExportHealth, when exporting is disabled, is always
+// healthy.
+type ExportHealth struct {
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastErr     error
+}
+
+// ExportStatus is a snapshot of an ExportHealth.
+type ExportStatus struct {
+	// Healthy is false when the latest export failed.
+	Healthy     bool      `json:"healthy"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	LastFailure time.Time `json:"last_failure,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+func (h *ExportHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.lastFailure, h.lastErr = time.Now(), err
+		return
+	}
+	h.lastSuccess = time.Now()
+}
+
+// Status returns the outcome of the latest exports.
+func (h *ExportHealth) Status() ExportStatus {
+	if h == nil {
+		return ExportStatus{Healthy: true}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := ExportStatus{
+		Healthy:     !h.lastSuccess.Before(h.lastFailure),
+		LastSuccess: h.lastSuccess,
+		LastFailure: h.lastFailure,
+	}
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+	return s
+}
+
+// healthSpanExporter records the outcome of each export of the wrapped
+// exporter.
+type healthSpanExporter struct {
+	sdktrace.SpanExporter
+	health *ExportHealth
+}
+
+func (e healthSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	e.health.record(err)
+	return err
+}
+
+// healthMetricExporter records the outcome of each export of the wrapped
+// exporter.
+type healthMetricExporter struct {
+	sdkmetric.Exporter
+	health *ExportHealth
+}
+
+func (e healthMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	err := e.Exporter.Export(ctx, rm)
+	e.health.record(err)
+	return err
+}
@@ -0,0 +1,49 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// Environment variables, set through the downward API or by hand, that
+// KubernetesDetector reads.
+const (
+	EnvPodName       = "K8S_POD_NAME"
+	EnvPodUID        = "K8S_POD_UID"
+	EnvNamespaceName = "K8S_NAMESPACE_NAME"
+	EnvNodeName      = "K8S_NODE_NAME"
+	EnvClusterName   = "K8S_CLUSTER_NAME"
+)
+
+// KubernetesDetector is a resource.Detector describing the pod the prober
+// runs in, from the environment variables its manifest sets: the pod's name
+// and UID, its namespace, its node and the cluster's name, which Kubernetes
+// doesn't expose. Unset variables are left out, so that it detects nothing
+// out of the cluster.
+type KubernetesDetector struct{}
+
+var _ resource.Detector = KubernetesDetector{}
+
+// Detect implements resource.Detector.
+func (KubernetesDetector) Detect(context.Context) (*resource.Resource, error) {
+	var attrs []attribute.KeyValue
+	for env, key := range map[string]attribute.Key{
+		EnvPodName:       semconv.K8SPodNameKey,
+		EnvPodUID:        semconv.K8SPodUIDKey,
+		EnvNamespaceName: semconv.K8SNamespaceNameKey,
+		EnvNodeName:      semconv.K8SNodeNameKey,
+		EnvClusterName:   semconv.K8SClusterNameKey,
+	} {
+		if v := os.Getenv(env); v != "" {
+			attrs = append(attrs, key.String(v))
+		}
+	}
+	if len(attrs) == 0 {
+		return resource.Empty(), nil
+	}
+	return resource.NewWithAttributes(semconv.SchemaURL, attrs...), nil
+}
@@ -0,0 +1,181 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// NewBaggageMeterProvider wraps mp so that the measurements of the
+// synchronous instruments of its meters also get the given baggage entries
+// of their context as attributes, the way BaggageSpanProcessor does for
+// spans. Attributes given by the caller take precedence.
+func NewBaggageMeterProvider(mp metric.MeterProvider, keys ...string) metric.MeterProvider {
+	return baggageMeterProvider{MeterProvider: mp, keys: keys}
+}
+
+type baggageMeterProvider struct {
+	metric.MeterProvider
+	keys baggageKeys
+}
+
+func (p baggageMeterProvider) Meter(name string, opts ...metric.MeterOption) metric.Meter {
+	return baggageMeter{Meter: p.MeterProvider.Meter(name, opts...), keys: p.keys}
+}
+
+// baggageKeys are the baggage entries added to measurements.
+type baggageKeys []string
+
+// attributes returns the option adding the entries of the baggage of ctx,
+// nil without any.
+func (k baggageKeys) attributes(ctx context.Context) metric.MeasurementOption {
+	b := baggage.FromContext(ctx)
+	var attrs []attribute.KeyValue
+	for _, key := range k {
+		if m := b.Member(key); m.Key() != "" {
+			attrs = append(attrs, attribute.String(key, m.Value()))
+		}
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return metric.WithAttributes(attrs...)
+}
+
+// add returns opts preceded by the baggage attributes of ctx, so that those
+// of the caller override them.
+func (k baggageKeys) add(ctx context.Context, opts []metric.AddOption) []metric.AddOption {
+	if o := k.attributes(ctx); o != nil {
+		return append([]metric.AddOption{o}, opts...)
+	}
+	return opts
+}
+
+// record is add for RecordOptions.
+func (k baggageKeys) record(ctx context.Context, opts []metric.RecordOption) []metric.RecordOption {
+	if o := k.attributes(ctx); o != nil {
+		return append([]metric.RecordOption{o}, opts...)
+	}
+	return opts
+}
+
+// baggageMeter wraps the synchronous instruments of its Meter, the
+// asynchronous ones being observed without the context of a probe.
+type baggageMeter struct {
+	metric.Meter
+	keys baggageKeys
+}
+
+func (m baggageMeter) Int64Counter(name string, opts ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	i, err := m.Meter.Int64Counter(name, opts...)
+	return baggageInt64Counter{i, m.keys}, err
+}
+
+func (m baggageMeter) Int64UpDownCounter(name string, opts ...metric.Int64UpDownCounterOption) (metric.Int64UpDownCounter, error) {
+	i, err := m.Meter.Int64UpDownCounter(name, opts...)
+	return baggageInt64UpDownCounter{i, m.keys}, err
+}
+
+func (m baggageMeter) Int64Histogram(name string, opts ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	i, err := m.Meter.Int64Histogram(name, opts...)
+	return baggageInt64Histogram{i, m.keys}, err
+}
+
+func (m baggageMeter) Int64Gauge(name string, opts ...metric.Int64GaugeOption) (metric.Int64Gauge, error) {
+	i, err := m.Meter.Int64Gauge(name, opts...)
+	return baggageInt64Gauge{i, m.keys}, err
+}
+
+func (m baggageMeter) Float64Counter(name string, opts ...metric.Float64CounterOption) (metric.Float64Counter, error) {
+	i, err := m.Meter.Float64Counter(name, opts...)
+	return baggageFloat64Counter{i, m.keys}, err
+}
+
+func (m baggageMeter) Float64UpDownCounter(name string, opts ...metric.Float64UpDownCounterOption) (metric.Float64UpDownCounter, error) {
+	i, err := m.Meter.Float64UpDownCounter(name, opts...)
+	return baggageFloat64UpDownCounter{i, m.keys}, err
+}
+
+func (m baggageMeter) Float64Histogram(name string, opts ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	i, err := m.Meter.Float64Histogram(name, opts...)
+	return baggageFloat64Histogram{i, m.keys}, err
+}
+
+func (m baggageMeter) Float64Gauge(name string, opts ...metric.Float64GaugeOption) (metric.Float64Gauge, error) {
+	i, err := m.Meter.Float64Gauge(name, opts...)
+	return baggageFloat64Gauge{i, m.keys}, err
+}
+
+type baggageInt64Counter struct {
+	metric.Int64Counter
+	keys baggageKeys
+}
+
+func (i baggageInt64Counter) Add(ctx context.Context, incr int64, opts ...metric.AddOption) {
+	i.Int64Counter.Add(ctx, incr, i.keys.add(ctx, opts)...)
+}
+
+type baggageInt64UpDownCounter struct {
+	metric.Int64UpDownCounter
+	keys baggageKeys
+}
+
+func (i baggageInt64UpDownCounter) Add(ctx context.Context, incr int64, opts ...metric.AddOption) {
+	i.Int64UpDownCounter.Add(ctx, incr, i.keys.add(ctx, opts)...)
+}
+
+type baggageInt64Histogram struct {
+	metric.Int64Histogram
+	keys baggageKeys
+}
+
+func (i baggageInt64Histogram) Record(ctx context.Context, value int64, opts ...metric.RecordOption) {
+	i.Int64Histogram.Record(ctx, value, i.keys.record(ctx, opts)...)
+}
+
+type baggageInt64Gauge struct {
+	metric.Int64Gauge
+	keys baggageKeys
+}
+
+func (i baggageInt64Gauge) Record(ctx context.Context, value int64, opts ...metric.RecordOption) {
+	i.Int64Gauge.Record(ctx, value, i.keys.record(ctx, opts)...)
+}
+
+type baggageFloat64Counter struct {
+	metric.Float64Counter
+	keys baggageKeys
+}
+
+func (i baggageFloat64Counter) Add(ctx context.Context, incr float64, opts ...metric.AddOption) {
+	i.Float64Counter.Add(ctx, incr, i.keys.add(ctx, opts)...)
+}
+
+type baggageFloat64UpDownCounter struct {
+	metric.Float64UpDownCounter
+	keys baggageKeys
+}
+
+func (i baggageFloat64UpDownCounter) Add(ctx context.Context, incr float64, opts ...metric.AddOption) {
+	i.Float64UpDownCounter.Add(ctx, incr, i.keys.add(ctx, opts)...)
+}
+
+type baggageFloat64Histogram struct {
+	metric.Float64Histogram
+	keys baggageKeys
+}
+
+func (i baggageFloat64Histogram) Record(ctx context.Context, value float64, opts ...metric.RecordOption) {
+	i.Float64Histogram.Record(ctx, value, i.keys.record(ctx, opts)...)
+}
+
+type baggageFloat64Gauge struct {
+	metric.Float64Gauge
+	keys baggageKeys
+}
+
+func (i baggageFloat64Gauge) Record(ctx context.Context, value float64, opts ...metric.RecordOption) {
+	i.Float64Gauge.Record(ctx, value, i.keys.record(ctx, opts)...)
+}
@@ -0,0 +1,229 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// PhaseBuckets are the bucket boundaries of the phase duration histogram, in
+// milliseconds. They span from a fast API call to a whole run.
+var PhaseBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000, 300000}
+
+// RunMetrics records the outcome of every probe run and the duration of its
+// phases, along with their success ratio: the ratio of attempts that
+// succeeded.
+type RunMetrics struct {
+	runs              metric.Int64Counter
+	lastRun           metric.Float64Gauge
+	phases            metric.Float64Histogram
+	reaped            metric.Int64Counter
+	phaseAttempts     metric.Int64Counter
+	phaseSuccesses    metric.Int64Counter
+	successRatio      metric.Float64Gauge
+	phaseAvailability metric.Float64Gauge
+	lockWait          metric.Float64Histogram
+
+	mu     sync.Mutex
+	counts map[string]map[results.Outcome]int64
+	// phaseCounts holds the availability of every phase since the process
+	// started, keyed by "<kind>/<phase>".
+	phaseCounts map[string]results.Availability
+}
+
+// NewRunMetrics creates the run instruments on meter.
+func NewRunMetrics(meter metric.Meter) (*RunMetrics, error) {
+	runs, err := meter.Int64Counter("probe.runs_total",
+		metric.WithDescription("Number of probe runs, by probe kind and outcome."),
+		metric.WithUnit("{run}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.runs_total counter: %w", err)
+	}
+
+	lastRun, err := meter.Float64Gauge("probe.last_run.timestamp",
+		metric.WithDescription("Unix time at which the last probe run ended, by probe kind and outcome."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.last_run.timestamp gauge: %w", err)
+	}
+
+	phases, err := meter.Float64Histogram("probe.phase.duration",
+		metric.WithDescription("Duration of the phases of probe runs, by probe kind, phase and outcome."),
+		metric.WithUnit("ms"),
+		metric.WithExplicitBucketBoundaries(PhaseBuckets...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.phase.duration histogram: %w", err)
+	}
+
+	reaped, err := meter.Int64Counter("probe.reaped_total",
+		metric.WithDescription("Number of expired objects left behind by probe runs and deleted by the reaper, by resource."),
+		metric.WithUnit("{object}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.reaped_total counter: %w", err)
+	}
+
+	phaseAttempts, err := meter.Int64Counter("probe.phase.attempts_total",
+		metric.WithDescription("Number of attempted phases of probe runs, by probe kind and phase."),
+		metric.WithUnit("{phase}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.phase.attempts_total counter: %w", err)
+	}
+
+	phaseSuccesses, err := meter.Int64Counter("probe.phase.successes_total",
+		metric.WithDescription("Number of successful phases of probe runs, by probe kind and phase."),
+		metric.WithUnit("{phase}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.phase.successes_total counter: %w", err)
+	}
+
+	successRatio, err := meter.Float64Gauge("probe.success_ratio",
+		metric.WithDescription("Ratio of the probe runs that succeeded since the prober started, by probe kind."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.success_ratio gauge: %w", err)
+	}
+
+	phaseAvailability, err := meter.Float64Gauge("probe.phase.availability",
+		metric.WithDescription("Ratio of the attempted phases of probe runs that succeeded since the prober started, by probe kind and phase."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.phase.availability gauge: %w", err)
+	}
+
+	lockWait, err := meter.Float64Histogram("probe.lock_wait.duration",
+		metric.WithDescription("Time spent acquiring the exclusive lock, by whether it was contended."),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.lock_wait.duration histogram: %w", err)
+	}
+
+	return &RunMetrics{
+		runs:              runs,
+		lastRun:           lastRun,
+		phases:            phases,
+		reaped:            reaped,
+		phaseAttempts:     phaseAttempts,
+		phaseSuccesses:    phaseSuccesses,
+		successRatio:      successRatio,
+		phaseAvailability: phaseAvailability,
+		lockWait:          lockWait,
+		counts:            make(map[string]map[results.Outcome]int64),
+		phaseCounts:       make(map[string]results.Availability),
+	}, nil
+}
+
+// RecordRun records one finished run of a probe. It must be called exactly
+// once per probe run.
+func (m *RunMetrics) RecordRun(ctx context.Context, kind string, outcome results.Outcome) {
+	attrs := metric.WithAttributes(
+		attribute.String("probe.kind", kind),
+		attribute.String("probe.outcome", string(outcome)),
+	)
+	m.runs.Add(ctx, 1, attrs)
+	m.lastRun.Record(ctx, float64(time.Now().UnixMilli())/1000, attrs)
+
+	m.mu.Lock()
+	if m.counts[kind] == nil {
+		m.counts[kind] = make(map[results.Outcome]int64)
+	}
+	m.counts[kind][outcome]++
+	m.mu.Unlock()
+	if ratio, ok := m.SuccessRatio(kind); ok {
+		m.successRatio.Record(ctx, ratio, metric.WithAttributes(attribute.String("probe.kind", kind)))
+	}
+}
+
+// RecordPhases records the duration of the phases of a finished run of a
+// probe, with the given extra attributes, e.g. the node it ran on, and
+// counts the attempted ones toward their availability.
+func (m *RunMetrics) RecordPhases(ctx context.Context, kind string, phases []results.Phase, attrs ...attribute.KeyValue) {
+	for _, ph := range phases {
+		m.phases.Record(ctx, float64(ph.Duration.Microseconds())/1000, metric.WithAttributes(append([]attribute.KeyValue{
+			attribute.String("probe.kind", kind),
+			attribute.String("probe.phase", ph.Name),
+			attribute.String("probe.outcome", string(ph.Outcome)),
+		}, attrs...)...))
+
+		if !ph.Outcome.Attempted() {
+			continue
+		}
+		phaseAttrs := []attribute.KeyValue{
+			attribute.String("probe.kind", kind),
+			attribute.String("probe.phase", ph.Name),
+		}
+		m.phaseAttempts.Add(ctx, 1, metric.WithAttributes(append(phaseAttrs, attrs...)...))
+		if ph.Outcome == results.OutcomeSuccess {
+			m.phaseSuccesses.Add(ctx, 1, metric.WithAttributes(append(phaseAttrs, attrs...)...))
+		}
+		m.mu.Lock()
+		key := kind + "/" + ph.Name
+		m.phaseCounts[key] = m.phaseCounts[key].Add(ph.Outcome)
+		ratio := m.phaseCounts[key].Ratio
+		m.mu.Unlock()
+		m.phaseAvailability.Record(ctx, ratio, metric.WithAttributes(phaseAttrs...))
+	}
+}
+
+// RecordReaped records the objects deleted by a reaper pass, by resource.
+func (m *RunMetrics) RecordReaped(ctx context.Context, counts map[string]int) {
+	for resource, n := range counts {
+		m.reaped.Add(ctx, int64(n), metric.WithAttributes(attribute.String("k8s.resource", resource)))
+	}
+}
+
+// RecordLockWait records the time spent acquiring the exclusive lock.
+func (m *RunMetrics) RecordLockWait(ctx context.Context, wait results.LockWait) {
+	m.lockWait.Record(ctx, float64(wait.Duration.Microseconds())/1000, metric.WithAttributes(
+		attribute.Bool("lock.contended", wait.Contended),
+	))
+}
+
+// Runs returns the number of runs of the given probe kind recorded since the
+// process started, skipped ones included.
+func (m *RunMetrics) Runs(kind string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for _, n := range m.counts[kind] {
+		total += n
+	}
+	return total
+}
+
+// SuccessRatio returns the fraction of the runs of the given probe kind that
+// succeeded since the process started. Skipped runs are not counted. The
+// second return value is false when no run was counted.
+func (m *RunMetrics) SuccessRatio(kind string) (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total, succeeded int64
+	for outcome, n := range m.counts[kind] {
+		if outcome.Skipped() {
+			continue
+		}
+		total += n
+		if outcome == results.OutcomeSuccess {
+			succeeded += n
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(succeeded) / float64(total), true
+}
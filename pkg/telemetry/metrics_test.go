@@ -0,0 +1,153 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// newTestRunMetrics returns run metrics collected by the returned reader.
+func newTestRunMetrics(t *testing.T) (*RunMetrics, *sdkmetric.ManualReader) {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() { mp.Shutdown(context.Background()) })
+	m, err := NewRunMetrics(mp.Meter("test"))
+	if err != nil {
+		t.Fatalf("NewRunMetrics() error = %v", err)
+	}
+	return m, reader
+}
+
+// runsTotal returns the value of probe.runs_total by outcome, for kind.
+func runsTotal(t *testing.T, reader *sdkmetric.ManualReader, kind string) map[results.Outcome]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	got := map[results.Outcome]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "probe.runs_total" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				if k, _ := dp.Attributes.Value("probe.kind"); k.AsString() != kind {
+					continue
+				}
+				outcome, _ := dp.Attributes.Value(attribute.Key("probe.outcome"))
+				got[results.Outcome(outcome.AsString())] += dp.Value
+			}
+		}
+	}
+	return got
+}
+
+func TestRecordRunEveryOutcome(t *testing.T) {
+	for _, outcome := range results.Outcomes {
+		t.Run(string(outcome), func(t *testing.T) {
+			m, reader := newTestRunMetrics(t)
+			m.RecordRun(context.Background(), "pod", outcome)
+
+			got := runsTotal(t, reader, "pod")
+			if len(got) != 1 || got[outcome] != 1 {
+				t.Errorf("probe.runs_total = %v, want a single %s run", got, outcome)
+			}
+			if n := m.Runs("pod"); n != 1 {
+				t.Errorf("Runs() = %d, want 1", n)
+			}
+
+			ratio, ok := m.SuccessRatio("pod")
+			switch {
+			case outcome.Skipped():
+				if ok {
+					t.Errorf("SuccessRatio() = %v, want none for a skipped run", ratio)
+				}
+			case outcome == results.OutcomeSuccess:
+				if !ok || ratio != 1 {
+					t.Errorf("SuccessRatio() = %v, %v, want 1", ratio, ok)
+				}
+			default:
+				if !ok || ratio != 0 {
+					t.Errorf("SuccessRatio() = %v, %v, want 0", ratio, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestSuccessRatio(t *testing.T) {
+	m, reader := newTestRunMetrics(t)
+	ctx := context.Background()
+	for _, outcome := range []results.Outcome{
+		results.OutcomeSuccess,
+		results.OutcomeSuccess,
+		results.OutcomeSuccess,
+		results.OutcomeBudgetExceeded,
+		results.OutcomeSkippedLocked,
+		results.OutcomeNamespaceTerminating,
+	} {
+		m.RecordRun(ctx, "pod", outcome)
+	}
+	m.RecordRun(ctx, "dns", results.OutcomeError)
+
+	if ratio, _ := m.SuccessRatio("pod"); ratio != 0.6 {
+		t.Errorf("SuccessRatio(pod) = %v, want 3 of 5 runs, the skipped one aside", ratio)
+	}
+	if ratio, _ := m.SuccessRatio("dns"); ratio != 0 {
+		t.Errorf("SuccessRatio(dns) = %v, want 0", ratio)
+	}
+	if _, ok := m.SuccessRatio("e2e"); ok {
+		t.Error("SuccessRatio(e2e) ok, want no runs")
+	}
+	if n := m.Runs("pod"); n != 6 {
+		t.Errorf("Runs(pod) = %d, want 6", n)
+	}
+
+	got := runsTotal(t, reader, "pod")
+	want := map[results.Outcome]int64{
+		results.OutcomeSuccess:              3,
+		results.OutcomeBudgetExceeded:       1,
+		results.OutcomeSkippedLocked:        1,
+		results.OutcomeNamespaceTerminating: 1,
+	}
+	for outcome, n := range want {
+		if got[outcome] != n {
+			t.Errorf("probe.runs_total{outcome=%s} = %d, want %d", outcome, got[outcome], n)
+		}
+	}
+}
+
+func TestRecordLockWait(t *testing.T) {
+	m, reader := newTestRunMetrics(t)
+	ctx := context.Background()
+	m.RecordLockWait(ctx, results.LockWait{Duration: 1500 * time.Millisecond, Contended: true})
+	m.RecordLockWait(ctx, results.LockWait{Duration: 2 * time.Millisecond})
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	got := map[bool]float64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			if metric.Name != "probe.lock_wait.duration" {
+				continue
+			}
+			for _, dp := range metric.Data.(metricdata.Histogram[float64]).DataPoints {
+				contended, _ := dp.Attributes.Value("lock.contended")
+				got[contended.AsBool()] += dp.Sum
+			}
+		}
+	}
+	if got[true] != 1500 || got[false] != 2 {
+		t.Errorf("probe.lock_wait.duration sums = %v, want 1500ms contended and 2ms not", got)
+	}
+}
@@ -0,0 +1,219 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	flowcontrolv1 "k8s.io/api/flowcontrol/v1"
+)
+
+// RequestTracer records every request made to the API server as a child span
+// of the span in the request's context, up to the response's headers, so
+// that the latency of individual API calls shows under the phase spans.
+// Spans are named after the method and the templated route, e.g.
+// "GET /api/v1/namespaces/{namespace}/pods/{name}", and carry the request's
+// method, path, host and response status, along with the time they waited
+// on the client-side rate limiter, see ThrottleRecorder. Requests retried by
+// client-go, e.g. after a 429, get a span each.
+type RequestTracer struct {
+	tracer trace.Tracer
+
+	// OnDemand, when set, only records the requests made within a context
+	// returned by WithRequestSpans.
+	OnDemand bool
+}
+
+type requestSpansKey struct{}
+
+// WithRequestSpans returns a context in which the requests are recorded by
+// an on-demand RequestTracer too.
+func WithRequestSpans(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestSpansKey{}, true)
+}
+
+// NewRequestTracer starts the request spans with a tracer of tp.
+func NewRequestTracer(tp trace.TracerProvider) *RequestTracer {
+	return &RequestTracer{tracer: tp.Tracer("k8s-latency-probe/requests")}
+}
+
+// Wrap wraps rt, it can be used as a rest.Config's WrapTransport. The
+// transports rt wraps see the request's span in its context.
+func (t *RequestTracer) Wrap(rt http.RoundTripper) http.RoundTripper {
+	return requestTransport{tracer: t.tracer, onDemand: t.OnDemand, next: rt}
+}
+
+type requestTransport struct {
+	tracer   trace.Tracer
+	onDemand bool
+	next     http.RoundTripper
+}
+
+func (t requestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.onDemand && req.Context().Value(requestSpansKey{}) == nil {
+		return t.next.RoundTrip(req)
+	}
+	ctx, span := t.tracer.Start(req.Context(), req.Method+" "+requestRoute(req.URL.Path),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+			attribute.String("server.address", req.URL.Host),
+		),
+	)
+	defer span.End()
+	if wait, ok := claimWait(req.Context()); ok {
+		span.SetAttributes(attribute.Float64(AttrClientThrottle, durationMS(wait)))
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// PropagateTraceContext wraps rt to send the W3C traceparent header of the
+// span in each request's context, so that the API server's own spans, when
+// its tracing is enabled, join the probe's traces. It can be used as a
+// rest.Config's WrapTransport, wrapped by the RequestTracer so that the API
+// server's spans are children of the request's.
+func PropagateTraceContext(rt http.RoundTripper) http.RoundTripper {
+	return traceContextTransport{next: rt}
+}
+
+type traceContextTransport struct {
+	next http.RoundTripper
+}
+
+func (t traceContextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.SpanContextFromContext(req.Context()).IsValid() {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	propagation.TraceContext{}.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+	return t.next.RoundTrip(req)
+}
+
+// AttrAuditID is the span attribute holding the Audit-Id of an API request,
+// the ID of its entries in the API server's audit log.
+const AttrAuditID = "k8s.audit_id"
+
+// RecordAuditID wraps rt to record the Audit-Id response header of each
+// request on the span in its context, so that latency outliers can be
+// looked up in the API server's audit log. It can be used as a rest.Config's
+// WrapTransport, wrapped by the RequestTracer so that each request's ID is
+// recorded on its own span; the span of a phase otherwise holds the ID of
+// its last request.
+func RecordAuditID(rt http.RoundTripper) http.RoundTripper {
+	return auditIDTransport{next: rt}
+}
+
+type auditIDTransport struct {
+	next http.RoundTripper
+}
+
+func (t auditIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		if id := resp.Header.Get("Audit-Id"); id != "" {
+			trace.SpanFromContext(req.Context()).SetAttributes(attribute.String(AttrAuditID, id))
+		}
+	}
+	return resp, err
+}
+
+// Span attributes holding the API Priority and Fairness classification of
+// an API request.
+const (
+	AttrFlowSchemaUID    = "k8s.apf.flow_schema_uid"
+	AttrFlowSchema       = "k8s.apf.flow_schema"
+	AttrPriorityLevelUID = "k8s.apf.priority_level_uid"
+	AttrPriorityLevel    = "k8s.apf.priority_level"
+)
+
+// RecordAPF returns a function wrapping a transport to record the UIDs of the
+// FlowSchema and priority level the API server classified each request
+// under, from its X-Kubernetes-PF-* response headers, on the span in the
+// request's context, along with their names when names, if not nil, knows
+// them. This tells under which API Priority and Fairness bucket the probe's
+// requests queued. It can be used as a rest.Config's WrapTransport, wrapped
+// by the RequestTracer so that the classification is recorded on each
+// request's own span.
+func RecordAPF(names func(uid string) (string, bool)) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return apfTransport{names: names, next: rt}
+	}
+}
+
+type apfTransport struct {
+	names func(uid string) (string, bool)
+	next  http.RoundTripper
+}
+
+func (t apfTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	span := trace.SpanFromContext(req.Context())
+	for _, h := range []struct{ header, uidAttr, nameAttr string }{
+		{flowcontrolv1.ResponseHeaderMatchedFlowSchemaUID, AttrFlowSchemaUID, AttrFlowSchema},
+		{flowcontrolv1.ResponseHeaderMatchedPriorityLevelConfigurationUID, AttrPriorityLevelUID, AttrPriorityLevel},
+	} {
+		uid := resp.Header.Get(h.header)
+		if uid == "" {
+			continue
+		}
+		span.SetAttributes(attribute.String(h.uidAttr, uid))
+		if t.names == nil {
+			continue
+		}
+		if name, ok := t.names(uid); ok {
+			span.SetAttributes(attribute.String(h.nameAttr, name))
+		}
+	}
+	return resp, nil
+}
+
+// requestRoute returns the path of an API request with the namespace and
+// object names replaced by placeholders, keeping span names low-cardinality:
+// /api/v1/namespaces/probes/pods/probe-1234/status becomes
+// /api/v1/namespaces/{namespace}/pods/{name}/status. Paths outside of the
+// resource APIs, e.g. /version, are kept as is.
+func requestRoute(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var prefix int
+	switch {
+	case len(segments) >= 2 && segments[0] == "api":
+		prefix = 2
+	case len(segments) >= 3 && segments[0] == "apis":
+		prefix = 3
+	default:
+		return path
+	}
+
+	route, rest := segments[:prefix:prefix], segments[prefix:]
+	if len(rest) >= 3 && rest[0] == "namespaces" {
+		route = append(route, "namespaces", "{namespace}")
+		rest = rest[2:]
+	}
+	if len(rest) >= 1 {
+		route = append(route, rest[0])
+	}
+	if len(rest) >= 2 {
+		route = append(route, "{name}")
+		route = append(route, rest[2:]...)
+	}
+	return "/" + strings.Join(route, "/")
+}
@@ -0,0 +1,37 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRequestTracerOnDemand(t *testing.T) {
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	tracer := NewRequestTracer(tp)
+	tracer.OnDemand = true
+	rt := tracer.Wrap(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+
+	get := func(ctx context.Context) {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://apiserver/api/v1/namespaces/default/pods", nil)
+		if _, err := rt.RoundTrip(req); err != nil {
+			t.Fatalf("RoundTrip() error = %v", err)
+		}
+	}
+
+	get(context.Background())
+	if got := len(spans.GetSpans()); got != 0 {
+		t.Fatalf("recorded %d spans, want none outside of WithRequestSpans", got)
+	}
+	get(WithRequestSpans(context.Background()))
+	if got := len(spans.GetSpans()); got != 1 {
+		t.Errorf("recorded %d spans, want 1 within WithRequestSpans", got)
+	}
+}
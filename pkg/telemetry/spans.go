@@ -0,0 +1,68 @@
+package telemetry
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ActiveSpans is a span processor keeping track of the spans that were
+// started but not ended yet, so that spans left open by an aborted probe can
+// still be ended, and exported, before the prober exits.
+type ActiveSpans struct {
+	mu    sync.Mutex
+	spans map[trace.SpanID]sdktrace.ReadWriteSpan
+}
+
+// NewActiveSpans returns an empty registry.
+func NewActiveSpans() *ActiveSpans {
+	return &ActiveSpans{spans: make(map[trace.SpanID]sdktrace.ReadWriteSpan)}
+}
+
+func (a *ActiveSpans) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.spans[s.SpanContext().SpanID()] = s
+}
+
+func (a *ActiveSpans) OnEnd(s sdktrace.ReadOnlySpan) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.spans, s.SpanContext().SpanID())
+}
+
+func (a *ActiveSpans) Shutdown(context.Context) error { return nil }
+
+func (a *ActiveSpans) ForceFlush(context.Context) error { return nil }
+
+// EndOpen ends every span of root's trace still open, other than root
+// itself, most recently started first so that children end before their
+// parents. Each of them records reason as its error. It returns the number of
+// spans ended.
+func (a *ActiveSpans) EndOpen(root trace.Span, reason error) int {
+	rc := root.SpanContext()
+
+	a.mu.Lock()
+	var open []sdktrace.ReadWriteSpan
+	for id, s := range a.spans {
+		if id != rc.SpanID() && s.SpanContext().TraceID() == rc.TraceID() {
+			open = append(open, s)
+		}
+	}
+	a.mu.Unlock()
+
+	sort.Slice(open, func(i, j int) bool {
+		return open[i].StartTime().After(open[j].StartTime())
+	})
+	// Ending a span calls OnEnd, which takes the lock.
+	for _, s := range open {
+		s.RecordError(reason)
+		s.SetStatus(codes.Error, reason.Error())
+		s.End()
+	}
+	return len(open)
+}
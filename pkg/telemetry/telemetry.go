@@ -0,0 +1,375 @@
+// Package telemetry sets up the OpenTelemetry tracer and meter providers used
+// by the prober.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+const (
+	// ExporterOTLP exports traces and metrics over OTLP, see Config.Protocol.
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes traces and metrics as JSON to Config.Output, for
+	// local debugging.
+	ExporterStdout = "stdout"
+	// ExporterNone disables exporting; spans and metrics are still recorded
+	// but dropped.
+	ExporterNone = "none"
+)
+
+const (
+	// ProtocolGRPC sends OTLP over gRPC.
+	ProtocolGRPC = "grpc"
+	// ProtocolHTTPProtobuf sends OTLP as protobuf over HTTP.
+	ProtocolHTTPProtobuf = "http/protobuf"
+)
+
+// Config controls how the telemetry providers are built.
+type Config struct {
+	ServiceName    string
+	ServiceVersion string
+
+	// Exporter selects the built-in exporter, one of ExporterOTLP,
+	// ExporterStdout or ExporterNone. Defaults to the OTEL_TRACES_EXPORTER
+	// environment variable, where console stands for ExporterStdout, or
+	// ExporterOTLP.
+	Exporter string
+
+	// Protocol selects the transport of ExporterOTLP, one of ProtocolGRPC or
+	// ProtocolHTTPProtobuf. Defaults to the OTEL_EXPORTER_OTLP_PROTOCOL
+	// environment variables, or ProtocolGRPC.
+	Protocol string
+
+	// Endpoint, when set, is where the OTLP exporters send to, overriding
+	// the OTEL_EXPORTER_OTLP_ENDPOINT environment variables. It is either a
+	// URL, e.g. http://collector:4317, or a host and port, e.g.
+	// collector:4317, reached over TLS. Over HTTP, the signal's path, e.g.
+	// /v1/traces, is appended to the URL's.
+	Endpoint string
+
+	// Output is where ExporterStdout writes. Defaults to os.Stdout.
+	Output io.Writer
+
+	// SpanExporter and MetricReader, when set, take precedence over
+	// Exporter. They allow callers to plug in their own exporters.
+	SpanExporter sdktrace.SpanExporter
+	MetricReader sdkmetric.Reader
+
+	// Prometheus additionally exposes the metrics in the Prometheus format,
+	// through Providers.MetricsHandler, whatever the exporter.
+	Prometheus bool
+
+	// BaggageKeys are the baggage entries copied onto every span started
+	// with them in its parent context. Defaults to DefaultBaggageKeys.
+	BaggageKeys []string
+	// MetricBaggageKeys are the baggage entries added to the attributes of
+	// the measurements made with them in their context, see
+	// NewBaggageMeterProvider. Defaults to DefaultMetricBaggageKeys.
+	MetricBaggageKeys []string
+
+	// ClusterName, when set, is recorded as the k8s.cluster.name resource
+	// attribute, overriding K8S_CLUSTER_NAME, so that the telemetry of
+	// probers in several clusters can be told apart.
+	ClusterName string
+
+	// ResourceAttributes are added to the resource describing the prober,
+	// on top of those found by KubernetesDetector and in the
+	// OTEL_RESOURCE_ATTRIBUTES environment variable.
+	ResourceAttributes []attribute.KeyValue
+
+	// SetGlobal registers the providers and propagator as the otel globals,
+	// the meter provider wrapped to add the MetricBaggageKeys.
+	SetGlobal bool
+}
+
+// Providers holds the providers built by Setup.
+type Providers struct {
+	TracerProvider *sdktrace.TracerProvider
+	MeterProvider  *sdkmetric.MeterProvider
+	Propagator     propagation.TextMapPropagator
+	Resource       *resource.Resource
+
+	// ActiveSpans tracks the spans not ended yet.
+	ActiveSpans *ActiveSpans
+
+	// MetricsHandler serves the metrics in the Prometheus format. It is nil
+	// unless Config.Prometheus is set.
+	MetricsHandler http.Handler
+
+	// ExportHealth tracks the outcome of the OTLP exports. It is nil unless
+	// the built-in OTLP exporter is used, over either protocol.
+	ExportHealth *ExportHealth
+}
+
+// Setup builds the tracer and meter providers described by cfg. The returned
+// shutdown function flushes and stops both providers; it should be given a
+// fresh, bounded context, independent of the one used for the probe. Spans
+// still open must be ended before, see ActiveSpans.EndOpen.
+func Setup(ctx context.Context, cfg Config) (*Providers, func(context.Context) error, error) {
+	res, err := newResource(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spanExporter, reader, health, err := newExporters(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := cfg.BaggageKeys
+	if keys == nil {
+		keys = DefaultBaggageKeys
+	}
+	active := NewActiveSpans()
+	tpOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSpanProcessor(NewBaggageSpanProcessor(keys...)),
+		sdktrace.WithSpanProcessor(active),
+	}
+	if spanExporter != nil {
+		tpOpts = append(tpOpts, sdktrace.WithBatcher(spanExporter))
+	}
+	tp := sdktrace.NewTracerProvider(tpOpts...)
+
+	mpOpts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	if reader != nil {
+		mpOpts = append(mpOpts, sdkmetric.WithReader(reader))
+	}
+	var metricsHandler http.Handler
+	if cfg.Prometheus {
+		registry := prometheus.NewRegistry()
+		promReader, err := otelprom.New(otelprom.WithRegisterer(registry))
+		if err != nil {
+			// The exporters already hold connections
+			errs := []error{fmt.Errorf("failed to create Prometheus exporter: %w", err), tp.Shutdown(ctx)}
+			if reader != nil {
+				errs = append(errs, reader.Shutdown(ctx))
+			}
+			return nil, nil, errors.Join(errs...)
+		}
+		mpOpts = append(mpOpts, sdkmetric.WithReader(promReader))
+		metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	}
+	mp := sdkmetric.NewMeterProvider(mpOpts...)
+
+	prop := propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+
+	metricKeys := cfg.MetricBaggageKeys
+	if metricKeys == nil {
+		metricKeys = DefaultMetricBaggageKeys
+	}
+	if cfg.SetGlobal {
+		otel.SetTracerProvider(tp)
+		otel.SetMeterProvider(NewBaggageMeterProvider(mp, metricKeys...))
+		otel.SetTextMapPropagator(prop)
+	}
+
+	providers := &Providers{
+		TracerProvider: tp,
+		MeterProvider:  mp,
+		Propagator:     prop,
+		Resource:       res,
+		ActiveSpans:    active,
+		MetricsHandler: metricsHandler,
+		ExportHealth:   health,
+	}
+
+	shutdown := func(ctx context.Context) error {
+		// Flush explicitly first so that a slow tracer exporter doesn't
+		// consume the whole budget before the final metrics are collected.
+		return errors.Join(
+			mp.ForceFlush(ctx),
+			tp.ForceFlush(ctx),
+			tp.Shutdown(ctx),
+			mp.Shutdown(ctx),
+		)
+	}
+
+	return providers, shutdown, nil
+}
+
+// newResource builds the resource describing this application and the pod
+// it runs in. Explicit attributes take precedence over the environment's.
+func newResource(ctx context.Context, cfg Config) (*resource.Resource, error) {
+	attrs := []attribute.KeyValue{
+		semconv.ServiceNameKey.String(cfg.ServiceName),
+		semconv.ServiceVersionKey.String(cfg.ServiceVersion),
+	}
+	if cfg.ClusterName != "" {
+		attrs = append(attrs, semconv.K8SClusterNameKey.String(cfg.ClusterName))
+	}
+	attrs = append(attrs, cfg.ResourceAttributes...)
+
+	res, err := resource.New(ctx,
+		resource.WithDetectors(KubernetesDetector{}),
+		resource.WithFromEnv(),
+		resource.WithAttributes(attrs...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+	return res, nil
+}
+
+// newExporters returns the span exporter and metric reader selected by cfg,
+// along with the health of the built-in OTLP exporters. Either may be nil
+// when exporting is disabled.
+func newExporters(ctx context.Context, cfg Config) (sdktrace.SpanExporter, sdkmetric.Reader, *ExportHealth, error) {
+	spanExporter, reader := cfg.SpanExporter, cfg.MetricReader
+	if spanExporter != nil || reader != nil {
+		return spanExporter, reader, nil, nil
+	}
+
+	exporter := cfg.Exporter
+	if exporter == "" {
+		exporter = os.Getenv("OTEL_TRACES_EXPORTER")
+	}
+	switch exporter {
+	case "", ExporterOTLP:
+		spanExporter, metricExporter, err := newOTLPExporters(ctx, cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		health := &ExportHealth{}
+		return healthSpanExporter{spanExporter, health},
+			sdkmetric.NewPeriodicReader(healthMetricExporter{metricExporter, health}),
+			health, nil
+	case ExporterStdout, "console":
+		out := cfg.Output
+		if out == nil {
+			out = os.Stdout
+		}
+		spanExporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create stdout trace exporter: %w", err)
+		}
+		metricExporter, err := stdoutmetric.New(stdoutmetric.WithWriter(out))
+		if err != nil {
+			return nil, nil, nil, errors.Join(fmt.Errorf("failed to create stdout metric exporter: %w", err), spanExporter.Shutdown(ctx))
+		}
+		return spanExporter, sdkmetric.NewPeriodicReader(metricExporter), nil, nil
+	case ExporterNone:
+		return nil, nil, nil, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown exporter %q", exporter)
+	}
+}
+
+// newTraceExporter creates the OTLP span exporter sending spans with client.
+// Tests replace it to see the client stopped.
+var newTraceExporter = otlptrace.New
+
+// newOTLPExporters returns the OTLP span and metric exporters over the
+// protocol selected by cfg, or by the environment, for each signal.
+func newOTLPExporters(ctx context.Context, cfg Config) (*otlptrace.Exporter, sdkmetric.Exporter, error) {
+	var (
+		spanExporter   *otlptrace.Exporter
+		metricExporter sdkmetric.Exporter
+		err            error
+	)
+	switch protocol := otlpProtocol(cfg.Protocol, "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"); protocol {
+	case ProtocolGRPC:
+		var opts []otlptracegrpc.Option
+		switch {
+		case strings.Contains(cfg.Endpoint, "://"):
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		spanExporter, err = newTraceExporter(ctx, otlptracegrpc.NewClient(opts...))
+	case ProtocolHTTPProtobuf:
+		var opts []otlptracehttp.Option
+		switch {
+		case strings.Contains(cfg.Endpoint, "://"):
+			opts = append(opts, otlptracehttp.WithEndpointURL(signalURL(cfg.Endpoint, "/v1/traces")))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		spanExporter, err = newTraceExporter(ctx, otlptracehttp.NewClient(opts...))
+	default:
+		return nil, nil, fmt.Errorf("unsupported OTLP protocol %q, want %s or %s", protocol, ProtocolGRPC, ProtocolHTTPProtobuf)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	switch protocol := otlpProtocol(cfg.Protocol, "OTEL_EXPORTER_OTLP_METRICS_PROTOCOL"); protocol {
+	case ProtocolGRPC:
+		var opts []otlpmetricgrpc.Option
+		switch {
+		case strings.Contains(cfg.Endpoint, "://"):
+			opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlpmetricgrpc.WithEndpoint(cfg.Endpoint))
+		}
+		metricExporter, err = otlpmetricgrpc.New(ctx, opts...)
+	case ProtocolHTTPProtobuf:
+		var opts []otlpmetrichttp.Option
+		switch {
+		case strings.Contains(cfg.Endpoint, "://"):
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(signalURL(cfg.Endpoint, "/v1/metrics")))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+		}
+		metricExporter, err = otlpmetrichttp.New(ctx, opts...)
+	default:
+		err = fmt.Errorf("unsupported OTLP protocol %q, want %s or %s", protocol, ProtocolGRPC, ProtocolHTTPProtobuf)
+	}
+	if err != nil {
+		// The span exporter already holds a connection
+		return nil, nil, errors.Join(fmt.Errorf("failed to create OTLP metric exporter: %w", err), spanExporter.Shutdown(ctx))
+	}
+	return spanExporter, metricExporter, nil
+}
+
+// otlpProtocol returns protocol, or the protocol set by the signal's
+// environment variable, by OTEL_EXPORTER_OTLP_PROTOCOL, or ProtocolGRPC.
+func otlpProtocol(protocol, signalEnv string) string {
+	for _, p := range []string{protocol, os.Getenv(signalEnv), os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")} {
+		if p != "" {
+			return p
+		}
+	}
+	return ProtocolGRPC
+}
+
+// signalURL appends the path of an OTLP/HTTP signal, e.g. /v1/traces, to the
+// base endpoint URL, as done for OTEL_EXPORTER_OTLP_ENDPOINT.
+func signalURL(endpoint, path string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		// Left for the exporter to report
+		return endpoint
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	return u.String()
+}
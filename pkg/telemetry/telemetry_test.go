@@ -0,0 +1,213 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// setupTest sets up providers exporting to an in-memory span exporter and a
+// manual metric reader, shut down at the end of the test.
+func setupTest(t *testing.T, cfg Config) (*Providers, *tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+	spans := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	cfg.SpanExporter = spans
+	cfg.MetricReader = reader
+	providers, shutdown, err := Setup(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("shutdown() error = %v", err)
+		}
+	})
+	return providers, spans, reader
+}
+
+func TestSetupExportsSpans(t *testing.T) {
+	providers, spans, _ := setupTest(t, Config{ServiceName: "k8s-latency-probe"})
+
+	_, span := providers.TracerProvider.Tracer("test").Start(context.Background(), "probe")
+	span.End()
+	if err := providers.TracerProvider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush() error = %v", err)
+	}
+
+	got := spans.GetSpans()
+	if len(got) != 1 || got[0].Name != "probe" {
+		t.Fatalf("exported spans = %v, want a single probe span", got)
+	}
+	if name, _ := got[0].Resource.Set().Value(semconv.ServiceNameKey); name.AsString() != "k8s-latency-probe" {
+		t.Errorf("service.name = %q, want k8s-latency-probe", name.AsString())
+	}
+}
+
+func TestSetupCollectsMetrics(t *testing.T) {
+	providers, _, reader := setupTest(t, Config{ServiceName: "k8s-latency-probe"})
+
+	counter, err := providers.MeterProvider.Meter("test").Int64Counter("probe.test")
+	if err != nil {
+		t.Fatalf("Int64Counter() error = %v", err)
+	}
+	counter.Add(context.Background(), 3)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if len(rm.ScopeMetrics) != 1 || len(rm.ScopeMetrics[0].Metrics) != 1 {
+		t.Fatalf("collected metrics = %+v, want a single metric", rm.ScopeMetrics)
+	}
+	sum, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Sum[int64])
+	if !ok || len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 3 {
+		t.Errorf("probe.test = %+v, want a sum of 3", rm.ScopeMetrics[0].Metrics[0].Data)
+	}
+}
+
+func TestSetupResource(t *testing.T) {
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "deployment.environment=test,service.name=overridden")
+	providers, _, _ := setupTest(t, Config{
+		ServiceName:        "k8s-latency-probe",
+		ServiceVersion:     "v1.2.3",
+		ClusterName:        "prod-1",
+		ResourceAttributes: []attribute.KeyValue{attribute.String("probe.zone", "a")},
+	})
+
+	want := map[attribute.Key]string{
+		semconv.ServiceNameKey:    "k8s-latency-probe",
+		semconv.ServiceVersionKey: "v1.2.3",
+		semconv.K8SClusterNameKey: "prod-1",
+		"probe.zone":              "a",
+		"deployment.environment":  "test",
+	}
+	for key, value := range want {
+		if got, _ := providers.Resource.Set().Value(key); got.AsString() != value {
+			t.Errorf("resource %s = %q, want %q", key, got.AsString(), value)
+		}
+	}
+}
+
+func TestSetupPropagator(t *testing.T) {
+	providers, _, _ := setupTest(t, Config{ServiceName: "k8s-latency-probe"})
+
+	fields := providers.Propagator.Fields()
+	for _, want := range []string{"traceparent", "baggage"} {
+		if !slices.Contains(fields, want) {
+			t.Errorf("propagator fields = %v, want %s", fields, want)
+		}
+	}
+}
+
+func TestSetupGlobal(t *testing.T) {
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	defer otel.SetMeterProvider(otel.GetMeterProvider())
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+
+	providers, _, _ := setupTest(t, Config{ServiceName: "k8s-latency-probe", SetGlobal: true})
+
+	if otel.GetTracerProvider() != providers.TracerProvider {
+		t.Error("global tracer provider isn't the one set up")
+	}
+	if !slices.Contains(otel.GetTextMapPropagator().Fields(), "baggage") {
+		t.Errorf("global propagator fields = %v, want baggage", otel.GetTextMapPropagator().Fields())
+	}
+}
+
+func TestSetupExporters(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		wantErr  bool
+		wantSpan bool
+	}{
+		{name: "none", cfg: Config{Exporter: ExporterNone}},
+		{name: "stdout", cfg: Config{Exporter: ExporterStdout}, wantSpan: true},
+		{name: "console", cfg: Config{Exporter: "console"}, wantSpan: true},
+		{name: "otlp", cfg: Config{Exporter: ExporterOTLP, Endpoint: "http://localhost:4317"}, wantSpan: true},
+		{name: "otlp over http", cfg: Config{Exporter: ExporterOTLP, Protocol: ProtocolHTTPProtobuf, Endpoint: "http://localhost:4318"}, wantSpan: true},
+		{name: "unknown exporter", cfg: Config{Exporter: "zipkin"}, wantErr: true},
+		{name: "unknown protocol", cfg: Config{Exporter: ExporterOTLP, Protocol: "http/json"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Output = io.Discard
+			spanExporter, reader, _, err := newExporters(context.Background(), tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newExporters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (spanExporter != nil) != tt.wantSpan || (reader != nil) != tt.wantSpan {
+				t.Errorf("newExporters() = %v, %v, want exporters: %v", spanExporter, reader, tt.wantSpan)
+			}
+			if spanExporter != nil {
+				spanExporter.Shutdown(context.Background())
+			}
+			if reader != nil {
+				reader.Shutdown(context.Background())
+			}
+		})
+	}
+}
+
+func TestSetupExporterFromEnv(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	spanExporter, reader, _, err := newExporters(context.Background(), Config{})
+	if err != nil || spanExporter != nil || reader != nil {
+		t.Errorf("newExporters() = %v, %v, %v, want no exporters", spanExporter, reader, err)
+	}
+}
+
+// stopRecorder records whether the OTLP trace client it wraps was stopped.
+type stopRecorder struct {
+	otlptrace.Client
+	stopped bool
+}
+
+func (c *stopRecorder) Stop(ctx context.Context) error {
+	c.stopped = true
+	return c.Client.Stop(ctx)
+}
+
+func TestSetupMetricProtocolError(t *testing.T) {
+	client := &stopRecorder{}
+	newTraceExporter = func(ctx context.Context, c otlptrace.Client) (*otlptrace.Exporter, error) {
+		client.Client = c
+		return otlptrace.New(ctx, client)
+	}
+	t.Cleanup(func() { newTraceExporter = otlptrace.New })
+
+	// The span exporter is created first, and shut down when the metric one
+	// can't be
+	t.Setenv("OTEL_EXPORTER_OTLP_METRICS_PROTOCOL", "http/json")
+	_, _, err := newOTLPExporters(context.Background(), Config{Endpoint: "http://localhost:4317"})
+	if err == nil {
+		t.Fatal("newOTLPExporters() error = nil, want the unsupported metric protocol")
+	}
+	if client.Client == nil || !client.stopped {
+		t.Error("the span exporter wasn't shut down")
+	}
+}
+
+func TestSignalURL(t *testing.T) {
+	tests := map[string]string{
+		"http://collector:4318":         "http://collector:4318/v1/traces",
+		"http://collector:4318/":        "http://collector:4318/v1/traces",
+		"https://gateway/otlp":          "https://gateway/otlp/v1/traces",
+		"https://gateway/otlp/?token=1": "https://gateway/otlp/v1/traces?token=1",
+	}
+	for endpoint, want := range tests {
+		if got := signalURL(endpoint, "/v1/traces"); got != want {
+			t.Errorf("signalURL(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
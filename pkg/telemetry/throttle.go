@@ -0,0 +1,340 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	flowcontrolv1 "k8s.io/api/flowcontrol/v1"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Span attributes holding a phase's throttling totals.
+const (
+	// AttrClientThrottle is the total time spent waiting on the client-side
+	// rate limiter, in milliseconds.
+	AttrClientThrottle = "probe.client_throttle_ms"
+	// AttrThrottledRequests is the number of requests rejected by the API
+	// server with a 429.
+	AttrThrottledRequests = "probe.throttled_requests"
+	// AttrThrottleWait is the total time waited before retrying rejected
+	// requests, in milliseconds.
+	AttrThrottleWait = "probe.throttle_wait_ms"
+)
+
+// ThrottleRecorder wraps a client-go rate limiter to make the time requests
+// spend waiting on it visible, so that self-inflicted latency isn't mistaken
+// for server latency. Waits longer than Threshold are recorded as
+// "client_throttled" events on the span in the request's context, limited
+// per context tracked with TrackThrottle, and every wait is added to the
+// totals tracked with TrackThrottle and recorded on the request's own span by
+// the RequestTracer.
+type ThrottleRecorder struct {
+	flowcontrol.RateLimiter
+	Threshold time.Duration
+	// EventBurst and EventWindow configure the limiter bounding the number
+	// of client_throttled events, see NewEventLimiter.
+	EventBurst  int
+	EventWindow time.Duration
+}
+
+// NewThrottleRecorder wraps a token bucket rate limiter with the given qps
+// and burst.
+func NewThrottleRecorder(qps float32, burst int, threshold time.Duration) *ThrottleRecorder {
+	return &ThrottleRecorder{
+		RateLimiter: flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+		Threshold:   threshold,
+		EventBurst:  10,
+		EventWindow: 5 * time.Second,
+	}
+}
+
+func (r *ThrottleRecorder) Wait(ctx context.Context) error {
+	start := time.Now()
+	err := r.RateLimiter.Wait(ctx)
+	wait := time.Since(start)
+
+	totalsFrom(ctx).add(func(t *ThrottleTotals) { t.clientWait.Add(int64(wait)) })
+	if t := totalsFrom(ctx); t != nil {
+		t.pending.Store(int64(wait))
+	}
+	if wait >= r.Threshold {
+		throttleEvent(ctx, "client_throttled", r.EventBurst, r.EventWindow,
+			attribute.Float64("wait_ms", durationMS(wait)),
+		)
+	}
+	return err
+}
+
+// RejectionRecorder records the requests the API server rejects with a 429,
+// typically because API Priority and Fairness is shedding load. client-go
+// retries them after the Retry-After delay, which would otherwise silently
+// add up as latency. Each rejection is counted in the
+// probe.throttled_requests_total counter, the delay before retrying it added
+// to the probe.throttle_wait_ms counter and to the totals tracked with
+// TrackThrottle, and recorded as a "server_throttled" event carrying the
+// matched priority level and flow schema on the request's span, limited per
+// context tracked with TrackThrottle.
+//
+// With MaxRetries set, the recorder retries rejected requests itself, with an
+// exponential backoff instead of client-go's constant Retry-After delay, so
+// that an overloaded API server isn't retried at the rate it asks for over
+// and over. Once out of retries, the last rejection is returned without its
+// Retry-After header, so that client-go doesn't retry it again.
+type RejectionRecorder struct {
+	// MaxRetries is the number of times a rejected request is retried,
+	// zero leaving the retries to client-go.
+	MaxRetries int
+	// Backoff is the delay before the first retry, doubling with every
+	// retry up to MaxBackoff. The Retry-After delay is waited for at least.
+	Backoff time.Duration
+	// EventBurst and EventWindow configure the limiter bounding the number
+	// of server_throttled events, see NewEventLimiter.
+	EventBurst  int
+	EventWindow time.Duration
+
+	rejected metric.Int64Counter
+	wait     metric.Float64Counter
+}
+
+// MaxBackoff caps the delay between the retries of a rejected request.
+const MaxBackoff = 30 * time.Second
+
+// NewRejectionRecorder creates the rejection instruments on meter.
+func NewRejectionRecorder(meter metric.Meter) (*RejectionRecorder, error) {
+	rejected, err := meter.Int64Counter("probe.throttled_requests_total",
+		metric.WithDescription("Number of requests rejected by the API server with a 429, by priority level."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.throttled_requests_total counter: %w", err)
+	}
+	wait, err := meter.Float64Counter("probe.throttle_wait_ms",
+		metric.WithDescription("Total time waited before retrying rejected requests, by priority level."),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe.throttle_wait_ms counter: %w", err)
+	}
+	return &RejectionRecorder{EventBurst: 10, EventWindow: 5 * time.Second, rejected: rejected, wait: wait}, nil
+}
+
+// Wrap wraps rt, it can be used as a rest.Config's WrapTransport.
+func (r *RejectionRecorder) Wrap(rt http.RoundTripper) http.RoundTripper {
+	return rejectionTransport{recorder: r, next: rt}
+}
+
+type rejectionTransport struct {
+	recorder *RejectionRecorder
+	next     http.RoundTripper
+}
+
+func (t rejectionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for retries := 0; ; retries++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+		// A body that can't be rewound leaves the retries to client-go
+		retry := retries < t.recorder.MaxRetries && (req.Body == nil || req.GetBody != nil)
+		var wait time.Duration
+		switch {
+		case retry:
+			wait = max(retryAfter(resp.Header), t.recorder.backoff(retries))
+		case t.recorder.MaxRetries == 0:
+			// client-go retries it
+			wait = retryAfter(resp.Header)
+		}
+		t.recorder.record(ctx, resp.Header, wait)
+		if !retry {
+			if t.recorder.MaxRetries > 0 {
+				resp.Header.Del("Retry-After")
+			}
+			return resp, nil
+		}
+
+		// Drain the body so that the connection can be reused
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.request.resend_count", retries+1))
+	}
+}
+
+// backoff returns the delay before the retry following the given number of
+// retries, with up to 10% of jitter so that concurrent requests rejected
+// together aren't retried together.
+func (r *RejectionRecorder) backoff(retries int) time.Duration {
+	d := min(r.Backoff<<min(retries, 30), MaxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	return d + rand.N(d/10+1)
+}
+
+// retryAfter returns the delay the API server asked to wait before retrying.
+// It sends a number of seconds, client-go waits for one second when it's
+// missing or malformed.
+func retryAfter(h http.Header) time.Duration {
+	if s, err := strconv.Atoi(h.Get("Retry-After")); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	return time.Second
+}
+
+func (r *RejectionRecorder) record(ctx context.Context, h http.Header, wait time.Duration) {
+	priorityLevel := h.Get(flowcontrolv1.ResponseHeaderMatchedPriorityLevelConfigurationUID)
+	flowSchema := h.Get(flowcontrolv1.ResponseHeaderMatchedFlowSchemaUID)
+
+	attrs := metric.WithAttributes(attribute.String("apf.priority_level_uid", priorityLevel))
+	r.rejected.Add(ctx, 1, attrs)
+	r.wait.Add(ctx, durationMS(wait), attrs)
+
+	totalsFrom(ctx).add(func(t *ThrottleTotals) {
+		t.rejected.Add(1)
+		t.retryWait.Add(int64(wait))
+	})
+	throttleEvent(ctx, "server_throttled", r.EventBurst, r.EventWindow,
+		attribute.String("apf.priority_level_uid", priorityLevel),
+		attribute.String("apf.flow_schema_uid", flowSchema),
+		attribute.Float64("retry_after_ms", durationMS(wait)),
+	)
+}
+
+// throttleEvent records a throttling event on the span in ctx. Throttling
+// repeats for as long as the API server is overloaded, so the events are
+// limited by the totals tracked with ctx, when there are any.
+func throttleEvent(ctx context.Context, name string, burst int, window time.Duration, attrs ...attribute.KeyValue) {
+	span := trace.SpanFromContext(ctx)
+	t := totalsFrom(ctx)
+	if t == nil {
+		span.AddEvent(name, trace.WithAttributes(attrs...))
+		return
+	}
+	t.limiter(name, burst, window).Record(span, attrs...)
+}
+
+// ThrottleTotals accumulates the throttling of every request made with a
+// context returned by TrackThrottle. Totals nest: requests also count
+// towards the totals tracked by any parent context.
+type ThrottleTotals struct {
+	parent     *ThrottleTotals
+	clientWait atomic.Int64
+	rejected   atomic.Int64
+	retryWait  atomic.Int64
+
+	// pending is the last client-side wait, not yet claimed by the
+	// request that waited, see claimWait.
+	pending atomic.Int64
+
+	mu       sync.Mutex
+	limiters map[string]*EventLimiter
+}
+
+type throttleKey struct{}
+
+// TrackThrottle returns a copy of ctx accumulating the throttling of every
+// request made with it.
+func TrackThrottle(ctx context.Context) (context.Context, *ThrottleTotals) {
+	t := &ThrottleTotals{parent: totalsFrom(ctx)}
+	return context.WithValue(ctx, throttleKey{}, t), t
+}
+
+func totalsFrom(ctx context.Context) *ThrottleTotals {
+	t, _ := ctx.Value(throttleKey{}).(*ThrottleTotals)
+	return t
+}
+
+// claimWait returns the client-side wait of the request about to be sent
+// with ctx. client-go waits on the rate limiter right before sending each
+// request with the same context, so the last wait recorded in the context's
+// totals is the request's, unless concurrent requests share the context.
+func claimWait(ctx context.Context) (time.Duration, bool) {
+	t := totalsFrom(ctx)
+	if t == nil {
+		return 0, false
+	}
+	return time.Duration(t.pending.Swap(0)), true
+}
+
+// limiter returns the limiter of the throttling events with the given name,
+// created with burst and window the first time.
+func (t *ThrottleTotals) limiter(name string, burst int, window time.Duration) *EventLimiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.limiters == nil {
+		t.limiters = make(map[string]*EventLimiter)
+	}
+	l, ok := t.limiters[name]
+	if !ok {
+		l = NewEventLimiter(name, burst, window)
+		t.limiters[name] = l
+	}
+	return l
+}
+
+func (t *ThrottleTotals) add(fn func(*ThrottleTotals)) {
+	for ; t != nil; t = t.parent {
+		fn(t)
+	}
+}
+
+// ClientWait returns the time spent waiting on the client-side rate limiter.
+func (t *ThrottleTotals) ClientWait() time.Duration {
+	return time.Duration(t.clientWait.Load())
+}
+
+// Rejected returns the number of requests rejected with a 429.
+func (t *ThrottleTotals) Rejected() int64 {
+	return t.rejected.Load()
+}
+
+// RetryWait returns the time waited before retrying rejected requests.
+func (t *ThrottleTotals) RetryWait() time.Duration {
+	return time.Duration(t.retryWait.Load())
+}
+
+// Record sets the totals as attributes on span, and records the throttling
+// events suppressed so far on it.
+func (t *ThrottleTotals) Record(span trace.Span) {
+	t.mu.Lock()
+	for _, name := range slices.Sorted(maps.Keys(t.limiters)) {
+		t.limiters[name].Flush(span)
+	}
+	t.mu.Unlock()
+	span.SetAttributes(
+		attribute.Float64(AttrClientThrottle, durationMS(t.ClientWait())),
+		attribute.Int64(AttrThrottledRequests, t.Rejected()),
+		attribute.Float64(AttrThrottleWait, durationMS(t.RetryWait())),
+	)
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
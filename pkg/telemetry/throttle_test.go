@@ -0,0 +1,287 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	flowcontrolv1 "k8s.io/api/flowcontrol/v1"
+)
+
+// throttlingTransport answers with a 429 the first rejections requests, then
+// with a 200, recording the body of every request it got.
+type throttlingTransport struct {
+	rejections int
+	retryAfter string
+	bodies     []string
+}
+
+func (t *throttlingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body string
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+	}
+	t.bodies = append(t.bodies, body)
+	if len(t.bodies) > t.rejections {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+	}
+	h := http.Header{}
+	h.Set(flowcontrolv1.ResponseHeaderMatchedPriorityLevelConfigurationUID, "pl-uid")
+	h.Set(flowcontrolv1.ResponseHeaderMatchedFlowSchemaUID, "fs-uid")
+	if t.retryAfter != "" {
+		h.Set("Retry-After", t.retryAfter)
+	}
+	return &http.Response{StatusCode: http.StatusTooManyRequests, Header: h, Body: io.NopCloser(strings.NewReader("slow down"))}, nil
+}
+
+// newTestRejectionRecorder returns a recorder whose instruments are collected
+// by the returned reader.
+func newTestRejectionRecorder(t *testing.T, maxRetries int) (*RejectionRecorder, *sdkmetric.ManualReader) {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() { mp.Shutdown(context.Background()) })
+	r, err := NewRejectionRecorder(mp.Meter("test"))
+	if err != nil {
+		t.Fatalf("NewRejectionRecorder() error = %v", err)
+	}
+	r.MaxRetries, r.Backoff = maxRetries, time.Millisecond
+	return r, reader
+}
+
+// rejectionMetrics returns the value of probe.throttled_requests_total and
+// probe.throttle_wait_ms for the pl-uid priority level.
+func rejectionMetrics(t *testing.T, reader *sdkmetric.ManualReader) (rejected int64, waitMS float64) {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					if pl, _ := dp.Attributes.Value("apf.priority_level_uid"); m.Name == "probe.throttled_requests_total" && pl.AsString() == "pl-uid" {
+						rejected += dp.Value
+					}
+				}
+			case metricdata.Sum[float64]:
+				for _, dp := range data.DataPoints {
+					if pl, _ := dp.Attributes.Value("apf.priority_level_uid"); m.Name == "probe.throttle_wait_ms" && pl.AsString() == "pl-uid" {
+						waitMS += dp.Value
+					}
+				}
+			}
+		}
+	}
+	return rejected, waitMS
+}
+
+func TestRejectionRecorderRetries(t *testing.T) {
+	recorder, reader := newTestRejectionRecorder(t, 3)
+	next := &throttlingTransport{rejections: 2, retryAfter: "0"}
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "create-pod")
+	ctx, totals := TrackThrottle(ctx)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://apiserver/api/v1/namespaces/default/pods", strings.NewReader(`{"kind":"Pod"}`))
+	resp, err := recorder.Wrap(next).RoundTrip(req)
+	span.End()
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200 after the retries", resp.StatusCode)
+	}
+
+	// Every retry resends the whole body
+	if len(next.bodies) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(next.bodies))
+	}
+	for i, body := range next.bodies {
+		if body != `{"kind":"Pod"}` {
+			t.Errorf("request %d body = %q, want the original one", i, body)
+		}
+	}
+
+	if got := totals.Rejected(); got != 2 {
+		t.Errorf("Rejected() = %d, want 2", got)
+	}
+	if got := totals.RetryWait(); got < 2*time.Millisecond {
+		t.Errorf("RetryWait() = %s, want at least the 2 backoffs", got)
+	}
+	rejected, waitMS := rejectionMetrics(t, reader)
+	if rejected != 2 {
+		t.Errorf("probe.throttled_requests_total = %d, want 2", rejected)
+	}
+	if waitMS < 2 {
+		t.Errorf("probe.throttle_wait_ms = %g, want at least 2", waitMS)
+	}
+
+	events := spans.GetSpans()[0].Events
+	if len(events) != 2 {
+		t.Fatalf("recorded %d events, want a server_throttled event per rejection", len(events))
+	}
+	for _, e := range events {
+		if e.Name != "server_throttled" || eventAttr(e, "apf.priority_level_uid").AsString() != "pl-uid" || eventAttr(e, "apf.flow_schema_uid").AsString() != "fs-uid" {
+			t.Errorf("event = %s %v, want server_throttled with the APF headers", e.Name, e.Attributes)
+		}
+	}
+}
+
+func TestRejectionRecorderEventsLimited(t *testing.T) {
+	recorder, _ := newTestRejectionRecorder(t, 30)
+	recorder.EventBurst, recorder.EventWindow = 3, 0
+	recorder.Backoff = 0
+	next := &throttlingTransport{rejections: 25, retryAfter: "0"}
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "wait-for-pod")
+	ctx, totals := TrackThrottle(ctx)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://apiserver/api/v1/namespaces/default/pods", nil)
+	if _, err := recorder.Wrap(next).RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	totals.Record(span)
+	span.End()
+
+	events := spans.GetSpans()[0].Events
+	if len(events) != 4 {
+		t.Fatalf("recorded %d events, want the burst of 3 and an aggregated one", len(events))
+	}
+	for _, e := range events[:3] {
+		if e.Name != "server_throttled" {
+			t.Errorf("event = %s, want server_throttled", e.Name)
+		}
+	}
+	last := events[3]
+	if last.Name != "22 more server_throttled" || eventAttr(last, "event.total").AsInt64() != 25 {
+		t.Errorf("aggregated event = %s %v, want the 22 suppressed rejections", last.Name, last.Attributes)
+	}
+	// The totals still count every rejection
+	if got := totals.Rejected(); got != 25 {
+		t.Errorf("Rejected() = %d, want 25", got)
+	}
+}
+
+func TestThrottleRecorderEventsLimited(t *testing.T) {
+	recorder := NewThrottleRecorder(1000, 1, 0)
+	recorder.EventBurst, recorder.EventWindow = 2, 0
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "wait-for-pod")
+	ctx, totals := TrackThrottle(ctx)
+	for range 10 {
+		if err := recorder.Wait(ctx); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+	totals.Record(span)
+	span.End()
+
+	events := spans.GetSpans()[0].Events
+	if len(events) != 3 || events[2].Name != "8 more client_throttled" {
+		t.Errorf("events = %v, want 2 client_throttled and an aggregated one", events)
+	}
+}
+
+func TestRejectionRecorderOutOfRetries(t *testing.T) {
+	recorder, reader := newTestRejectionRecorder(t, 2)
+	next := &throttlingTransport{rejections: 10, retryAfter: "0"}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://apiserver/api/v1/namespaces/default/pods", nil)
+	resp, err := recorder.Wrap(next).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want the last 429", resp.StatusCode)
+	}
+	// Without it, client-go would retry it again
+	if got := resp.Header.Get("Retry-After"); got != "" {
+		t.Errorf("Retry-After = %q, want it removed", got)
+	}
+	if len(next.bodies) != 3 {
+		t.Errorf("sent %d requests, want the first and 2 retries", len(next.bodies))
+	}
+	if rejected, _ := rejectionMetrics(t, reader); rejected != 3 {
+		t.Errorf("probe.throttled_requests_total = %d, want 3", rejected)
+	}
+}
+
+func TestRejectionRecorderLeavesRetriesToClientGo(t *testing.T) {
+	recorder, reader := newTestRejectionRecorder(t, 0)
+	next := &throttlingTransport{rejections: 1, retryAfter: "2"}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://apiserver/api/v1/namespaces/default/pods", nil)
+	resp, err := recorder.Wrap(next).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("response = %d with Retry-After %q, want the 429 as is", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	// client-go waits for the Retry-After delay before retrying
+	if _, waitMS := rejectionMetrics(t, reader); waitMS != 2000 {
+		t.Errorf("probe.throttle_wait_ms = %g, want 2000", waitMS)
+	}
+}
+
+func TestRejectionRecorderContextDone(t *testing.T) {
+	recorder, _ := newTestRejectionRecorder(t, 5)
+	recorder.Backoff = time.Hour
+	next := &throttlingTransport{rejections: 10}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://apiserver/api/v1/namespaces/default/pods", nil)
+	if _, err := recorder.Wrap(next).RoundTrip(req); err != context.DeadlineExceeded {
+		t.Errorf("RoundTrip() error = %v, want the context's", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"", time.Second},
+		{"-1", time.Second},
+		{"soon", time.Second},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.header != "" {
+			h.Set("Retry-After", tt.header)
+		}
+		if got := retryAfter(h); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := &RejectionRecorder{Backoff: time.Second}
+	for retries, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := r.backoff(retries); got < want || got > want+want/10 {
+			t.Errorf("backoff(%d) = %s, want %s with up to 10%% of jitter", retries, got, want)
+		}
+	}
+	if got := r.backoff(40); got > MaxBackoff+MaxBackoff/10 {
+		t.Errorf("backoff(40) = %s, want at most %s", got, MaxBackoff)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// pluginName is the name of the binary kubectl runs for `kubectl
+// latency-probe`, dashes in plugin names being underscores in binary names.
+const pluginName = "kubectl-latency_probe"
+
+// Formats of the results written to stdout.
+const (
+	resultsJSON = "json"
+	resultsText = "text"
+)
+
+var resultsFormat = flag.String("results-format", resultsJSON, "format of the results of every run written to stdout, json or text, a human-readable breakdown of the phases of each probe")
+
+// pluginDefaults are the defaults of the flags that differ when run as a
+// kubectl plugin: a one-shot probe reporting on the terminal, without a
+// telemetry backend.
+var pluginDefaults = map[string]string{
+	"exporter":       "none",
+	"results-format": resultsText,
+	"summary":        "false",
+	"progress":       "true",
+	"log-level":      "warn",
+}
+
+// isKubectlPlugin reports whether the binary runs as a kubectl plugin, i.e.
+// is named after it.
+func isKubectlPlugin(arg0 string) bool {
+	name := strings.TrimSuffix(filepath.Base(arg0), ".exe")
+	return name == pluginName
+}
+
+// setPluginDefaults changes the defaults of fs to those of the kubectl
+// plugin, and adds the -n shorthand of --namespace kubectl users expect.
+// It must be called before parsing fs.
+func setPluginDefaults(fs *flag.FlagSet) {
+	for name, value := range pluginDefaults {
+		f := fs.Lookup(name)
+		f.Value.Set(value)
+		f.DefValue = value
+	}
+	ns := fs.Lookup("namespace")
+	fs.Var(ns.Value, "n", "shorthand for --namespace")
+}
+
+// validateResultsFormat returns an error unless --results-format is known.
+func validateResultsFormat() error {
+	switch *resultsFormat {
+	case resultsJSON, resultsText:
+		return nil
+	default:
+		return fmt.Errorf("unknown --results-format %q, must be %s or %s", *resultsFormat, resultsJSON, resultsText)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runPod measures how long it takes for a label change on a freshly created
+// pod to become visible to a List, with the probe.Prober of the library.
+func (p *prober) runPod(ctx context.Context) results.Probe {
+	pr := &probe.Prober{
+		Clients:   p.clients,
+		Namespace: p.namespace,
+		Instance:  p.instance,
+		Pod: probe.PodOptions{
+			Image:        p.cfg.Image,
+			FieldManager: *fieldManager,
+			Labels: p.labels(map[string]string{
+				"app": "probe",
+			}),
+			Annotations:     p.annotations(),
+			Template:        p.cfg.PodTemplate,
+			Mutators:        p.cfg.Mutators,
+			OwnerReferences: p.owners,
+		},
+		Detection:     *detection,
+		PollIntervals: p.cfg.PollIntervals(),
+		EventBurst:    p.eventBurst(),
+		EventWindow:   *pollEventWindow,
+		PodEvents:     p.watchesPodEvents(),
+		Pending:       p.pending,
+		Tracer:        p.tracer,
+		Log:           p.log,
+		Status:        p.status,
+	}
+	if p.artifacts.enabled() {
+		pr.Observe = func(obs probe.PodObservation) { p.artifacts.observe(obs) }
+		pr.Snapshot = func(pod *corev1.Pod) { p.artifacts.snapshot(pod.Name, pod) }
+	}
+	return pr.Run(ctx)
+}
+
+// samplePending samples the number of pods pending cluster-wide, if enabled,
+// and records it in result's attributes.
+func (p *prober) samplePending(ctx context.Context, result *results.Probe) (probe.PendingPods, bool) {
+	pending, ok, err := p.pending.Sample(ctx)
+	if err != nil {
+		p.log.WarnContext(ctx, "Failed to sample pending pods", "error", err)
+	}
+	if ok {
+		result.Attributes[probe.AttrPendingPods] = pending.String()
+	}
+	return pending, ok
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+var podEvents = flag.Bool("pod-events", true, "watch the Events involving the probe pods, e.g. Scheduled, Pulling or FailedScheduling, and record them as span events explaining slow runs")
+
+// watchPodEvents starts watching the Events involving the named pod, unless
+// disabled and not escalated. The returned function stops watching and records them on the
+// span in ctx, with their timestamps shifted by the skew it is given.
+func (p *prober) watchPodEvents(ctx context.Context, name string) func(context.Context, time.Duration) {
+	if !p.watchesPodEvents() {
+		return func(context.Context, time.Duration) {}
+	}
+	w := probe.WatchEvents(ctx, p.clients.Cleanup, p.namespace, "Pod", name)
+	return func(ctx context.Context, skew time.Duration) {
+		events, err := w.Stop()
+		if err != nil {
+			p.log.WarnContext(ctx, "Failed to watch the probe pod's Events", "pod", name, "error", err)
+		}
+		probe.RecordEvents(ctx, events, skew)
+	}
+}
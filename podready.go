@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runPodReady measures how long a freshly created pod takes to become Ready,
+// broken down into scheduling, container start and readiness.
+func (p *prober) runPodReady(ctx context.Context) results.Probe {
+	readyResult := results.Probe{
+		Kind:    "pod-ready",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+
+	opts := probe.PodOptions{
+		Name:         fmt.Sprintf("probe-ready-%s", p.instance),
+		Namespace:    p.namespace,
+		Image:        p.cfg.Image,
+		FieldManager: *fieldManager,
+		Labels: p.labels(map[string]string{
+			"app": "probe",
+		}),
+		Annotations:     p.annotations(),
+		Template:        p.cfg.PodTemplate,
+		Mutators:        p.cfg.Mutators,
+		OwnerReferences: p.owners,
+	}
+
+	pending, sampled := p.samplePending(ctx, &readyResult)
+	if sampled {
+		trace.SpanFromContext(ctx).SetAttributes(pending.Attributes()...)
+	}
+
+	var (
+		created, observed corev1.Pod
+		skew              time.Duration
+	)
+	recordEvents := p.watchPodEvents(ctx, opts.Name)
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		probe.CreatePod(p.clients, opts, &created, &skew),
+		probe.WaitPodReady(p.clients.Measure, p.tracer, p.namespace, opts.Name, p.cfg.PollInterval, &skew, &observed),
+	})
+	recordEvents(ctx, skew)
+	readyResult.Phases = phases
+	for _, ph := range phases {
+		if ph.Outcome != results.OutcomeSuccess {
+			continue
+		}
+		switch ph.Name {
+		case "create-pod":
+			readyResult.AddEvent("created", ph.Start.Add(ph.Duration))
+		case "wait-ready":
+			readyResult.AddEvent("observed-ready", ph.Start.Add(ph.Duration))
+		case "teardown-create-pod":
+			readyResult.AddEvent("deleted", ph.Start.Add(ph.Duration))
+		}
+	}
+	readyResult.Attributes["clock_skew_ms"] = strconv.FormatInt(skew.Milliseconds(), 10)
+	if err != nil {
+		readyResult.Outcome = probe.OutcomeFor(err)
+		readyResult.Errors = append(readyResult.Errors, err.Error())
+		return readyResult
+	}
+
+	if observed.Spec.NodeName != "" {
+		attrs, err := probe.NodeAttributes(ctx, p.clients.Cleanup, observed.Spec.NodeName)
+		if err != nil {
+			p.log.WarnContext(ctx, "Failed to get node, only recording its name", "node", observed.Spec.NodeName, "error", err)
+		}
+		maps.Copy(readyResult.Attributes, attrs)
+	}
+	for _, ev := range probe.PodEvents(&observed) {
+		readyResult.AddEvent(ev.Name, ev.Time.Add(-skew))
+	}
+	probe.RecordPodLifecycle(ctx, &observed, skew)
+	readyResult.Phases = append(readyResult.Phases, probe.ReadyPhases(&observed, skew)...)
+
+	return readyResult
+}
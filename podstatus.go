@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runPodStatus measures how long the kubelet takes to report a started
+// container through the API, separately from scheduling and container start.
+func (p *prober) runPodStatus(ctx context.Context) results.Probe {
+	statusResult := results.Probe{
+		Kind:    "pod-status",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+
+	schedulingHist := must(otel.Meter("k8s-latency-probe").Float64Histogram("probe.scheduling.duration",
+		metric.WithDescription("Time between the creation of a probe pod and it being scheduled, by number of pods pending cluster-wide."),
+		metric.WithUnit("ms"),
+	))
+	lagHist := must(otel.Meter("k8s-latency-probe").Float64Histogram("probe.status_report_lag",
+		metric.WithDescription("Time between a container starting and the prober observing it running through the API."),
+		metric.WithUnit("ms"),
+	))
+
+	opts := probe.PodOptions{
+		Name:         fmt.Sprintf("probe-status-%s", p.instance),
+		Namespace:    p.namespace,
+		Image:        p.cfg.Image,
+		FieldManager: *fieldManager,
+		Labels: p.labels(map[string]string{
+			"app": "probe",
+		}),
+		Annotations:     p.annotations(),
+		Template:        p.cfg.PodTemplate,
+		Mutators:        p.cfg.Mutators,
+		OwnerReferences: p.owners,
+	}
+
+	pending, sampled := p.samplePending(ctx, &statusResult)
+	if sampled {
+		trace.SpanFromContext(ctx).SetAttributes(pending.Attributes()...)
+	}
+
+	var (
+		created, observed corev1.Pod
+		skew              time.Duration
+		observedAt        time.Time
+	)
+	recordEvents := p.watchPodEvents(ctx, opts.Name)
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		probe.CreatePod(p.clients, opts, &created, &skew),
+		probe.WaitPodRunning(p.clients.Measure, p.namespace, opts.Name, p.cfg.PollInterval, &observed, &observedAt),
+	})
+	recordEvents(ctx, skew)
+	statusResult.Phases = phases
+	for _, ph := range phases {
+		if ph.Outcome != results.OutcomeSuccess {
+			continue
+		}
+		switch ph.Name {
+		case "create-pod":
+			statusResult.AddEvent("created", ph.Start.Add(ph.Duration))
+		case "teardown-create-pod":
+			statusResult.AddEvent("deleted", ph.Start.Add(ph.Duration))
+		}
+	}
+	statusResult.Attributes["clock_skew_ms"] = strconv.FormatInt(skew.Milliseconds(), 10)
+	if err != nil {
+		statusResult.Outcome = probe.OutcomeFor(err)
+		statusResult.Errors = append(statusResult.Errors, err.Error())
+		return statusResult
+	}
+
+	derived, skewed := probe.StatusPhases(&observed, observedAt, skew)
+	for _, ph := range derived {
+		if ph.Name != "scheduling" {
+			continue
+		}
+		attrs := []attribute.KeyValue{attribute.String("node.name", observed.Spec.NodeName)}
+		if sampled {
+			attrs = append(attrs, attribute.String(probe.AttrPendingPods, pending.Class()))
+		}
+		schedulingHist.Record(ctx, float64(ph.Duration.Microseconds())/1000, metric.WithAttributes(attrs...))
+	}
+	for _, ev := range probe.PodEvents(&observed) {
+		statusResult.AddEvent(ev.Name, ev.Time.Add(-skew))
+	}
+	probe.RecordPodLifecycle(ctx, &observed, skew)
+	statusResult.AddEvent("observed-running", observedAt)
+	statusResult.Phases = append(statusResult.Phases, derived...)
+	if skewed {
+		statusResult.Attributes["status_report_lag.skewed"] = "true"
+		trace.SpanFromContext(ctx).AddEvent("status report lag skewed", trace.WithAttributes(
+			attribute.Int64("clock_skew_ms", skew.Milliseconds()),
+		))
+		return statusResult
+	}
+	for _, ph := range derived {
+		if ph.Name == "status-report-lag" {
+			lagHist.Record(ctx, float64(ph.Duration.Microseconds())/1000, metric.WithAttributes(
+				attribute.String("node.name", observed.Spec.NodeName),
+			))
+		}
+	}
+
+	return statusResult
+}
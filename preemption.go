@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	victimPriorityClass    = flag.String("victim-priority-class", "probe-victim", "PriorityClass of the pod the preemption probe preempts, lower than --preemptor-priority-class")
+	preemptorPriorityClass = flag.String("preemptor-priority-class", "probe-preemptor", "PriorityClass of the pod the preemption probe schedules by preempting another, higher than --victim-priority-class")
+)
+
+// runPreemption measures how long the scheduler takes to preempt a pod to
+// make room for a higher priority one: a low priority victim pod is run,
+// then a preemptor pod is created requiring the victim's node but refusing
+// to share it with the victim, so that it can only be scheduled by
+// preempting it, whatever the node's capacity.
+func (p *prober) runPreemption(ctx context.Context) results.Probe {
+	preemptResult := results.Probe{
+		Kind:    "preemption",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+
+	podOpts := func(name string, labels map[string]string, mutators ...probe.PodMutator) probe.PodOptions {
+		return probe.PodOptions{
+			Name:            name,
+			Namespace:       p.namespace,
+			Image:           p.cfg.Image,
+			FieldManager:    *fieldManager,
+			Labels:          p.labels(labels),
+			Annotations:     p.annotations(),
+			Template:        p.cfg.PodTemplate,
+			Mutators:        slices.Concat(p.cfg.Mutators, mutators),
+			OwnerReferences: p.owners,
+		}
+	}
+	victimLabels := map[string]string{"app": "probe", probe.VictimLabel: p.instance}
+	victimOpts := podOpts(fmt.Sprintf("probe-victim-%s", p.instance), victimLabels,
+		probe.WithPriorityClass(*victimPriorityClass), probe.NoGracePeriod)
+	var victim, preemptor corev1.Pod
+	preemptorOpts := podOpts(fmt.Sprintf("probe-preemptor-%s", p.instance), map[string]string{"app": "probe"},
+		probe.WithPriorityClass(*preemptorPriorityClass),
+		probe.AvoidPods(victimLabels),
+		func(pod *corev1.Pod) error {
+			return probe.RequireNode(victim.Spec.NodeName)(pod)
+		},
+	)
+
+	var (
+		skew                     time.Duration
+		preemptedAt, scheduledAt time.Time
+		preempted                bool
+	)
+	createVictim := probe.CreatePod(p.clients, victimOpts, new(corev1.Pod), new(time.Duration))
+	createVictim.Name = "create-victim-pod"
+	waitVictim := probe.WaitPodRunning(p.clients.Measure, p.namespace, victimOpts.Name, p.cfg.PollInterval, &victim, new(time.Time))
+	waitVictim.Name = "wait-victim-running"
+	createPreemptor := probe.CreatePod(p.clients, preemptorOpts, new(corev1.Pod), &skew)
+	createPreemptor.Name = "create-preemptor-pod"
+
+	// The Preempted Event on the victim names its preemptor
+	recordEvents := p.watchPodEvents(ctx, victimOpts.Name)
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		createVictim,
+		waitVictim,
+		createPreemptor,
+		probe.WaitPodPreempted(p.clients.Measure, p.namespace, victimOpts.Name, p.cfg.PollInterval, &preemptedAt, &preempted),
+		probe.WaitPodScheduled(p.clients.Measure, p.namespace, preemptorOpts.Name, p.cfg.PollInterval, &preemptor, &scheduledAt),
+	})
+	recordEvents(ctx, skew)
+	preemptResult.Phases = phases
+	preemptResult.Attributes["clock_skew_ms"] = strconv.FormatInt(skew.Milliseconds(), 10)
+	if victim.Spec.NodeName != "" {
+		preemptResult.Attributes["node"] = victim.Spec.NodeName
+	}
+	if !preemptedAt.IsZero() {
+		preemptResult.Attributes["preempted_by_scheduler"] = strconv.FormatBool(preempted)
+	}
+	if err != nil {
+		preemptResult.Outcome = probe.OutcomeFor(err)
+		preemptResult.Errors = append(preemptResult.Errors, err.Error())
+		return preemptResult
+	}
+
+	// From the preemptor's creation being acknowledged to it being bound
+	for _, ph := range phases {
+		if ph.Name == "create-preemptor-pod" {
+			preemption := results.Phase{
+				Name:     "preemption",
+				Start:    ph.Start.Add(ph.Duration),
+				Duration: scheduledAt.Sub(ph.Start.Add(ph.Duration)),
+				Outcome:  results.OutcomeSuccess,
+			}
+			probe.RecordPhaseSpans(ctx, p.tracer, []results.Phase{preemption})
+			preemptResult.Phases = append(preemptResult.Phases, preemption)
+		}
+	}
+	return preemptResult
+}
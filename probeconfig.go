@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+var (
+	podImage          = flag.String("image", "busybox", "image of the pods created by the pod, pod-status, pod-ready, endpoints, configmap-mount, dns, pvc, job, image-pull, scheduler and preemption probes, and of the e2e probe's client pods with --per-node")
+	namespaceOverride = flag.String("namespace", "", "namespace the probes operate in; defaults to $"+namespaceEnv+", then the kubeconfig's or the pod's namespace")
+	pollInterval      = flag.Duration("poll-interval", 100*time.Millisecond, "interval between polls of an object's state, once past the pod probe's first second of fast polling")
+	runTimeoutFlag    = flag.Duration("timeout", 5*time.Minute, "bound of a whole run; teardowns get their own --teardown-timeout")
+	createTimeout     = flag.Duration("create-timeout", 0, "bound of each phase creating an object, within --timeout; 0 for none")
+	detectTimeout     = flag.Duration("detect-timeout", 0, "bound of each phase waiting to observe a change, within --timeout; 0 for none")
+	teardownTimeout   = flag.Duration("teardown-timeout", probe.TeardownTimeout, "bound of each teardown of the objects a run created")
+	cleanup           = flag.Bool("cleanup", true, "delete the objects a run created when it ends; with --cleanup=false they are left behind for inspection, until they expire")
+	extraLabels       = labelsFlag{}
+	podTemplate       = &podTemplateFlag{}
+)
+
+func init() {
+	flag.Var(extraLabels, "labels", "comma-separated key=value labels set on every object the probes create, e.g. team=sre; may be repeated")
+	flag.Var(podTemplate, "pod-template", "path to a YAML PodTemplateSpec the probe pods are based on, e.g. setting their resources, node selector, tolerations, security context or priority class; may be given inline in the --config file")
+}
+
+// reservedLabels are the label keys the probes rely on, which --labels can't
+// override.
+var reservedLabels = []string{"app", "probe-instance", probe.LabelManagedBy, probe.LabelRunID}
+
+// labelsFlag collects comma-separated key=value flags into labels.
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	pairs := make([]string, 0, len(l))
+	for _, key := range slices.Sorted(maps.Keys(l)) {
+		pairs = append(pairs, key+"="+l[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (labelsFlag) repeated() {}
+
+func (l labelsFlag) Set(s string) error {
+	for pair := range strings.SplitSeq(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid label %q, must be key=value", pair)
+		}
+		l[key] = value
+	}
+	return nil
+}
+
+// podTemplateFlag holds the PodTemplateSpec read from the file it is set to,
+// or given inline in the --config file.
+type podTemplateFlag struct {
+	path     string
+	template *corev1.PodTemplateSpec
+}
+
+func (f *podTemplateFlag) String() string {
+	return f.path
+}
+
+func (f *podTemplateFlag) Set(path string) error {
+	if path == "" {
+		f.path, f.template = "", nil
+		return nil
+	}
+	template, err := probe.PodTemplateFromFile(path)
+	if err != nil {
+		return err
+	}
+	f.path, f.template = path, template
+	return nil
+}
+
+// SetObject implements objectValue.
+func (f *podTemplateFlag) SetObject(raw json.RawMessage) error {
+	template, err := probe.ParsePodTemplate(raw)
+	if err != nil {
+		return err
+	}
+	f.path, f.template = "", template
+	return nil
+}
+
+// ProbeConfig holds the tunables of the probes, set by flags or by the
+// --config file.
+type ProbeConfig struct {
+	// Image is the image of the probe pods.
+	Image string
+	// Namespace overrides the namespace the probes operate in when set.
+	Namespace string
+	// PollInterval paces the polls of the state of the objects created.
+	PollInterval time.Duration
+	// RunTimeout bounds a whole run.
+	RunTimeout time.Duration
+	// CreateTimeout and DetectTimeout bound each phase creating an object
+	// and each phase waiting to observe a change, when positive.
+	CreateTimeout time.Duration
+	DetectTimeout time.Duration
+	// TeardownTimeout bounds each teardown.
+	TeardownTimeout time.Duration
+	// Labels are set on every object created, along with the probes' own.
+	Labels map[string]string
+	// PodTemplate is the base of the probe pods, see --pod-template.
+	PodTemplate *corev1.PodTemplateSpec
+	// Mutators are applied to the probe pods, see --mutate-from.
+	Mutators []probe.PodMutator
+}
+
+// probeConfig returns the probe configuration set by the flags, validated.
+func probeConfig() (ProbeConfig, error) {
+	cfg := ProbeConfig{
+		Image:           *podImage,
+		Namespace:       *namespaceOverride,
+		PollInterval:    *pollInterval,
+		RunTimeout:      *runTimeoutFlag,
+		CreateTimeout:   *createTimeout,
+		DetectTimeout:   *detectTimeout,
+		TeardownTimeout: *teardownTimeout,
+		Labels:          maps.Clone(extraLabels),
+		PodTemplate:     podTemplate.template,
+	}
+	errs := []error{cfg.Validate()}
+	if *mutateFrom != "" {
+		mutate, err := probe.PatchMutatorFromFile(*mutateFrom)
+		errs = append(errs, err)
+		cfg.Mutators = append(cfg.Mutators, mutate)
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// Validate returns every problem with the configuration.
+func (c ProbeConfig) Validate() error {
+	var errs []error
+	if strings.TrimSpace(c.Image) == "" {
+		errs = append(errs, errors.New("image must not be empty"))
+	}
+	if c.Namespace != "" {
+		if _, err := validNamespace(c.Namespace, "--namespace"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("poll-interval must be positive, got %s", c.PollInterval))
+	}
+	if c.RunTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("timeout must be positive, got %s", c.RunTimeout))
+	}
+	if c.CreateTimeout < 0 {
+		errs = append(errs, fmt.Errorf("create-timeout must not be negative, got %s", c.CreateTimeout))
+	}
+	if c.DetectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("detect-timeout must not be negative, got %s", c.DetectTimeout))
+	}
+	if c.TeardownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("teardown-timeout must be positive, got %s", c.TeardownTimeout))
+	}
+	for _, key := range slices.Sorted(maps.Keys(c.Labels)) {
+		if slices.Contains(reservedLabels, key) {
+			errs = append(errs, fmt.Errorf("label %q is reserved for the probes", key))
+			continue
+		}
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, fmt.Errorf("invalid label key %q: %s", key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(c.Labels[key]) {
+			errs = append(errs, fmt.Errorf("invalid value of label %q: %s", key, msg))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PollIntervals returns the intervals of the pod probe's wait loop, waiting
+// PollInterval between polls once past the first second of fast polling.
+func (c ProbeConfig) PollIntervals() probe.PollIntervals {
+	intervals := probe.DefaultPollIntervals
+	intervals.Waiting = c.PollInterval
+	intervals.Fresh = min(intervals.Fresh, c.PollInterval)
+	return intervals
+}
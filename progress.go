@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+// progress renders the run's status on stderr while it is in flight. On a
+// terminal it redraws a single line; otherwise it falls back to periodic log
+// lines. It is always stopped before the results are written.
+type progress struct {
+	status *probe.Status
+	out    io.Writer
+	tty    bool
+
+	stopOnce sync.Once
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// startProgress starts rendering status. It returns nil if progress output
+// is disabled.
+func startProgress(status *probe.Status, enabled bool) *progress {
+	if !enabled {
+		return nil
+	}
+
+	p := &progress{
+		status:  status,
+		out:     os.Stderr,
+		tty:     term.IsTerminal(int(os.Stderr.Fd())),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+func (p *progress) loop() {
+	defer close(p.stopped)
+
+	interval := 200 * time.Millisecond
+	if !p.tty {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			if p.tty {
+				// Clear the progress line
+				fmt.Fprint(p.out, "\r\033[K")
+			}
+			return
+		case <-ticker.C:
+			p.render()
+		}
+	}
+}
+
+func (p *progress) render() {
+	s := p.status.Snapshot()
+	line := fmt.Sprintf("[%s] phase=%s (%s) attempts=%d",
+		s.Elapsed.Round(100*time.Millisecond),
+		s.Phase,
+		s.PhaseElapsed.Round(100*time.Millisecond),
+		s.Attempts,
+	)
+	if s.LastObservation != "" {
+		line += " last=" + s.LastObservation
+	}
+
+	if p.tty {
+		fmt.Fprintf(p.out, "\r\033[K%s", line)
+	} else {
+		fmt.Fprintln(p.out, line)
+	}
+}
+
+// stop stops rendering and waits until the display is cleared.
+func (p *progress) stop() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() { close(p.done) })
+	<-p.stopped
+}
+
+// dumpStatusOnSignal writes the status of the run in progress, as returned by
+// status, as JSON to stderr every time the process receives SIGUSR1.
+func dumpStatusOnSignal(status func() *probe.Status) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			data, _ := json.Marshal(status().Snapshot())
+			fmt.Fprintf(os.Stderr, "%s\n", data)
+		}
+	}()
+}
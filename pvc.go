@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	storageClasses = flag.String("storage-class", "", "comma-separated StorageClasses measured in turn by the pvc probe; defaults to the cluster's default StorageClass")
+	pvcSize        = flag.String("pvc-size", "1Gi", "size requested by the claims of the pvc probe")
+	pvcMount       = flag.Bool("pvc-mount", true, "also mount each claim of the pvc probe in a pod, measuring time to mounted; needed by WaitForFirstConsumer StorageClasses")
+)
+
+// defaultStorageClass names the cluster's default StorageClass in phase
+// names.
+const defaultStorageClass = "default"
+
+// parseStorageClasses returns the StorageClasses to measure from
+// --storage-class, "" standing for the cluster's default, and the size of
+// the claims from --pvc-size.
+func parseStorageClasses() ([]string, resource.Quantity, error) {
+	size, err := resource.ParseQuantity(*pvcSize)
+	if err != nil {
+		return nil, size, fmt.Errorf("invalid --pvc-size %q: %w", *pvcSize, err)
+	}
+	if *storageClasses == "" {
+		return []string{""}, size, nil
+	}
+	var classes []string
+	for class := range strings.SplitSeq(*storageClasses, ",") {
+		class = strings.TrimSpace(class)
+		if class == "" {
+			return nil, size, fmt.Errorf("invalid --storage-class %q, empty StorageClass name", *storageClasses)
+		}
+		classes = append(classes, class)
+	}
+	return classes, size, nil
+}
+
+// runPVC measures, for each StorageClass in turn, how long a freshly
+// created claim takes to be bound to a provisioned volume and, with
+// --pvc-mount, to be mounted in a pod.
+func (p *prober) runPVC(ctx context.Context, classes []string, size resource.Quantity) results.Probe {
+	pvcResult := results.Probe{
+		Kind:    "pvc",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+			"pvc_size":  size.String(),
+		},
+	}
+
+	for i, class := range classes {
+		label := class
+		if label == "" {
+			label = defaultStorageClass
+		}
+		name := fmt.Sprintf("probe-pvc-%s-%d", p.instance, i)
+		labels := p.labels(map[string]string{
+			"app":            "probe-pvc",
+			"probe-instance": p.instance,
+		})
+
+		stages := []probe.Stage{
+			probe.CreatePVC(p.clients, probe.PVCOptions{
+				Name:         name,
+				Namespace:    p.namespace,
+				Labels:       labels,
+				Annotations:  p.annotations(),
+				StorageClass: class,
+				Size:         size,
+				FieldManager: *fieldManager,
+			}),
+		}
+		var (
+			created, observed corev1.Pod
+			observedAt        time.Time
+			skew              time.Duration
+		)
+		if *pvcMount {
+			stages = append(stages, probe.CreatePod(p.clients, probe.PodOptions{
+				Name:            name,
+				Namespace:       p.namespace,
+				Image:           p.cfg.Image,
+				FieldManager:    *fieldManager,
+				Labels:          labels,
+				Annotations:     p.annotations(),
+				Template:        p.cfg.PodTemplate,
+				Mutators:        append([]probe.PodMutator{probe.MountPVC(name)}, p.cfg.Mutators...),
+				OwnerReferences: p.owners,
+			}, &created, &skew))
+		}
+		stages = append(stages, probe.WaitPVCBound(p.clients.Measure, p.namespace, name, p.cfg.PollInterval))
+		if *pvcMount {
+			stages = append(stages, probe.WaitPodRunning(p.clients.Measure, p.namespace, name, p.cfg.PollInterval, &observed, &observedAt))
+		}
+
+		phases, err := probe.RunStages(ctx, p.tracer, stages)
+		for _, ph := range phases {
+			ph.Name = fmt.Sprintf("%s@%s", ph.Name, label)
+			pvcResult.Phases = append(pvcResult.Phases, ph)
+		}
+		pvcResult.Phases = append(pvcResult.Phases, pvcPhases(phases, label)...)
+		if err != nil {
+			pvcResult.Outcome = probe.OutcomeFor(err)
+			pvcResult.Errors = append(pvcResult.Errors, fmt.Sprintf("%s: %v", label, err))
+			break
+		}
+	}
+
+	return pvcResult
+}
+
+// pvcPhases derives the time to bound and to mounted of a claim, from its
+// creation to the claim being observed bound and its pod running.
+func pvcPhases(phases []results.Phase, class string) []results.Phase {
+	end := func(name string) (time.Time, bool) {
+		for _, ph := range phases {
+			if ph.Name == name && ph.Outcome == results.OutcomeSuccess {
+				return ph.Start.Add(ph.Duration), true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	created, ok := end("create-pvc")
+	if !ok {
+		return nil
+	}
+	var derived []results.Phase
+	for _, d := range []struct{ name, stage string }{
+		{"bound", "wait-bound"},
+		{"mounted", "wait-running"},
+	} {
+		if at, ok := end(d.stage); ok {
+			derived = append(derived, results.Phase{
+				Name:     d.name + "@" + class,
+				Start:    created,
+				Duration: at.Sub(created),
+				Outcome:  results.OutcomeSuccess,
+			})
+		}
+	}
+	return derived
+}
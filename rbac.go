@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/rest"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var rbacCheck = flag.String("rbac-check", probe.RBACCheckReview, "how the rbac-propagation probe checks that its RoleBinding took effect: review, with a SelfSubjectAccessReview, or request, making the request it allows")
+
+// runRBACPropagation measures how long a RoleBinding takes to be enforced: a
+// throwaway ServiceAccount is created and authenticated, then bound to a
+// Role, and checks as that ServiceAccount whether the Role's permission is
+// allowed until it is.
+func (p *prober) runRBACPropagation(ctx context.Context, config *rest.Config) results.Probe {
+	opts := probe.IdentityOptions{
+		Name:      fmt.Sprintf("probe-rbac-%s", p.instance),
+		Namespace: p.namespace,
+		Labels: p.labels(map[string]string{
+			"app":            "probe",
+			"probe-instance": p.instance,
+		}),
+		Annotations:     p.annotations(),
+		TokenExpiration: time.Hour,
+		FieldManager:    *fieldManager,
+	}
+	opts.RoleBinding = probe.RoleTemplate(opts.Name)
+	rule := probe.RBACRule(opts)
+	rbacResult := results.Probe{
+		Kind:    "rbac-propagation",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+			"check":     *rbacCheck,
+		},
+	}
+
+	// The ServiceAccount is authenticated before it is bound, so that
+	// rbac-propagation only covers authorization
+	var token string
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		probe.CreateServiceAccount(p.clients.Cleanup, opts),
+		probe.RequestToken(p.clients.Cleanup, opts, &token),
+		probe.WaitAuthenticated(config, &token, p.namespace, rule, p.cfg.PollInterval),
+		probe.CreateRole(p.clients, opts, []rbacv1.PolicyRule{rule}),
+		probe.CreateRoleBinding(p.clients.Measure, opts),
+		probe.RBACPropagation(config, &token, p.namespace, rule, *rbacCheck, p.cfg.PollInterval),
+	})
+	rbacResult.Phases = phases
+	if err != nil {
+		rbacResult.Outcome = probe.OutcomeFor(err)
+		rbacResult.Errors = append(rbacResult.Errors, err.Error())
+	}
+
+	return rbacResult
+}
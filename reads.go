@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runReads compares the latency of the same get and list of a ConfigMap
+// read with a quorum read and from the API server's watch cache.
+func (p *prober) runReads(ctx context.Context) results.Probe {
+	reads := must(otel.Meter("k8s-latency-probe").Float64Histogram("probe.read.duration",
+		metric.WithDescription("Duration of the reads of the reads probe, by verb and consistency."),
+		metric.WithUnit("ms"),
+	))
+
+	return p.runRequests(ctx, "reads", probe.ReadStages, func(ctx context.Context, ph results.Phase, attrs []attribute.KeyValue) {
+		verb, consistency, ok := strings.Cut(ph.Name, "-")
+		if !ok || (verb != "get" && verb != "list") {
+			return
+		}
+		reads.Record(ctx, float64(ph.Duration.Microseconds())/1000, metric.WithAttributes(append(attrs,
+			attribute.String("read.verb", verb),
+			attribute.String("read.consistency", consistency),
+		)...))
+	})
+}
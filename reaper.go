@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/metadata"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+var (
+	reap         = flag.Bool("reap", true, "delete the expired objects left behind by earlier runs at startup, then every --reap-interval in daemon mode")
+	reapInterval = flag.Duration("reap-interval", 10*time.Minute, "interval between reaper passes in daemon mode")
+	reapTTL      = flag.Duration("reap-ttl", 0, "also reap the prober's objects created longer ago than this, whatever their expiry; must be longer than --timeout")
+)
+
+// The prober's own pod, exposed through the downward API, owns the probe
+// pods when set.
+const (
+	podNameEnv = telemetry.EnvPodName
+	podUIDEnv  = telemetry.EnvPodUID
+)
+
+// proberOwner returns the owner references of the probe pods: the prober's
+// own pod, running in podNamespace, so that they are garbage collected with
+// it even if the prober is killed before cleaning up. Owners must be in the
+// same namespace as their dependents, so there is none when the probes run
+// in another namespace.
+func proberOwner(podNamespace, namespace string) []metav1.OwnerReference {
+	name, uid := os.Getenv(podNameEnv), os.Getenv(podUIDEnv)
+	if name == "" || uid == "" {
+		return nil
+	}
+	if podNamespace != namespace {
+		slog.Info("Not setting the prober's pod as the owner of the probe pods, it is in another namespace", "pod_namespace", podNamespace, "namespace", namespace)
+		return nil
+	}
+	return []metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       name,
+		UID:        types.UID(uid),
+	}}
+}
+
+// reapWith deletes the expired objects left behind by earlier runs in r's
+// namespace and those of --namespaces, along with the namespaces and webhook
+// configurations they created, with client, leaving those of the active runs
+// alone, once then, in daemon mode, every --reap-interval.
+func (r *runner) reapWith(ctx context.Context, client metadata.Interface, active func() []string, daemon bool) {
+	if r.cluster != "" {
+		ctx = must(telemetry.ContextWithBaggage(ctx, map[string]string{telemetry.BaggageCluster: r.cluster}))
+	}
+	reaper := &probe.Reaper{
+		Client:     client,
+		Namespaces: []string{r.namespace},
+		Active:     active,
+		TTL:        *reapTTL,
+	}
+	if *ephemeralNamespace || r.kind == "namespace-deletion" {
+		reaper.ClusterResources = append(reaper.ClusterResources, probe.NamespaceResource)
+	}
+	if r.kind == "webhook-overhead" && r.webhook.calibration != nil {
+		reaper.ClusterResources = append(reaper.ClusterResources, probe.WebhookConfigurationResource)
+	}
+	if r.namespaces != nil {
+		reaper.ListNamespaces = func(ctx context.Context) ([]string, error) {
+			namespaces, err := r.namespaces.list(ctx, r.clientset)
+			if err != nil {
+				return nil, err
+			}
+			if !slices.Contains(namespaces, r.namespace) {
+				namespaces = append(namespaces, r.namespace)
+			}
+			return namespaces, nil
+		}
+	}
+	r.reportReaped(ctx)(reaper.Reap(ctx, time.Now()))
+	if daemon {
+		go reaper.Run(ctx, *reapInterval, r.reportReaped(ctx))
+	}
+}
+
+// reportReaped returns the report function of the reaper's passes: it logs
+// what was deleted or failed, records it in the reaped objects metric and as
+// an Event on the prober's namespace.
+func (r *runner) reportReaped(ctx context.Context) func(map[string]int, error) {
+	return func(counts map[string]int, err error) {
+		if err != nil {
+			slog.WarnContext(ctx, "Reaper failed, carrying on", "error", err)
+		}
+		if len(counts) == 0 {
+			return
+		}
+		parts := make([]string, 0, len(counts))
+		for _, res := range slices.Sorted(maps.Keys(counts)) {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[res], res))
+		}
+		slog.InfoContext(ctx, "Reaped expired objects left behind by earlier runs", "objects", strings.Join(parts, ", "))
+		r.metrics.RecordReaped(ctx, counts)
+		if err := probe.RecordReaped(ctx, r.clientset, r.namespace, counts); err != nil {
+			slog.ErrorContext(ctx, "Failed to record reaped objects event", "error", err)
+		}
+	}
+}
+
+// activeRuns returns the ID of the run in flight, if any, whose objects the
+// reaper must leave alone.
+func (r *runner) activeRuns() []string {
+	if id := r.runID.Load(); id != nil {
+		return []string{*id}
+	}
+	return nil
+}
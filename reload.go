@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+var configWatch = flag.Duration("config-watch", 0, "interval at which the --config file is checked for changes, e.g. when mounted from a ConfigMap, and reloaded with --interval; 0 only reloads it on SIGHUP")
+
+// reloadableFlags are the options of the --config file a reload applies,
+// between two runs of --interval. Changes to the others are only logged,
+// they take effect on restart.
+var reloadableFlags = []string{"probe", "interval", "max-latency", "image", "labels", "poll-interval", "timeout", "create-timeout", "detect-timeout", "teardown-timeout"}
+
+// Reload triggers.
+const (
+	reloadSIGHUP = "sighup"
+	reloadFile   = "file"
+)
+
+// configReloader reloads the --config file of a --interval daemon, on SIGHUP
+// or when it changes. A nil *configReloader never reloads.
+type configReloader struct {
+	path string
+	// cmdline are the flags set on the command line, which the file never
+	// overrides.
+	cmdline map[string]bool
+	// values are the options of the file last applied.
+	values  map[string]json.RawMessage
+	trigger chan string
+}
+
+// configChange is the change of an option of the config file.
+type configChange struct {
+	option   string
+	old, new string
+	// applied is false for the options that aren't reloadable.
+	applied bool
+}
+
+// newConfigReloader returns the reloader of --config with --interval, or nil
+// otherwise. cmdline are the flags set on the command line.
+func newConfigReloader(cmdline map[string]bool) (*configReloader, error) {
+	// The runners of --contexts copy the configuration they start with
+	if *configFile == "" || *interval <= 0 || *kubeContexts != "" {
+		if *configWatch != 0 {
+			return nil, errors.New("--config-watch needs --config and --interval")
+		}
+		return nil, nil
+	}
+	values, err := readConfig(*configFile)
+	if err != nil {
+		return nil, err
+	}
+	return &configReloader{
+		path:    *configFile,
+		cmdline: cmdline,
+		values:  values,
+		trigger: make(chan string, 1),
+	}, nil
+}
+
+// watch triggers a reload on every SIGHUP and, with --config-watch,
+// whenever the content of the file changes, until ctx is done.
+func (c *configReloader) watch(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	last, _ := os.ReadFile(c.path)
+	go func() {
+		defer signal.Stop(hup)
+		var tick <-chan time.Time
+		if *configWatch > 0 {
+			ticker := time.NewTicker(*configWatch)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				c.send(reloadSIGHUP)
+			case <-tick:
+				// Mounted ConfigMaps are updated by swapping a symlink,
+				// only the content tells whether the file changed.
+				data, err := os.ReadFile(c.path)
+				if err != nil || bytes.Equal(data, last) {
+					continue
+				}
+				last = data
+				c.send(reloadFile)
+			}
+		}
+	}()
+}
+
+// send queues a reload, unless one already is.
+func (c *configReloader) send(trigger string) {
+	select {
+	case c.trigger <- trigger:
+	default:
+	}
+}
+
+// triggered returns the channel of the reloads to apply, nil for a nil
+// *configReloader.
+func (c *configReloader) triggered() <-chan string {
+	if c == nil {
+		return nil
+	}
+	return c.trigger
+}
+
+// reload reads the config file again and applies the changes of its
+// reloadable options to r, which must not be running. Invalid changes are
+// rolled back as a whole, the previous configuration staying in effect.
+// Each reload is its own trace, with a span documenting the changes.
+func (c *configReloader) reload(ctx context.Context, r *runner, trigger string) error {
+	ctx, span := r.tracer.Start(ctx, "prober.config-reload", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("config.path", c.path),
+		attribute.String("config.trigger", trigger),
+	))
+	defer span.End()
+
+	changes, err := c.apply(r)
+	for _, ch := range changes {
+		span.AddEvent("config changed", trace.WithAttributes(
+			attribute.String("config.option", ch.option),
+			attribute.String("config.old", ch.old),
+			attribute.String("config.new", ch.new),
+			attribute.Bool("config.applied", ch.applied),
+		))
+		if !ch.applied {
+			slog.WarnContext(ctx, "Config option changed, restart to apply it", "option", ch.option, "old", ch.old, "new", ch.new)
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		slog.ErrorContext(ctx, "Failed to reload config, keeping the previous one", "path", c.path, "trigger", trigger, "error", err)
+		return err
+	}
+
+	var applied []string
+	for _, ch := range changes {
+		if ch.applied {
+			applied = append(applied, fmt.Sprintf("%s: %q -> %q", ch.option, ch.old, ch.new))
+		}
+	}
+	span.SetAttributes(attribute.Int("config.changes", len(applied)))
+	slog.InfoContext(ctx, "Config reloaded", "path", c.path, "trigger", trigger, "changes", applied)
+	return nil
+}
+
+// apply sets the flags of the options changed since the last reload and
+// updates r with them, and returns the changes.
+func (c *configReloader) apply(r *runner) ([]configChange, error) {
+	values, err := readConfig(c.path)
+	if err != nil {
+		return nil, err
+	}
+	fs := flag.CommandLine
+	for name := range values {
+		if fs.Lookup(name) == nil || name == "config" {
+			return nil, fmt.Errorf("config %s: unknown option %q", c.path, name)
+		}
+	}
+
+	previous := map[string]string{}
+	for _, name := range reloadableFlags {
+		previous[name] = fs.Lookup(name).Value.String()
+	}
+	rollback := func() {
+		for name, value := range previous {
+			resetFlag(fs.Lookup(name))
+			fs.Set(name, value)
+		}
+	}
+
+	var changes []configChange
+	names := slices.Sorted(maps.Keys(values))
+	for name := range c.values {
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		old, raw := c.values[name], values[name]
+		if bytes.Equal(old, raw) || c.cmdline[name] {
+			continue
+		}
+		if !slices.Contains(reloadableFlags, name) {
+			changes = append(changes, configChange{option: name, old: string(old), new: string(raw)})
+			continue
+		}
+		f := fs.Lookup(name)
+		change := configChange{option: name, old: f.Value.String(), applied: true}
+		// An option removed from the file goes back to its default
+		resetFlag(f)
+		if raw != nil {
+			if err := setOption(fs, name, raw); err != nil {
+				rollback()
+				return nil, fmt.Errorf("config %s: invalid %s: %w", c.path, name, err)
+			}
+		}
+		change.new = f.Value.String()
+		changes = append(changes, change)
+	}
+
+	rebuilt, err := c.validate(r)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	c.values = values
+	cfg := rebuilt.cfg
+	r.cfg = cfg
+	r.kind = *probeKind
+	r.payloadSizes = rebuilt.payloadSizes
+	r.storageClasses, r.pvcSize = rebuilt.storageClasses, rebuilt.pvcSize
+	r.webhook = rebuilt.webhook
+	probe.CreateTimeout = cfg.CreateTimeout
+	probe.DetectTimeout = cfg.DetectTimeout
+	probe.TeardownTimeout = cfg.TeardownTimeout
+	return changes, nil
+}
+
+// interval returns the --interval, as set by the last reload.
+func (c *configReloader) interval() time.Duration {
+	return *interval
+}
+
+// reloadedConfig is what a reload rebuilds of a runner's configuration.
+type reloadedConfig struct {
+	cfg            ProbeConfig
+	payloadSizes   []int
+	storageClasses []string
+	pvcSize        resource.Quantity
+	webhook        *webhookOverhead
+}
+
+// validate returns the configuration of the flags as set by a reload, or why
+// they can't be applied to r. It runs the checks startup runs, those of the
+// probe kind included, since the reload may change it.
+func (c *configReloader) validate(r *runner) (reloadedConfig, error) {
+	var (
+		rc   reloadedConfig
+		errs []error
+		err  error
+	)
+	if !slices.Contains(probeKinds, *probeKind) {
+		errs = append(errs, fmt.Errorf("unknown probe %q", *probeKind))
+	}
+	if *interval <= 0 {
+		errs = append(errs, fmt.Errorf("interval must be positive, got %s", *interval))
+	}
+	// The node rotation and namespaces are kept, only their checks of the
+	// probe kind may fail
+	if _, err := newNodeSampler(*probeKind); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newNamespaceFanOut(); err != nil {
+		errs = append(errs, err)
+	}
+	rc.cfg, err = probeConfig()
+	errs = append(errs, err)
+	if *reapTTL != 0 && *reapTTL <= rc.cfg.RunTimeout {
+		errs = append(errs, fmt.Errorf("--reap-ttl %s must be longer than --timeout %s", *reapTTL, rc.cfg.RunTimeout))
+	}
+	rc.payloadSizes, err = parsePayloadSizes()
+	errs = append(errs, err)
+	rc.storageClasses, rc.pvcSize, err = parseStorageClasses()
+	errs = append(errs, err)
+	rc.webhook, err = newWebhookOverhead(*probeKind)
+	errs = append(errs, err)
+	if rc.webhook != nil && r.webhook != nil {
+		// The calibration webhook started with the prober keeps serving
+		rc.webhook.calibration = r.webhook.calibration
+	}
+	return rc, errors.Join(errs...)
+}
+
+// resetFlag sets f back to its default value. The flags collecting repeated
+// values into maps are emptied, their Set only adding to them.
+func resetFlag(f *flag.Flag) {
+	switch v := f.Value.(type) {
+	case labelsFlag:
+		clear(v)
+	case thresholdsFlag:
+		clear(v)
+	default:
+		f.Value.Set(f.DefValue)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadChecksProbeKind(t *testing.T) {
+	setFlag(t, interval, time.Minute)
+	setFlag(t, probeKind, "pod")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("probe: webhook-overhead\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	webhook, err := newWebhookOverhead("pod")
+	if err != nil {
+		t.Fatalf("newWebhookOverhead() error = %v", err)
+	}
+	r := &runner{kind: "pod", webhook: webhook}
+
+	// Startup would reject the webhook-overhead probe without a way to tell
+	// its pods apart
+	c := &configReloader{path: path, cmdline: map[string]bool{}}
+	if _, err := c.apply(r); err == nil {
+		t.Fatal("apply() error = nil, want the webhook-overhead probe's")
+	}
+	if *probeKind != "pod" || r.kind != "pod" {
+		t.Errorf("--probe = %s and runner kind = %s after a failed reload, want pod", *probeKind, r.kind)
+	}
+
+	setFlag(t, webhookUnmatchedNamespace, "unmatched")
+	if _, err := c.apply(r); err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if r.kind != "webhook-overhead" || r.webhook.unmatchedNamespace != "unmatched" {
+		t.Errorf("runner = %s with webhook %+v, want webhook-overhead rebuilt for it", r.kind, r.webhook)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+	"go.wperron.io/k8slatencyprobe/pkg/store"
+)
+
+var (
+	storePath      = flag.String("store", "", "path to a SQLite database every probe result is recorded in, for trend analysis with the store subcommand; disabled when empty")
+	storeRetention = flag.Duration("store-retention", 30*24*time.Hour, "how long the results recorded in --store are kept; 0 keeps them forever")
+)
+
+// openStore opens the --store database, or returns nil without it.
+func openStore() (*store.Store, error) {
+	if *storePath == "" {
+		return nil, nil
+	}
+	return store.Open(*storePath)
+}
+
+// recordStore records the results of run in the --store database, then
+// deletes those older than --store-retention. A nil db records nothing.
+func recordStore(ctx context.Context, db *store.Store, run results.Run) error {
+	if db == nil {
+		return nil
+	}
+	if err := db.Record(ctx, run); err != nil {
+		return err
+	}
+	if *storeRetention > 0 {
+		if _, err := db.Prune(ctx, time.Now().Add(-*storeRetention)); err != nil {
+			return fmt.Errorf("failed to prune results older than %s: %w", *storeRetention, err)
+		}
+	}
+	return nil
+}
+
+// runStore implements the store subcommand, reporting on the results
+// recorded with --store. It returns the process exit code.
+func runStore(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s store report [flags] db\n       %s store query db 'SELECT ...'\n", os.Args[0], os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	switch args[0] {
+	case "report":
+		return runStoreReport(args[1:])
+	case "query":
+		if len(args) != 3 {
+			usage()
+			return 2
+		}
+		return runStoreQuery(args[1], args[2])
+	default:
+		usage()
+		return 2
+	}
+}
+
+// openExistingStore opens the store at path, which unlike with --store must
+// exist already.
+func openExistingStore(path string) (*store.Store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return store.Open(path)
+}
+
+// runStoreReport prints the trend of the phases recorded in a store.
+func runStoreReport(args []string) int {
+	fs := flag.NewFlagSet("store report", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s store report [flags] db\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	kind := fs.String("probe", "", "only report on this kind of probe")
+	phase := fs.String("phase", "", "only report on this phase")
+	node := fs.String("node", "", "only report on the probes that ran on this node")
+	since := fs.Duration("since", 7*24*time.Hour, "how far back the report goes")
+	bucket := fs.Duration("bucket", 24*time.Hour, "duration each row of the report covers")
+	format := fs.String("format", "table", "output format, one of table, csv or json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *bucket <= 0 {
+		fmt.Fprintf(os.Stderr, "--bucket must be positive, got %s\n", *bucket)
+		return 2
+	}
+
+	db, err := openExistingStore(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	trend, err := db.Trend(context.Background(), store.TrendOptions{
+		Kind:   *kind,
+		Phase:  *phase,
+		Node:   *node,
+		Since:  time.Now().Add(-*since),
+		Bucket: *bucket,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read results: %v\n", err)
+		return 1
+	}
+
+	switch *format {
+	case "table":
+		err = writeTrendTable(os.Stdout, trend)
+	case "csv":
+		err = writeTrendCSV(os.Stdout, trend)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(trend)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown --format %q\n", *format)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write report: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runStoreQuery prints the rows returned by a read-only SQL query on a
+// store, tab-separated with a header.
+func runStoreQuery(path, query string) int {
+	db, err := openExistingStore(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	columns, rows, err := db.Query(context.Background(), query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "query failed: %v\n", err)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t"))+"\t")
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write rows: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func writeTrendTable(w io.Writer, trend []store.TrendRow) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "BUCKET\tPHASE\tN\tERRORS\tP50\tP90\tP99\tMAX\t")
+	for _, r := range trend {
+		d := func(d time.Duration) string {
+			if r.Count == 0 {
+				return "-"
+			}
+			return formatDuration(d)
+		}
+		fmt.Fprintf(tw, "%s\t%s/%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n",
+			r.Bucket.UTC().Format(time.RFC3339), r.Kind, r.Phase, r.Count, r.Errors,
+			d(r.P50), d(r.P90), d(r.P99), d(r.Max),
+		)
+	}
+	return tw.Flush()
+}
+
+func writeTrendCSV(w io.Writer, trend []store.TrendRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"bucket", "kind", "phase", "count", "errors", "p50_ms", "p90_ms", "p99_ms", "max_ms"})
+	ms := func(d time.Duration) string { return fmt.Sprint(float64(d.Microseconds()) / 1000) }
+	for _, r := range trend {
+		cw.Write([]string{
+			r.Bucket.UTC().Format(time.RFC3339), r.Kind, r.Phase, fmt.Sprint(r.Count), fmt.Sprint(r.Errors),
+			ms(r.P50), ms(r.P90), ms(r.P99), ms(r.Max),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace/noop"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+// newTestRunner returns a runner of pod probes against client, whose run
+// metrics are collected by the returned reader. Every permission is granted.
+func newTestRunner(t *testing.T, client *fake.Clientset) (*runner, *sdkmetric.ManualReader) {
+	t.Helper()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = true
+		return true, review, nil
+	})
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() { mp.Shutdown(context.Background()) })
+	metrics, err := telemetry.NewRunMetrics(mp.Meter("test"))
+	if err != nil {
+		t.Fatalf("NewRunMetrics() error = %v", err)
+	}
+	cfg, err := probeConfig()
+	if err != nil {
+		t.Fatalf("probeConfig() error = %v", err)
+	}
+	cfg.RunTimeout = 10 * time.Second
+	return &runner{
+		cfg:         cfg,
+		tracer:      noop.NewTracerProvider().Tracer("test"),
+		metrics:     metrics,
+		activeSpans: telemetry.NewActiveSpans(),
+		clientset:   client,
+		namespace:   "probes",
+		kind:        "pod",
+		pause:       &probe.PauseChecker{Client: client, Namespace: "probes", Annotation: "probe.wperron.io/paused"},
+	}, reader
+}
+
+// runOutcomes returns the value of probe.runs_total for the pod probe, by
+// outcome.
+func runOutcomes(t *testing.T, reader *sdkmetric.ManualReader) map[results.Outcome]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	got := map[results.Outcome]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "probe.runs_total" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				if kind, _ := dp.Attributes.Value("probe.kind"); kind.AsString() != "pod" {
+					continue
+				}
+				outcome, _ := dp.Attributes.Value(attribute.Key("probe.outcome"))
+				got[results.Outcome(outcome.AsString())] += dp.Value
+			}
+		}
+	}
+	return got
+}
+
+// setFlag sets the flag behind ptr to v for the duration of the test.
+func setFlag[T any](t *testing.T, ptr *T, v T) {
+	old := *ptr
+	*ptr = v
+	t.Cleanup(func() { *ptr = old })
+}
+
+func TestRunRecordsOneOutcome(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "probes"}}
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, client *fake.Clientset)
+		outcome  results.Outcome
+		exitCode int
+	}{
+		{
+			name: "paused",
+			setup: func(t *testing.T, client *fake.Clientset) {
+				paused := namespace.DeepCopy()
+				paused.Annotations = map[string]string{"probe.wperron.io/paused": "true"}
+				client.Tracker().Add(paused)
+			},
+			outcome: results.OutcomeSkippedPaused,
+		},
+		{
+			name: "preflight failed",
+			setup: func(t *testing.T, client *fake.Clientset) {
+				client.PrependReactor("create", "selfsubjectaccessreviews", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, &authorizationv1.SelfSubjectAccessReview{}, nil
+				})
+			},
+			outcome:  results.OutcomeError,
+			exitCode: 1,
+		},
+		{
+			name: "lock skipped",
+			setup: func(t *testing.T, client *fake.Clientset) {
+				setFlag(t, exclusive, true)
+				setFlag(t, exclusiveWait, 0)
+				now := metav1.NewMicroTime(time.Now())
+				client.Tracker().Add(&coordinationv1.Lease{
+					ObjectMeta: metav1.ObjectMeta{Name: lockName, Namespace: "probes"},
+					Spec: coordinationv1.LeaseSpec{
+						HolderIdentity:       ptr.To("other-run"),
+						LeaseDurationSeconds: ptr.To(int32(3600)),
+						AcquireTime:          &now,
+						RenewTime:            &now,
+					},
+				})
+			},
+			outcome: results.OutcomeSkippedLocked,
+		},
+		{
+			name: "namespace terminating",
+			setup: func(t *testing.T, client *fake.Clientset) {
+				client.PrependReactor("create", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+					err := apierrors.NewForbidden(corev1.Resource("pods"), "", errors.New("namespace probes is being terminated"))
+					err.ErrStatus.Details.Causes = []metav1.StatusCause{{Type: corev1.NamespaceTerminatingCause}}
+					return true, nil, err
+				})
+			},
+			outcome:  results.OutcomeNamespaceTerminating,
+			exitCode: 1,
+		},
+		{
+			name: "error",
+			setup: func(t *testing.T, client *fake.Clientset) {
+				client.PrependReactor("create", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewBadRequest("invalid pod")
+				})
+			},
+			outcome:  results.OutcomeError,
+			exitCode: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewClientset()
+			r, reader := newTestRunner(t, client)
+			tt.setup(t, client)
+			if _, err := client.CoreV1().Namespaces().Get(context.Background(), "probes", metav1.GetOptions{}); apierrors.IsNotFound(err) {
+				client.Tracker().Add(namespace.DeepCopy())
+			}
+
+			if code := r.run(context.Background()); code != tt.exitCode {
+				t.Errorf("run() = %d, want %d", code, tt.exitCode)
+			}
+			got := runOutcomes(t, reader)
+			if len(got) != 1 || got[tt.outcome] != 1 {
+				t.Errorf("probe.runs_total = %v, want a single %s run", got, tt.outcome)
+			}
+			if n := r.metrics.Runs("pod"); n != 1 {
+				t.Errorf("Runs() = %d, want 1", n)
+			}
+		})
+	}
+}
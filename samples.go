@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+var (
+	count       = flag.Int("count", 1, "number of probes of the --probe kind each run takes, each with its own objects, for statistical sampling")
+	concurrency = flag.Int("concurrency", 1, "number of the --count probes of a run in flight at once")
+)
+
+// validateSampling returns an error unless --count and --concurrency are
+// usable.
+func validateSampling() error {
+	if *count < 1 {
+		return fmt.Errorf("--count must be at least 1, got %d", *count)
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1, got %d", *concurrency)
+	}
+	return nil
+}
+
+// runSamples runs --count probes, on each of the nodes sampled with
+// --per-node, in each zone with --per-zone or in each namespace of
+// --namespaces, --concurrency of them at once, per namespace for the latter,
+// and returns their results in order, along with whether any of them failed. A single probe runs right
+// under the run's root span as it always did; several each get their own
+// instance ID and prober.sample span.
+func (r *runner) runSamples(ctx context.Context, p *prober, start time.Time) ([]results.Probe, bool) {
+	var samples []*prober
+	// Set with --per-node, see runNodeSample
+	var sampleAttrs map[string]string
+	limit := *concurrency
+	switch {
+	case r.namespaces != nil:
+		namespaces, err := r.namespaces.list(ctx, r.clientset)
+		if err != nil {
+			p.log.ErrorContext(ctx, "Failed to list the namespaces to probe", "error", err)
+			return []results.Probe{failedSampling(p, err)}, true
+		}
+		for _, ns := range namespaces {
+			for range *count {
+				samples = append(samples, p.sample().inNamespace(ns, r.namespace))
+			}
+		}
+		// Namespaces are compared, so they are probed at the same time
+		limit *= len(namespaces)
+	case r.nodes != nil && *perZone:
+		zones, err := r.sampleZones(ctx)
+		if err != nil {
+			p.log.ErrorContext(ctx, "Failed to list the zones to probe", "error", err)
+			return []results.Probe{failedSampling(p, err)}, true
+		}
+		for _, zone := range zones {
+			for range *count {
+				samples = append(samples, p.sample().inZone(zone))
+			}
+		}
+	case r.nodes != nil:
+		nodes, err := r.sampleNodes(ctx, p.log)
+		if err != nil {
+			p.log.ErrorContext(ctx, "Failed to pick the nodes to probe", "error", err)
+			return []results.Probe{failedSampling(p, err)}, true
+		}
+		sampleAttrs = nodes.attributes()
+		for _, node := range nodes.names {
+			for range *count {
+				samples = append(samples, p.sample().onNode(node))
+			}
+		}
+	case *count == 1:
+		result, failed := r.runSample(ctx, p, start)
+		return []results.Probe{result}, failed
+	default:
+		for range *count {
+			samples = append(samples, p.sample())
+		}
+	}
+
+	probes := make([]results.Probe, len(samples))
+	failed := make([]bool, len(samples))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, sp := range samples {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			sctx := must(telemetry.ContextWithBaggage(ctx, map[string]string{
+				telemetry.BaggageInstanceID: sp.instance,
+			}))
+			sctx, span := r.tracer.Start(sctx, "prober.sample")
+			defer span.End()
+			span.SetAttributes(attribute.Int("sample.index", i), attribute.String("instance", sp.instance))
+			if sp.node != "" {
+				span.SetAttributes(attribute.String(probe.AttrNodeName, sp.node))
+			}
+			if sp.zone != "" {
+				span.SetAttributes(attribute.String(probe.AttrZone, sp.zone))
+			}
+			if r.namespaces != nil {
+				span.SetAttributes(attribute.String(attrNamespace, sp.namespace))
+				// Every namespace has its own policies, checked as the
+				// run's namespace otherwise is
+				if err := preflight(sctx, r.clientset, sp.namespace, sp.kind); err != nil {
+					sp.log.ErrorContext(sctx, "Preflight failed", "error", err)
+					probes[i], failed[i] = failedSampling(sp, err), true
+					probes[i].Attributes["namespace"] = sp.namespace
+					span.SetStatus(codes.Error, err.Error())
+					return
+				}
+			}
+
+			probes[i], failed[i] = r.runSample(sctx, sp, start)
+			if sp.node != "" {
+				probes[i].Attributes[probe.AttrNodeName] = sp.node
+			}
+			maps.Copy(probes[i].Attributes, sampleAttrs)
+			if sp.zone != "" {
+				probes[i].Attributes[probe.AttrZone] = sp.zone
+			}
+			if r.namespaces != nil {
+				probes[i].Attributes["namespace"] = sp.namespace
+			}
+			span.SetAttributes(attribute.String("probe.outcome", string(probes[i].Outcome)))
+			if failed[i] {
+				span.SetStatus(codes.Error, string(probes[i].Outcome))
+			}
+		}()
+	}
+	wg.Wait()
+	return probes, slices.Contains(failed, true)
+}
+
+// runSample runs a single probe of the run's kind, and returns its result and
+// whether it failed.
+func (r *runner) runSample(ctx context.Context, p *prober, start time.Time) (results.Probe, bool) {
+	ctx, throttle := telemetry.TrackThrottle(ctx)
+	result, err := p.runProbe(ctx, p.kind, func(ctx context.Context) results.Probe {
+		if custom, ok := probe.Registered(p.kind); ok {
+			return p.runCustom(ctx, custom)
+		}
+		switch p.kind {
+		case "e2e":
+			return p.runE2E(ctx, r.ipFamily, r.trafficPolicy)
+		case "configmap", "secret":
+			return p.runObject(ctx, p.kind, r.payloadSizes)
+		case "pod-status":
+			return p.runPodStatus(ctx)
+		case "pod-ready":
+			return p.runPodReady(ctx)
+		case "endpoints":
+			return p.runEndpoints(ctx)
+		case "configmap-mount":
+			return p.runConfigMapMount(ctx)
+		case "dns":
+			return p.runDNS(ctx, r.ipFamily)
+		case "pvc":
+			return p.runPVC(ctx, r.storageClasses, r.pvcSize)
+		case "job":
+			return p.runJob(ctx)
+		case "image-pull":
+			return p.runImagePull(ctx)
+		case "scheduler":
+			return p.runScheduler(ctx)
+		case "preemption":
+			return p.runPreemption(ctx)
+		case "verbs":
+			return p.runVerbs(ctx)
+		case "reads":
+			return p.runReads(ctx)
+		case "namespace-deletion":
+			return p.runNamespaceDeletion(ctx)
+		case "webhook-overhead":
+			return p.runWebhookOverhead(ctx, r.webhook)
+		case "rbac-propagation":
+			return p.runRBACPropagation(ctx, r.identityConfig)
+		case "token-request":
+			return p.runTokenRequest(ctx)
+		default:
+			return p.runPod(ctx)
+		}
+	})
+	if throttle.Rejected() > 0 {
+		result.Attributes[telemetry.AttrThrottledRequests] = strconv.FormatInt(throttle.Rejected(), 10)
+		result.Attributes[telemetry.AttrThrottleWait] = strconv.FormatInt(throttle.RetryWait().Milliseconds(), 10)
+		if probe.Throttled(result.Outcome, time.Since(start), r.cfg.RunTimeout, throttle.RetryWait()) {
+			result.Outcome = results.OutcomeThrottled
+		}
+	}
+	r.tagZone(ctx, p, &result)
+	checkThresholds(ctx, &result, r.thresholds)
+	return result, err != nil || (result.Outcome != results.OutcomeSuccess && !result.Outcome.Skipped())
+}
+
+// failedSampling returns the result of a run whose nodes, zones or
+// namespaces couldn't be listed, or of a probe failing its preflight.
+func failedSampling(p *prober, err error) results.Probe {
+	return results.Probe{
+		Kind:       p.kind,
+		Outcome:    probe.OutcomeFor(err),
+		Attributes: map[string]string{},
+		Errors:     []string{err.Error()},
+	}
+}
+
+// sample returns a copy of the prober with its own instance ID, so that the
+// objects of concurrent probes of a run never collide.
+func (p *prober) sample() *prober {
+	sp := *p
+	sp.instance = must(newID())
+	sp.log = probeLogger(p.runID, sp.instance, p.namespace, p.kind, p.cluster)
+	return &sp
+}
+
+// runCustom runs a probe of a kind registered with probe.Register.
+func (p *prober) runCustom(ctx context.Context, custom probe.Probe) results.Probe {
+	return probe.RunProbe(ctx, custom, probe.Env{
+		Clients:         p.clients,
+		Namespace:       p.namespace,
+		Instance:        p.instance,
+		Labels:          p.labels(nil),
+		Annotations:     p.annotations(),
+		OwnerReferences: p.owners,
+		Tracer:          p.tracer,
+		Log:             p.log,
+		Status:          p.status,
+	})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+)
+
+var (
+	schedules      = scheduleFlag{}
+	scheduleJitter = flag.Duration("schedule-jitter", 0, "maximum random delay added to every run started by --schedule, so that probers sharing a schedule don't all hit the API server at once")
+	scheduleAddr   = flag.String("schedule-addr", "", "address on which the --schedule entries are listed on /schedules and enabled or disabled at runtime, e.g. :8082; disabled when empty")
+)
+
+func init() {
+	flag.Var(&schedules, "schedule", "kind=cron run the probe of the given kind on a cron schedule instead of the --probe, e.g. \"pod=* * * * *\" or \"pvc=@hourly\"; may be repeated, every kind running on its own schedule")
+}
+
+// scheduleEntry is a --schedule flag, a probe kind and the cron expression it
+// runs on.
+type scheduleEntry struct {
+	kind string
+	expr string
+	cron cron.Schedule
+}
+
+// scheduleFlag collects the --schedule flags.
+type scheduleFlag []scheduleEntry
+
+func (s *scheduleFlag) String() string {
+	entries := make([]string, 0, len(*s))
+	for _, e := range *s {
+		entries = append(entries, e.kind+"="+e.expr)
+	}
+	return strings.Join(entries, ",")
+}
+
+func (*scheduleFlag) repeated() {}
+
+func (s *scheduleFlag) Set(v string) error {
+	kind, expr, ok := strings.Cut(v, "=")
+	kind, expr = strings.TrimSpace(kind), strings.TrimSpace(expr)
+	if !ok || kind == "" || expr == "" {
+		return fmt.Errorf("invalid schedule %q, must be kind=cron", v)
+	}
+	if !slices.Contains(probeKinds, kind) {
+		return fmt.Errorf("unknown probe %q in schedule %q", kind, v)
+	}
+	if slices.ContainsFunc(*s, func(e scheduleEntry) bool { return e.kind == kind }) {
+		return fmt.Errorf("probe %q is scheduled twice", kind)
+	}
+	sched, err := probe.ParseCron(expr)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, scheduleEntry{kind: kind, expr: expr, cron: sched})
+	return nil
+}
+
+// longestPeriod returns the longest time between two runs of the schedules
+// after now.
+func (s scheduleFlag) longestPeriod(now time.Time) time.Duration {
+	var longest time.Duration
+	for _, e := range s {
+		next := e.cron.Next(now)
+		longest = max(longest, e.cron.Next(next).Sub(next))
+	}
+	return longest
+}
+
+// scheduler runs the probes of the --schedule entries, each kind on its own
+// runner so that runs of different kinds can overlap, while those of a kind
+// never do.
+type scheduler struct {
+	jitter time.Duration
+	jobs   []*scheduledJob
+}
+
+// scheduledJob runs the probe of a kind on its schedule.
+type scheduledJob struct {
+	entry  scheduleEntry
+	runner *runner
+
+	mu       sync.Mutex
+	disabled bool
+	next     time.Time
+	lastRun  time.Time
+	lastCode int
+}
+
+// scheduleStatus is the state of a schedule listed on /schedules.
+type scheduleStatus struct {
+	Probe    string    `json:"probe"`
+	Schedule string    `json:"schedule"`
+	Enabled  bool      `json:"enabled"`
+	NextRun  time.Time `json:"next_run,omitzero"`
+	LastRun  time.Time `json:"last_run,omitzero"`
+	// LastExitCode is the exit code of the last run, 0 if it succeeded.
+	LastExitCode int `json:"last_exit_code"`
+}
+
+// newScheduler returns the scheduler of the --schedule entries, or nil
+// without any. Its jobs need runners, see bind, before it runs.
+func newScheduler(entries []scheduleEntry, jitter time.Duration) *scheduler {
+	if len(entries) == 0 {
+		return nil
+	}
+	s := &scheduler{jitter: jitter}
+	for _, e := range entries {
+		s.jobs = append(s.jobs, &scheduledJob{entry: e})
+	}
+	return s
+}
+
+// bind gives every job its own runner, derived from base.
+func (s *scheduler) bind(base *runner) {
+	for _, j := range s.jobs {
+		j.runner = base.forProbe(j.entry.kind, base.namespace)
+	}
+}
+
+// run runs every schedule until ctx is done, then waits for the runs in
+// flight to end.
+func (s *scheduler) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.loop(ctx, s.jitter)
+		}()
+	}
+	<-ctx.Done()
+	slog.InfoContext(ctx, "Stopping, waiting for the scheduled runs in flight")
+	wg.Wait()
+}
+
+// loop runs the job's probe at every activation of its schedule, delayed by
+// up to jitter, until ctx is done. Activations missed while a run was in
+// flight are skipped, and so are those of a disabled schedule.
+func (j *scheduledJob) loop(ctx context.Context, jitter time.Duration) {
+	log := slog.With("probe", j.entry.kind, "schedule", j.entry.expr)
+	for {
+		next := j.entry.cron.Next(time.Now())
+		if jitter > 0 {
+			next = next.Add(rand.N(jitter))
+		}
+		j.mu.Lock()
+		j.next = next
+		j.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !j.enabled() {
+			log.DebugContext(ctx, "Schedule disabled, skipping run")
+			continue
+		}
+		code := j.runner.run(ctx)
+		if code != 0 {
+			log.WarnContext(ctx, "Run failed", "exit_code", code)
+		}
+		j.mu.Lock()
+		j.lastRun, j.lastCode = time.Now(), code
+		j.mu.Unlock()
+	}
+}
+
+func (j *scheduledJob) enabled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.disabled
+}
+
+func (j *scheduledJob) status() scheduleStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return scheduleStatus{
+		Probe:        j.entry.kind,
+		Schedule:     j.entry.expr,
+		Enabled:      !j.disabled,
+		NextRun:      j.next,
+		LastRun:      j.lastRun,
+		LastExitCode: j.lastCode,
+	}
+}
+
+// activeRuns returns the IDs of the runs in flight, whose objects the reaper
+// must leave alone.
+func (s *scheduler) activeRuns() []string {
+	var ids []string
+	for _, j := range s.jobs {
+		ids = append(ids, j.runner.activeRuns()...)
+	}
+	return ids
+}
+
+// list serves the state of every schedule.
+func (s *scheduler) list(w http.ResponseWriter, _ *http.Request) {
+	statuses := make([]scheduleStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		statuses = append(statuses, j.status())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// toggle returns the handler enabling or disabling the schedule of the
+// {probe} in the request's path. Disabling a schedule doesn't stop its run
+// in flight, if any.
+func (s *scheduler) toggle(enable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kind := r.PathValue("probe")
+		i := slices.IndexFunc(s.jobs, func(j *scheduledJob) bool { return j.entry.kind == kind })
+		if i < 0 {
+			http.Error(w, fmt.Sprintf("no schedule for probe %q", kind), http.StatusNotFound)
+			return
+		}
+		j := s.jobs[i]
+		j.mu.Lock()
+		j.disabled = !enable
+		j.mu.Unlock()
+		slog.InfoContext(r.Context(), "Schedule toggled", "probe", kind, "enabled", enable)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(j.status())
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runScheduler tells the scheduler's latency apart from the kubelet's: a
+// first, unbound pod is waited for until the scheduler binds it, then until
+// its container runs, and a second pod is created bound to the same node,
+// bypassing the scheduler, giving the kubelet's latency on its own. A
+// regression in the wait-scheduled phase is the scheduler's, one in both
+// wait-running and wait-bound-running the node's.
+func (p *prober) runScheduler(ctx context.Context) results.Probe {
+	schedResult := results.Probe{
+		Kind:    "scheduler",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+
+	podOpts := func(name string, mutators ...probe.PodMutator) probe.PodOptions {
+		return probe.PodOptions{
+			Name:         name,
+			Namespace:    p.namespace,
+			Image:        p.cfg.Image,
+			FieldManager: *fieldManager,
+			Labels: p.labels(map[string]string{
+				"app": "probe",
+			}),
+			Annotations:     p.annotations(),
+			Template:        p.cfg.PodTemplate,
+			Mutators:        slices.Concat(p.cfg.Mutators, mutators),
+			OwnerReferences: p.owners,
+		}
+	}
+	unboundOpts := podOpts(fmt.Sprintf("probe-sched-%s", p.instance))
+	var scheduled, running, bound corev1.Pod
+	boundOpts := podOpts(fmt.Sprintf("probe-bound-%s", p.instance), func(pod *corev1.Pod) error {
+		pod.Spec.NodeName = scheduled.Spec.NodeName
+		return nil
+	})
+
+	var (
+		skew                            time.Duration
+		scheduledAt, runningAt, boundAt time.Time
+	)
+	waitBound := probe.WaitPodRunning(p.clients.Measure, p.namespace, boundOpts.Name, p.cfg.PollInterval, &bound, &boundAt)
+	waitBound.Name = "wait-bound-running"
+	createBound := probe.CreatePod(p.clients, boundOpts, new(corev1.Pod), new(time.Duration))
+	createBound.Name = "create-bound-pod"
+
+	// FailedScheduling Events explain a slow wait-scheduled
+	recordEvents := p.watchPodEvents(ctx, unboundOpts.Name)
+	phases, err := probe.RunStages(ctx, p.tracer, []probe.Stage{
+		probe.CreatePod(p.clients, unboundOpts, new(corev1.Pod), &skew),
+		probe.WaitPodScheduled(p.clients.Measure, p.namespace, unboundOpts.Name, p.cfg.PollInterval, &scheduled, &scheduledAt),
+		probe.WaitPodRunning(p.clients.Measure, p.namespace, unboundOpts.Name, p.cfg.PollInterval, &running, &runningAt),
+		createBound,
+		waitBound,
+	})
+	recordEvents(ctx, skew)
+	schedResult.Phases = phases
+	schedResult.Attributes["clock_skew_ms"] = strconv.FormatInt(skew.Milliseconds(), 10)
+	if scheduled.Spec.NodeName != "" {
+		schedResult.Attributes["node"] = scheduled.Spec.NodeName
+	}
+	if err != nil {
+		schedResult.Outcome = probe.OutcomeFor(err)
+		schedResult.Errors = append(schedResult.Errors, err.Error())
+		return schedResult
+	}
+
+	// The cluster's timestamps break the waits down further, to the second
+	for _, pod := range []struct {
+		pod    *corev1.Pod
+		at     time.Time
+		suffix string
+	}{{&running, runningAt, ""}, {&bound, boundAt, "-bound"}} {
+		derived, _ := probe.StatusPhases(pod.pod, pod.at, skew)
+		for _, ph := range derived {
+			ph.Name += pod.suffix
+			schedResult.Phases = append(schedResult.Phases, ph)
+		}
+	}
+	return schedResult
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	sloConfig       = flag.String("slo-config", "", "path to a YAML file of SLOs whose error budget burn rates are computed over the results of the runs, with --interval, --schedule or --operator")
+	sloAlertmanager = flag.String("slo-alertmanager-url", "", "Alertmanager compatible endpoint the SLO windows burning their budget too fast are posted to as alerts, e.g. http://alertmanager:9093/api/v2/alerts")
+)
+
+// sloMonitor computes the burn rates of the SLOs of --slo-config after every
+// run, records them as metrics and alerts on the windows breached.
+type sloMonitor struct {
+	evaluator *probe.SLOEvaluator
+	alerter   *probe.BurnRateAlerter
+	burnRate  metric.Float64Gauge
+	breached  metric.Int64Gauge
+}
+
+// newSLOMonitor returns the monitor of the SLOs of --slo-config, or nil
+// without it.
+func newSLOMonitor() (*sloMonitor, error) {
+	if *sloConfig == "" {
+		if *sloAlertmanager != "" {
+			return nil, fmt.Errorf("--slo-alertmanager-url needs --slo-config")
+		}
+		return nil, nil
+	}
+	if *interval <= 0 && !*operatorMode && len(schedules) == 0 {
+		return nil, fmt.Errorf("--slo-config needs --interval, --schedule or --operator")
+	}
+	slos, err := probe.LoadSLOs(*sloConfig)
+	if err != nil {
+		return nil, err
+	}
+	meter := otel.Meter("k8s-latency-probe")
+	m := &sloMonitor{
+		evaluator: probe.NewSLOEvaluator(slos),
+		burnRate: must(meter.Float64Gauge("probe.slo.burn_rate",
+			metric.WithDescription("Rate at which the error budget of an SLO is burning over a window, 1 burning it exactly as fast as the objective allows."),
+			metric.WithUnit("1"),
+		)),
+		breached: must(meter.Int64Gauge("probe.slo.breached",
+			metric.WithDescription("Whether the error budget of an SLO burns faster than its threshold over both a window's long and short durations."),
+			metric.WithUnit("1"),
+		)),
+	}
+	if *sloAlertmanager != "" {
+		m.alerter = probe.NewBurnRateAlerter(*sloAlertmanager, *clusterName)
+	}
+	return m, nil
+}
+
+// observe counts the probes of a run that just ended toward the SLOs, then
+// records their burn rates and sends the alerts due.
+func (m *sloMonitor) observe(ctx context.Context, p *prober, probes []results.Probe) {
+	if m == nil {
+		return
+	}
+	now := time.Now()
+	m.evaluator.Observe(probes, now)
+	statuses := m.evaluator.Evaluate(now)
+	for _, st := range statuses {
+		for _, w := range st.Windows {
+			slo := attribute.String("slo.name", st.SLO.Name)
+			m.burnRate.Record(ctx, w.Long, metric.WithAttributes(slo, attribute.String("slo.window", w.Window.Long.Duration.String())))
+			m.burnRate.Record(ctx, w.Short, metric.WithAttributes(slo, attribute.String("slo.window", w.Window.Short.Duration.String())))
+			var breached int64
+			if w.Breached {
+				breached = 1
+				p.log.WarnContext(ctx, "SLO error budget burning too fast", "slo", st.SLO.Name, "window", w.Window.Long.Duration.String(),
+					"burn_rate", w.Long, "short_burn_rate", w.Short, "threshold", w.Window.BurnRate)
+			}
+			m.breached.Record(ctx, breached, metric.WithAttributes(slo, attribute.String("slo.window", w.Window.Long.Duration.String())))
+		}
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := m.alerter.Send(sctx, m.alerter.Alerts(statuses, now)); err != nil {
+		p.log.ErrorContext(ctx, "Failed to send SLO alerts", "url", *sloAlertmanager, "error", err)
+	}
+}
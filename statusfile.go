@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// maxStatusSize is the size limit of a container's termination message.
+const maxStatusSize = 4096
+
+// attrPanic marks the result of a probe that panicked.
+const attrPanic = "probe.panic"
+
+// exitStatus is the compact summary of a run written to --status-file, e.g.
+// the container's termination message, for callers that need a structured
+// outcome without parsing the results.
+type exitStatus struct {
+	Outcome    results.Outcome `json:"outcome"`
+	ExitCode   int             `json:"exit_code"`
+	RunID      string          `json:"run_id,omitempty"`
+	TraceID    string          `json:"trace_id,omitempty"`
+	ErrorClass string          `json:"error_class,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	// LatenciesMS are the durations of the probes' phases, keyed by phase
+	// name, prefixed with the probe kind when the run has several probes.
+	LatenciesMS map[string]int64 `json:"latencies_ms,omitempty"`
+	// Truncated is set when some of the above was cut to fit
+	// maxStatusSize.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// statusFile writes the run's exitStatus to a path when the run ends. A nil
+// *statusFile writes nothing. Concurrent runs share it.
+type statusFile struct {
+	path string
+
+	mu      sync.Mutex
+	status  exitStatus
+	written bool
+}
+
+func newStatusFile(path string) *statusFile {
+	if path == "" {
+		return nil
+	}
+	return &statusFile{path: path}
+}
+
+// record summarizes the final results of the run.
+func (s *statusFile) record(res *results.Results, traceID string) {
+	if s == nil {
+		return
+	}
+	st := exitStatus{
+		Outcome: results.OutcomeSuccess,
+		RunID:   res.Run.ID,
+		TraceID: traceID,
+	}
+	for _, pr := range res.Run.Probes {
+		for _, ph := range pr.Phases {
+			name := ph.Name
+			if len(res.Run.Probes) > 1 {
+				name = pr.Kind + "." + name
+			}
+			if st.LatenciesMS == nil {
+				st.LatenciesMS = make(map[string]int64)
+			}
+			st.LatenciesMS[name] = ph.Duration.Milliseconds()
+		}
+		if pr.Outcome == results.OutcomeSuccess || st.Outcome != results.OutcomeSuccess {
+			continue
+		}
+		st.Outcome = pr.Outcome
+		st.ErrorClass = errorClass(pr)
+		if len(pr.Errors) > 0 {
+			st.Error = pr.Errors[0]
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = true
+	s.status = st
+}
+
+// errorClass returns the class of the failure of pr: "panic", or the outcome
+// of its first failed phase.
+func errorClass(pr results.Probe) string {
+	if pr.Attributes[attrPanic] == "true" {
+		return "panic"
+	}
+	for _, ph := range pr.Phases {
+		if ph.Outcome != results.OutcomeSuccess {
+			return string(ph.Outcome)
+		}
+	}
+	return string(pr.Outcome)
+}
+
+// fail records err as the reason the run ended before any probe ran.
+func (s *statusFile) fail(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = true
+	s.status = exitStatus{Outcome: results.OutcomeError, ErrorClass: "setup", Error: err.Error()}
+}
+
+// write writes the status to the file, as a run ending with exitCode or
+// panicking with recovered, which is non-nil in that case.
+func (s *statusFile) write(exitCode int, recovered any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status
+	switch {
+	case recovered != nil:
+		st.Outcome, st.ErrorClass, st.Error = results.OutcomeError, "panic", fmt.Sprint(recovered)
+		// The runtime exits with code 2 on unrecovered panics
+		exitCode = 2
+	case !s.written:
+		st.Outcome, st.ErrorClass = results.OutcomeError, "setup"
+		st.Error = "the run ended before any probe ran"
+	}
+	st.ExitCode = exitCode
+
+	data, err := marshalStatus(st, maxStatusSize)
+	if err == nil {
+		err = os.WriteFile(s.path, data, 0o644)
+	}
+	if err != nil {
+		slog.Error("Failed to write status file", "error", err)
+	}
+}
+
+// marshalStatus encodes st in at most limit bytes. When it doesn't fit,
+// latencies are dropped until it would fit with no error message, then the
+// error message is cut to the longest that fits, so the result is always a
+// complete JSON document.
+func marshalStatus(st exitStatus, limit int) ([]byte, error) {
+	data, err := json.Marshal(st)
+	if err != nil || len(data) <= limit {
+		return data, err
+	}
+	st.Truncated = true
+
+	message := st.Error
+	encode := func(n int) ([]byte, bool) {
+		st.Error = truncateString(message, n)
+		data, err := json.Marshal(st)
+		return data, err == nil && len(data) <= limit
+	}
+
+	names := slices.Sorted(maps.Keys(st.LatenciesMS))
+	for _, ok := encode(0); !ok; _, ok = encode(0) {
+		if len(names) == 0 {
+			return nil, fmt.Errorf("status doesn't fit in %d bytes", limit)
+		}
+		delete(st.LatenciesMS, names[len(names)-1])
+		names = names[:len(names)-1]
+	}
+
+	n := sort.Search(len(message)+1, func(n int) bool {
+		_, ok := encode(n)
+		return !ok
+	})
+	data, _ = encode(n - 1)
+	return data, nil
+}
+
+// truncateString cuts s to at most n bytes on a rune boundary, marking the
+// cut with an ellipsis.
+func truncateString(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return strings.TrimSpace(s[:n]) + "..."
+}
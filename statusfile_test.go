@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+func TestMarshalStatusTruncated(t *testing.T) {
+	st := exitStatus{
+		Outcome:     results.OutcomeError,
+		ExitCode:    1,
+		ErrorClass:  "timeout",
+		Error:       strings.Repeat("délai dépassé ", 1000),
+		LatenciesMS: map[string]int64{},
+	}
+	for i := range 300 {
+		st.LatenciesMS[fmt.Sprintf("pod.phase-%03d", i)] = int64(i)
+	}
+
+	data, err := marshalStatus(st, maxStatusSize)
+	if err != nil {
+		t.Fatalf("marshalStatus() error = %v", err)
+	}
+	if len(data) > maxStatusSize {
+		t.Errorf("status is %d bytes, want at most %d", len(data), maxStatusSize)
+	}
+	var got exitStatus
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("status isn't valid JSON: %v", err)
+	}
+	if !got.Truncated || got.Outcome != results.OutcomeError || got.ExitCode != 1 || got.ErrorClass != "timeout" {
+		t.Errorf("status = %+v, want the outcome kept and marked truncated", got)
+	}
+	// Latencies are dropped until the rest fits with an empty message, which
+	// then takes up what's left, cut on a rune boundary
+	if len(got.LatenciesMS) == 0 || len(got.LatenciesMS) == 300 {
+		t.Errorf("kept %d latencies, want as many as fit", len(got.LatenciesMS))
+	}
+	if !strings.HasSuffix(got.Error, "...") || !utf8.ValidString(got.Error) || !strings.HasPrefix(st.Error, strings.TrimSuffix(got.Error, "...")) {
+		t.Errorf("error = %q, want a valid prefix of the message", got.Error)
+	}
+	if len(data) < maxStatusSize-len("dé...") {
+		t.Errorf("status is %d bytes, want the message cut to the longest that fits", len(data))
+	}
+}
+
+func TestMarshalStatusLatenciesDropped(t *testing.T) {
+	st := exitStatus{Outcome: results.OutcomeSuccess, LatenciesMS: map[string]int64{}}
+	for i := range 300 {
+		st.LatenciesMS[fmt.Sprintf("pod.phase-%03d", i)] = int64(i)
+	}
+	data, err := marshalStatus(st, maxStatusSize)
+	if err != nil {
+		t.Fatalf("marshalStatus() error = %v", err)
+	}
+	var got exitStatus
+	if err := json.Unmarshal(data, &got); err != nil || len(data) > maxStatusSize {
+		t.Fatalf("status = %d bytes, %v, want valid JSON within %d bytes", len(data), err, maxStatusSize)
+	}
+	if !got.Truncated || len(got.LatenciesMS) == 0 || len(got.LatenciesMS) == 300 {
+		t.Errorf("kept %d latencies, want as many as fit", len(got.LatenciesMS))
+	}
+	if _, ok := got.LatenciesMS["pod.phase-000"]; !ok {
+		t.Error("dropped pod.phase-000, want the last phases by name dropped first")
+	}
+}
+
+func TestMarshalStatusFits(t *testing.T) {
+	st := exitStatus{Outcome: results.OutcomeSuccess, LatenciesMS: map[string]int64{"wait-for-pod": 1200}}
+	data, err := marshalStatus(st, maxStatusSize)
+	if err != nil {
+		t.Fatalf("marshalStatus() error = %v", err)
+	}
+	if want := `{"outcome":"success","exit_code":0,"latencies_ms":{"wait-for-pod":1200}}`; string(data) != want {
+		t.Errorf("marshalStatus() = %s, want %s", data, want)
+	}
+}
+
+func TestStatusFileWritePanic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termination-log")
+	s := newStatusFile(path)
+	s.write(0, strings.Repeat("x", 2*maxStatusSize))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got exitStatus
+	if err := json.Unmarshal(data, &got); err != nil || len(data) > maxStatusSize {
+		t.Fatalf("status file = %d bytes, %v, want valid JSON within %d bytes", len(data), err, maxStatusSize)
+	}
+	if got.ErrorClass != "panic" || got.ExitCode != 2 || !got.Truncated {
+		t.Errorf("status = %+v, want a truncated panic with exit code 2", got)
+	}
+}
+
+func TestStatusFileConcurrentRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termination-log")
+	s := newStatusFile(path)
+
+	// Scheduled, triggered and per-cluster runs share the status file
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := &results.Results{Run: results.Run{
+				ID:     fmt.Sprintf("run-%d", i),
+				Probes: []results.Probe{{Kind: "pod", Outcome: results.OutcomeSuccess}},
+			}}
+			for range 50 {
+				s.record(res, "")
+				s.write(0, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got exitStatus
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("status file isn't valid JSON: %v", err)
+	}
+	if got.Outcome != results.OutcomeSuccess || !strings.HasPrefix(got.RunID, "run-") {
+		t.Errorf("status = %+v, want the success of one of the runs", got)
+	}
+}
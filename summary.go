@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	printSummary = flag.Bool("summary", true, "print a summary of every run on stderr when the process exits: phase durations and percentiles, error counts and the instance IDs of the failed probes")
+	outputFile   = flag.String("output-file", "", "path where the summary of every run is written when the process exits, in the --output format")
+	outputFormat = flag.String("output", "", "format of --output-file, json or csv; defaults to csv if its name ends with .csv, json otherwise")
+)
+
+// validateOutput returns an error unless --output and --output-file are
+// usable.
+func validateOutput() error {
+	switch *outputFormat {
+	case "", results.SummaryJSON, results.SummaryCSV:
+	default:
+		return fmt.Errorf("unknown --output %q, must be one of %s or %s", *outputFormat, results.SummaryJSON, results.SummaryCSV)
+	}
+	if *outputFormat != "" && *outputFile == "" {
+		return fmt.Errorf("--output needs --output-file")
+	}
+	return nil
+}
+
+// runSummary accumulates the results of every run of the process, see
+// --summary and --output-file. A nil *runSummary records nothing.
+type runSummary struct {
+	mu      sync.Mutex
+	builder *results.SummaryBuilder
+}
+
+// newRunSummary returns the summary of the process' runs, or nil when it is
+// neither printed nor written.
+func newRunSummary() *runSummary {
+	if !*printSummary && *outputFile == "" {
+		return nil
+	}
+	return &runSummary{builder: results.NewSummaryBuilder()}
+}
+
+// add adds a run that ended to the summary.
+func (s *runSummary) add(run results.Run) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.builder.Add(run)
+}
+
+// write prints the summary on stderr and writes it to --output-file. It is
+// called once, when the process exits.
+func (s *runSummary) write() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	summary := s.builder.Summary()
+	s.mu.Unlock()
+	if summary.Runs == 0 {
+		return
+	}
+
+	if *printSummary {
+		fmt.Fprintln(os.Stderr)
+		if err := results.WriteSummaryText(os.Stderr, summary); err != nil {
+			slog.Error("Failed to print summary", "error", err)
+		}
+	}
+	if *outputFile != "" {
+		format := *outputFormat
+		if format == "" {
+			format = results.SummaryFormat(*outputFile)
+		}
+		if err := writeSummary(*outputFile, summary, format); err != nil {
+			slog.Error("Failed to write summary", "path", *outputFile, "error", err)
+		}
+	}
+}
+
+// writeSummary writes summary to path in format.
+func writeSummary(path string, summary results.Summary, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := results.WriteSummary(f, summary, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
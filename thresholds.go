@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// exitThresholdExceeded is the exit code of a run whose probes all
+// completed, but with phases longer than their --max-latency.
+const exitThresholdExceeded = 3
+
+var maxLatency = thresholdsFlag{}
+
+func init() {
+	flag.Var(maxLatency, "max-latency", "comma-separated phase=duration thresholds, e.g. wait-for-pod=2s; a probe with a longer phase fails with the budget_exceeded outcome and the run exits with code 3; may be repeated")
+}
+
+// thresholdsFlag collects comma-separated phase=duration flags into the
+// longest acceptable durations of the phases, by name.
+type thresholdsFlag map[string]time.Duration
+
+func (t thresholdsFlag) String() string {
+	pairs := make([]string, 0, len(t))
+	for _, name := range slices.Sorted(maps.Keys(t)) {
+		pairs = append(pairs, name+"="+t[name].String())
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (thresholdsFlag) repeated() {}
+
+func (t thresholdsFlag) Set(s string) error {
+	for pair := range strings.SplitSeq(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid threshold %q, must be phase=duration", pair)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid threshold of phase %s: %w", name, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("threshold of phase %s must be positive, got %s", name, d)
+		}
+		t[name] = d
+	}
+	return nil
+}
+
+// checkThresholds gives result the budget_exceeded outcome if it succeeded
+// but any of its phases took longer than its threshold, and reports whether
+// it did. Each such phase is recorded as an error of the result and as an
+// event on the span in ctx, whose status is then set to error.
+func checkThresholds(ctx context.Context, result *results.Probe, thresholds map[string]time.Duration) bool {
+	if result.Outcome != results.OutcomeSuccess {
+		return false
+	}
+	span := trace.SpanFromContext(ctx)
+	for _, ph := range result.Phases {
+		limit, ok := thresholds[ph.Name]
+		if !ok || ph.Outcome != results.OutcomeSuccess || ph.Duration <= limit {
+			continue
+		}
+		result.Outcome = results.OutcomeBudgetExceeded
+		result.Errors = append(result.Errors, fmt.Sprintf("%s took %s, over its %s threshold", ph.Name, ph.Duration.Round(time.Millisecond), limit))
+		span.AddEvent("threshold exceeded", trace.WithAttributes(
+			attribute.String("phase", ph.Name),
+			attribute.Int64("phase.duration_ms", ph.Duration.Milliseconds()),
+			attribute.Int64("threshold.ms", limit.Milliseconds()),
+		))
+	}
+	if result.Outcome != results.OutcomeBudgetExceeded {
+		return false
+	}
+	span.SetStatus(codes.Error, strings.Join(result.Errors, "; "))
+	return true
+}
+
+// exitCodeFor returns the exit code of a run ending with probes: 1 if any of
+// them failed, exitThresholdExceeded if they all completed but some exceeded
+// their thresholds, 0 otherwise.
+func exitCodeFor(probes []results.Probe) int {
+	code := 0
+	for _, pr := range probes {
+		switch {
+		case pr.Outcome == results.OutcomeSuccess || pr.Outcome.Skipped():
+		case pr.Outcome == results.OutcomeBudgetExceeded:
+			code = exitThresholdExceeded
+		default:
+			return 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var tokenReview = flag.Bool("token-review", false, "validate the token issued by the token-request probe with a TokenReview, which needs to create tokenreviews cluster-wide")
+
+// runTokenRequest measures how long the TokenRequest API takes to issue a
+// ServiceAccount token, the path every pod mounting a projected token goes
+// through at startup: a throwaway ServiceAccount is created, then a token is
+// requested for it and, with --token-review, validated.
+func (p *prober) runTokenRequest(ctx context.Context) results.Probe {
+	opts := probe.IdentityOptions{
+		Name:      fmt.Sprintf("probe-token-%s", p.instance),
+		Namespace: p.namespace,
+		Labels: p.labels(map[string]string{
+			"app":            "probe",
+			"probe-instance": p.instance,
+		}),
+		Annotations: p.annotations(),
+		// The shortest the API server accepts, the token is never used
+		TokenExpiration: 10 * time.Minute,
+		FieldManager:    *fieldManager,
+	}
+	tokenResult := results.Probe{
+		Kind:    "token-request",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+			"review":    strconv.FormatBool(*tokenReview),
+		},
+	}
+
+	var token string
+	stages := []probe.Stage{
+		probe.CreateServiceAccount(p.clients.Cleanup, opts),
+		probe.RequestToken(p.clients.Measure, opts, &token),
+	}
+	if *tokenReview {
+		stages = append(stages, probe.ReviewToken(p.clients.Measure, opts, &token))
+	}
+	phases, err := probe.RunStages(ctx, p.tracer, stages)
+	tokenResult.Phases = phases
+	if err != nil {
+		tokenResult.Outcome = probe.OutcomeFor(err)
+		tokenResult.Errors = append(tokenResult.Errors, err.Error())
+	}
+
+	return tokenResult
+}
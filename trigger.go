@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var triggerAddr = flag.String("trigger-addr", "", "address on which POST /probe runs a probe on demand and responds with its results, e.g. :8083; disabled when empty")
+
+// trigger runs probes on demand, one at a time, outside of the daemon's own
+// runs, e.g. to measure the control plane during an incident.
+type trigger struct {
+	// ctx bounds the triggered runs, which end with the prober rather than
+	// with the request.
+	ctx     context.Context
+	kind    string
+	runners map[string]*runner
+
+	// running is held while a triggered run is in flight, current being
+	// its runner and result its results once it ended.
+	running sync.Mutex
+	result  *results.Results
+	mu      sync.Mutex
+	current *runner
+}
+
+// bind gives the trigger a runner per kind, derived from base, whose runs
+// end when ctx is done. Until then, triggers are refused.
+func (t *trigger) bind(ctx context.Context, base *runner) {
+	runners := make(map[string]*runner, len(probeKinds))
+	for _, kind := range probeKinds {
+		r := base.forProbe(kind, base.namespace)
+		r.report = func(_ context.Context, run results.Run, _ int) {
+			t.result = &results.Results{SchemaVersion: results.SchemaVersion, Run: run}
+		}
+		runners[kind] = r
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ctx, t.kind, t.runners = ctx, base.kind, runners
+}
+
+// activeRuns returns the ID of the triggered run in flight, if any, whose
+// objects the reaper must leave alone.
+func (t *trigger) activeRuns() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current == nil {
+		return nil
+	}
+	return t.current.activeRuns()
+}
+
+// probe runs the probe of the ?probe= kind, --probe by default, and responds
+// with its results once it ended, whatever its outcome. A trigger while
+// another triggered run is in flight is refused rather than queued.
+func (t *trigger) probe(w http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	ctx, kind, runners := t.ctx, t.kind, t.runners
+	t.mu.Unlock()
+	if runners == nil {
+		http.Error(w, "the prober is starting", http.StatusServiceUnavailable)
+		return
+	}
+	if k := req.URL.Query().Get("probe"); k != "" {
+		kind = k
+	}
+	if !slices.Contains(probeKinds, kind) {
+		http.Error(w, fmt.Sprintf("unknown probe %q", kind), http.StatusBadRequest)
+		return
+	}
+	if !t.running.TryLock() {
+		http.Error(w, "a triggered run is already in flight", http.StatusTooManyRequests)
+		return
+	}
+	defer t.running.Unlock()
+
+	r := runners[kind]
+	t.mu.Lock()
+	t.current = r
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.current = nil
+		t.mu.Unlock()
+	}()
+
+	slog.InfoContext(req.Context(), "Triggered run", "probe", kind, "remote_addr", req.RemoteAddr)
+	t.result = nil
+	code := r.run(ctx)
+	if code != 0 {
+		slog.WarnContext(req.Context(), "Triggered run failed", "probe", kind, "exit_code", code)
+	}
+	if t.result == nil {
+		http.Error(w, fmt.Sprintf("run failed with exit code %d", code), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := t.result.Encode(w, *resultsSchema); err != nil {
+		slog.ErrorContext(req.Context(), "Failed to write the results of the triggered run", "error", err)
+	}
+}
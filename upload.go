@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"go.wperron.io/k8slatencyprobe/pkg/objectstore"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+	"go.wperron.io/k8slatencyprobe/pkg/telemetry"
+)
+
+var (
+	uploadURL      = flag.String("upload-url", "", "bucket the results of every run are uploaded to, keyed by cluster and time, as s3://bucket/prefix or gs://bucket/prefix; credentials are read from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY, an HMAC key for GCS")
+	uploadEndpoint = flag.String("upload-endpoint", "", "S3 API endpoint of --upload-url, for S3 compatible stores such as MinIO; defaults to the one of AWS or GCS")
+	uploadRegion   = flag.String("upload-region", os.Getenv("AWS_REGION"), "region of the --upload-url bucket; defaults to $AWS_REGION, then us-east-1 for S3")
+)
+
+// resultUploader uploads the results of every run to the bucket of
+// --upload-url. A nil *resultUploader uploads nothing.
+type resultUploader struct {
+	bucket  *objectstore.Bucket
+	cluster string
+}
+
+// newResultUploader returns the uploader of --upload-url, or nil without it.
+func newResultUploader() (*resultUploader, error) {
+	if *uploadURL == "" {
+		return nil, nil
+	}
+	cluster := *clusterName
+	if cluster == "" {
+		cluster = os.Getenv(telemetry.EnvClusterName)
+	}
+	if cluster == "" && *kubeContexts == "" {
+		return nil, fmt.Errorf("--upload-url needs --cluster-name or $%s to key the results by", telemetry.EnvClusterName)
+	}
+	bucket, err := objectstore.ParseBucket(*uploadURL, *uploadEndpoint, *uploadRegion)
+	if err != nil {
+		return nil, err
+	}
+	if bucket.Credentials, err = objectstore.CredentialsFromEnv(); err != nil {
+		return nil, fmt.Errorf("--upload-url: %w", err)
+	}
+	bucket.Client = &http.Client{Timeout: 30 * time.Second}
+	return &resultUploader{bucket: bucket, cluster: cluster}, nil
+}
+
+// forCluster returns the uploader of the results of the cluster named name,
+// see --contexts.
+func (u *resultUploader) forCluster(name string) *resultUploader {
+	if u == nil {
+		return nil
+	}
+	return &resultUploader{bucket: u.bucket, cluster: name}
+}
+
+// key returns the key of the results of run,
+// <prefix>/<cluster>/<yyyy>/<mm>/<dd>/<start>-<run ID>.json, so that listing
+// a cluster's or a day's prefix finds the runs in the order they started.
+func (u *resultUploader) key(run results.Run) string {
+	start := run.Start.UTC()
+	return u.bucket.Key(fmt.Sprintf("%s/%s/%s-%s.json", u.cluster, start.Format("2006/01/02"), start.Format("20060102T150405Z"), run.ID))
+}
+
+// upload uploads res, encoded in the --results-schema version like the
+// results written on stdout, and returns its key.
+func (u *resultUploader) upload(ctx context.Context, res *results.Results) (string, error) {
+	if u == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := res.Encode(&buf, *resultsSchema); err != nil {
+		return "", err
+	}
+	key := u.key(res.Run)
+	return key, u.bucket.Put(ctx, key, buf.Bytes(), "application/json")
+}
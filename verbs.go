@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+// runVerbs measures the latency of single API requests of each verb against
+// a ConfigMap, isolating the API server's and etcd's latency from the
+// controllers' and the scheduler's.
+func (p *prober) runVerbs(ctx context.Context) results.Probe {
+	requests := must(otel.Meter("k8s-latency-probe").Float64Histogram("probe.verb.duration",
+		metric.WithDescription("Duration of single API requests of the verbs probe, by verb."),
+		metric.WithUnit("ms"),
+	))
+
+	return p.runRequests(ctx, "verbs", probe.VerbStages, func(ctx context.Context, ph results.Phase, attrs []attribute.KeyValue) {
+		if strings.HasPrefix(ph.Name, "teardown-") {
+			return
+		}
+		requests.Record(ctx, float64(ph.Duration.Microseconds())/1000, metric.WithAttributes(
+			append(attrs, attribute.String("probe.verb", ph.Name))...,
+		))
+	})
+}
+
+// runRequests runs the stages of a probe of kind making API requests against
+// a ConfigMap, once with each of the clients of byContentType, and records
+// the successful phases with record, their names stripped of the content
+// type, which is then given as an attribute.
+func (p *prober) runRequests(ctx context.Context, kind string, stages func(probe.Clients, probe.ObjectOptions) []probe.Stage, record func(context.Context, results.Phase, []attribute.KeyValue)) results.Probe {
+	reqResult := results.Probe{
+		Kind:    kind,
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+		},
+	}
+
+	for _, c := range p.byContentType() {
+		name := fmt.Sprintf("probe-%s-%s", kind, p.instance)
+		var attrs []attribute.KeyValue
+		if c.name != "" {
+			name += "-" + c.name
+			attrs = append(attrs, attribute.String("k8s.content_type", c.name))
+		}
+		ss := stages(c.clients, probe.ObjectOptions{
+			Name:      name,
+			Namespace: p.namespace,
+			Labels: p.labels(map[string]string{
+				"app":            "probe",
+				"probe-instance": p.instance,
+			}),
+			Annotations:  p.annotations(),
+			FieldManager: *fieldManager,
+		})
+		for i := range ss {
+			ss[i].Name += c.suffix()
+		}
+
+		phases, err := probe.RunStages(ctx, p.tracer, ss)
+		for _, ph := range phases {
+			if ph.Outcome == results.OutcomeSuccess {
+				stripped := ph
+				stripped.Name = strings.TrimSuffix(ph.Name, c.suffix())
+				record(ctx, stripped, attrs)
+			}
+		}
+		reqResult.Phases = append(reqResult.Phases, phases...)
+		if err != nil {
+			reqResult.Outcome = probe.OutcomeFor(err)
+			reqResult.Errors = append(reqResult.Errors, err.Error())
+			break
+		}
+	}
+	return reqResult
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/ptr"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var (
+	webhookMatchLabels        = flag.String("webhook-match-labels", "", "comma-separated key=value labels of the pod of the webhook-overhead probe meant to be matched by the admission webhooks, e.g. by their objectSelector, which the other pod doesn't get")
+	webhookUnmatchedNamespace = flag.String("webhook-unmatched-namespace", "", "namespace of the pod of the webhook-overhead probe meant not to be matched by the admission webhooks, e.g. excluded by their namespaceSelector; defaults to the probe's namespace")
+	webhookDryRun             = flag.Bool("webhook-dry-run", true, "create the pods of the webhook-overhead probe with dry-run, admitted but never persisted; webhooks with side effects reject dry-run requests")
+	webhookCalibrateService   = flag.String("webhook-calibrate-service", "", "name:port of a Service of the prober's namespace routing to --webhook-calibrate-addr: the webhook-overhead probe then also registers a no-op webhook served by the prober, measuring the overhead of a webhook doing nothing")
+	webhookCalibrateAddr      = flag.String("webhook-calibrate-addr", ":8443", "address the no-op webhook of --webhook-calibrate-service is served on, over TLS with a self-signed certificate")
+)
+
+// webhookOverhead configures the webhook-overhead probe.
+type webhookOverhead struct {
+	matchLabels        map[string]string
+	unmatchedNamespace string
+	dryRun             bool
+	// calibration, if set, is the no-op webhook every probe registers.
+	calibration *webhookCalibration
+}
+
+// webhookCalibration is the no-op webhook served by the prober, see
+// --webhook-calibrate-service.
+type webhookCalibration struct {
+	hook     *probe.NoopWebhook
+	service  admissionregistrationv1.ServiceReference
+	caBundle []byte
+}
+
+// newWebhookOverhead returns the configuration of the webhook-overhead probe
+// given by the --webhook-* flags.
+func newWebhookOverhead(kind string) (*webhookOverhead, error) {
+	var errs []error
+	w := &webhookOverhead{dryRun: *webhookDryRun}
+	if *webhookMatchLabels != "" {
+		set, err := labels.ConvertSelectorToLabelsMap(*webhookMatchLabels)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid --webhook-match-labels %q: %w", *webhookMatchLabels, err))
+		}
+		w.matchLabels = set
+	}
+	if *webhookUnmatchedNamespace != "" {
+		ns, err := validNamespace(*webhookUnmatchedNamespace, "--webhook-unmatched-namespace")
+		if err != nil {
+			errs = append(errs, err)
+		}
+		w.unmatchedNamespace = ns
+	}
+	if kind == "webhook-overhead" && len(w.matchLabels) == 0 && w.unmatchedNamespace == "" {
+		errs = append(errs, errors.New("the webhook-overhead probe needs --webhook-match-labels or --webhook-unmatched-namespace to tell its pods apart"))
+	}
+	if *webhookCalibrateService != "" {
+		name, port, _ := strings.Cut(*webhookCalibrateService, ":")
+		p, err := strconv.ParseInt(port, 10, 32)
+		if name == "" || err != nil || p < 1 || p > 65535 {
+			errs = append(errs, fmt.Errorf("invalid --webhook-calibrate-service %q, must be name:port", *webhookCalibrateService))
+		}
+		if *kubeContexts != "" {
+			errs = append(errs, errors.New("--webhook-calibrate-service can't be used with --contexts, the other clusters can't call the prober"))
+		}
+		w.calibration = &webhookCalibration{
+			hook:    probe.NewNoopWebhook(),
+			service: admissionregistrationv1.ServiceReference{Name: name, Port: ptr.To(int32(p))},
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// serveCalibration serves the no-op webhook, if calibrating, behind its
+// Service in namespace until the returned function is called, with a
+// certificate generated for the Service's DNS names.
+func (w *webhookOverhead) serveCalibration(namespace string) (stop func(), err error) {
+	c := w.calibration
+	if c == nil {
+		return func() {}, nil
+	}
+	c.service.Namespace = namespace
+	host := fmt.Sprintf("%s.%s.svc", c.service.Name, namespace)
+	cert, caBundle, err := probe.WebhookCertificate(host, host+".cluster.local")
+	if err != nil {
+		return nil, err
+	}
+	c.caBundle = caBundle
+	ln, err := net.Listen("tcp", *webhookCalibrateAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the calibration webhook: %w", err)
+	}
+	srv := &http.Server{
+		Handler:           c.hook,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.ServeTLS(ln, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Calibration webhook server failed", "addr", *webhookCalibrateAddr, "error", err)
+		}
+	}()
+	slog.Info("Serving the calibration webhook", "addr", ln.Addr().String(), "service", host)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("Failed to shutdown the calibration webhook server", "error", err)
+		}
+	}, nil
+}
+
+// webhookTargets are the pods of the webhook-overhead probe compared with
+// the unmatched one, by the suffix of their create-* phase.
+var webhookTargets = []string{"matched", "calibrated"}
+
+// runWebhookOverhead measures the latency admission webhooks add to the
+// creation of a pod: the same pod is created once so as not to be matched by
+// the webhooks and once so as to be, and the difference between the two is
+// their overhead. With --webhook-calibrate-service, a third pod is only
+// matched by a no-op webhook served by the prober, the overhead of any
+// webhook call.
+func (p *prober) runWebhookOverhead(ctx context.Context, w *webhookOverhead) results.Probe {
+	admissions := must(otel.Meter("k8s-latency-probe").Float64Histogram("probe.webhook.admission.duration",
+		metric.WithDescription("Duration of the pod creations of the webhook-overhead probe, by webhook.target: unmatched, matched or calibrated."),
+		metric.WithUnit("ms"),
+	))
+	whResult := results.Probe{
+		Kind:    "webhook-overhead",
+		Outcome: results.OutcomeSuccess,
+		Attributes: map[string]string{
+			"namespace": p.namespace,
+			"instance":  p.instance,
+			"dry_run":   strconv.FormatBool(w.dryRun),
+		},
+	}
+
+	unmatchedNamespace := cmp.Or(w.unmatchedNamespace, p.namespace)
+	podOpts := func(target, namespace string, extra map[string]string) probe.PodOptions {
+		labels := map[string]string{"app": "probe", "probe-instance": p.instance}
+		maps.Copy(labels, extra)
+		opts := probe.PodOptions{
+			Name:         fmt.Sprintf("probe-webhook-%s-%s", p.instance, target),
+			Namespace:    namespace,
+			Image:        p.cfg.Image,
+			FieldManager: *fieldManager,
+			Labels:       p.labels(labels),
+			Annotations:  p.annotations(),
+			Template:     p.cfg.PodTemplate,
+			Mutators:     p.cfg.Mutators,
+		}
+		if namespace == p.namespace {
+			opts.OwnerReferences = p.owners
+		}
+		return opts
+	}
+	stages := []probe.Stage{
+		probe.CreateAdmittedPod(p.clients, "create-unmatched", podOpts("unmatched", unmatchedNamespace, nil), w.dryRun),
+		probe.CreateAdmittedPod(p.clients, "create-matched", podOpts("matched", p.namespace, w.matchLabels), w.dryRun),
+	}
+	if c := w.calibration; c != nil {
+		defer c.hook.Forget(p.instance)
+		calibrated := podOpts("calibrated", unmatchedNamespace, map[string]string{probe.LabelCalibrate: p.instance})
+		stages = append(stages,
+			probe.RegisterWebhook(p.clients, probe.WebhookOptions{
+				Name:         fmt.Sprintf("probe-webhook-%s", p.instance),
+				Instance:     p.instance,
+				Labels:       p.labels(map[string]string{"app": "probe", "probe-instance": p.instance}),
+				Annotations:  p.annotations(),
+				Service:      c.service,
+				CABundle:     c.caBundle,
+				FieldManager: *fieldManager,
+			}),
+			// Always dry-run, the same pod being created until the webhook
+			// is called
+			probe.WaitWebhookReady(c.hook, p.instance, p.cfg.PollInterval, probe.CreateAdmittedPod(p.clients, "", calibrated, true).Run),
+			probe.CreateAdmittedPod(p.clients, "create-calibrated", calibrated, w.dryRun),
+		)
+	}
+
+	phases, err := probe.RunStages(ctx, p.tracer, stages)
+	whResult.Phases = phases
+	created := map[string]time.Duration{}
+	for _, ph := range phases {
+		target, ok := strings.CutPrefix(ph.Name, "create-")
+		if !ok || ph.Outcome != results.OutcomeSuccess {
+			continue
+		}
+		created[target] = ph.Duration
+		if target != "webhook" {
+			admissions.Record(ctx, float64(ph.Duration.Microseconds())/1000, metric.WithAttributes(attribute.String("webhook.target", target)))
+		}
+	}
+	if unmatched, ok := created["unmatched"]; ok {
+		for _, target := range webhookTargets {
+			if d, ok := created[target]; ok {
+				overhead := d - unmatched
+				whResult.Attributes["webhook."+target+"_overhead_ms"] = strconv.FormatInt(overhead.Milliseconds(), 10)
+				trace.SpanFromContext(ctx).SetAttributes(attribute.Float64("webhook."+target+"_overhead_ms", float64(overhead.Microseconds())/1000))
+			}
+		}
+	}
+	if err != nil {
+		whResult.Outcome = probe.OutcomeFor(err)
+		whResult.Errors = append(whResult.Errors, err.Error())
+	}
+
+	return whResult
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"go.wperron.io/k8slatencyprobe/pkg/probe"
+	"go.wperron.io/k8slatencyprobe/pkg/results"
+)
+
+var perZone = flag.Bool("per-zone", false, "run --count probes in each zone of the schedulable, ready nodes matching --node-selector, their pods restricted to the zone with a node selector, to compare latency across zones")
+
+// sampleZones returns the zones to probe in this run.
+func (r *runner) sampleZones(ctx context.Context) ([]string, error) {
+	nodes, err := r.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: r.nodes.Selector.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	zones := r.nodes.Zones(nodes.Items)
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("no schedulable, ready node matching --node-selector %q has a %s label", *nodeSelector, probe.ZoneLabel)
+	}
+	return zones, nil
+}
+
+// inZone returns a copy of the sample prober p whose pods are restricted to
+// the nodes of zone.
+func (p *prober) inZone(zone string) *prober {
+	p.cfg.Mutators = slices.Concat(p.cfg.Mutators, []probe.PodMutator{probe.InZone(zone)})
+	p.zone = zone
+	return p
+}
+
+// tagZone records the zone of the node the probe's pods landed on in its
+// result's attributes and on the span in ctx. Probes not reporting their
+// node are left as is.
+func (r *runner) tagZone(ctx context.Context, p *prober, result *results.Probe) {
+	zone := result.Attributes[probe.AttrZone]
+	if zone == "" {
+		node := result.Attributes[probe.AttrNodeName]
+		if node == "" {
+			node = result.Attributes["node"]
+		}
+		if node == "" {
+			return
+		}
+		// The run's context may be done already, e.g. after a timeout
+		zctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		var err error
+		if zone, err = r.zones.Zone(zctx, node); err != nil {
+			p.log.WarnContext(ctx, "Failed to get the zone of the node", "node", node, "error", err)
+			return
+		}
+		if zone == "" {
+			return
+		}
+		result.Attributes[probe.AttrZone] = zone
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(probe.AttrZone, zone))
+}